@@ -11,11 +11,18 @@ import (
 
 	"messaging-app/config"
 	"messaging-app/internal/controllers"
+	"messaging-app/internal/email"
 	"messaging-app/internal/kafka"
+	"messaging-app/internal/linkpreview"
+	"messaging-app/internal/models"
+	"messaging-app/internal/push"
 	"messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
 	"messaging-app/internal/services"
+	"messaging-app/internal/storage"
 	"messaging-app/internal/websocket"
+	"messaging-app/pkg/contentfilter"
+	"messaging-app/pkg/logging"
 	"messaging-app/pkg/middleware"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +33,7 @@ import (
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
+	logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel)
 	metrics := config.GetMetrics()
 
 	// Initialize MongoDB
@@ -35,8 +43,10 @@ func main() {
 			Username: cfg.MongoUser,
 			Password: cfg.MongoPassword,
 		}).
-		SetMaxPoolSize(100).
-		SetSocketTimeout(10 * time.Second)
+		SetMaxPoolSize(uint64(cfg.MongoMaxPoolSize)).
+		SetSocketTimeout(cfg.MongoSocketTimeout).
+		SetTimeout(cfg.MongoOperationTimeout).
+		SetMonitor(config.MongoCommandMonitor(metrics))
 
 	mongoClient, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
@@ -50,6 +60,13 @@ func main() {
 
 	db := mongoClient.Database(cfg.DBName)
 
+	// Missing indexes slow queries down but don't stop the server from serving
+	indexReport, err := repositories.EnsureIndexes(context.Background(), db, cfg.LinkPreviewTTL, cfg.IndexMigrate)
+	if err != nil {
+		log.Printf("Index setup incomplete: %v", err)
+	}
+	log.Printf("Indexes: %d created, %d rebuilt, %d conflicting", len(indexReport.Created), len(indexReport.Rebuilt), len(indexReport.Conflicts))
+
 	// Initialize Redis Cluster
 	redisClient := redis.NewClusterClient(cfg)
 	defer func() {
@@ -70,6 +87,29 @@ func main() {
 	messageRepo := repositories.NewMessageRepository(db)
 	groupRepo := repositories.NewGroupRepository(db)
 	friendshipRepo := repositories.NewFriendshipRepository(db)
+	followRepo := repositories.NewFollowRepository(db)
+	mediaRepo := repositories.NewMediaRepository(db)
+	exportRepo := repositories.NewExportRepository(db)
+	linkPreviewRepo := repositories.NewLinkPreviewRepository(db)
+	outboxRepo := repositories.NewOutboxRepository(db)
+	pollRepo := repositories.NewPollRepository(db)
+	starRepo := repositories.NewStarRepository(db)
+	archiveRepo := repositories.NewArchiveRepository(db)
+	auditRepo := repositories.NewAuditRepository(db)
+	deviceRepo := repositories.NewDeviceRepository(db)
+	announcementRepo := repositories.NewAnnouncementRepository(db)
+
+	// Initialize media storage
+	mediaStorage, err := storage.NewLocalStorage(cfg.MediaStorageDir, cfg.MediaBaseURL, cfg.MediaSigningKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize media storage: %v", err)
+	}
+
+	// Data exports are private and only reachable through signed links
+	exportStorage, err := storage.NewLocalStorage(cfg.ExportStorageDir, cfg.MediaBaseURL, cfg.MediaSigningKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize export storage: %v", err)
+	}
 
 	// Initialize Kafka Producer
 	kafkaProducer := kafka.NewMessageProducer(cfg.KafkaBrokers, cfg.KafkaTopic)
@@ -79,35 +119,181 @@ func main() {
 		}
 	}()
 
+	// Link previews are fetched in the background so sending never waits on other sites
+	linkPreviewProducer := kafka.NewMessageProducer(cfg.KafkaBrokers, cfg.LinkPreviewTopic)
+	defer func() {
+		if err := linkPreviewProducer.Close(); err != nil {
+			log.Printf("Error closing link preview producer: %v", err)
+		}
+	}()
+
+	// The outbox publishes synchronously so it knows which events reached Kafka
+	outboxProducer := kafka.NewSyncProducer(cfg.KafkaBrokers, cfg.KafkaTopic)
+	defer func() {
+		if err := outboxProducer.Close(); err != nil {
+			log.Printf("Error closing outbox producer: %v", err)
+		}
+	}()
+	outboxRelay := services.NewOutboxRelay(outboxRepo, outboxProducer, redisClient.GetClient())
+
+	// Messages sent over WebSockets go through the message service too
+	mediaService := services.NewMediaService(mediaRepo, mediaStorage, cfg)
+	messageService := services.NewMessageService(messageRepo, groupRepo, friendshipRepo, userRepo, kafkaProducer, redisClient.GetClient(), mediaService, linkPreviewProducer, outboxRelay, pollRepo, starRepo, archiveRepo)
+	messageService.SetMaxContentLength(cfg.MaxMessageLength)
+	var contentFilter *contentfilter.ListFilter
+	if cfg.ContentFilterPolicy != contentfilter.PolicyOff {
+		contentFilter, err = contentfilter.NewListFilter(cfg.ContentFilterFile, cfg.ContentFilterSubstitutions)
+		if err != nil {
+			log.Fatalf("Failed to load content filter: %v", err)
+		}
+		messageService.SetContentFilter(contentFilter, cfg.ContentFilterPolicy)
+
+		// SIGHUP reloads the list without a restart
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				if err := contentFilter.Reload(); err != nil {
+					log.Printf("Failed to reload content filter, keeping the previous list: %v", err)
+					continue
+				}
+				log.Println("Reloaded content filter")
+			}
+		}()
+	}
+	// One summary cache serves every service that embeds users in responses
+	userSummaries := services.NewUserSummaryCache(userRepo, redisClient.GetClient())
+	messageService.SetUserSummaryCache(userSummaries)
+
+	// Users without a connection get push notifications, sent in the background
+	pushProducer := kafka.NewMessageProducer(cfg.KafkaBrokers, cfg.PushTopic)
+	defer func() {
+		if err := pushProducer.Close(); err != nil {
+			log.Printf("Error closing push producer: %v", err)
+		}
+	}()
+	pushSender, err := push.NewSender(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize push notifications: %v", err)
+	}
+	pushService := services.NewPushService(deviceRepo, pushProducer, pushSender, redisClient.GetClient(), messageService)
+
 	// Initialize WebSocket Hub
-	hub := websocket.NewHub(redisClient, groupRepo)
+	hub := websocket.NewHub(redisClient, groupRepo, userRepo, messageService, pushService, messageService)
+	hub.SetConnectionOptions(websocket.ConnectionOptions{
+		MaxMessageSize: cfg.WSMaxMessageSize,
+		AllowedOrigins: cfg.AllowedOrigins,
+		SendBufferSize: cfg.WSSendBufferSize,
+		SendPolicy:     websocket.SendPolicy(cfg.WSSlowClientPolicy),
+	})
 
 	// Initialize Kafka Consumer
 	kafkaConsumer := kafka.NewMessageConsumer(cfg.KafkaBrokers, cfg.KafkaTopic, "message-group", hub)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	// Consumers stop before the hub shuts down, so nothing arrives for a drained hub
+	consumerCtx, stopConsumers := context.WithCancel(backgroundCtx)
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		kafkaConsumer.ConsumeMessages(consumerCtx)
+	}()
+
+	// Events that couldn't be published when they were stored are retried here
+	outboxRelayDone := make(chan struct{})
+	go func() {
+		defer close(outboxRelayDone)
+		outboxRelay.Run(backgroundCtx, cfg.OutboxRelayInterval)
+	}()
+
+	// Emails are queued on Kafka and sent in the background
+	emailProducer := kafka.NewMessageProducer(cfg.KafkaBrokers, cfg.EmailTopic)
+	defer func() {
+		if err := emailProducer.Close(); err != nil {
+			log.Printf("Error closing email producer: %v", err)
+		}
+	}()
+	emailConsumer := kafka.NewEmailConsumer(cfg.KafkaBrokers, cfg.EmailTopic, "email-group", email.NewSender(cfg))
+	emailConsumerDone := make(chan struct{})
 	go func() {
-		kafkaConsumer.ConsumeMessages(context.Background())
+		defer close(emailConsumerDone)
+		emailConsumer.ConsumeMessages(consumerCtx)
+	}()
+
+	linkPreviewService := services.NewLinkPreviewService(linkPreviewRepo, messageRepo, linkpreview.NewFetcher(cfg.LinkPreviewTimeout), kafkaProducer, cfg.LinkPreviewTTL)
+	linkPreviewConsumer := kafka.NewLinkPreviewConsumer(cfg.KafkaBrokers, cfg.LinkPreviewTopic, "link-preview-group", linkPreviewService)
+	linkPreviewConsumerDone := make(chan struct{})
+	go func() {
+		defer close(linkPreviewConsumerDone)
+		linkPreviewConsumer.ConsumeMessages(consumerCtx)
+	}()
+
+	pushConsumer := kafka.NewPushConsumer(cfg.KafkaBrokers, cfg.PushTopic, "push-group", pushService)
+	pushConsumerDone := make(chan struct{})
+	go func() {
+		defer close(pushConsumerDone)
+		pushConsumer.ConsumeMessages(consumerCtx)
 	}()
 
 	// Initialize Services
-	authService := services.NewAuthService(userRepo, cfg.JWTSecret, redisClient.GetClient(), cfg)
-	userService := services.NewUserService(userRepo)
-	messageService := services.NewMessageService(messageRepo, groupRepo, friendshipRepo, kafkaProducer, redisClient.GetClient())
-	groupService := services.NewGroupService(groupRepo, userRepo)
-	friendshipService := services.NewFriendshipService(friendshipRepo, userRepo)
+	// Security events are written in the background so they don't slow down logins
+	auditLog := services.NewAuditLog(auditRepo, userRepo, emailProducer)
+	auditLogDone := make(chan struct{})
+	go func() {
+		defer close(auditLogDone)
+		auditLog.Run(backgroundCtx)
+	}()
+	authService := services.NewAuthService(userRepo, cfg.JWTSecret, redisClient.GetClient(), emailProducer, cfg, auditLog)
+	go authService.RunAccountPurger(backgroundCtx, time.Hour)
+	accountDeletionService := services.NewAccountDeletionService(userRepo, friendshipRepo, followRepo, messageRepo, deviceRepo, auditRepo, redisClient.GetClient(), emailProducer, cfg)
+	go accountDeletionService.RunAccountDeleter(backgroundCtx, time.Hour)
+	// Messages held back for undo send are delivered once their window passes
+	go messageService.RunDispatcher(backgroundCtx, time.Second)
+	userService := services.NewUserService(userRepo, friendshipRepo, followRepo, redisClient.GetClient(), auditLog)
+	userService.SetUserSummaryCache(userSummaries)
+	avatarService := services.NewAvatarService(userRepo, mediaStorage, cfg)
+	avatarService.SetUserSummaryCache(userSummaries)
+	go userSummaries.Run(backgroundCtx)
+	exportService := services.NewExportService(exportRepo, userRepo, messageRepo, friendshipRepo, groupRepo, exportStorage, emailProducer, cfg)
+	go exportService.RunExportPurger(backgroundCtx, time.Hour)
+	groupService := services.NewGroupService(groupRepo, userRepo, messageRepo, redisClient.GetClient(), kafkaProducer)
+	friendshipService := services.NewFriendshipService(friendshipRepo, userRepo, redisClient.GetClient())
+	followService := services.NewFollowService(followRepo, userRepo, friendshipRepo)
+	pollService := services.NewPollService(pollRepo, groupRepo, messageRepo, kafkaProducer)
+	announcementService := services.NewAnnouncementService(announcementRepo, kafkaProducer)
+	if contentFilter != nil {
+		announcementService.SetContentFilter(contentFilter, cfg.ContentFilterPolicy)
+	}
+	syncService := services.NewSyncService(messageRepo, groupRepo, friendshipRepo, userRepo)
 
 	// Initialize Controllers
 	authController := controllers.NewAuthController(authService)
 	userController := controllers.NewUserController(userService)
+	activityController := controllers.NewActivityController(auditLog)
 	messageController := controllers.NewMessageController(messageService)
 	groupController := controllers.NewGroupController(groupService, userService)
 	friendshipController := controllers.NewFriendshipController(friendshipService)
+	followController := controllers.NewFollowController(followService)
+	pollController := controllers.NewPollController(pollService)
+	mediaController := controllers.NewMediaController(mediaService)
+	exportController := controllers.NewExportController(exportService)
+	avatarController := controllers.NewAvatarController(avatarService)
+	deviceController := controllers.NewDeviceController(pushService)
+	announcementController := controllers.NewAnnouncementController(announcementService)
+	syncController := controllers.NewSyncController(syncService)
 
 	// Initialize Gin Router with metrics middleware
 	router := gin.Default()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.ClientInfo())
+	router.Use(middleware.CORS(cfg.AllowedOrigins))
 	router.Use(config.MetricsMiddleware(metrics)) 
+	router.Use(middleware.ErrorHandler())
+	// Uploads are limited by the configured media and avatar sizes instead
+	router.Use(middleware.BodyLimit(cfg.MaxRequestBodySize, "/api/media/upload/:key", "/api/users/me/avatar"))
 
 	// WebSocket router (without metrics middleware)
 	webSocketRouter := gin.Default()
+	webSocketRouter.Use(middleware.RequestID())
 
 	// Start metrics server on separate port
 	go func() {
@@ -154,26 +340,113 @@ func main() {
 		c.JSON(code, status)
 	})
 
+	// Rate limiters
+	loginLimiter := middleware.RateLimitMiddleware(redisClient.GetClient(), middleware.RateLimit{
+		Name:    "auth",
+		Limit:   cfg.LoginRateLimit,
+		Window:  cfg.RateLimitWindow,
+		KeyFunc: middleware.ByIP,
+	})
+	messageLimiter := middleware.RateLimitMiddleware(redisClient.GetClient(), middleware.RateLimit{
+		Name:    "messages",
+		Limit:   cfg.MessageRateLimit,
+		Window:  cfg.RateLimitWindow,
+		KeyFunc: middleware.ByUser,
+	})
+	// Kept low so the phone number space can't be enumerated
+	discoveryLimiter := middleware.RateLimitMiddleware(redisClient.GetClient(), middleware.RateLimit{
+		Name:    "discovery",
+		Limit:   cfg.DiscoveryRateLimit,
+		Window:  cfg.RateLimitWindow,
+		KeyFunc: middleware.ByUser,
+	})
+	// Kept low so nobody can flood an inbox with reset emails
+	passwordResetLimiter := middleware.RateLimitMiddleware(redisClient.GetClient(), middleware.RateLimit{
+		Name:    "password_reset",
+		Limit:   cfg.PasswordResetRateLimit,
+		Window:  time.Hour,
+		KeyFunc: middleware.ByEmail,
+	})
+	wsTicketLimiter := middleware.RateLimitMiddleware(redisClient.GetClient(), middleware.RateLimit{
+		Name:    "ws_ticket",
+		Limit:   cfg.WSTicketRateLimit,
+		Window:  cfg.RateLimitWindow,
+		KeyFunc: middleware.ByUser,
+	})
+
 	// Auth routes
-	router.POST("/api/auth/register", authController.Register)
-	router.POST("/api/auth/login", authController.Login)
+	router.POST("/api/auth/register", loginLimiter, authController.Register)
+	router.POST("/api/auth/login", loginLimiter, authController.Login)
 	router.POST("/api/auth/refresh", authController.Refresh)
 	router.POST("/api/auth/logout", authController.Logout)
+	router.POST("/api/auth/reactivate", loginLimiter, authController.Reactivate)
+	router.GET("/api/auth/verify-email", authController.VerifyEmail)
+	router.POST("/api/auth/forgot-password", loginLimiter, passwordResetLimiter, authController.ForgotPassword)
+	router.POST("/api/auth/reset-password", loginLimiter, authController.ResetPassword)
+	router.POST("/api/auth/2fa", loginLimiter, authController.CompleteTwoFactorLogin)
+
+	// Media uploads are authorized by the presigned URL signature
+	router.PUT("/api/media/upload/:key", mediaController.Upload)
+	router.Static("/media", mediaStorage.Dir())
+	router.GET("/api/downloads/:key", exportController.Download)
 
 	// Protected routes
-	authMiddleware := middleware.AuthMiddleware(cfg.JWTSecret, redisClient.GetClient())
+	authMiddleware := middleware.AuthMiddleware(cfg.JWTSecret, redisClient.GetClient(), userRepo)
 	api := router.Group("/api", authMiddleware)
 	{
 		// User endpoints
 		api.GET("/user", userController.GetUser)          
 		api.PUT("/user", userController.UpdateUser)      
+		api.POST("/user/deactivate", authController.Deactivate)
+		api.DELETE("/users/me", authController.DeleteAccount)
+		api.POST("/auth/verify-email/resend", loginLimiter, authController.ResendVerificationEmail)
+		api.POST("/auth/logout-all", authController.LogoutEverywhere)
+		api.POST("/auth/ws-ticket", wsTicketLimiter, authController.IssueWSTicket)
+		api.POST("/users/me/2fa/setup", authController.SetupTwoFactor)
+		api.POST("/users/me/2fa/verify", authController.EnableTwoFactor)
+		api.POST("/users/me/2fa/disable", authController.DisableTwoFactor)
+		api.POST("/users/me/export", exportController.StartExport)
+		api.POST("/users/me/avatar", avatarController.UploadAvatar)
+		api.POST("/users/me/devices", deviceController.RegisterDevice)
+		api.DELETE("/users/me/devices", deviceController.UnregisterDevice)
+		api.GET("/users/me/activity", activityController.ListActivity)
+		api.GET("/users/me/follow-requests", followController.ListFollowRequests)
+		api.POST("/users/me/follow-requests/:id/accept", followController.AcceptFollowRequest)
+		api.DELETE("/users/me/follow-requests/:id", followController.RejectFollowRequest)
+		api.GET("/users/me/export/:jobId", exportController.GetExport)
 		api.GET("/users", userController.ListUsers)      
+		api.GET("/users/suggest", userController.SuggestUsers)
+		api.POST("/users/discover", discoveryLimiter, userController.DiscoverByPhone)
 		api.GET("/users/:id", userController.GetUserByID)
+		api.GET("/users/:id/friends", userController.ListFriends)
+		api.POST("/users/:id/follow", followController.Follow)
+		api.DELETE("/users/:id/follow", followController.Unfollow)
+		api.GET("/users/:id/followers", followController.ListFollowers)
+		api.GET("/users/:id/following", followController.ListFollowing)
 
 		// Message endpoints
-		api.POST("/messages", messageController.SendMessage)
+		api.POST("/messages", messageLimiter, messageController.SendMessage)
+		api.POST("/messages/seen", messageController.MarkMessagesAsSeen)
+		api.GET("/messages/unread", messageController.GetUnreadCount)
+		api.GET("/messages/search", messageController.SearchMessages)
+		api.GET("/messages/starred", messageController.GetStarredMessages)
 		api.GET("/messages/:id", messageController.GetMessages)
 		api.DELETE("/messages/:id", messageController.DeleteMessage)
+		api.POST("/messages/:id/forward", messageLimiter, messageController.ForwardMessage)
+		api.GET("/messages/:id/receipts", messageController.GetReceipts)
+		api.POST("/messages/:id/star", messageController.StarMessage)
+		api.DELETE("/messages/:id/star", messageController.UnstarMessage)
+		api.GET("/conversations", messageController.GetConversations)
+		api.POST("/conversations/:id/archive", messageController.ArchiveConversation)
+		api.DELETE("/conversations/:id/archive", messageController.UnarchiveConversation)
+		api.GET("/sync", syncController.Sync)
+		api.GET("/polls/:id", pollController.GetPoll)
+		api.POST("/polls/:id/votes", pollController.Vote)
+		api.DELETE("/polls/:id/votes", pollController.RetractVote)
+		api.POST("/polls/:id/close", pollController.ClosePoll)
+
+		// Media endpoints
+		api.POST("/media/presign", mediaController.Presign)
 
 		// Group endpoints
 		api.POST("/groups", groupController.CreateGroup)        
@@ -181,7 +454,25 @@ func main() {
 		api.PATCH("/groups/:id", groupController.UpdateGroup)    
 		api.POST("/groups/:id/members", groupController.AddMember)
 		api.DELETE("/groups/:id/members/:user_id", groupController.RemoveMember) 
+		api.DELETE("/groups/:id", groupController.DeleteGroup)
 		api.POST("/groups/:id/admins", groupController.AddAdmin)
+		api.DELETE("/groups/:id/admins/:user_id", groupController.RemoveAdmin)
+		api.POST("/groups/:id/moderators", groupController.AddModerator)
+		api.DELETE("/groups/:id/moderators/:user_id", groupController.RemoveModerator)
+		api.POST("/groups/:id/transfer-ownership", groupController.TransferOwnership)
+		api.GET("/groups/:id/members", groupController.GetGroupMembers)
+		api.POST("/groups/:id/leave", groupController.LeaveGroup)
+		api.POST("/groups/:id/invites", groupController.CreateInvite)
+		api.GET("/groups/:id/invites", groupController.ListInvites)
+		api.DELETE("/groups/:id/invites/:invite_id", groupController.RevokeInvite)
+		api.GET("/groups/join/:token", groupController.PreviewInvite)
+		api.POST("/groups/join/:token", groupController.JoinWithInvite)
+		api.GET("/groups/:id/join-requests", groupController.ListJoinRequests)
+		api.POST("/groups/:id/join-requests/:request_id/approve", groupController.ApproveJoinRequest)
+		api.POST("/groups/:id/join-requests/:request_id/reject", groupController.RejectJoinRequest)
+		api.GET("/groups/:id/pins", groupController.ListPinnedMessages)
+		api.POST("/groups/:id/pins/:message_id", groupController.PinMessage)
+		api.DELETE("/groups/:id/pins/:message_id", groupController.UnpinMessage)
 		api.GET("/users/me/groups", groupController.GetUserGroups)
 
 		// Friendship endpoints
@@ -189,14 +480,27 @@ func main() {
 		api.POST("/friendships/requests/:id/respond", friendshipController.RespondToRequest)
 		api.GET("/friendships", friendshipController.ListFriendships)
 		api.GET("/friendships/check", friendshipController.CheckFriendship)
+		api.GET("/friendships/friends", userController.ListFriends)
+		api.GET("/friendships/status/:user_id", friendshipController.GetFriendshipStatus)
+		api.GET("/friendships/suggestions", friendshipController.GetSuggestions)
 		api.DELETE("/friendships/:id", friendshipController.Unfriend)
 		api.POST("/friendships/block/:user_id", friendshipController.BlockUser)
 		api.DELETE("/friendships/block/:user_id", friendshipController.UnblockUser)
 		api.GET("/friendships/block/:user_id/status", friendshipController.IsBlocked)
 		api.GET("/friendships/blocked", friendshipController.GetBlockedUsers)
+
+		// Announcement endpoints
+		api.GET("/announcements/active", announcementController.GetActive)
+		api.POST("/announcements/:id/dismiss", announcementController.Dismiss)
+
+		// Admin endpoints
+		admin := api.Group("/admin", middleware.RequireRole(models.RoleAdmin))
+		admin.POST("/broadcast", announcementController.Broadcast)
+		admin.GET("/flagged-messages", messageController.GetFlaggedMessages)
 	}
 
-	webSocketRouter.GET("/ws", authMiddleware, func(c *gin.Context) {
+	wsAuthMiddleware := middleware.WSJwtAuthMiddleware(cfg.JWTSecret, redisClient.GetClient(), userRepo, cfg.WSAllowTokenAuth)
+	webSocketRouter.GET("/ws", wsAuthMiddleware, func(c *gin.Context) {
 		// Track WebSocket connection
 		config.IncWebsocketConnections(metrics)
 		defer config.DecWebsocketConnections(metrics)
@@ -238,9 +542,25 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
+	// Stop consuming and wait for the final Kafka offsets to be committed
+	stopConsumers()
+	for _, done := range []chan struct{}{consumerDone, emailConsumerDone, linkPreviewConsumerDone, pushConsumerDone} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Println("Timed out waiting for Kafka consumer to stop")
+		}
+	}
+
+	// Close WebSocket connections with a restart code so clients reconnect
+	// calmly; the server's Shutdown doesn't touch hijacked connections
+	if err := hub.Shutdown(ctx); err != nil {
+		log.Printf("WebSocket hub shutdown error: %v", err)
+	}
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
@@ -249,5 +569,18 @@ func main() {
 		log.Printf("WebSocket server shutdown error: %v", err)
 	}
 
+	// Stop the remaining background work
+	stopBackground()
+	select {
+	case <-outboxRelayDone:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for the outbox relay to stop")
+	}
+	select {
+	case <-auditLogDone:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for the audit log to stop")
+	}
+
 	log.Println("Server exited properly")
 }
\ No newline at end of file
@@ -1,62 +1,437 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	MongoURI       string
-	MongoUser      string
-	MongoPassword  string
-	DBName         string
-	KafkaBrokers   []string
-	JWTSecret      string
-	ServerPort     string
-	KafkaTopic     string
-	WebSocketPort  string
-	RedisURLs      []string
-	RedisPass      string
-	AccessTokenTTL time.Duration
+	MongoURI        string
+	MongoUser       string
+	MongoPassword   string
+	DBName          string
+	KafkaBrokers    []string
+	JWTSecret       string
+	ServerPort      string
+	KafkaTopic      string
+	WebSocketPort   string
+	RedisURLs       []string
+	RedisPass       string
+	AccessTokenTTL  time.Duration
 	RefreshTokenTTL time.Duration
-	PrometheusPort string
+	PrometheusPort  string
+
+	// Browser origins allowed to call the API and open WebSockets; "*" allows any
+	AllowedOrigins []string
+	// How long shutdown waits for consumers and connections to finish
+	ShutdownTimeout time.Duration
+
+	// Applied to every MongoDB operation without its own deadline
+	MongoOperationTimeout time.Duration
+	MongoSocketTimeout    time.Duration
+	MongoMaxPoolSize      int64
+	// Rebuild indexes whose options changed instead of keeping the old ones
+	IndexMigrate bool
+
+	// Rate limits, requests per RateLimitWindow
+	LoginRateLimit     int
+	MessageRateLimit   int
+	DiscoveryRateLimit int
+	WSTicketRateLimit  int
+	// Password reset emails per address
+	PasswordResetRateLimit int
+	RateLimitWindow        time.Duration
+
+	// Largest frame accepted from a WebSocket client, and largest REST
+	// request body outside of uploads, in bytes
+	WSMaxMessageSize   int64
+	MaxRequestBodySize int64
+	// Frames queued per WebSocket connection, and what happens to a client
+	// that falls further behind: "disconnect" or "drop_oldest"
+	WSSendBufferSize   int
+	WSSlowClientPolicy string
+	// Accept access tokens on the WebSocket endpoint as well as tickets;
+	// only until clients have moved to POST /api/auth/ws-ticket
+	WSAllowTokenAuth bool
+	// Longest message content accepted, in characters
+	MaxMessageLength int
+
+	// Message content filtering: ContentFilterPolicy is "off", "flag" (keep
+	// the message but queue it for moderators) or "block" (reject it). The
+	// words and patterns are read from ContentFilterFile, reloaded on SIGHUP;
+	// ContentFilterSubstitutions are "from=to" character pairs undone before
+	// matching.
+	ContentFilterPolicy        string
+	ContentFilterFile          string
+	ContentFilterSubstitutions []string
+
+	// Media uploads
+	MediaStorageDir   string
+	MediaBaseURL      string
+	MediaSigningKey   string
+	MediaUploadURLTTL time.Duration
+	MediaMaxSizes     map[string]int64
+	MediaAllowedTypes map[string][]string
+	AvatarMaxSize     int64
+
+	// Data exports are kept privately in ExportStorageDir and downloaded
+	// through signed links valid for ExportLinkTTL
+	ExportStorageDir string
+	ExportLinkTTL    time.Duration
+
+	// How long a deactivated account can be reactivated before it is anonymized
+	AccountReactivationGrace time.Duration
+
+	// How long a deletion request can be cancelled by logging in, and whether
+	// the deleted user's messages are kept ("keep") or tombstoned ("tombstone")
+	AccountDeletionGrace         time.Duration
+	AccountDeletionContentPolicy string
+
+	// Outgoing email. Without an SMTP host emails are only logged.
+	EmailTopic           string
+	EmailVerificationURL string
+	PasswordResetURL     string
+	SMTPHost             string
+	SMTPPort             string
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPFrom             string
+
+	// Link previews are generated in the background from LinkPreviewTopic and
+	// fetched again once older than LinkPreviewTTL
+	LinkPreviewTopic   string
+	LinkPreviewTimeout time.Duration
+	LinkPreviewTTL     time.Duration
+
+	// Kafka events are stored in an outbox first; one instance relays the
+	// ones that couldn't be published right away every OutboxRelayInterval
+	OutboxRelayInterval time.Duration
+
+	// Push notifications for offline users are queued on PushTopic. Without
+	// FCM credentials they are only logged.
+	PushTopic          string
+	FCMCredentialsFile string
+
+	// Log output: LogFormat is "json" or "console", LogLevel one of debug,
+	// info, warn and error
+	LogFormat string
+	LogLevel  string
+
+	// Two-factor authentication; secrets are encrypted with TwoFactorEncryptionKey
+	TwoFactorIssuer        string
+	TwoFactorEncryptionKey string
 }
 
+// LoadConfig loads the configuration from the environment and the optional
+// CONFIG_FILE, exiting when it is invalid
 func LoadConfig() *Config {
+	cfg, err := Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// Load reads the configuration. Environment variables (and .env) take
+// precedence over the YAML or JSON file at path, keyed by the same variable
+// names, which takes precedence over the defaults. Every missing or invalid
+// setting is reported in the error, not just the first.
+func Load(path string) (*Config, error) {
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Println("Using environment variables directly")
 	}
 
-	accessTTL, _ := strconv.Atoi(getEnv("ACCESS_TOKEN_TTL", "15"))
-	refreshTTL, _ := strconv.Atoi(getEnv("REFRESH_TOKEN_TTL", "7"))
+	l := &loader{lookup: os.LookupEnv}
+	if path != "" {
+		if l.file, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+	cfg := l.load()
+	if err := cfg.Validate(l.problems...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (l *loader) load() *Config {
+	jwtSecret := l.str("JWT_SECRET", "very-secret-key")
 
 	return &Config{
-		MongoURI:       getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		MongoUser:      getEnv("MONGO_USER", ""),
-		MongoPassword:  getEnv("MONGO_PASSWORD", ""),
-		DBName:         getEnv("DB_NAME", "messaging_app"),
-		KafkaBrokers:   strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ","),
-		JWTSecret:      getEnv("JWT_SECRET", "very-secret-key"),
-		ServerPort:     getEnv("SERVER_PORT", "8080"),
-		KafkaTopic:     getEnv("KAFKA_TOPIC", "messages"),
-		WebSocketPort:  getEnv("WS_PORT", "8081"),
-		RedisURLs:      strings.Split(getEnv("REDIS_URL", "localhost:6379"), ","),
-		RedisPass:      getEnv("REDIS_PASS", ""),
-		AccessTokenTTL: time.Minute * time.Duration(accessTTL),
-		RefreshTokenTTL: time.Hour * 24 * time.Duration(refreshTTL),
-		PrometheusPort: getEnv("PROMETHEUS_PORT", "9091"),
-	}
-}
-
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
+		MongoURI:        l.str("MONGO_URI", "mongodb://localhost:27017"),
+		MongoUser:       l.str("MONGO_USER", ""),
+		MongoPassword:   l.str("MONGO_PASSWORD", ""),
+		DBName:          l.str("DB_NAME", "messaging_app"),
+		KafkaBrokers:    l.list("KAFKA_BROKERS", "localhost:9092"),
+		JWTSecret:       jwtSecret,
+		ServerPort:      l.str("SERVER_PORT", "8080"),
+		KafkaTopic:      l.str("KAFKA_TOPIC", "messages"),
+		WebSocketPort:   l.str("WS_PORT", "8081"),
+		RedisURLs:       l.list("REDIS_URL", "localhost:6379"),
+		RedisPass:       l.str("REDIS_PASS", ""),
+		AccessTokenTTL:  time.Minute * l.duration("ACCESS_TOKEN_TTL", 15),
+		RefreshTokenTTL: time.Hour * 24 * l.duration("REFRESH_TOKEN_TTL", 7),
+		PrometheusPort:  l.str("PROMETHEUS_PORT", "9091"),
+
+		AllowedOrigins:  l.list("ALLOWED_ORIGINS", "*"),
+		ShutdownTimeout: time.Second * l.duration("SHUTDOWN_TIMEOUT", 10),
+
+		MongoOperationTimeout: time.Second * l.duration("MONGO_OPERATION_TIMEOUT", 10),
+		MongoSocketTimeout:    time.Second * l.duration("MONGO_SOCKET_TIMEOUT", 10),
+		MongoMaxPoolSize:      l.int64("MONGO_MAX_POOL_SIZE", 100),
+		IndexMigrate:          l.str("INDEX_MIGRATE", "false") == "true",
+
+		WSMaxMessageSize:   l.int64("WS_MAX_MESSAGE_SIZE", 16384),
+		MaxRequestBodySize: l.int64("MAX_REQUEST_BODY_SIZE", 1<<20),
+		WSSendBufferSize:   l.int("WS_SEND_BUFFER_SIZE", 256),
+		WSSlowClientPolicy: l.str("WS_SLOW_CLIENT_POLICY", "disconnect"),
+		WSAllowTokenAuth:   l.str("WS_ALLOW_TOKEN_AUTH", "true") == "true",
+		MaxMessageLength:   l.int("MAX_MESSAGE_LENGTH", 4000),
+
+		ContentFilterPolicy:        l.str("CONTENT_FILTER_POLICY", "off"),
+		ContentFilterFile:          l.str("CONTENT_FILTER_FILE", ""),
+		ContentFilterSubstitutions: l.list("CONTENT_FILTER_SUBSTITUTIONS", "0=o,1=i,3=e,4=a,5=s,7=t,@=a,$=s"),
+
+		LoginRateLimit:         l.int("RATE_LIMIT_LOGIN", 5),
+		MessageRateLimit:       l.int("RATE_LIMIT_MESSAGES", 30),
+		DiscoveryRateLimit:     l.int("RATE_LIMIT_DISCOVERY", 3),
+		WSTicketRateLimit:      l.int("RATE_LIMIT_WS_TICKET", 10),
+		PasswordResetRateLimit: l.int("RATE_LIMIT_PASSWORD_RESET", 3),
+		RateLimitWindow:        time.Minute,
+
+		MediaStorageDir:   l.str("MEDIA_STORAGE_DIR", "./uploads"),
+		MediaBaseURL:      l.str("MEDIA_BASE_URL", "http://localhost:8080"),
+		MediaSigningKey:   l.str("MEDIA_SIGNING_KEY", jwtSecret),
+		MediaUploadURLTTL: time.Minute * l.duration("MEDIA_UPLOAD_URL_TTL", 15),
+		MediaMaxSizes: map[string]int64{
+			"image": l.int64("MEDIA_MAX_IMAGE_SIZE", 10<<20),
+			"video": l.int64("MEDIA_MAX_VIDEO_SIZE", 100<<20),
+			"file":  l.int64("MEDIA_MAX_FILE_SIZE", 25<<20),
+		},
+		MediaAllowedTypes: map[string][]string{
+			"image": l.list("MEDIA_IMAGE_TYPES", "image/jpeg,image/png,image/gif,image/webp"),
+			"video": l.list("MEDIA_VIDEO_TYPES", "video/mp4,video/webm"),
+			"file":  l.list("MEDIA_FILE_TYPES", "application/pdf,application/zip,text/plain,application/octet-stream"),
+		},
+		AvatarMaxSize: l.int64("AVATAR_MAX_SIZE", 5<<20),
+
+		ExportStorageDir: l.str("EXPORT_STORAGE_DIR", "./exports"),
+		ExportLinkTTL:    time.Hour * l.duration("EXPORT_LINK_TTL_HOURS", 48),
+
+		AccountReactivationGrace: time.Hour * 24 * l.duration("ACCOUNT_REACTIVATION_DAYS", 30),
+
+		AccountDeletionGrace:         time.Hour * 24 * l.duration("ACCOUNT_DELETION_DAYS", 14),
+		AccountDeletionContentPolicy: l.str("ACCOUNT_DELETION_CONTENT_POLICY", "keep"),
+
+		EmailTopic:           l.str("EMAIL_TOPIC", "emails"),
+		EmailVerificationURL: l.str("EMAIL_VERIFICATION_URL", "http://localhost:8080/api/auth/verify-email"),
+		PasswordResetURL:     l.str("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		SMTPHost:             l.str("SMTP_HOST", ""),
+		SMTPPort:             l.str("SMTP_PORT", "587"),
+		SMTPUsername:         l.str("SMTP_USERNAME", ""),
+		SMTPPassword:         l.str("SMTP_PASSWORD", ""),
+		SMTPFrom:             l.str("SMTP_FROM", "no-reply@localhost"),
+
+		LogFormat: l.str("LOG_FORMAT", "console"),
+		LogLevel:  l.str("LOG_LEVEL", "info"),
+
+		PushTopic:          l.str("PUSH_TOPIC", "push_notifications"),
+		FCMCredentialsFile: l.str("FCM_CREDENTIALS_FILE", ""),
+
+		LinkPreviewTopic:   l.str("LINK_PREVIEW_TOPIC", "link_previews"),
+		LinkPreviewTimeout: time.Second * l.duration("LINK_PREVIEW_TIMEOUT", 5),
+		LinkPreviewTTL:     time.Hour * l.duration("LINK_PREVIEW_TTL_HOURS", 24),
+
+		OutboxRelayInterval: time.Second * l.duration("OUTBOX_RELAY_INTERVAL", 2),
+
+		TwoFactorIssuer:        l.str("TWO_FACTOR_ISSUER", "MessagingApp"),
+		TwoFactorEncryptionKey: l.str("TWO_FACTOR_ENCRYPTION_KEY", jwtSecret),
+	}
+}
+
+// Validate checks the settings the server can't start without, reporting
+// every problem found along with any passed in
+func (c *Config) Validate(problems ...string) error {
+	required := map[string]string{
+		"MONGO_URI":   c.MongoURI,
+		"DB_NAME":     c.DBName,
+		"JWT_SECRET":  c.JWTSecret,
+		"KAFKA_TOPIC": c.KafkaTopic,
+	}
+	for _, name := range sortedKeys(required) {
+		if required[name] == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+	if len(c.KafkaBrokers) == 0 {
+		problems = append(problems, "KAFKA_BROKERS is required")
+	}
+	if len(c.RedisURLs) == 0 {
+		problems = append(problems, "REDIS_URL is required")
+	}
+	if len(c.AllowedOrigins) == 0 {
+		problems = append(problems, `ALLOWED_ORIGINS is required, use "*" to allow any origin`)
+	}
+
+	ports := map[string]string{
+		"SERVER_PORT":     c.ServerPort,
+		"WS_PORT":         c.WebSocketPort,
+		"PROMETHEUS_PORT": c.PrometheusPort,
+	}
+	for _, name := range sortedKeys(ports) {
+		if port, err := strconv.Atoi(ports[name]); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a valid port", name, ports[name]))
+		}
+	}
+
+	positive := map[string]int64{
+		"ACCESS_TOKEN_TTL":          int64(c.AccessTokenTTL),
+		"REFRESH_TOKEN_TTL":         int64(c.RefreshTokenTTL),
+		"SHUTDOWN_TIMEOUT":          int64(c.ShutdownTimeout),
+		"MONGO_OPERATION_TIMEOUT":   int64(c.MongoOperationTimeout),
+		"MONGO_SOCKET_TIMEOUT":      int64(c.MongoSocketTimeout),
+		"MONGO_MAX_POOL_SIZE":       c.MongoMaxPoolSize,
+		"WS_MAX_MESSAGE_SIZE":       c.WSMaxMessageSize,
+		"MAX_REQUEST_BODY_SIZE":     c.MaxRequestBodySize,
+		"WS_SEND_BUFFER_SIZE":       int64(c.WSSendBufferSize),
+		"MAX_MESSAGE_LENGTH":        int64(c.MaxMessageLength),
+		"RATE_LIMIT_LOGIN":          int64(c.LoginRateLimit),
+		"RATE_LIMIT_MESSAGES":       int64(c.MessageRateLimit),
+		"RATE_LIMIT_DISCOVERY":      int64(c.DiscoveryRateLimit),
+		"RATE_LIMIT_WS_TICKET":      int64(c.WSTicketRateLimit),
+		"RATE_LIMIT_PASSWORD_RESET": int64(c.PasswordResetRateLimit),
+		"OUTBOX_RELAY_INTERVAL":     int64(c.OutboxRelayInterval),
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			problems = append(problems, name+" must be greater than zero")
+		}
+	}
+
+	if c.AccountDeletionContentPolicy != "keep" && c.AccountDeletionContentPolicy != "tombstone" {
+		problems = append(problems, fmt.Sprintf("ACCOUNT_DELETION_CONTENT_POLICY: %q must be keep or tombstone", c.AccountDeletionContentPolicy))
+	}
+	if c.WSSlowClientPolicy != "disconnect" && c.WSSlowClientPolicy != "drop_oldest" {
+		problems = append(problems, fmt.Sprintf("WS_SLOW_CLIENT_POLICY: %q must be disconnect or drop_oldest", c.WSSlowClientPolicy))
+	}
+	switch c.ContentFilterPolicy {
+	case "off":
+	case "flag", "block":
+		if c.ContentFilterFile == "" {
+			problems = append(problems, "CONTENT_FILTER_FILE is required when CONTENT_FILTER_POLICY is "+c.ContentFilterPolicy)
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("CONTENT_FILTER_POLICY: %q must be off, flag or block", c.ContentFilterPolicy))
+	}
+	if c.LogFormat != "console" && c.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT: %q must be console or json", c.LogFormat))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL: %q must be debug, info, warn or error", c.LogLevel))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// loader reads settings by variable name, collecting the values it can't parse
+type loader struct {
+	lookup   func(string) (string, bool)
+	file     map[string]string
+	problems []string
+}
+
+func (l *loader) str(key, defaultValue string) string {
+	if value, exists := l.lookup(key); exists {
+		return value
+	}
+	if value, exists := l.file[key]; exists {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func (l *loader) int64(key string, defaultValue int64) int64 {
+	raw := l.str(key, "")
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s: %q is not a whole number", key, raw))
+		return defaultValue
+	}
+	return value
+}
+
+func (l *loader) int(key string, defaultValue int) int {
+	return int(l.int64(key, int64(defaultValue)))
+}
+
+// duration reads a count of whatever unit the caller multiplies it by
+func (l *loader) duration(key string, defaultValue int64) time.Duration {
+	return time.Duration(l.int64(key, defaultValue))
+}
+
+func (l *loader) list(key, defaultValue string) []string {
+	var out []string
+	for _, item := range strings.Split(l.str(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// readConfigFile reads a flat YAML or JSON object of variable names to
+// values. Lists may be given as arrays.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("parsing config file %s: %s must be a value or a list", path, key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLoader(env, file map[string]string) *loader {
+	return &loader{
+		lookup: func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		},
+		file: file,
+	}
+}
+
+func TestLoaderPrecedenceAndParsing(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		file  map[string]string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.MongoMaxPoolSize != 100 || cfg.MongoSocketTimeout != 10*time.Second {
+					t.Errorf("mongo pool = %d, socket timeout = %v", cfg.MongoMaxPoolSize, cfg.MongoSocketTimeout)
+				}
+				if !reflect.DeepEqual(cfg.AllowedOrigins, []string{"*"}) {
+					t.Errorf("allowed origins = %v", cfg.AllowedOrigins)
+				}
+				if cfg.ShutdownTimeout != 10*time.Second || cfg.WSMaxMessageSize != 16384 {
+					t.Errorf("shutdown timeout = %v, ws max message size = %d", cfg.ShutdownTimeout, cfg.WSMaxMessageSize)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: map[string]string{"MONGO_MAX_POOL_SIZE": "20", "ALLOWED_ORIGINS": "https://staging.example.com"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.MongoMaxPoolSize != 20 {
+					t.Errorf("mongo pool = %d, want 20", cfg.MongoMaxPoolSize)
+				}
+				if !reflect.DeepEqual(cfg.AllowedOrigins, []string{"https://staging.example.com"}) {
+					t.Errorf("allowed origins = %v", cfg.AllowedOrigins)
+				}
+			},
+		},
+		{
+			name: "environment overrides file",
+			env:  map[string]string{"SHUTDOWN_TIMEOUT": "30", "ALLOWED_ORIGINS": "https://a.example.com, https://b.example.com"},
+			file: map[string]string{"SHUTDOWN_TIMEOUT": "5", "ALLOWED_ORIGINS": "https://c.example.com"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.ShutdownTimeout != 30*time.Second {
+					t.Errorf("shutdown timeout = %v, want 30s", cfg.ShutdownTimeout)
+				}
+				want := []string{"https://a.example.com", "https://b.example.com"}
+				if !reflect.DeepEqual(cfg.AllowedOrigins, want) {
+					t.Errorf("allowed origins = %v, want %v", cfg.AllowedOrigins, want)
+				}
+			},
+		},
+		{
+			name: "keys derived from the JWT secret follow it",
+			env:  map[string]string{"JWT_SECRET": "s3cret"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.MediaSigningKey != "s3cret" || cfg.TwoFactorEncryptionKey != "s3cret" {
+					t.Errorf("signing key = %q, 2FA key = %q", cfg.MediaSigningKey, cfg.TwoFactorEncryptionKey)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := testLoader(tt.env, tt.file)
+			cfg := l.load()
+			if err := cfg.Validate(l.problems...); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "unparseable numbers",
+			env:  map[string]string{"MONGO_MAX_POOL_SIZE": "lots", "WS_MAX_MESSAGE_SIZE": "8k"},
+			want: []string{`MONGO_MAX_POOL_SIZE: "lots" is not a whole number`, `WS_MAX_MESSAGE_SIZE: "8k" is not a whole number`},
+		},
+		{
+			name: "missing values",
+			env:  map[string]string{"MONGO_URI": "", "JWT_SECRET": "", "KAFKA_BROKERS": " , ", "ALLOWED_ORIGINS": ""},
+			want: []string{"MONGO_URI is required", "JWT_SECRET is required", "KAFKA_BROKERS is required", "ALLOWED_ORIGINS is required"},
+		},
+		{
+			name: "out of range",
+			env:  map[string]string{"SERVER_PORT": "70000", "WS_PORT": "ws", "SHUTDOWN_TIMEOUT": "0", "MONGO_MAX_POOL_SIZE": "-1"},
+			want: []string{`SERVER_PORT: "70000" is not a valid port`, `WS_PORT: "ws" is not a valid port`, "SHUTDOWN_TIMEOUT must be greater than zero", "MONGO_MAX_POOL_SIZE must be greater than zero"},
+		},
+		{
+			name: "unknown choices",
+			env:  map[string]string{"LOG_FORMAT": "xml", "LOG_LEVEL": "trace", "ACCOUNT_DELETION_CONTENT_POLICY": "purge", "WS_SLOW_CLIENT_POLICY": "block"},
+			want: []string{`LOG_FORMAT: "xml"`, `LOG_LEVEL: "trace"`, `ACCOUNT_DELETION_CONTENT_POLICY: "purge"`, `WS_SLOW_CLIENT_POLICY: "block"`},
+		},
+		{
+			name: "content filter without a list",
+			env:  map[string]string{"CONTENT_FILTER_POLICY": "block"},
+			want: []string{"CONTENT_FILTER_FILE is required when CONTENT_FILTER_POLICY is block"},
+		},
+		{
+			name: "unknown content filter policy",
+			env:  map[string]string{"CONTENT_FILTER_POLICY": "warn", "CONTENT_FILTER_FILE": "words.txt"},
+			want: []string{`CONTENT_FILTER_POLICY: "warn" must be off, flag or block`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := testLoader(tt.env, nil)
+			err := l.load().Validate(l.problems...)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "yaml",
+			content: "MONGO_MAX_POOL_SIZE: 50\nALLOWED_ORIGINS:\n  - https://a.example.com\n  - https://b.example.com\nINDEX_MIGRATE: true\n",
+			want: map[string]string{
+				"MONGO_MAX_POOL_SIZE": "50",
+				"ALLOWED_ORIGINS":     "https://a.example.com,https://b.example.com",
+				"INDEX_MIGRATE":       "true",
+			},
+		},
+		{
+			name:    "json",
+			content: `{"SERVER_PORT": "9000", "SHUTDOWN_TIMEOUT": 20}`,
+			want:    map[string]string{"SERVER_PORT": "9000", "SHUTDOWN_TIMEOUT": "20"},
+		},
+		{
+			name:    "nested objects are rejected",
+			content: "MONGO:\n  URI: mongodb://db\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			content: "{not json",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readConfigFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
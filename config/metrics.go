@@ -1,14 +1,17 @@
 package config
 
 import (
+	"context"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
 )
 
 type Metrics struct {
@@ -17,6 +20,8 @@ type Metrics struct {
 	WebsocketConnections prometheus.Gauge
 	KafkaMessages        *prometheus.CounterVec
 	HTTPErrors           *prometheus.CounterVec
+	MongoDuration        *prometheus.HistogramVec
+	MongoErrors          *prometheus.CounterVec
 }
 
 var (
@@ -63,6 +68,21 @@ func GetMetrics() *Metrics {
 				},
 				[]string{"method", "path", "status"},
 			),
+			MongoDuration: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    "messaging_mongo_operation_duration_seconds",
+					Help:    "Duration of MongoDB operations",
+					Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10},
+				},
+				[]string{"collection", "operation"},
+			),
+			MongoErrors: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "messaging_mongo_operation_errors_total",
+					Help: "Count of failed MongoDB operations",
+				},
+				[]string{"collection", "operation", "reason"}, // reason is "timeout" or "error"
+			),
 		}
 
 		prometheus.MustRegister(
@@ -71,6 +91,8 @@ func GetMetrics() *Metrics {
 			metricsInstance.WebsocketConnections,
 			metricsInstance.KafkaMessages,
 			metricsInstance.HTTPErrors,
+			metricsInstance.MongoDuration,
+			metricsInstance.MongoErrors,
 		)
 	})
 	return metricsInstance
@@ -138,4 +160,71 @@ func DecWebsocketConnections(metrics *Metrics) {
 // RecordKafkaMessage records a Kafka message in metrics
 func RecordKafkaMessage(metrics Metrics, topic, msgType string) {
 	metrics.KafkaMessages.WithLabelValues(topic, msgType).Inc()
-}
\ No newline at end of file
+}
+
+// mongoOperations maps driver command names to the operation label; other
+// commands (index builds, handshakes, ...) aren't recorded
+var mongoOperations = map[string]string{
+	"find":          "find",
+	"getMore":       "find",
+	"count":         "find",
+	"distinct":      "find",
+	"insert":        "insert",
+	"update":        "update",
+	"findAndModify": "update",
+	"delete":        "delete",
+	"aggregate":     "aggregate",
+}
+
+// MongoCommandMonitor records the duration and failures of every repository
+// operation. Install it with options.Client().SetMonitor.
+func MongoCommandMonitor(metrics *Metrics) *event.CommandMonitor {
+	// Collection names are only on the started event
+	var collections sync.Map
+
+	finished := func(requestID int64) (string, bool) {
+		collection, ok := collections.LoadAndDelete(requestID)
+		if !ok {
+			return "", false
+		}
+		return collection.(string), true
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			if _, ok := mongoOperations[evt.CommandName]; !ok {
+				return
+			}
+			field := evt.CommandName
+			if field == "getMore" {
+				field = "collection"
+			}
+			collection, ok := evt.Command.Lookup(field).StringValueOK()
+			if !ok {
+				return
+			}
+			collections.Store(evt.RequestID, collection)
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			collection, ok := finished(evt.RequestID)
+			if !ok {
+				return
+			}
+			metrics.MongoDuration.WithLabelValues(collection, mongoOperations[evt.CommandName]).Observe(evt.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			collection, ok := finished(evt.RequestID)
+			if !ok {
+				return
+			}
+			operation := mongoOperations[evt.CommandName]
+			metrics.MongoDuration.WithLabelValues(collection, operation).Observe(evt.Duration.Seconds())
+
+			reason := "error"
+			if failure := strings.ToLower(evt.Failure); strings.Contains(failure, "deadline") || strings.Contains(failure, "timeout") {
+				reason = "timeout"
+			}
+			metrics.MongoErrors.WithLabelValues(collection, operation, reason).Inc()
+		},
+	}
+}
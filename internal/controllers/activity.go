@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ActivityController struct {
+	auditLog *services.AuditLog
+}
+
+func NewActivityController(auditLog *services.AuditLog) *ActivityController {
+	return &ActivityController{auditLog: auditLog}
+}
+
+// @Summary List recent security activity
+// @Description Logins, failed logins, new devices, password and email changes and two-factor changes from the last 90 days, newest first
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Param type query string false "Comma-separated event types"
+// @Success 200 {object} models.AuditEventListResponse
+// @Failure 400 {object} gin.H
+// @Router /users/me/activity [get]
+func (c *ActivityController) ListActivity(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var types []string
+	if raw := ctx.Query("type"); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+	page, limit := pageParams(ctx)
+
+	response, err := c.auditLog.List(ctx.Request.Context(), userID, types, page, limit)
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"net/http"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AnnouncementController struct {
+	announcementService *services.AnnouncementService
+}
+
+func NewAnnouncementController(announcementService *services.AnnouncementService) *AnnouncementController {
+	return &AnnouncementController{announcementService: announcementService}
+}
+
+// @Summary Broadcast an announcement
+// @Description Announce something, such as a maintenance window, to every user (admins only). Connected clients receive a SystemAnnouncement event once it starts.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param announcement body models.AnnouncementRequest true "Announcement"
+// @Success 201 {object} models.Announcement
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} gin.H
+// @Router /admin/broadcast [post]
+func (c *AnnouncementController) Broadcast(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	var req models.AnnouncementRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.Error(apperrors.Validation(err.Error()))
+		return
+	}
+
+	announcement, err := c.announcementService.Broadcast(ctx.Request.Context(), userID, req)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, announcement)
+}
+
+// @Summary List active announcements
+// @Description List the announcements showing now that the current user hasn't dismissed, newest first
+// @Tags announcements
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.Announcement
+// @Router /announcements/active [get]
+func (c *AnnouncementController) GetActive(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	announcements, err := c.announcementService.GetActive(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, announcements)
+}
+
+// @Summary Dismiss an announcement
+// @Description Stop showing an announcement to the current user
+// @Tags announcements
+// @Security ApiKeyAuth
+// @Param id path string true "Announcement ID"
+// @Success 204
+// @Failure 404 {object} apperrors.Response
+// @Router /announcements/{id}/dismiss [post]
+func (c *AnnouncementController) Dismiss(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+	announcementID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid announcement ID"))
+		return
+	}
+
+	if err := c.announcementService.Dismiss(ctx.Request.Context(), userID, announcementID); err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
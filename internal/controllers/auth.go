@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"errors"
 	"messaging-app/internal/models"
 	"messaging-app/internal/services"
+	"messaging-app/pkg/logging"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AuthController struct {
@@ -17,13 +21,17 @@ func NewAuthController(authService *services.AuthService) *AuthController {
 }
 
 func (c *AuthController) Register(ctx *gin.Context) {
-	var user models.User
-	if err := ctx.ShouldBindJSON(&user); err != nil {
+	var req models.RegisterRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	response, err := c.authService.Register(ctx.Request.Context(), &user)
+	response, err := c.authService.Register(ctx.Request.Context(), &models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: req.Password,
+	})
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -45,7 +53,11 @@ func (c *AuthController) Login(ctx *gin.Context) {
 
 	response, err := c.authService.Login(ctx.Request.Context(), loginReq.Email, loginReq.Password)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		status := http.StatusUnauthorized
+		if errors.Is(err, services.ErrAccountDeactivated) {
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -78,4 +90,260 @@ func (c *AuthController) Logout(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
-}
\ No newline at end of file
+}
+
+// LogoutEverywhere ends every session of the current user, on all devices
+func (c *AuthController) LogoutEverywhere(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+
+	if err := c.authService.LogoutEverywhere(ctx.Request.Context(), userID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Successfully logged out everywhere"})
+}
+// IssueWSTicket hands out a short-lived ticket for opening a WebSocket
+func (c *AuthController) IssueWSTicket(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+
+	response, err := c.authService.IssueWSTicket(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *AuthController) Deactivate(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	tokenString := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+
+	if err := c.authService.DeactivateAccount(ctx.Request.Context(), userID, tokenString); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrAccountDeactivated) {
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Account deactivated"})
+}
+
+// DeleteAccount schedules the permanent deletion of the current account
+func (c *AuthController) DeleteAccount(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	tokenString := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+
+	var req models.DeleteAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dueAt, err := c.authService.ScheduleDeletion(ctx.Request.Context(), userID, tokenString, req.Password)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrIncorrectPassword):
+			status = http.StatusUnauthorized
+		case errors.Is(err, services.ErrAccountDeactivated):
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message":         "Account scheduled for deletion",
+		"deletion_due_at": dueAt,
+	})
+}
+
+func (c *AuthController) Reactivate(ctx *gin.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := c.authService.ReactivateAccount(ctx.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, services.ErrAccountNotDeactivated):
+			status = http.StatusConflict
+		case errors.Is(err, services.ErrReactivationExpired):
+			status = http.StatusGone
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *AuthController) VerifyEmail(ctx *gin.Context) {
+	if err := c.authService.VerifyEmail(ctx.Request.Context(), ctx.Query("token")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+func (c *AuthController) ResendVerificationEmail(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if err := c.authService.SendVerificationEmail(ctx.Request.Context(), userID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrEmailAlreadyVerified) {
+			status = http.StatusConflict
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or not
+// the address belongs to an account.
+func (c *AuthController) ForgotPassword(ctx *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.RequestPasswordReset(ctx.Request.Context(), req.Email); err != nil {
+		logging.FromContext(ctx.Request.Context()).Error("Failed to send password reset email", "error", err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "If the address belongs to an account, a reset link has been sent"})
+}
+
+func (c *AuthController) ResetPassword(ctx *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.ResetPassword(ctx.Request.Context(), req.Token, req.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidResetToken) || errors.Is(err, services.ErrWeakPassword) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Password reset"})
+}
+
+// twoFactorErrorStatus maps two-factor errors to HTTP statuses
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode), errors.Is(err, services.ErrInvalidChallenge):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrEmailNotVerified):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled), errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorNotSetUp):
+		return http.StatusConflict
+	case strings.HasPrefix(err.Error(), "invalid credentials"):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (c *AuthController) CompleteTwoFactorLogin(ctx *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := c.authService.CompleteTwoFactorLogin(ctx.Request.Context(), req.ChallengeToken, req.Code)
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *AuthController) SetupTwoFactor(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	response, err := c.authService.SetupTwoFactor(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *AuthController) EnableTwoFactor(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := c.authService.EnableTwoFactor(ctx.Request.Context(), userID, req.Code)
+	if err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (c *AuthController) DisableTwoFactor(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.TwoFactorDisableRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.DisableTwoFactor(ctx.Request.Context(), userID, req.Password, req.Code); err != nil {
+		ctx.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
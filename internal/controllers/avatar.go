@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"messaging-app/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Room for the multipart headers around the file itself
+const multipartOverhead = 64 << 10
+
+type AvatarController struct {
+	avatarService *services.AvatarService
+}
+
+func NewAvatarController(avatarService *services.AvatarService) *AvatarController {
+	return &AvatarController{avatarService: avatarService}
+}
+
+// @Summary Upload an avatar
+// @Description Upload a JPEG, PNG or GIF as the "avatar" form field. The image is cropped to a square and stored in several sizes.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} models.AvatarResponse
+// @Failure 400 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Failure 415 {object} gin.H
+// @Router /users/me/avatar [post]
+func (c *AvatarController) UploadAvatar(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, c.avatarService.MaxSize()+multipartOverhead)
+	header, err := ctx.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrMediaTooLarge.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "avatar file is required"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+
+	resp, err := c.avatarService.UploadAvatar(ctx.Request.Context(), userID, file)
+	if err != nil {
+		ctx.JSON(mediaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resp)
+}
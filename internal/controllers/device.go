@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeviceController struct {
+	pushService *services.PushService
+}
+
+func NewDeviceController(pushService *services.PushService) *DeviceController {
+	return &DeviceController{pushService: pushService}
+}
+
+// @Summary Register a device for push notifications
+// @Description The device receives a notification when a message arrives while the user has no WebSocket connection. Registering a token again moves it to the current user.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param device body models.DeviceRequest true "Platform and push token"
+// @Success 200 {object} models.Device
+// @Failure 400 {object} gin.H
+// @Router /users/me/devices [post]
+func (c *DeviceController) RegisterDevice(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.DeviceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	device, err := c.pushService.RegisterDevice(ctx.Request.Context(), userID, req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, device)
+}
+
+// @Summary Unregister a push device
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param device body models.DeviceDeleteRequest true "Push token"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /users/me/devices [delete]
+func (c *DeviceController) UnregisterDevice(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.DeviceDeleteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.pushService.UnregisterDevice(ctx.Request.Context(), userID, req.Token); err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"net/http"
+
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ExportController struct {
+	exportService *services.ExportService
+}
+
+func NewExportController(exportService *services.ExportService) *ExportController {
+	return &ExportController{exportService: exportService}
+}
+
+// @Summary Export my data
+// @Description Start generating a zip archive of the current user's data. The user is emailed a download link when it is ready.
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 202 {object} models.DataExportJob
+// @Failure 409 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /users/me/export [post]
+func (c *ExportController) StartExport(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	job, err := c.exportService.StartExport(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// @Summary Get data export status
+// @Description Poll an export job; completed jobs include a time-limited download_url
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Param jobId path string true "Export job ID"
+// @Success 200 {object} models.DataExportJob
+// @Failure 400 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Router /users/me/export/{jobId} [get]
+func (c *ExportController) GetExport(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	jobID, err := primitive.ObjectIDFromHex(ctx.Param("jobId"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid export ID"))
+		return
+	}
+
+	job, err := c.exportService.GetExport(ctx.Request.Context(), userID, jobID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// @Summary Download a data export
+// @Description Download an export archive through its signed link
+// @Tags users
+// @Produce application/zip
+// @Param key path string true "Archive key"
+// @Param expires query string true "Link expiry (unix seconds)"
+// @Param signature query string true "Link signature"
+// @Success 200 {file} binary
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Router /downloads/{key} [get]
+func (c *ExportController) Download(ctx *gin.Context) {
+	key := ctx.Param("key")
+	archive, err := c.exportService.OpenDownload(ctx.Request.Context(), key, ctx.Query("expires"), ctx.Query("signature"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	defer archive.Close()
+
+	ctx.DataFromReader(http.StatusOK, -1, "application/zip", archive, map[string]string{
+		"Content-Disposition": `attachment; filename="` + key + `"`,
+	})
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"messaging-app/internal/models"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FollowController struct {
+	followService *services.FollowService
+}
+
+func NewFollowController(followService *services.FollowService) *FollowController {
+	return &FollowController{followService: followService}
+}
+
+// Follow godoc
+// @Summary Follow a user
+// @Description Following a private account sends a request and returns status "pending"
+// @Security BearerAuth
+// @Tags follows
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.FollowResponse
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/{id}/follow [post]
+func (c *FollowController) Follow(ctx *gin.Context) {
+	userID, targetID, ok := userAndTarget(ctx)
+	if !ok {
+		return
+	}
+
+	response, err := c.followService.Follow(ctx.Request.Context(), userID, targetID)
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Unfollow godoc
+// @Summary Unfollow a user or withdraw a follow request
+// @Security BearerAuth
+// @Tags follows
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/{id}/follow [delete]
+func (c *FollowController) Unfollow(ctx *gin.Context) {
+	userID, targetID, ok := userAndTarget(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.followService.Unfollow(ctx.Request.Context(), userID, targetID); err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListFollowers godoc
+// @Summary List a user's followers (paginated)
+// @Description A private account's followers are only visible to the account and its followers
+// @Security BearerAuth
+// @Tags follows
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Param q query string false "Username prefix"
+// @Success 200 {object} models.UserListResponse
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/{id}/followers [get]
+func (c *FollowController) ListFollowers(ctx *gin.Context) {
+	c.listFollows(ctx, c.followService.ListFollowers)
+}
+
+// ListFollowing godoc
+// @Summary List the accounts a user follows (paginated)
+// @Description A private account's list is only visible to the account and its followers
+// @Security BearerAuth
+// @Tags follows
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Param q query string false "Username prefix"
+// @Success 200 {object} models.UserListResponse
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/{id}/following [get]
+func (c *FollowController) ListFollowing(ctx *gin.Context) {
+	c.listFollows(ctx, c.followService.ListFollowing)
+}
+
+// ListFollowRequests godoc
+// @Summary List pending requests to follow the current user
+// @Security BearerAuth
+// @Tags follows
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {object} models.UserListResponse
+// @Failure 400 {object} gin.H
+// @Router /api/users/me/follow-requests [get]
+func (c *FollowController) ListFollowRequests(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	page, limit := pageParams(ctx)
+	response, err := c.followService.ListFollowRequests(ctx.Request.Context(), userID, page, limit)
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AcceptFollowRequest godoc
+// @Summary Accept a request to follow the current user
+// @Security BearerAuth
+// @Tags follows
+// @Param id path string true "Requesting user ID"
+// @Success 204
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/me/follow-requests/{id}/accept [post]
+func (c *FollowController) AcceptFollowRequest(ctx *gin.Context) {
+	c.respondToRequest(ctx, true)
+}
+
+// RejectFollowRequest godoc
+// @Summary Reject a request to follow the current user
+// @Security BearerAuth
+// @Tags follows
+// @Param id path string true "Requesting user ID"
+// @Success 204
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/me/follow-requests/{id} [delete]
+func (c *FollowController) RejectFollowRequest(ctx *gin.Context) {
+	c.respondToRequest(ctx, false)
+}
+
+func (c *FollowController) respondToRequest(ctx *gin.Context, accept bool) {
+	userID, followerID, ok := userAndTarget(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.followService.RespondToFollowRequest(ctx.Request.Context(), userID, followerID, accept); err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+type followListFunc func(ctx context.Context, viewerID, ownerID primitive.ObjectID, page, limit int64, prefix string) (*models.UserListResponse, error)
+
+func (c *FollowController) listFollows(ctx *gin.Context, list followListFunc) {
+	viewerID, ownerID, ok := userAndTarget(ctx)
+	if !ok {
+		return
+	}
+
+	page, limit := pageParams(ctx)
+	response, err := list(ctx.Request.Context(), viewerID, ownerID, page, limit, ctx.Query("q"))
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// userAndTarget parses the current user and the :id user, answering 400 when
+// either is invalid
+func userAndTarget(ctx *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	targetID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, targetID, true
+}
+
+// pageParams reads page and limit, falling back to the first page of 20
+func pageParams(ctx *gin.Context) (int64, int64) {
+	page, _ := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	return page, limit
+}
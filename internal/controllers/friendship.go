@@ -169,6 +169,41 @@ func (c *FriendshipController) ListFriendships(ctx *gin.Context) {
 	})
 }
 
+// @Summary Friend suggestions
+// @Description Get people the current user may know, ranked by mutual friends
+// @Tags friendships
+// @Produce json
+// @Param limit query int false "Max suggestions (max 50)" default(10)
+// @Success 200 {array} models.FriendSuggestion
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Router /friendships/suggestions [get]
+func (c *FriendshipController) GetSuggestions(ctx *gin.Context) {
+	uid, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "unauthenticated"})
+		return
+	}
+	currentUserID, err := primitive.ObjectIDFromHex(uid.(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+
+	suggestions, err := c.friendshipService.GetFriendSuggestions(ctx.Request.Context(), currentUserID, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load suggestions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, suggestions)
+}
+
 // @Summary Check friendship status
 // @Description Check if two users are friends
 // @Tags friendships
@@ -201,6 +236,42 @@ func (c *FriendshipController) CheckFriendship(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"are_friends": areFriends})
 }
 
+// @Summary Get detailed friendship status
+// @Description Get friendship, pending request and block state with another user in one call
+// @Tags friendships
+// @Produce json
+// @Param user_id path string true "Other user ID"
+// @Success 200 {object} models.FriendshipStatusResponse
+// @Failure 400 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /friendships/status/{user_id} [get]
+func (c *FriendshipController) GetFriendshipStatus(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	currentUserID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	otherUserID, err := primitive.ObjectIDFromHex(ctx.Param("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid other user ID"})
+		return
+	}
+
+	status, err := c.friendshipService.GetDetailedFriendshipStatus(ctx.Request.Context(), currentUserID, otherUserID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
 // @Summary Unfriend a user
 // @Description Remove a friendship between two users
 // @Tags friendships
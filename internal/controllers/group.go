@@ -2,11 +2,14 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"messaging-app/internal/models"
 	"messaging-app/internal/services"
 	"messaging-app/pkg/utils"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -31,16 +34,43 @@ type CreateGroupRequest struct {
 	MemberIDs []string `json:"member_ids" binding:"required,min=1,dive,objectid"`
 }
 
+// GroupResponse carries member/admin counts and only a preview of the
+// members; the full list is paginated through GET /groups/:id/members.
 type GroupResponse struct {
-	ID        primitive.ObjectID  `json:"id"`
-	Name      string              `json:"name"`
-	Creator   UserShortResponse   `json:"creator"`
-	Members   []UserShortResponse `json:"members"`
-	Admins    []UserShortResponse `json:"admins"`
-	CreatedAt time.Time           `json:"created_at"`
-	UpdatedAt time.Time           `json:"updated_at"`
+	ID          primitive.ObjectID  `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Avatar      string              `json:"avatar"`
+	Creator     UserShortResponse   `json:"creator"`
+	Owner       UserShortResponse   `json:"owner"`
+	MemberCount int                 `json:"member_count"`
+	AdminCount  int                 `json:"admin_count"`
+	Members     []UserShortResponse `json:"members"`
+	Admins      []UserShortResponse `json:"admins"`
+	Moderators  []UserShortResponse `json:"moderators"`
+	RequireApproval bool            `json:"require_approval"`
+	Settings    models.GroupSettings `json:"settings"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
 }
 
+type GroupMemberResponse struct {
+	UserShortResponse
+	IsAdmin bool   `json:"is_admin"`
+	Role    string `json:"role"`
+}
+
+type GroupMembersResponse struct {
+	Members []GroupMemberResponse `json:"members"`
+	Total   int64                 `json:"total"`
+	Page    int64                 `json:"page"`
+	Limit   int64                 `json:"limit"`
+	HasMore bool                  `json:"has_more"`
+}
+
+// Number of members embedded in GroupResponse
+const groupMemberPreviewSize = 5
+
 type UserShortResponse struct {
 	ID       primitive.ObjectID `json:"id"`
 	Username string             `json:"username"`
@@ -52,7 +82,17 @@ type AddMemberRequest struct {
 }
 
 type UpdateGroupRequest struct {
-	Name string `json:"name" binding:"omitempty,min=3,max=50"`
+	Name            string                     `json:"name" binding:"omitempty,min=3,max=50"`
+	Description     *string                    `json:"description" binding:"omitempty,max=500"`
+	Avatar          *string                    `json:"avatar" binding:"omitempty,max=2048"`
+	RequireApproval *bool                      `json:"require_approval"`
+	Settings        *UpdateGroupSettingsRequest `json:"settings"`
+}
+
+// UpdateGroupSettingsRequest changes only the settings it includes
+type UpdateGroupSettingsRequest struct {
+	AddMembers   *string `json:"add_members" binding:"omitempty,oneof=admins all"`
+	SendMessages *string `json:"send_messages" binding:"omitempty,oneof=admins all"`
 }
 
 // Handlers
@@ -233,6 +273,23 @@ func (c *GroupController) UpdateGroup(ctx *gin.Context) {
 	if req.Name != "" {
 		updates["name"] = req.Name
 	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Avatar != nil {
+		updates["avatar"] = *req.Avatar
+	}
+	if req.RequireApproval != nil {
+		updates["require_approval"] = *req.RequireApproval
+	}
+	if req.Settings != nil {
+		if req.Settings.AddMembers != nil {
+			updates["settings.add_members"] = *req.Settings.AddMembers
+		}
+		if req.Settings.SendMessages != nil {
+			updates["settings.send_messages"] = *req.Settings.SendMessages
+		}
+	}
 
 	if len(updates) == 0 {
 		utils.RespondWithError(ctx, http.StatusBadRequest, "No valid fields to update")
@@ -285,50 +342,474 @@ func (c *GroupController) GetUserGroups(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, responses)
 }
 
-// Helper methods
-func (c *GroupController) convertGroupToResponse(ctx context.Context, group *models.Group) (*GroupResponse, error) {
-	creator, err := c.userService.GetUserByID(ctx, group.CreatorID)
+func (c *GroupController) GetGroupMembers(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get creator details")
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
 	}
 
-	members := make([]UserShortResponse, len(group.Members))
-	for i, memberID := range group.Members {
-		user, err := c.userService.GetUserByID(ctx, memberID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get member details")
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	page, err := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	memberIDs, group, total, err := c.groupService.GetGroupMembers(ctx, groupID, userID, page, limit)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	users, err := c.lookupUsers(ctx, memberIDs)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusInternalServerError, "Failed to prepare response")
+		return
+	}
+
+	members := make([]GroupMemberResponse, len(memberIDs))
+	for i, id := range memberIDs {
+		members[i] = GroupMemberResponse{
+			UserShortResponse: users[id],
+			IsAdmin:           containsObjectID(group.Admins, id),
+			Role:              group.Role(id),
 		}
-		members[i] = UserShortResponse{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
+	}
+
+	ctx.JSON(http.StatusOK, GroupMembersResponse{
+		Members: members,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+		HasMore: page < (total+limit-1)/limit,
+	})
+}
+
+func (c *GroupController) LeaveGroup(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	if err := c.groupService.LeaveGroup(ctx, groupID, userID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// memberActionParams reads the requester, the group from :id and the target
+// user from the JSON body (bodyTarget) or the :user_id path param.
+func (c *GroupController) memberActionParams(ctx *gin.Context, bodyTarget bool) (requesterID, groupID, targetID primitive.ObjectID, ok bool) {
+	requesterID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err = primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	target := ctx.Param("user_id")
+	if bodyTarget {
+		var req AddMemberRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			utils.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+			return
 		}
+		target = req.UserID
+	}
+
+	targetID, err = primitive.ObjectIDFromHex(target)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid user ID format")
+		return
+	}
+	return requesterID, groupID, targetID, true
+}
+
+func (c *GroupController) TransferOwnership(ctx *gin.Context) {
+	requesterID, groupID, newOwnerID, ok := c.memberActionParams(ctx, true)
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.TransferOwnership(ctx, groupID, requesterID, newOwnerID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) RemoveAdmin(ctx *gin.Context) {
+	requesterID, groupID, adminID, ok := c.memberActionParams(ctx, false)
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.RemoveAdmin(ctx, groupID, requesterID, adminID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) AddModerator(ctx *gin.Context) {
+	requesterID, groupID, moderatorID, ok := c.memberActionParams(ctx, true)
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.AddModerator(ctx, groupID, requesterID, moderatorID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) RemoveModerator(ctx *gin.Context) {
+	requesterID, groupID, moderatorID, ok := c.memberActionParams(ctx, false)
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.RemoveModerator(ctx, groupID, requesterID, moderatorID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) DeleteGroup(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	if err := c.groupService.DeleteGroup(ctx, groupID, userID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) CreateInvite(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	var req models.CreateGroupInviteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.RespondWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invite, err := c.groupService.CreateInvite(ctx, groupID, userID, time.Duration(req.ExpiresInHours)*time.Hour, req.MaxUses)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, invite)
+}
+
+func (c *GroupController) ListInvites(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	invites, err := c.groupService.ListInvites(ctx, groupID, userID)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invites)
+}
+
+func (c *GroupController) RevokeInvite(ctx *gin.Context) {
+	requesterID, groupID, inviteID, ok := c.groupItemParams(ctx, "invite_id")
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.RevokeInvite(ctx, groupID, requesterID, inviteID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) PreviewInvite(ctx *gin.Context) {
+	preview, err := c.groupService.GetInvitePreview(ctx, ctx.Param("token"))
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, preview)
+}
+
+func (c *GroupController) JoinWithInvite(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	result, err := c.groupService.JoinWithInvite(ctx, ctx.Param("token"), userID)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if result.Status == models.JoinRequestPending {
+		status = http.StatusAccepted
+	}
+	ctx.JSON(status, result)
+}
+
+func (c *GroupController) ListJoinRequests(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	requests, err := c.groupService.ListJoinRequests(ctx, groupID, userID)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, requests)
+}
+
+func (c *GroupController) ApproveJoinRequest(ctx *gin.Context) {
+	c.resolveJoinRequest(ctx, true)
+}
+
+func (c *GroupController) RejectJoinRequest(ctx *gin.Context) {
+	c.resolveJoinRequest(ctx, false)
+}
+
+func (c *GroupController) resolveJoinRequest(ctx *gin.Context, approve bool) {
+	requesterID, groupID, requestID, ok := c.groupItemParams(ctx, "request_id")
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.ResolveJoinRequest(ctx, groupID, requesterID, requestID, approve); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// groupItemParams reads the requester, the group from :id and the ID of a
+// group sub-resource from the named path param
+func (c *GroupController) PinMessage(ctx *gin.Context) {
+	requesterID, groupID, messageID, ok := c.groupItemParams(ctx, "message_id")
+	if !ok {
+		return
+	}
+
+	pin, err := c.groupService.PinMessage(ctx, groupID, requesterID, messageID)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, pin)
+}
+
+func (c *GroupController) UnpinMessage(ctx *gin.Context) {
+	requesterID, groupID, messageID, ok := c.groupItemParams(ctx, "message_id")
+	if !ok {
+		return
+	}
+
+	if err := c.groupService.UnpinMessage(ctx, groupID, requesterID, messageID); err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *GroupController) ListPinnedMessages(ctx *gin.Context) {
+	userID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	pins, err := c.groupService.ListPinnedMessages(ctx, groupID, userID)
+	if err != nil {
+		utils.RespondWithError(ctx, utils.GetStatusCode(err), err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, pins)
+}
+
+func (c *GroupController) groupItemParams(ctx *gin.Context, param string) (requesterID, groupID, itemID primitive.ObjectID, ok bool) {
+	requesterID, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusUnauthorized, "Authentication required")
+		return
+	}
+
+	groupID, err = primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
+
+	itemID, err = primitive.ObjectIDFromHex(ctx.Param(param))
+	if err != nil {
+		utils.RespondWithError(ctx, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	return requesterID, groupID, itemID, true
+}
+
+// Helper methods
+func (c *GroupController) convertGroupToResponse(ctx context.Context, group *models.Group) (*GroupResponse, error) {
+	preview := group.Members
+	if len(preview) > groupMemberPreviewSize {
+		preview = preview[:groupMemberPreviewSize]
+	}
+
+	ids := append([]primitive.ObjectID{group.CreatorID, group.Owner()}, preview...)
+	ids = append(ids, group.Admins...)
+	ids = append(ids, group.Moderators...)
+	users, err := c.lookupUsers(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member details")
+	}
+
+	members := make([]UserShortResponse, len(preview))
+	for i, memberID := range preview {
+		members[i] = users[memberID]
 	}
 
 	admins := make([]UserShortResponse, len(group.Admins))
 	for i, adminID := range group.Admins {
-		user, err := c.userService.GetUserByID(ctx, adminID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get admin details")
-		}
-		admins[i] = UserShortResponse{
+		admins[i] = users[adminID]
+	}
+
+	moderators := make([]UserShortResponse, len(group.Moderators))
+	for i, moderatorID := range group.Moderators {
+		moderators[i] = users[moderatorID]
+	}
+
+	return &GroupResponse{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		Avatar:      group.Avatar,
+		Creator:     users[group.CreatorID],
+		Owner:       users[group.Owner()],
+		MemberCount: len(group.Members),
+		AdminCount:  len(group.Admins),
+		Members:     members,
+		Admins:      admins,
+		Moderators:  moderators,
+		RequireApproval: group.RequireApproval,
+		Settings:    group.Settings.WithDefaults(),
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}, nil
+}
+
+// lookupUsers resolves user summaries through the shared cache; users that
+// no longer exist get a placeholder entry.
+func (c *GroupController) lookupUsers(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]UserShortResponse, error) {
+	summaries, err := c.userService.GetUserSummaries(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[primitive.ObjectID]UserShortResponse, len(summaries))
+	for id, user := range summaries {
+		result[id] = UserShortResponse{
 			ID:       user.ID,
 			Username: user.Username,
 			Email:    user.Email,
 		}
 	}
+	return result, nil
+}
 
-	return &GroupResponse{
-		ID:        group.ID,
-		Name:      group.Name,
-		Creator: UserShortResponse{
-			ID:       creator.ID,
-			Username: creator.Username,
-			Email:    creator.Email,
-		},
-		Members:   members,
-		Admins:    admins,
-		CreatedAt: group.CreatedAt,
-		UpdatedAt: group.UpdatedAt,
-	}, nil
-}
\ No newline at end of file
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/services"
+	"messaging-app/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MediaController struct {
+	mediaService *services.MediaService
+}
+
+func NewMediaController(mediaService *services.MediaService) *MediaController {
+	return &MediaController{mediaService: mediaService}
+}
+
+// @Summary Presign a media upload
+// @Description Validate an attachment and get a time-limited upload URL
+// @Tags media
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.PresignRequest true "Attachment kind, MIME type and size"
+// @Success 201 {object} models.PresignResponse
+// @Failure 400 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Router /media/presign [post]
+func (c *MediaController) Presign(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	ownerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.PresignRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := c.mediaService.Presign(ctx.Request.Context(), ownerID, req)
+	if err != nil {
+		ctx.JSON(mediaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, resp)
+}
+
+// @Summary Upload media
+// @Description Upload callback for a presigned URL; the signature replaces authentication
+// @Tags media
+// @Accept octet-stream
+// @Produce json
+// @Param key path string true "Media key"
+// @Param expires query string true "Expiry timestamp"
+// @Param signature query string true "Upload signature"
+// @Success 200 {object} models.Media
+// @Failure 403 {object} gin.H
+// @Failure 413 {object} gin.H
+// @Router /media/upload/{key} [put]
+func (c *MediaController) Upload(ctx *gin.Context) {
+	media, err := c.mediaService.CompleteUpload(
+		ctx.Request.Context(),
+		ctx.Param("key"),
+		ctx.Query("expires"),
+		ctx.Query("signature"),
+		ctx.Request.Body,
+	)
+	if err != nil {
+		ctx.JSON(mediaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, media)
+}
+
+func mediaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUnsupportedMediaKind),
+		errors.Is(err, services.ErrAvatarDimensions),
+		errors.Is(err, storage.ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrMediaTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, services.ErrMediaTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrInvalidSignature),
+		errors.Is(err, storage.ErrUploadExpired):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrMediaNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
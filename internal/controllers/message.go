@@ -6,11 +6,18 @@ import (
 
 	"messaging-app/internal/models"
 	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Headers of idempotent create requests
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotency-Replayed"
+)
+
 type MessageController struct {
 	messageService *services.MessageService
 }
@@ -26,60 +33,41 @@ func NewMessageController(messageService *services.MessageService) *MessageContr
 // @Produce json
 // @Security ApiKeyAuth
 // @Param message body models.MessageRequest true "Message to send"
+// @Param Idempotency-Key header string false "Retries with the same key return the original message"
+// @Success 200 {object} models.Message "Replayed for a repeated Idempotency-Key"
 // @Success 201 {object} models.Message
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
 // @Router /messages [post]
 func (c *MessageController) SendMessage(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(string)
 	senderID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid user ID"})
+		ctx.Error(apperrors.Validation("invalid user ID"))
 		return
 	}
 
 	var req models.MessageRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
-		return
-	}
-
-	// Validate content
-	if req.Content == "" && len(req.MediaURLs) == 0 {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "message content or media URLs required"})
+		ctx.Error(apperrors.Validation(err.Error()))
 		return
 	}
 
-	// Validate content type
-	if !models.IsValidContentType(req.ContentType) {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid content type"})
+	idempotencyKey := ctx.GetHeader(IdempotencyKeyHeader)
+	message, replayed, err := c.messageService.SendMessageIdempotent(ctx.Request.Context(), senderID, idempotencyKey, req)
+	if err != nil {
+		ctx.Error(err)
 		return
 	}
 
-	// Validate that either receiverID or groupID is provided but not both
-	if req.ReceiverID == "" && req.GroupID == "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "either receiverID or groupID must be provided"})
-		return
+	if idempotencyKey != "" {
+		ctx.Header(IdempotencyReplayedHeader, strconv.FormatBool(replayed))
 	}
-	if req.ReceiverID != "" && req.GroupID != "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "cannot specify both receiverID and groupID"})
+	if replayed {
+		ctx.JSON(http.StatusOK, message)
 		return
 	}
-
-	message, err := c.messageService.SendMessage(ctx.Request.Context(), senderID, req)
-	if err != nil {
-		statusCode := http.StatusBadRequest
-		switch err.Error() {
-		case "not a group member", "can only message friends":
-			statusCode = http.StatusForbidden
-		case "group not found", "receiver not found":
-			statusCode = http.StatusNotFound
-		}
-		ctx.JSON(statusCode, models.ErrorResponse{Error: err.Error()})
-		return
-	}
-
 	ctx.JSON(http.StatusCreated, message)
 }
 
@@ -94,15 +82,17 @@ func (c *MessageController) SendMessage(ctx *gin.Context) {
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Messages per page" default(50)
 // @Param before query string false "Get messages before this timestamp (RFC3339)"
+// @Param threadID query string false "Only return replies to this message"
 // @Success 200 {object} models.MessageResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
 // @Router /messages [get]
 func (c *MessageController) GetMessages(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(string)
 	senderID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid user ID"})
+		ctx.Error(apperrors.Validation("invalid user ID"))
 		return
 	}
 
@@ -121,6 +111,7 @@ func (c *MessageController) GetMessages(ctx *gin.Context) {
 	groupID := ctx.Query("groupID")
 	receiverID := ctx.Query("receiverID")
 	before := ctx.Query("before")
+	threadID := ctx.Query("threadID")
 
 	query := models.MessageQuery{
 		SenderID:   senderID.Hex(),
@@ -129,28 +120,29 @@ func (c *MessageController) GetMessages(ctx *gin.Context) {
 		GroupID:    groupID,
 		ReceiverID: receiverID,
 		Before:     before,
+		ThreadID:   threadID,
 	}
 
 	// Validate the query
 	if groupID != "" && receiverID != "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "cannot specify both groupID and receiverID"})
+		ctx.Error(apperrors.Validation("cannot specify both groupID and receiverID"))
 		return
 	}
 	if groupID == "" && receiverID == "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "must specify either groupID or receiverID"})
+		ctx.Error(apperrors.Validation("must specify either groupID or receiverID"))
 		return
 	}
 
 	messages, err := c.messageService.GetAllMessages(ctx.Request.Context(), query)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		ctx.Error(err)
 		return
 	}
 
 	// Get total count for pagination
 	total, err := c.messageService.GetConversationMessageTotalCount(ctx.Request.Context(), query)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		ctx.Error(err)
 		return
 	}
 
@@ -165,6 +157,56 @@ func (c *MessageController) GetMessages(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// @Summary Search messages
+// @Description Search message content within one conversation, newest first
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param q query string true "Text to search for"
+// @Param groupID query string false "Group ID"
+// @Param receiverID query string false "Receiver ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Results per page" default(20)
+// @Success 200 {object} models.MessageSearchResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /messages/search [get]
+func (c *MessageController) SearchMessages(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	currentUserID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// Every match is returned with its neighbours, so pages stay small
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	response, err := c.messageService.SearchMessages(ctx.Request.Context(), currentUserID, models.MessageSearchQuery{
+		Query:      ctx.Query("q"),
+		GroupID:    ctx.Query("groupID"),
+		ReceiverID: ctx.Query("receiverID"),
+		Page:       page,
+		Limit:      limit,
+	})
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
 // @Summary Mark messages as seen
 // @Description Mark messages as seen by the current user
 // @Tags messages
@@ -173,25 +215,25 @@ func (c *MessageController) GetMessages(ctx *gin.Context) {
 // @Security ApiKeyAuth
 // @Param messageIDs body []string true "Array of message IDs to mark as seen"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
 // @Router /messages/seen [post]
 func (c *MessageController) MarkMessagesAsSeen(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(string)
 	currentUserID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid user ID"})
+		ctx.Error(apperrors.Validation("invalid user ID"))
 		return
 	}
 
 	var messageIDs []string
 	if err := ctx.ShouldBindJSON(&messageIDs); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		ctx.Error(apperrors.Validation(err.Error()))
 		return
 	}
 
 	if len(messageIDs) == 0 {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "at least one message ID required"})
+		ctx.Error(apperrors.Validation("at least one message ID required"))
 		return
 	}
 
@@ -200,7 +242,7 @@ func (c *MessageController) MarkMessagesAsSeen(ctx *gin.Context) {
 	for _, id := range messageIDs {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid message ID: " + id})
+			ctx.Error(apperrors.Validation("invalid message ID: " + id))
 			return
 		}
 		objectIDs = append(objectIDs, objID)
@@ -208,37 +250,94 @@ func (c *MessageController) MarkMessagesAsSeen(ctx *gin.Context) {
 
 	err = c.messageService.MarkMessagesAsSeen(ctx.Request.Context(), currentUserID, objectIDs)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		ctx.Error(err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
 }
 
+// @Summary List conversations
+// @Description List the current user's direct and group conversations with their last message and unread count, most recent first. Archived conversations are left out unless include_archived is set.
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Conversations per page" default(20)
+// @Param include_archived query bool false "Include archived conversations, flagged as archived"
+// @Success 200 {object} models.ConversationListResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /conversations [get]
+func (c *MessageController) GetConversations(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	currentUserID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	page, err := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	includeArchived, _ := strconv.ParseBool(ctx.Query("include_archived"))
+	response, err := c.messageService.GetConversations(ctx.Request.Context(), currentUserID, page, limit, includeArchived)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
 // @Summary Get unread message count
-// @Description Get count of unread messages for the current user
+// @Description Get count of unread messages, and of those mentioning the current user
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
+// @Param byConversation query bool false "Include the per-conversation unread breakdown"
+// @Param detailed query bool false "Include the per-conversation unread and mention breakdowns"
 // @Success 200 {object} models.UnreadCountResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} apperrors.Response
 // @Router /messages/unread [get]
 func (c *MessageController) GetUnreadCount(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(string)
 	currentUserID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid user ID"})
+		ctx.Error(apperrors.Validation("invalid user ID"))
 		return
 	}
 
-	count, err := c.messageService.GetUnreadCount(ctx.Request.Context(), currentUserID)
+	counts, err := c.messageService.GetUnreadCounts(ctx.Request.Context(), currentUserID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		ctx.Error(err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, models.UnreadCountResponse{Count: count})
+	response := models.UnreadCountResponse{}
+	for _, n := range counts.Messages {
+		response.Count += n
+	}
+	for _, n := range counts.Mentions {
+		response.Mentions += n
+	}
+	detailed, _ := strconv.ParseBool(ctx.Query("detailed"))
+	if byConversation, _ := strconv.ParseBool(ctx.Query("byConversation")); byConversation || detailed {
+		response.ByConversation = counts.Messages
+	}
+	if detailed {
+		response.MentionsByConversation = counts.Mentions
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
 
 // @Summary Delete a message
@@ -249,38 +348,302 @@ func (c *MessageController) GetUnreadCount(ctx *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Message ID"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
 // @Router /messages/{id} [delete]
 func (c *MessageController) DeleteMessage(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(string)
 	currentUserID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid user ID"})
+		ctx.Error(apperrors.Validation("invalid user ID"))
 		return
 	}
 
 	messageID := ctx.Param("id")
 	objID, err := primitive.ObjectIDFromHex(messageID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid message ID"})
+		ctx.Error(apperrors.Validation("invalid message ID"))
 		return
 	}
 
 	_, err = c.messageService.DeleteMessage(ctx.Request.Context(), objID.Hex(), currentUserID)
 	if err != nil {
-		switch err.Error() {
-		case "message not found":
-			ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
-		case "not authorized to delete this message":
-			ctx.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
-		default:
-			ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
-		}
+		ctx.Error(err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
-}
\ No newline at end of file
+}
+
+// @Summary Get message receipts
+// @Description When each recipient's devices received the message and when they saw it (sender only)
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Message ID"
+// @Success 200 {object} models.MessageReceiptsResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Router /messages/{id}/receipts [get]
+func (c *MessageController) GetReceipts(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid message ID"))
+		return
+	}
+
+	receipts, err := c.messageService.GetReceipts(ctx.Request.Context(), currentUserID, messageID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, receipts)
+}
+
+// @Summary Forward a message
+// @Description Forward a message you can read to a friend or a group you belong to
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Message ID"
+// @Param destination body models.ForwardMessageRequest true "Conversation to forward to"
+// @Success 201 {object} models.Message
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /messages/{id}/forward [post]
+func (c *MessageController) ForwardMessage(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(string)
+	currentUserID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid message ID"))
+		return
+	}
+
+	var req models.ForwardMessageRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.Error(apperrors.Validation(err.Error()))
+		return
+	}
+
+	message, err := c.messageService.ForwardMessage(ctx.Request.Context(), currentUserID, messageID, req)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, message)
+}
+
+// @Summary Star a message
+// @Description Star a message of a conversation you participate in, to find it again later
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Message ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /messages/{id}/star [post]
+func (c *MessageController) StarMessage(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid message ID"))
+		return
+	}
+
+	if err := c.messageService.StarMessage(ctx.Request.Context(), currentUserID, messageID); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
+}
+
+// @Summary Unstar a message
+// @Description Remove your star from a message
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Message ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /messages/{id}/star [delete]
+func (c *MessageController) UnstarMessage(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	messageID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid message ID"))
+		return
+	}
+
+	if err := c.messageService.UnstarMessage(ctx.Request.Context(), currentUserID, messageID); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
+}
+
+// @Summary List starred messages
+// @Description List the current user's starred messages across conversations, most recently starred first
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Messages per page" default(20)
+// @Success 200 {object} models.StarredMessageListResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /messages/starred [get]
+func (c *MessageController) GetStarredMessages(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	page, err := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	response, err := c.messageService.GetStarredMessages(ctx.Request.Context(), currentUserID, page, limit)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Archive a conversation
+// @Description Hide a conversation from your conversation list until it gets a new message. Other participants are unaffected.
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID, or the other user's ID for a direct conversation"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 403 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /conversations/{id}/archive [post]
+func (c *MessageController) ArchiveConversation(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	conversationID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid conversation ID"))
+		return
+	}
+
+	if err := c.messageService.ArchiveConversation(ctx.Request.Context(), currentUserID, conversationID); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
+}
+
+// @Summary Unarchive a conversation
+// @Description Bring an archived conversation back to your conversation list
+// @Tags messages
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID, or the other user's ID for a direct conversation"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /conversations/{id}/archive [delete]
+func (c *MessageController) UnarchiveConversation(ctx *gin.Context) {
+	currentUserID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+
+	conversationID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid conversation ID"))
+		return
+	}
+
+	if err := c.messageService.UnarchiveConversation(ctx.Request.Context(), currentUserID, conversationID); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Success: true})
+}
+
+// @Summary List flagged messages
+// @Description The moderation queue: messages the content filter flagged, newest first (admins only)
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Messages per page" default(50)
+// @Success 200 {object} models.FlaggedMessageListResponse
+// @Failure 403 {object} apperrors.Response
+// @Failure 500 {object} apperrors.Response
+// @Router /admin/flagged-messages [get]
+func (c *MessageController) GetFlaggedMessages(ctx *gin.Context) {
+	page, err := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	response, err := c.messageService.GetFlaggedMessages(ctx.Request.Context(), page, limit)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
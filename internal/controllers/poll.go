@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"net/http"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PollController struct {
+	pollService *services.PollService
+}
+
+func NewPollController(pollService *services.PollService) *PollController {
+	return &PollController{pollService: pollService}
+}
+
+// @Summary Get a poll
+// @Description Get a group poll with its results as the requester may see them
+// @Tags polls
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Poll ID"
+// @Success 200 {object} models.PollResults
+// @Failure 403 {object} apperrors.Response
+// @Failure 404 {object} apperrors.Response
+// @Router /polls/{id} [get]
+func (c *PollController) GetPoll(ctx *gin.Context) {
+	userID, pollID, ok := userAndPoll(ctx)
+	if !ok {
+		return
+	}
+
+	results, err := c.pollService.GetResults(ctx.Request.Context(), userID, pollID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
+
+// @Summary Vote in a poll
+// @Description Vote for one or more options by index, replacing an earlier vote
+// @Tags polls
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Poll ID"
+// @Param vote body models.PollVoteRequest true "Chosen options"
+// @Success 200 {object} models.PollResults
+// @Failure 400 {object} apperrors.Response
+// @Failure 409 {object} apperrors.Response
+// @Router /polls/{id}/votes [post]
+func (c *PollController) Vote(ctx *gin.Context) {
+	userID, pollID, ok := userAndPoll(ctx)
+	if !ok {
+		return
+	}
+
+	var req models.PollVoteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.Error(apperrors.Validation(err.Error()))
+		return
+	}
+
+	results, err := c.pollService.Vote(ctx.Request.Context(), userID, pollID, req.Options)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
+
+// @Summary Retract a poll vote
+// @Tags polls
+// @Security ApiKeyAuth
+// @Param id path string true "Poll ID"
+// @Success 204
+// @Failure 404 {object} apperrors.Response
+// @Failure 409 {object} apperrors.Response
+// @Router /polls/{id}/votes [delete]
+func (c *PollController) RetractVote(ctx *gin.Context) {
+	userID, pollID, ok := userAndPoll(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.pollService.RetractVote(ctx.Request.Context(), userID, pollID); err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// @Summary Close a poll
+// @Description Stop a poll from taking votes (poll creator only)
+// @Tags polls
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Poll ID"
+// @Success 200 {object} models.PollResults
+// @Failure 403 {object} apperrors.Response
+// @Failure 409 {object} apperrors.Response
+// @Router /polls/{id}/close [post]
+func (c *PollController) ClosePoll(ctx *gin.Context) {
+	userID, pollID, ok := userAndPoll(ctx)
+	if !ok {
+		return
+	}
+
+	results, err := c.pollService.ClosePoll(ctx.Request.Context(), userID, pollID)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
+
+func userAndPoll(ctx *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	pollID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid poll ID"))
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, pollID, true
+}
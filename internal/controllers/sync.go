@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SyncController struct {
+	syncService *services.SyncService
+}
+
+func NewSyncController(syncService *services.SyncService) *SyncController {
+	return &SyncController{syncService: syncService}
+}
+
+// @Summary Sync changes
+// @Description Everything that changed for the current user since a sync token: messages per conversation, friendships, groups and friends' profiles
+// @Tags sync
+// @Produce json
+// @Security ApiKeyAuth
+// @Param since query string true "Token from the previous sync, or an RFC 3339 timestamp"
+// @Success 200 {object} models.SyncResponse
+// @Failure 400 {object} apperrors.Response
+// @Router /sync [get]
+func (c *SyncController) Sync(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.Error(apperrors.Validation("invalid user ID"))
+		return
+	}
+	since, err := services.ParseSyncToken(ctx.Query("since"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	response, err := c.syncService.Sync(ctx.Request.Context(), userID, since)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
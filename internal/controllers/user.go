@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"errors"
 	"messaging-app/internal/models"
 	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
 	"net/http"
 	"strconv"
 
@@ -47,43 +49,47 @@ func (c *UserController) GetUser(ctx *gin.Context) {
         Username:  user.Username,
 		Email: 	   user.Email,
         Avatar:    user.Avatar,
+        AvatarSizes: user.AvatarSizes,
         CreatedAt: user.CreatedAt,
 		Friends:   user.Friends,
+		UndoSendSeconds: user.UndoSendSeconds,
+		FriendListVisibility: user.FriendListVisibility,
+		IsPrivate: user.IsPrivate,
 		Blocked:   user.Blocked,
     }
 	ctx.JSON(http.StatusOK, userDTO)
 }
 
 // GetUserByID godoc
-// @Summary Get user by ID
+// @Summary Get a user's profile
+// @Description Fields are filtered by the viewer's relationship with the user
 // @Security BearerAuth
 // @Tags users
 // @Produce json
 // @Param id path string true "User ID"
-// @Success 200 {object} models.PublicUser
+// @Success 200 {object} models.ProfileResponse
 // @Failure 400 {object} gin.H
 // @Failure 404 {object} gin.H
 // @Router /api/users/{id} [get]
 func (c *UserController) GetUserByID(ctx *gin.Context) {
-    userID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+    viewerID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
     if err != nil {
         ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
         return
     }
 
-    user, err := c.userService.GetUserByID(ctx.Request.Context(), userID)
+    userID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
     if err != nil {
-        ctx.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+        ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
         return
     }
 
-    publicUser := models.User{
-        ID:        user.ID,
-        Username:  user.Username,
-        Avatar:    user.Avatar,
-        CreatedAt: user.CreatedAt,
+    profile, err := c.userService.GetProfile(ctx.Request.Context(), viewerID, userID)
+    if err != nil {
+        ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+        return
     }
-    ctx.JSON(http.StatusOK, publicUser)
+    ctx.JSON(http.StatusOK, profile)
 }
 
 // UpdateUser godoc
@@ -114,7 +120,11 @@ func (c *UserController) UpdateUser(ctx *gin.Context) {
 
 	updatedUser, err := c.userService.UpdateUser(ctx.Request.Context(), objID, &updateReq)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -133,6 +143,12 @@ func (c *UserController) UpdateUser(ctx *gin.Context) {
 // @Failure 400 {object} gin.H
 // @Router /api/users [get]
 func (c *UserController) ListUsers(ctx *gin.Context) {
+	viewerID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
 	page, _ := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
 	limit, _ := strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64)
 	search := ctx.Query("search")
@@ -144,11 +160,130 @@ func (c *UserController) ListUsers(ctx *gin.Context) {
 		limit = 20
 	}
 
-	response, err := c.userService.ListUsers(ctx.Request.Context(), page, limit, search)
+	response, err := c.userService.ListUsers(ctx.Request.Context(), viewerID, page, limit, search)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// DiscoverByPhone godoc
+// @Summary Find contacts by phone number hash
+// @Description Hashes are hex SHA-256 of E.164 numbers. Only users who allow discovery are returned, without saying which hash matched.
+// @Security BearerAuth
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.PhoneDiscoveryRequest true "Contact hashes"
+// @Success 200 {object} models.PhoneDiscoveryResponse
+// @Failure 400 {object} gin.H
+// @Failure 429 {object} gin.H
+// @Router /api/users/discover [post]
+func (c *UserController) DiscoverByPhone(ctx *gin.Context) {
+	viewerID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
 	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req models.PhoneDiscoveryRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	response, err := c.userService.DiscoverByPhone(ctx.Request.Context(), viewerID, req.Hashes)
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+}
+
+// ListFriends godoc
+// @Summary List a user's friends (paginated)
+// @Description Without an id, lists the current user's friends
+// @Security BearerAuth
+// @Tags users
+// @Produce json
+// @Param id path string false "User ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Param q query string false "Username prefix"
+// @Success 200 {object} models.UserListResponse
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Router /api/users/{id}/friends [get]
+// @Router /api/friendships/friends [get]
+func (c *UserController) ListFriends(ctx *gin.Context) {
+	viewerID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	ownerID := viewerID
+	if id := ctx.Param("id"); id != "" {
+		if ownerID, err = primitive.ObjectIDFromHex(id); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+	}
+
+	page, _ := strconv.ParseInt(ctx.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	response, err := c.userService.ListFriends(ctx.Request.Context(), viewerID, ownerID, page, limit, ctx.Query("q"))
+	if err != nil {
+		ctx.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// SuggestUsers godoc
+// @Summary Autocomplete usernames for mentions
+// @Security BearerAuth
+// @Tags users
+// @Produce json
+// @Param q query string true "Username prefix"
+// @Param limit query int false "Max suggestions" default(10)
+// @Success 200 {array} models.UserSuggestion
+// @Failure 400 {object} gin.H
+// @Router /api/users/suggest [get]
+func (c *UserController) SuggestUsers(ctx *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(ctx.MustGet("userID").(string))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	query := ctx.Query("q")
+	if query == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, _ := strconv.ParseInt(ctx.DefaultQuery("limit", "10"), 10, 64)
+	if limit < 1 || limit > 25 {
+		limit = 10
+	}
+
+	suggestions, err := c.userService.SuggestUsers(ctx.Request.Context(), userID, query, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, suggestions)
+}
@@ -0,0 +1,72 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+)
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, message models.EmailMessage) error
+}
+
+// NewSender returns an SMTP sender, or a sender that only logs when no SMTP
+// host is configured (local development)
+func NewSender(cfg *config.Config) Sender {
+	if cfg.SMTPHost == "" {
+		return LogSender{}
+	}
+	return &SMTPSender{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+	}
+}
+
+// SMTPSender sends mail through an SMTP server
+type SMTPSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message models.EmailMessage) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message.Body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(net.JoinHostPort(s.host, s.port), auth, s.from, []string{message.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", message.To, err)
+	}
+	return nil
+}
+
+// LogSender writes emails to the log instead of sending them
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, message models.EmailMessage) error {
+	log.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
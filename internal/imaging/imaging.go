@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+var (
+	// ErrUnsupportedFormat means the bytes aren't a JPEG, PNG or GIF,
+	// whatever the file name or declared type says
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrDimensions means the image is too small or too large
+	ErrDimensions = errors.New("image dimensions out of range")
+)
+
+// Limits bound the dimensions of accepted images
+type Limits struct {
+	MinSide int
+	MaxSide int
+}
+
+// Decode sniffs the format from the content, checks the dimensions before
+// decoding so oversized images are never expanded in memory, and returns the
+// image with its MIME type
+func Decode(data []byte, limits Limits) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch contentType {
+	case "image/jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "image/png":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	case "image/gif":
+		// Animated GIFs keep their first frame
+		decode, decodeConfig = gif.Decode, gif.DecodeConfig
+	default:
+		return nil, "", ErrUnsupportedFormat
+	}
+
+	cfg, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if cfg.Width < limits.MinSide || cfg.Height < limits.MinSide ||
+		cfg.Width > limits.MaxSide || cfg.Height > limits.MaxSide {
+		return nil, "", ErrDimensions
+	}
+
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedFormat
+	}
+	return img, contentType, nil
+}
+
+// Square crops the centre square of img and scales it to size x size,
+// averaging the source pixels that cover each target pixel
+func Square(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		sy0, sy1 := span(dy, size, side)
+		for dx := 0; dx < size; dx++ {
+			sx0, sx1 := span(dx, size, side)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(x0+sx, y0+sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(dx, dy, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels [start, end) covered by target pixel i when
+// side source pixels are scaled to size; at least one when upscaling
+func span(i, size, side int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+// Encode writes img as contentType: JPEG stays JPEG, everything else becomes
+// PNG so transparency survives
+func Encode(w io.Writer, img image.Image, contentType string) (string, error) {
+	if contentType == "image/jpeg" {
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return "image/png", png.Encode(w, img)
+}
@@ -3,16 +3,32 @@ package kafka
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"messaging-app/internal/email"
 	"messaging-app/internal/models"
+	"messaging-app/pkg/logging"
+	"strconv"
 
 	"messaging-app/internal/websocket"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/kafka-go"
 )
 
+// maxProcessAttempts is how often a message is handled before it is dead-lettered
+const maxProcessAttempts = 3
+
+// Writes to the dead-letter topic are retried with these delays, doubling
+// in between, until one succeeds
+const (
+	deadLetterRetryDelay    = 100 * time.Millisecond
+	maxDeadLetterRetryDelay = 30 * time.Second
+)
+
 var (
 	messagesConsumed = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -21,6 +37,20 @@ var (
 		},
 		[]string{"topic"},
 	)
+	messagesFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_failed_total",
+			Help: "Total number of failed attempts to process a Kafka message",
+		},
+		[]string{"topic"},
+	)
+	messagesDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_dead_lettered_total",
+			Help: "Total number of Kafka messages moved to the dead-letter topic",
+		},
+		[]string{"topic"},
+	)
 	consumeDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "kafka_consume_duration_seconds",
@@ -29,14 +59,97 @@ var (
 		},
 		[]string{"topic"},
 	)
+	consumerMetricsOnce sync.Once
 )
 
+// errMalformed marks messages that can never be processed, so retrying is pointless
+var errMalformed = errors.New("malformed message")
+
+// messageReader is the part of *kafka.Reader the consumer uses
+type messageReader interface {
+	Config() kafka.ReaderConfig
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// messageWriter is the part of *kafka.Writer the consumer uses
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// MessageConsumer reads a topic and hands each message to a handler, retrying
+// failures and dead-lettering what can't be handled
 type MessageConsumer struct {
-	reader *kafka.Reader
-	hub    *websocket.Hub
+	reader messageReader
+	dlq    messageWriter
+	hub    websocket.MessageBroadcaster
+	handle func(context.Context, kafka.Message) error
 }
 
-func NewMessageConsumer(brokers []string, topic string, groupID string, hub *websocket.Hub) *MessageConsumer {
+// NewMessageConsumer delivers chat messages and events from topic to the hub
+func NewMessageConsumer(brokers []string, topic string, groupID string, hub websocket.MessageBroadcaster) *MessageConsumer {
+	c := newConsumer(brokers, topic, groupID)
+	c.hub = hub
+	c.handle = c.broadcast
+	return c
+}
+
+// NewEmailConsumer sends the emails queued on topic with sender
+func NewEmailConsumer(brokers []string, topic string, groupID string, sender email.Sender) *MessageConsumer {
+	c := newConsumer(brokers, topic, groupID)
+	c.handle = func(ctx context.Context, msg kafka.Message) error {
+		var message models.EmailMessage
+		if err := json.Unmarshal(msg.Value, &message); err != nil {
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+		return sender.Send(ctx, message)
+	}
+	return c
+}
+
+// LinkPreviewGenerator builds the previews queued by the message service
+type LinkPreviewGenerator interface {
+	GeneratePreview(ctx context.Context, job models.LinkPreviewJob) error
+}
+
+// NewLinkPreviewConsumer generates the link previews queued on topic
+func NewLinkPreviewConsumer(brokers []string, topic string, groupID string, generator LinkPreviewGenerator) *MessageConsumer {
+	c := newConsumer(brokers, topic, groupID)
+	c.handle = func(ctx context.Context, msg kafka.Message) error {
+		var job models.LinkPreviewJob
+		if err := json.Unmarshal(msg.Value, &job); err != nil {
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+		return generator.GeneratePreview(ctx, job)
+	}
+	return c
+}
+
+// PushDeliverer sends the push notifications queued by the hub
+type PushDeliverer interface {
+	DeliverPush(ctx context.Context, job models.PushJob) error
+}
+
+// NewPushConsumer delivers the push notifications queued on topic
+func NewPushConsumer(brokers []string, topic string, groupID string, deliverer PushDeliverer) *MessageConsumer {
+	c := newConsumer(brokers, topic, groupID)
+	c.handle = func(ctx context.Context, msg kafka.Message) error {
+		var job models.PushJob
+		if err := json.Unmarshal(msg.Value, &job); err != nil {
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+		return deliverer.DeliverPush(ctx, job)
+	}
+	return c
+}
+
+func newConsumer(brokers []string, topic string, groupID string) *MessageConsumer {
+	consumerMetricsOnce.Do(func() {
+		prometheus.MustRegister(messagesConsumed, messagesFailed, messagesDeadLettered, consumeDuration)
+	})
+
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:        brokers,
 		Topic:          topic,
@@ -46,35 +159,199 @@ func NewMessageConsumer(brokers []string, topic string, groupID string, hub *web
 		CommitInterval: time.Second,
 	})
 
+	// Dead-lettering is synchronous so the offset is only committed once the
+	// message is safely stored
+	dlq := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  DeadLetterTopic(topic),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireOne,
+		AllowAutoTopicCreation: true,
+	}
+
 	return &MessageConsumer{
 		reader: r,
-		hub:    hub,
+		dlq:    dlq,
 	}
 }
 
+// DeadLetterTopic returns the topic that unprocessable messages from topic are moved to
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// ConsumeMessages delivers messages until ctx is cancelled. Offsets are
+// committed after each message is handled or dead-lettered, and flushed when
+// the reader is closed on return. A message that could be neither is never
+// committed: consuming stops on it until the dead-letter topic takes it.
+//
+// Messages are handled one at a time in partition order, which is what keeps
+// each conversation in order on its way to the hub; don't hand them to a
+// worker pool.
 func (c *MessageConsumer) ConsumeMessages(ctx context.Context) {
-	defer c.reader.Close()
+	defer func() {
+		if err := c.reader.Close(); err != nil {
+			slog.Error("Error closing Kafka reader", "error", err)
+		}
+		if err := c.dlq.Close(); err != nil {
+			slog.Error("Error closing dead-letter writer", "error", err)
+		}
+	}()
 
+	topic := c.reader.Config().Topic
 	for {
-		start := time.Now()
-		msg, err := c.reader.ReadMessage(ctx)
+		msg, err := c.reader.FetchMessage(ctx)
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("Error reading message", "topic", topic, "error", err)
 			continue
 		}
 
-		var message models.Message
-		if err := json.Unmarshal(msg.Value, &message); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
+		start := time.Now()
+		msgCtx := messageContext(ctx, msg)
+		if err := c.processWithRetry(msgCtx, msg); err != nil {
+			if !c.deadLetterWithRetry(msgCtx, msg, err) {
+				// Shutting down with the message stored nowhere; leaving its
+				// offset uncommitted gets it redelivered
+				return
+			}
+		} else {
+			messagesConsumed.WithLabelValues(topic).Inc()
+		}
+		consumeDuration.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+
+		// Commit even when shutting down so the handled message isn't redelivered
+		if err := c.reader.CommitMessages(context.WithoutCancel(ctx), msg); err != nil {
+			logging.FromContext(msgCtx).Error("Error committing offset", "error", err)
+		}
+	}
+}
+
+// messageContext carries the request ID the record was produced for and a
+// logger recording where the record came from
+func messageContext(ctx context.Context, msg kafka.Message) context.Context {
+	for _, h := range msg.Headers {
+		if h.Key == requestIDHeader {
+			ctx = logging.WithRequestID(ctx, string(h.Value))
+		}
+	}
+	return logging.With(ctx, "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+}
+
+func (c *MessageConsumer) processWithRetry(ctx context.Context, msg kafka.Message) error {
+	var err error
+	for attempt := 1; attempt <= maxProcessAttempts; attempt++ {
+		if err = c.process(ctx, msg); err == nil {
+			return nil
 		}
+		messagesFailed.WithLabelValues(msg.Topic).Inc()
+		if errors.Is(err, errMalformed) {
+			return err
+		}
+		logging.FromContext(ctx).Warn("Error processing message",
+			"attempt", attempt, "max_attempts", maxProcessAttempts, "error", err)
 
-		
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	return err
+}
 
-		// Broadcast to WebSocket clients
-		c.hub.Broadcast <- message
+// process runs the handler, turning a panic into an error so one bad message
+// can't kill the consumer goroutine
+func (c *MessageConsumer) process(ctx context.Context, msg kafka.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while handling message: %v", r)
+		}
+	}()
 
-		messagesConsumed.WithLabelValues(c.reader.Config().Topic).Inc()
-		consumeDuration.WithLabelValues(c.reader.Config().Topic).Observe(time.Since(start).Seconds())
+	// Shutting down doesn't cut off the message being handled
+	return c.handle(context.WithoutCancel(ctx), msg)
+}
+
+// broadcast hands a chat message or typed event to the hub
+func (c *MessageConsumer) broadcast(ctx context.Context, msg kafka.Message) error {
+	// Typed events carry a "type" field, chat messages don't
+	var probe struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(msg.Value, &probe); err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
 	}
-}
\ No newline at end of file
+
+	if probe.Type != "" {
+		var event models.WebSocketEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			return fmt.Errorf("%w: %v", errMalformed, err)
+		}
+		logging.FromContext(ctx).Debug("Delivering event", "type", event.Type)
+		c.hub.BroadcastEvent(event)
+		return nil
+	}
+
+	var message models.Message
+	if err := json.Unmarshal(msg.Value, &message); err != nil {
+		return fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	// Broadcast to WebSocket clients. The hub logs by message ID, which this
+	// line ties to the request that sent it.
+	logging.FromContext(ctx).Debug("Delivering message", "message_id", message.ID.Hex())
+	c.hub.BroadcastMessage(message)
+	return nil
+}
+
+// deadLetterWithRetry dead-letters msg, retrying until it succeeds. It
+// reports false if ctx was cancelled first.
+//
+// Moving on to the next message instead would lose this one: committing a
+// later offset commits every offset before it.
+func (c *MessageConsumer) deadLetterWithRetry(ctx context.Context, msg kafka.Message, cause error) bool {
+	delay := deadLetterRetryDelay
+	for {
+		err := c.deadLetter(ctx, msg, cause)
+		if err == nil {
+			return true
+		}
+		logging.FromContext(ctx).Error("Error dead-lettering message", "cause", cause, "error", err, "retry_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxDeadLetterRetryDelay)
+	}
+}
+
+// deadLetter copies msg to the dead-letter topic with the failure recorded in headers
+func (c *MessageConsumer) deadLetter(ctx context.Context, msg kafka.Message, cause error) error {
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "dlq-error", Value: []byte(cause.Error())},
+		kafka.Header{Key: "dlq-original-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "dlq-original-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dlq-original-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: "dlq-failed-at", Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	err := c.dlq.WriteMessages(context.WithoutCancel(ctx), kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	messagesDeadLettered.WithLabelValues(msg.Topic).Inc()
+	logging.FromContext(ctx).Error("Dead-lettered message", "error", cause)
+	return nil
+}
@@ -0,0 +1,153 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeReader struct {
+	messages  chan kafka.Message
+	committed chan kafka.Message
+}
+
+func newFakeReader(msgs ...kafka.Message) *fakeReader {
+	r := &fakeReader{
+		messages:  make(chan kafka.Message, len(msgs)),
+		committed: make(chan kafka.Message, len(msgs)),
+	}
+	for _, msg := range msgs {
+		r.messages <- msg
+	}
+	return r
+}
+
+func (r *fakeReader) Config() kafka.ReaderConfig {
+	return kafka.ReaderConfig{Topic: "messages"}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case msg := <-r.messages:
+		return msg, nil
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		r.committed <- msg
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+// fakeWriter fails the first failures writes
+type fakeWriter struct {
+	mu       sync.Mutex
+	failures int
+	attempts chan struct{}
+	written  []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts <- struct{}{}
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("broker unavailable")
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func newTestConsumer(reader *fakeReader, dlq *fakeWriter) *MessageConsumer {
+	return &MessageConsumer{
+		reader: reader,
+		dlq:    dlq,
+		handle: func(ctx context.Context, msg kafka.Message) error {
+			var v map[string]any
+			if err := json.Unmarshal(msg.Value, &v); err != nil {
+				return fmt.Errorf("%w: %v", errMalformed, err)
+			}
+			return nil
+		},
+	}
+}
+
+func TestFailedDeadLetterIsNotCommitted(t *testing.T) {
+	reader := newFakeReader(
+		kafka.Message{Topic: "messages", Offset: 0, Value: []byte("garbage")},
+		kafka.Message{Topic: "messages", Offset: 1, Value: []byte(`{}`)},
+	)
+	dlq := &fakeWriter{failures: 1 << 30, attempts: make(chan struct{}, 100)}
+	consumer := newTestConsumer(reader, dlq)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.ConsumeMessages(ctx)
+		close(done)
+	}()
+
+	// The write is retried rather than given up on
+	for i := 0; i < 2; i++ {
+		select {
+		case <-dlq.attempts:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dead-letter write %d never happened", i+1)
+		}
+	}
+	cancel()
+	<-done
+
+	select {
+	case msg := <-reader.committed:
+		t.Fatalf("offset %d was committed although the message is stored nowhere", msg.Offset)
+	default:
+	}
+}
+
+func TestDeadLetterIsRetriedBeforeCommitting(t *testing.T) {
+	reader := newFakeReader(
+		kafka.Message{Topic: "messages", Offset: 0, Value: []byte("garbage")},
+		kafka.Message{Topic: "messages", Offset: 1, Value: []byte(`{}`)},
+	)
+	dlq := &fakeWriter{failures: 1, attempts: make(chan struct{}, 100)}
+	consumer := newTestConsumer(reader, dlq)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.ConsumeMessages(ctx)
+
+	for want := int64(0); want < 2; want++ {
+		select {
+		case msg := <-reader.committed:
+			if msg.Offset != want {
+				t.Fatalf("committed offset %d, want %d", msg.Offset, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("offset %d never committed", want)
+		}
+	}
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+	if len(dlq.written) != 1 || string(dlq.written[0].Value) != "garbage" {
+		t.Fatalf("dead-lettered %v, want the garbage message once", dlq.written)
+	}
+	if len(dlq.attempts) != 2 {
+		t.Errorf("%d dead-letter attempts, want 2", len(dlq.attempts))
+	}
+}
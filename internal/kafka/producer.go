@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"messaging-app/internal/models"
+	"messaging-app/pkg/logging"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,6 +30,10 @@ var (
 	)
 )
 
+// requestIDHeader carries the ID of the request a record was produced for,
+// so the consumer's logs can be matched with the request's
+const requestIDHeader = "request-id"
+
 type MessageProducer struct {
 	writer *kafka.Writer
 	topic  string
@@ -58,7 +63,18 @@ func NewMessageProducer(brokers []string, topic string) *MessageProducer {
 	}
 }
 
-func (p *MessageProducer) ProduceMessage(ctx context.Context, message models.Message) error {
+// NewSyncProducer is a producer whose writes return once the broker has
+// acknowledged them, for callers that must know a record was delivered
+func NewSyncProducer(brokers []string, topic string) *MessageProducer {
+	p := NewMessageProducer(brokers, topic)
+	p.writer.Async = false
+	return p
+}
+
+// ProduceMessage publishes a chat message keyed by key, which should be the
+// message's ConversationKey so the conversation stays on one partition and in
+// order
+func (p *MessageProducer) ProduceMessage(ctx context.Context, key string, message models.Message) error {
 	start := time.Now()
 	defer func() {
 		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
@@ -71,13 +87,128 @@ func (p *MessageProducer) ProduceMessage(ctx context.Context, message models.Mes
 
 	return p.writer.WriteMessages(ctx,
 		kafka.Message{
-			Key:   []byte(message.ReceiverID.Hex()),
-			Value: jsonMsg,
-			Time:  time.Now(),
+			Key:     []byte(key),
+			Value:   jsonMsg,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
+		},
+	)
+}
+
+// ProduceEvent publishes a typed WebSocket event keyed by key
+func (p *MessageProducer) ProduceEvent(ctx context.Context, key string, event models.WebSocketEvent) error {
+	start := time.Now()
+	defer func() {
+		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	}()
+
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.writer.WriteMessages(ctx,
+		kafka.Message{
+			Key:     []byte(key),
+			Value:   jsonEvent,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
 		},
 	)
 }
 
+// QueueEmail publishes an email for the email consumer to send, keyed by recipient
+func (p *MessageProducer) QueueEmail(ctx context.Context, message models.EmailMessage) error {
+	start := time.Now()
+	defer func() {
+		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	}()
+
+	jsonEmail, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return p.writer.WriteMessages(ctx,
+		kafka.Message{
+			Key:     []byte(message.To),
+			Value:   jsonEmail,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
+		},
+	)
+}
+
+// QueueLinkPreview publishes a preview job for the link preview worker, keyed by message
+func (p *MessageProducer) QueueLinkPreview(ctx context.Context, job models.LinkPreviewJob) error {
+	start := time.Now()
+	defer func() {
+		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	}()
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return p.writer.WriteMessages(ctx,
+		kafka.Message{
+			Key:     []byte(job.MessageID.Hex()),
+			Value:   jsonJob,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
+		},
+	)
+}
+
+// QueuePush publishes a push job for the push worker, keyed by recipient
+func (p *MessageProducer) QueuePush(ctx context.Context, job models.PushJob) error {
+	start := time.Now()
+	defer func() {
+		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	}()
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return p.writer.WriteMessages(ctx,
+		kafka.Message{
+			Key:     []byte(job.UserID.Hex()),
+			Value:   jsonJob,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
+		},
+	)
+}
+
+// Publish writes an already encoded record, such as one from the outbox
+func (p *MessageProducer) Publish(ctx context.Context, key string, value []byte) error {
+	start := time.Now()
+	defer func() {
+		produceDuration.WithLabelValues(p.topic).Observe(time.Since(start).Seconds())
+	}()
+
+	return p.writer.WriteMessages(ctx,
+		kafka.Message{
+			Key:     []byte(key),
+			Value:   value,
+			Time:    time.Now(),
+			Headers: requestHeaders(ctx),
+		},
+	)
+}
+
+// requestHeaders returns the headers recording the request ID of ctx, if any
+func requestHeaders(ctx context.Context) []kafka.Header {
+	id := logging.RequestID(ctx)
+	if id == "" {
+		return nil
+	}
+	return []kafka.Header{{Key: requestIDHeader, Value: []byte(id)}}
+}
+
 func (p *MessageProducer) Close() error {
 	return p.writer.Close()
 }
\ No newline at end of file
@@ -0,0 +1,266 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"messaging-app/internal/models"
+)
+
+// MaxBodyBytes is how much of a page is read looking for its metadata
+const MaxBodyBytes = 1 << 20
+
+// maxRedirects bounds how many redirects are followed per fetch
+const maxRedirects = 5
+
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 500
+)
+
+var (
+	// ErrBlockedAddress is returned for links that resolve, directly or
+	// through a redirect, to a private or otherwise internal address
+	ErrBlockedAddress = errors.New("link points to a blocked address")
+	// ErrNoPreview is returned for pages that aren't HTML or carry no metadata
+	ErrNoPreview = errors.New("page has no preview metadata")
+)
+
+var (
+	urlPattern      = regexp.MustCompile(`(?i)https?://[^\s<>"']+`)
+	metaPattern     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern     = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	spacesPattern   = regexp.MustCompile(`\s+`)
+	blockedNetworks = mustParseCIDRs(
+		"0.0.0.0/8",     // "this" network
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved
+		"64:ff9b::/96",  // NAT64, can reach IPv4 internals
+		"2001:db8::/32", // documentation
+	)
+)
+
+// FirstURL returns the first http(s) link in text, or "" if there is none
+func FirstURL(text string) string {
+	// Trailing punctuation usually belongs to the sentence, not the link
+	return strings.TrimRight(urlPattern.FindString(text), ".,;:!?)]}")
+}
+
+// Normalize canonicalizes a link so equivalent spellings share one preview.
+// Only http(s) links without credentials are accepted.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return "", errors.New("links with credentials are not previewed")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", errors.New("link has no host")
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String(), nil
+}
+
+// Fetcher downloads pages and extracts their OpenGraph metadata. Every
+// connection it opens is checked against the blocked address ranges after
+// DNS resolution, so neither rebinding nor redirects reach internal hosts.
+type Fetcher struct {
+	client *http.Client
+}
+
+// NewFetcher returns a fetcher whose requests, body included, are bounded by timeout
+func NewFetcher(timeout time.Duration) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlocked(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// Never go through an environment proxy; it would do the dialing
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Fetch downloads the page at link and returns its preview, keyed by link
+func (f *Fetcher) Fetch(ctx context.Context, link string) (*models.LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "MessagingAppLinkPreview/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	if !strings.Contains(contentType, "text/html") && !strings.Contains(contentType, "application/xhtml") {
+		return nil, ErrNoPreview
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parse(string(body), resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = link
+	preview.FetchedAt = time.Now()
+	return preview, nil
+}
+
+// parse extracts the OpenGraph fields of a page, falling back to its title
+// and meta description. base resolves relative image URLs.
+func parse(page string, base *url.URL) *models.LinkPreview {
+	meta := make(map[string]string)
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3] + m[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		// The first occurrence wins, as with OpenGraph consumers generally
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+
+	preview := &models.LinkPreview{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+		SiteName:    meta["og:site_name"],
+	}
+	if preview.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			preview.Title = clean(m[1])
+		}
+	}
+	if preview.Description == "" {
+		preview.Description = meta["description"]
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptionLength)
+
+	if image := meta["og:image"]; image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+	return preview
+}
+
+func clean(s string) string {
+	return strings.TrimSpace(spacesPattern.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+func isBlocked(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Announcement levels
+const (
+	AnnouncementInfo     = "info"
+	AnnouncementWarning  = "warning"
+	AnnouncementCritical = "critical"
+)
+
+// Announcement limits
+const (
+	MaxAnnouncementTitleLength = 200
+	MaxAnnouncementBodyLength  = 2000
+)
+
+// Announcement is a system message from the operators to every user, e.g. a
+// maintenance window. It is stored once and shown from StartsAt until EndsAt
+// to everyone who hasn't dismissed it.
+type Announcement struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title     string             `bson:"title" json:"title"`
+	Body      string             `bson:"body" json:"body"`
+	Level     string             `bson:"level" json:"level"`
+	StartsAt  time.Time          `bson:"starts_at" json:"starts_at"`
+	EndsAt    time.Time          `bson:"ends_at" json:"ends_at"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// AnnouncementDismissal records that a user closed an announcement
+type AnnouncementDismissal struct {
+	AnnouncementID primitive.ObjectID `bson:"announcement_id"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	DismissedAt    time.Time          `bson:"dismissed_at"`
+}
+
+// AnnouncementRequest creates an announcement. StartsAt defaults to now.
+type AnnouncementRequest struct {
+	Title    string     `json:"title" binding:"required"`
+	Body     string     `json:"body" binding:"required"`
+	Level    string     `json:"level"` // defaults to info
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   time.Time  `json:"ends_at" binding:"required"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConversationArchive hides a conversation from one user's conversation list.
+// It only applies while the conversation has no activity after ArchivedAt;
+// a new message brings the conversation back.
+type ConversationArchive struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"` // the group, or the other user of a direct chat
+	ArchivedAt     time.Time          `bson:"archived_at" json:"archived_at"`
+}
+
+// ConversationUnarchivedEvent tells a user's connections that a new message
+// brought an archived conversation back to their conversation list
+type ConversationUnarchivedEvent struct {
+	UserID         primitive.ObjectID `json:"user_id"`
+	ConversationID primitive.ObjectID `json:"conversation_id"`
+	IsGroup        bool               `json:"is_group"`
+	MessageID      primitive.ObjectID `json:"message_id"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditRetention is how long security events are kept
+const AuditRetention = 90 * 24 * time.Hour
+
+// Security events recorded in a user's activity log
+const (
+	AuditLogin             = "login"
+	AuditLoginFailed       = "login_failed"
+	AuditNewDevice         = "new_device" // a login from an IP and client not seen before
+	AuditPasswordChanged   = "password_changed"
+	AuditPasswordReset     = "password_reset" // through a reset email, without the old password
+	AuditEmailChanged      = "email_changed"
+	AuditTwoFactorEnabled  = "two_factor_enabled"
+	AuditTwoFactorDisabled = "two_factor_disabled"
+)
+
+var ValidAuditEventTypes = map[string]bool{
+	AuditLogin:             true,
+	AuditLoginFailed:       true,
+	AuditNewDevice:         true,
+	AuditPasswordChanged:   true,
+	AuditPasswordReset:     true,
+	AuditEmailChanged:      true,
+	AuditTwoFactorEnabled:  true,
+	AuditTwoFactorDisabled: true,
+}
+
+// AuditEvent is an append-only record of a security-relevant account event.
+// Fingerprint identifies the IP and client it came from.
+type AuditEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"-"`
+	Type        string             `bson:"type" json:"type"`
+	IP          string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent   string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Fingerprint string             `bson:"fingerprint,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// AuditEventListResponse is a page of a user's activity log, newest first
+type AuditEventListResponse struct {
+	Events  []AuditEvent `json:"events"`
+	Page    int64        `json:"page"`
+	Limit   int64        `json:"limit"`
+	HasMore bool         `json:"has_more"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// EmailMessage is a plain-text email queued for delivery
+type EmailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
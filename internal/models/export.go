@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Data export job statuses
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// DataExportJob tracks the generation of a user's data archive. Active is
+// true while the job is pending or running; at most one job per user can be
+// active.
+type DataExportJob struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Status      string             `bson:"status" json:"status"`
+	Active      bool               `bson:"active" json:"-"`
+	StorageKey  string             `bson:"storage_key,omitempty" json:"-"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	DownloadURL string             `bson:"-" json:"download_url,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // when the archive is deleted
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GroupInvite is a shareable link token for joining a group. MaxUses 0 means unlimited.
+type GroupInvite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GroupID   primitive.ObjectID `bson:"group_id" json:"group_id"`
+	Token     string             `bson:"token" json:"token"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	MaxUses   int                `bson:"max_uses" json:"max_uses"`
+	Uses      int                `bson:"uses" json:"uses"`
+	Revoked   bool               `bson:"revoked" json:"revoked"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Usable reports whether the invite can still be used at time now
+func (i *GroupInvite) Usable(now time.Time) bool {
+	return !i.Revoked && now.Before(i.ExpiresAt) && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+type CreateGroupInviteRequest struct {
+	ExpiresInHours int `json:"expires_in_hours" binding:"omitempty,min=1,max=720"`
+	MaxUses        int `json:"max_uses" binding:"omitempty,min=0"`
+}
+
+// GroupInvitePreview is what someone holding an invite link sees before joining
+type GroupInvitePreview struct {
+	GroupID         primitive.ObjectID `json:"group_id"`
+	Name            string             `json:"name"`
+	MemberCount     int                `json:"member_count"`
+	RequireApproval bool               `json:"require_approval"`
+	ExpiresAt       time.Time          `json:"expires_at"`
+}
+
+// Join request statuses
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+// GroupJoinRequest is created when someone uses an invite to a group that requires approval
+type GroupJoinRequest struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GroupID   primitive.ObjectID `bson:"group_id" json:"group_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	InviteID  primitive.ObjectID `bson:"invite_id" json:"invite_id"`
+	Status    string             `bson:"status" json:"status"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// JoinGroupResponse tells the client whether it joined or is waiting for approval
+type JoinGroupResponse struct {
+	Status    string              `json:"status"` // "joined" or "pending"
+	GroupID   primitive.ObjectID  `json:"group_id"`
+	RequestID *primitive.ObjectID `json:"request_id,omitempty"`
+}
+
+// GroupMembershipEvent tells a group's members that UserID joined or left,
+// and the hub to start or stop routing the group's messages to UserID's open
+// connections. ActorID is the admin who added or removed the user, or the
+// user themselves when they joined or left.
+type GroupMembershipEvent struct {
+	GroupID primitive.ObjectID `json:"group_id"`
+	UserID  primitive.ObjectID `json:"user_id"`
+	ActorID primitive.ObjectID `json:"actor_id"`
+}
+
+// GroupUpdatedEvent carries a group's new details and settings to its
+// members so open chats can refresh their header
+type GroupUpdatedEvent struct {
+	GroupID         primitive.ObjectID `json:"group_id"`
+	UpdatedBy       primitive.ObjectID `json:"updated_by"`
+	Name            string             `json:"name"`
+	Description     string             `json:"description"`
+	Avatar          string             `json:"avatar"`
+	RequireApproval bool               `json:"require_approval"`
+	Settings        GroupSettings      `json:"settings"`
+}
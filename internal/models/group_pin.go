@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPinnedMessages caps how many messages a group can pin
+const MaxPinnedMessages = 20
+
+// PinnedMessage is a message an admin pinned to the top of a group
+type PinnedMessage struct {
+	MessageID primitive.ObjectID `bson:"message_id" json:"message_id"`
+	PinnedBy  primitive.ObjectID `bson:"pinned_by" json:"pinned_by"`
+	PinnedAt  time.Time          `bson:"pinned_at" json:"pinned_at"`
+}
+
+// PinnedMessageResponse is a pin with its message resolved
+type PinnedMessageResponse struct {
+	PinnedMessage
+	Message Message `json:"message"`
+}
+
+// MessagePinEvent tells group members a message was pinned or unpinned.
+// PinnedBy and PinnedAt are only set on pins.
+type MessagePinEvent struct {
+	GroupID   primitive.ObjectID `json:"group_id"`
+	MessageID primitive.ObjectID `json:"message_id"`
+	PinnedBy  primitive.ObjectID `json:"pinned_by,omitempty"`
+	PinnedAt  *time.Time         `json:"pinned_at,omitempty"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LinkPreview is the OpenGraph summary of a page linked from a message.
+// Previews are shared between messages and keyed by normalized URL.
+type LinkPreview struct {
+	URL         string    `bson:"_id" json:"url"`
+	Title       string    `bson:"title,omitempty" json:"title,omitempty"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	ImageURL    string    `bson:"image_url,omitempty" json:"image_url,omitempty"`
+	SiteName    string    `bson:"site_name,omitempty" json:"site_name,omitempty"`
+	FetchedAt   time.Time `bson:"fetched_at" json:"fetched_at"`
+}
+
+// LinkPreviewJob asks the preview worker to attach a preview of URL to a message
+type LinkPreviewJob struct {
+	MessageID primitive.ObjectID `json:"message_id"`
+	URL       string             `json:"url"`
+}
+
+// PreviewReadyEvent tells the participants of a conversation that a message's
+// link preview is available
+type PreviewReadyEvent struct {
+	MessageID  primitive.ObjectID `json:"message_id"`
+	SenderID   primitive.ObjectID `json:"sender_id"`
+	ReceiverID primitive.ObjectID `json:"receiver_id,omitempty"`
+	GroupID    primitive.ObjectID `json:"group_id,omitempty"`
+	Preview    LinkPreview        `json:"preview"`
+}
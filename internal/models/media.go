@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MediaStatusPending  = "pending"
+	MediaStatusUploaded = "uploaded"
+)
+
+// Media is an uploaded attachment. Kind is one of the image/video/file
+// message content types and decides which size and MIME limits apply.
+type Media struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID     primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Key         string             `bson:"key" json:"key"`
+	Kind        string             `bson:"kind" json:"kind"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+	URL         string             `bson:"url" json:"url"`
+	Status      string             `bson:"status" json:"status"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UploadedAt  *time.Time         `bson:"uploaded_at,omitempty" json:"uploaded_at,omitempty"`
+}
+
+type PresignRequest struct {
+	Kind        string `json:"kind" binding:"required"`
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+}
+
+type PresignResponse struct {
+	MediaID   string            `json:"media_id"`
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	MediaURL  string            `json:"media_url"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,7 +17,19 @@ type Message struct {
 	Content     string               `bson:"content,omitempty" json:"content,omitempty"` 
 	ContentType string               `bson:"content_type" json:"content_type"`
 	MediaURLs   []string             `bson:"media_urls,omitempty" json:"media_urls,omitempty"`
-	SeenBy      []primitive.ObjectID `bson:"seen_by" json:"seen_by"`
+	ReplyToID   primitive.ObjectID   `bson:"reply_to_id,omitempty" json:"reply_to_id,omitempty"`
+	ReplyTo     *ReplyPreview        `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	ForwardedFrom *ForwardedFrom     `bson:"forwarded_from,omitempty" json:"forwarded_from,omitempty"`
+	LinkPreview *LinkPreview         `bson:"link_preview,omitempty" json:"link_preview,omitempty"`
+	PollID      primitive.ObjectID   `bson:"poll_id,omitempty" json:"poll_id,omitempty"` // set for ContentTypePoll
+	Mentions    []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"` // group members mentioned as @username
+	SeenBy      []SeenReceipt        `bson:"seen_by" json:"seen_by"`
+	DeliveredTo []DeliveryReceipt    `bson:"delivered_to,omitempty" json:"delivered_to,omitempty"`
+	Status      string               `bson:"status,omitempty" json:"status,omitempty"`
+	DispatchAt  *time.Time           `bson:"dispatch_at,omitempty" json:"dispatch_at,omitempty"`
+	Seq         int64                `bson:"seq,omitempty" json:"seq,omitempty"` // increases within the conversation, in delivery order
+	Starred     bool                 `bson:"-" json:"starred,omitempty"` // by the user reading the history
+	Flagged     bool                 `bson:"flagged,omitempty" json:"-"` // matched the content filter; only moderators see it
 	IsDeleted       bool       `bson:"is_deleted" json:"is_deleted"`
     DeletedAt      *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
     OriginalContent string     `bson:"original_content,omitempty" json:"-"`
@@ -24,6 +37,118 @@ type Message struct {
 	UpdatedAt   time.Time            `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 }
 
+// MessageStatusPendingDispatch marks a message held back during its sender's
+// undo window. Only the sender can see it until it is dispatched at DispatchAt.
+const MessageStatusPendingDispatch = "pending_dispatch"
+
+// MaxUndoSendSeconds caps how long a user can choose to hold messages back
+const MaxUndoSendSeconds = 30
+
+// ConversationKey identifies the conversation m belongs to: the group ID, or
+// both participants' IDs in a fixed order so the two directions of a direct
+// chat share it. Kafka partitions by it, keeping a conversation in order.
+func (m Message) ConversationKey() string {
+	if !m.GroupID.IsZero() {
+		return m.GroupID.Hex()
+	}
+	a, b := m.SenderID.Hex(), m.ReceiverID.Hex()
+	if a > b {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
+
+// Tombstone is what clients see of a deleted message: enough to keep its
+// place in the conversation, without its content, media or references
+func (m Message) Tombstone() Message {
+	return Message{
+		ID:          m.ID,
+		SenderID:    m.SenderID,
+		ReceiverID:  m.ReceiverID,
+		GroupID:     m.GroupID,
+		Seq:         m.Seq,
+		ContentType: ContentTypeDeleted,
+		SeenBy:      []SeenReceipt{},
+		IsDeleted:   true,
+		DeletedAt:   m.DeletedAt,
+		CreatedAt:   m.CreatedAt,
+	}
+}
+
+// ReplyPreview is a snapshot of the message being replied to, stored on the
+// reply so clients can render it without a second fetch
+type ReplyPreview struct {
+	MessageID  primitive.ObjectID `bson:"message_id" json:"message_id"`
+	SenderID   primitive.ObjectID `bson:"sender_id" json:"sender_id"`
+	SenderName string             `bson:"sender_name,omitempty" json:"sender_name,omitempty"`
+	Content    string             `bson:"content,omitempty" json:"content,omitempty"`
+}
+
+// ForwardedFrom credits the author of a forwarded message. It deliberately
+// doesn't reference the source message or conversation.
+type ForwardedFrom struct {
+	SenderName string    `bson:"sender_name" json:"sender_name"`
+	SentAt     time.Time `bson:"sent_at" json:"sent_at"`
+}
+
+// ForwardMessageRequest names the conversation a message is forwarded to
+type ForwardMessageRequest struct {
+	ReceiverID string `json:"receiver_id,omitempty"`
+	GroupID    string `json:"group_id,omitempty"`
+}
+
+// DefaultMaxMessageLength caps message content, in characters, unless
+// configured otherwise
+const DefaultMaxMessageLength = 4000
+
+// A message carries at most MaxMediaURLs attachments, each an http or https
+// URL of up to MaxMediaURLLength bytes
+const (
+	MaxMediaURLs      = 10
+	MaxMediaURLLength = 2048
+)
+
+// ReplyPreviewLength is how many characters of the original message a preview keeps
+const ReplyPreviewLength = 80
+
+// SeenReceipt records when a participant read a message
+type SeenReceipt struct {
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+	SeenAt time.Time          `bson:"seen_at" json:"seen_at"`
+}
+
+// DeliveryReceipt records when a recipient's device first received a message,
+// over a WebSocket or as a push notification
+type DeliveryReceipt struct {
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	DeliveredAt time.Time          `bson:"delivered_at" json:"delivered_at"`
+}
+
+// MessageReceipt is one recipient's progress with a message. A seen message
+// counts as delivered even if its delivery wasn't recorded.
+type MessageReceipt struct {
+	UserID      primitive.ObjectID `json:"user_id"`
+	DeliveredAt *time.Time         `json:"delivered_at"`
+	SeenAt      *time.Time         `json:"seen_at"`
+}
+
+// MessageReceiptsResponse lists a message's receipts for its sender
+type MessageReceiptsResponse struct {
+	MessageID primitive.ObjectID `json:"message_id"`
+	Receipts  []MessageReceipt   `json:"receipts"`
+}
+
+// MessageDeliveredEvent tells a sender's connections that recipients' devices
+// received their message
+type MessageDeliveredEvent struct {
+	MessageID    primitive.ObjectID   `json:"message_id"`
+	SenderID     primitive.ObjectID   `json:"sender_id"`
+	ReceiverID   primitive.ObjectID   `json:"receiver_id,omitempty"`
+	GroupID      primitive.ObjectID   `json:"group_id,omitempty"`
+	RecipientIDs []primitive.ObjectID `json:"recipient_ids"`
+	DeliveredAt  time.Time            `json:"delivered_at"`
+}
+
 type TypingEvent struct {
     ConversationID string `json:"conversation_id"` // group_id or user_id
     UserID        string `json:"user_id"`
@@ -39,6 +164,7 @@ type MessageQuery struct {
 	Page       int    `form:"page,default=1"`
 	Limit      int    `form:"limit,default=50"`
 	Before     string `form:"before"` 
+	ThreadID   string `form:"thread_id"`
 }
 
 type MessageRequest struct {
@@ -49,6 +175,8 @@ type MessageRequest struct {
 	Content     string   `json:"content,omitempty"`
 	ContentType string   `json:"content_type"`
 	MediaURLs   []string `json:"media_urls,omitempty"` 
+	ReplyTo     string   `json:"reply_to,omitempty"`
+	Poll        *PollRequest `json:"poll,omitempty"` // required for ContentTypePoll, groups only
 }
 
 type MessageResponse struct {
@@ -59,6 +187,94 @@ type MessageResponse struct {
 	HasMore  bool      `json:"has_more"` 
 }
 
+// MessageSearchQuery searches the content of one conversation
+type MessageSearchQuery struct {
+	Query      string
+	GroupID    string
+	ReceiverID string
+	Page       int
+	Limit      int
+}
+
+// MessageSearchResult is a matching message with its neighbours in the
+// conversation, so clients can jump to it in context
+type MessageSearchResult struct {
+	Message Message  `json:"message"`
+	Before  *Message `json:"before,omitempty"`
+	After   *Message `json:"after,omitempty"`
+}
+
+type MessageSearchResponse struct {
+	Results []MessageSearchResult `json:"results"`
+	Page    int64                 `json:"page"`
+	Limit   int64                 `json:"limit"`
+	HasMore bool                  `json:"has_more"`
+}
+
+// FlaggedMessageListResponse is a page of the moderation queue: messages the
+// content filter flagged, newest first
+type FlaggedMessageListResponse struct {
+	Messages []Message `json:"messages"`
+	Page     int64     `json:"page"`
+	Limit    int64     `json:"limit"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// MaxSearchQueryLength bounds the search text of a message search
+const MaxSearchQueryLength = 200
+
+// WebSocketEvent is a typed envelope for non-message real-time events
+// travelling through Kafka and the WebSocket hub.
+type WebSocketEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// MessagesSeenEvent tells the original senders that their messages were read.
+// ConversationID is the group ID for group chats, otherwise the reader's ID
+// (the conversation as seen from the sender's side).
+type MessagesSeenEvent struct {
+	ConversationID string               `json:"conversation_id"`
+	IsGroup        bool                 `json:"is_group"`
+	ReaderID       primitive.ObjectID   `json:"reader_id"`
+	MessageIDs     []primitive.ObjectID `json:"message_ids"`
+	SenderIDs      []primitive.ObjectID `json:"sender_ids"`
+	SeenCounts     map[string]int       `json:"seen_counts"` // message ID -> number of readers
+	SeenAt         time.Time            `json:"seen_at"`
+}
+
+// WebSocket event types
+const (
+	EventMessagesSeen           = "MessagesSeen"
+	EventGroupMemberAdded       = "GroupMemberAdded"
+	EventGroupMemberRemoved     = "GroupMemberRemoved"
+	EventPresenceSnapshot       = "PresenceSnapshot"
+	EventPresenceChanged        = "PresenceChanged"
+	EventMessagePinned          = "MessagePinned"
+	EventMessageUnpinned        = "MessageUnpinned"
+	EventPreviewReady           = "PreviewReady"
+	EventGroupUpdated           = "GroupUpdated"
+	EventPollVoted              = "PollVoted"
+	EventPollClosed             = "PollClosed"
+	EventMessageDelivered       = "MessageDelivered"
+	EventSystemAnnouncement     = "SystemAnnouncement"
+	EventConversationUnarchived = "ConversationUnarchived"
+)
+
+// PresenceSnapshotEvent lists the user's friends that are online, sent once
+// when a WebSocket connects
+type PresenceSnapshotEvent struct {
+	OnlineFriends []string `json:"online_friends"`
+}
+
+// PresenceChangedEvent is pushed to a user's friends when they come online
+// or go offline on every instance
+type PresenceChangedEvent struct {
+	UserID string    `json:"user_id"`
+	Online bool      `json:"online"`
+	At     time.Time `json:"at"`
+}
+
 // Helper struct for message status updates
 type MessageStatusUpdate struct {
 	MessageID primitive.ObjectID `json:"message_id"`
@@ -66,6 +282,28 @@ type MessageStatusUpdate struct {
 	Action    string             `json:"action"` // "seen", "delivered", etc.
 }
 
+// ConversationSummary is one row of the chat sidebar. ID is the group ID for
+// group chats, otherwise the other participant's user ID.
+type ConversationSummary struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	IsGroup      bool               `bson:"is_group" json:"is_group"`
+	Name         string             `bson:"-" json:"name"`
+	Avatar       string             `bson:"-" json:"avatar,omitempty"`
+	LastMessage  Message            `bson:"last_message" json:"last_message"`
+	LastActivity time.Time          `bson:"last_activity" json:"last_activity"`
+	UnreadCount  int64              `bson:"unread_count" json:"unread_count"`
+	MentionCount int64              `bson:"mention_count" json:"mention_count"` // unread messages mentioning the user
+	Archived     bool               `bson:"-" json:"archived,omitempty"`
+}
+
+type ConversationListResponse struct {
+	Conversations []ConversationSummary `json:"conversations"`
+	Total         int64                 `json:"total"`
+	Page          int64                 `json:"page"`
+	Limit         int64                 `json:"limit"`
+	HasMore       bool                  `json:"has_more"`
+}
+
 type ErrorResponse struct {
     Error string `json:"error"`
 }
@@ -75,7 +313,17 @@ type SuccessResponse struct {
 }
 
 type UnreadCountResponse struct {
-    Count int64 `json:"count"`
+    Count                  int64            `json:"count"`
+    Mentions               int64            `json:"mentions"`                           // unread messages mentioning the user
+    ByConversation         map[string]int64 `json:"by_conversation,omitempty"`          // conversation ID -> unread
+    MentionsByConversation map[string]int64 `json:"mentions_by_conversation,omitempty"` // group ID -> unread mentions
+}
+
+// UnreadCounts are a user's unread messages and unread mentions of them, keyed
+// by conversation ID
+type UnreadCounts struct {
+	Messages map[string]int64
+	Mentions map[string]int64
 }
 
 // Content type constants
@@ -90,6 +338,7 @@ const (
     ContentTypeTextFile  = "text_file"
     ContentTypeMultiple  = "multiple"
     ContentTypeDeleted   = "deleted"
+    ContentTypePoll      = "poll"
 )
 
 var ValidContentTypes = map[string]bool{
@@ -103,6 +352,7 @@ var ValidContentTypes = map[string]bool{
     ContentTypeTextFile:  true,
     ContentTypeMultiple:  true,
     ContentTypeDeleted:   true,
+    ContentTypePoll:      true,
 }
 
 
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OutboxEvent is a Kafka record stored alongside the write it announces. The
+// outbox relay publishes it once the broker is reachable, so the record isn't
+// lost when publishing right after the write fails.
+type OutboxEvent struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Key           string             `bson:"key"`
+	Value         []byte             `bson:"value"`
+	RequestID     string             `bson:"request_id,omitempty"` // of the request that stored the event
+	Attempts      int                `bson:"attempts"`
+	LastError     string             `bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	SentAt        *time.Time         `bson:"sent_at,omitempty"`
+}
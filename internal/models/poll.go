@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Poll limits
+const (
+	MinPollOptions        = 2
+	MaxPollOptions        = 10
+	MaxPollQuestionLength = 300
+	MaxPollOptionLength   = 100
+)
+
+// Who sees a poll's results
+const (
+	PollResultsAlways      = "always"
+	PollResultsAfterVoting = "after_voting" // until the poll closes
+)
+
+// Poll is attached to a group message with ContentTypePoll. Votes are stored
+// separately, one PollVote per voter.
+type Poll struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MessageID         primitive.ObjectID `bson:"message_id" json:"message_id"`
+	GroupID           primitive.ObjectID `bson:"group_id" json:"group_id"`
+	CreatorID         primitive.ObjectID `bson:"creator_id" json:"creator_id"`
+	Question          string             `bson:"question" json:"question"`
+	Options           []string           `bson:"options" json:"options"`
+	MultiChoice       bool               `bson:"multi_choice" json:"multi_choice"`
+	ResultsVisibility string             `bson:"results_visibility" json:"results_visibility"`
+	ClosesAt          *time.Time         `bson:"closes_at,omitempty" json:"closes_at,omitempty"`
+	ClosedAt          *time.Time         `bson:"closed_at,omitempty" json:"closed_at,omitempty"` // closed early by the creator
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// IsClosed reports whether the poll stopped taking votes by now
+func (p *Poll) IsClosed(now time.Time) bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !now.Before(*p.ClosesAt))
+}
+
+// PollVote holds a user's choices in a poll, as option indexes. A single
+// choice poll takes exactly one.
+type PollVote struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PollID    primitive.ObjectID `bson:"poll_id" json:"poll_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Options   []int              `bson:"options" json:"options"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// PollRequest creates a poll along with a group message
+type PollRequest struct {
+	Question          string     `json:"question"`
+	Options           []string   `json:"options"`
+	MultiChoice       bool       `json:"multi_choice"`
+	ClosesAt          *time.Time `json:"closes_at,omitempty"`
+	ResultsVisibility string     `json:"results_visibility,omitempty"` // defaults to PollResultsAlways
+}
+
+// PollVoteRequest picks options by index
+type PollVoteRequest struct {
+	Options []int `json:"options" binding:"required"`
+}
+
+// PollResults is a poll as one viewer sees it. Counts is nil while the
+// results are hidden from the viewer.
+type PollResults struct {
+	Poll
+	Closed      bool    `json:"closed"`
+	Counts      []int64 `json:"counts,omitempty"` // votes per option, in option order
+	TotalVoters int64   `json:"total_voters"`
+	Voted       bool    `json:"voted"`
+	MyOptions   []int   `json:"my_options,omitempty"`
+}
+
+// PollEvent is sent to a group's connections when a poll's votes change or it
+// closes. Counts is left out while the poll hides results until voting.
+type PollEvent struct {
+	PollID      primitive.ObjectID `json:"poll_id"`
+	MessageID   primitive.ObjectID `json:"message_id"`
+	GroupID     primitive.ObjectID `json:"group_id"`
+	Counts      []int64            `json:"counts,omitempty"`
+	TotalVoters int64              `json:"total_voters"`
+	Closed      bool               `json:"closed"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Device platforms that can receive push notifications
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformWeb     = "web"
+)
+
+// Device is a push token registered by one of a user's app installs. A token
+// belongs to one user at a time: registering it again moves it.
+type Device struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Platform  string             `bson:"platform" json:"platform"`
+	Token     string             `bson:"token" json:"token"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type DeviceRequest struct {
+	Platform string `json:"platform" binding:"required,oneof=android ios web"`
+	Token    string `json:"token" binding:"required,max=4096"`
+}
+
+type DeviceDeleteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PushJob asks the push worker to notify a user who was offline when a
+// message arrived
+type PushJob struct {
+	UserID    primitive.ObjectID `json:"user_id"`
+	MessageID primitive.ObjectID `json:"message_id"`
+	// Notifications of one conversation replace each other on the device
+	CollapseKey string    `json:"collapse_key"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body"`
+	QueuedAt    time.Time `json:"queued_at"`
+}
+
+// PushNotification is one notification for one device
+type PushNotification struct {
+	Token       string
+	Platform    string
+	CollapseKey string
+	Title       string
+	Body        string
+	Data        map[string]string
+}
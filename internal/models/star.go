@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Star marks a message a user wants to find again. Stars are private to the
+// user who set them.
+type Star struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"-"`
+	MessageID       primitive.ObjectID `bson:"message_id" json:"message_id"`
+	ConversationKey string             `bson:"conversation_key" json:"-"`
+	CreatedAt       time.Time          `bson:"created_at" json:"starred_at"`
+}
+
+// StarredConversation is the conversation a starred message belongs to, as
+// the user sees it: the group, or the other participant of a direct chat
+type StarredConversation struct {
+	ID      primitive.ObjectID `json:"id"`
+	IsGroup bool               `json:"is_group"`
+	Name    string             `json:"name"`
+	Avatar  string             `json:"avatar,omitempty"`
+}
+
+type StarredMessage struct {
+	Message      Message             `json:"message"`
+	Sender       UserSummary         `json:"sender"`
+	Conversation StarredConversation `json:"conversation"`
+	StarredAt    time.Time           `json:"starred_at"`
+}
+
+// StarredMessageListResponse is a page of the user's starred messages, most
+// recently starred first
+type StarredMessageListResponse struct {
+	Messages []StarredMessage `json:"messages"`
+	Page     int64            `json:"page"`
+	Limit    int64            `json:"limit"`
+	HasMore  bool             `json:"has_more"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sync limits bound one response; has_more tells the client to sync again
+const (
+	MaxSyncConversations           = 100
+	MaxSyncMessagesPerConversation = 50
+	MaxSyncFriendships             = 200
+	MaxSyncGroups                  = 100
+	MaxSyncUsers                   = 200
+)
+
+// ConversationChanges are the messages of one conversation created or changed
+// since the sync cursor, oldest change first. When HasMore is set only the
+// most recent ones are included and the client fetches the rest from the
+// conversation's history.
+type ConversationChanges struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"` // the group ID, or the other user's ID
+	IsGroup   bool               `bson:"is_group" json:"is_group"`
+	Messages  []Message          `bson:"messages" json:"messages"`
+	ChangedAt time.Time          `bson:"changed_at" json:"changed_at"`
+	HasMore   bool               `bson:"has_more" json:"has_more"`
+}
+
+// SyncResponse is everything that changed for a user since a sync cursor.
+// Pass SyncToken as since next time. Items changed at the cursor itself may
+// be sent twice, so clients merge them by ID.
+type SyncResponse struct {
+	Conversations []ConversationChanges `json:"conversations"`
+	Friendships   []Friendship          `json:"friendships"`
+	Groups        []Group               `json:"groups"`
+	Users         []SafeUserResponse    `json:"users"` // friends whose profile changed
+	SyncToken     string                `json:"sync_token"`
+	HasMore       bool                  `json:"has_more"`
+}
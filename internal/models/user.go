@@ -9,13 +9,108 @@ import (
 type User struct {
     ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
     Username  string               `bson:"username" json:"username"`
+    UsernameLower string           `bson:"username_lower" json:"-"` // indexed for prefix search
     Email     string               `bson:"email" json:"email"`
+    PhoneNumber string             `bson:"phone_number,omitempty" json:"phone_number,omitempty"` // E.164
+    PhoneHash   string             `bson:"phone_hash,omitempty" json:"-"`                        // SHA-256 of PhoneNumber, matched by contact discovery
+    DiscoverableByPhone *bool      `bson:"discoverable_by_phone,omitempty" json:"discoverable_by_phone,omitempty"` // nil means discoverable
+    PhoneVisibility string         `bson:"phone_visibility,omitempty" json:"phone_visibility,omitempty"` // empty means FriendListOnlyMe
     Password  string               `bson:"password" json:"password"`
+    EmailVerified bool             `bson:"email_verified" json:"email_verified"`
+    TwoFactorEnabled bool          `bson:"two_factor_enabled" json:"two_factor_enabled"`
+    TwoFactorSecret  string        `bson:"two_factor_secret,omitempty" json:"-"` // encrypted
+    RecoveryCodes    []string      `bson:"recovery_codes,omitempty" json:"-"`    // SHA-256 hashes
+    UndoSendSeconds  int           `bson:"undo_send_seconds,omitempty" json:"undo_send_seconds"` // 0 sends immediately
+    FriendListVisibility string    `bson:"friend_list_visibility,omitempty" json:"friend_list_visibility,omitempty"` // empty means FriendListFriends
+    IsPrivate        bool          `bson:"is_private,omitempty" json:"is_private"` // follows need approval
+    Role             string        `bson:"role,omitempty" json:"-"`                // empty means RoleUser; never set by clients
+    TokenVersion     int64         `bson:"token_version,omitempty" json:"-"`       // bumped to end every session
 	Avatar     string              `bson:"avatar" json:"avatar"`
+	AvatarSizes map[string]string  `bson:"avatar_sizes,omitempty" json:"avatar_sizes,omitempty"` // URL per pixel size
+	AvatarKeys  []string           `bson:"avatar_keys,omitempty" json:"-"`                      // storage keys, removed on replacement
     Friends   []primitive.ObjectID `bson:"friends" json:"friends"`
     Blocked   []primitive.ObjectID `bson:"blocked" json:"-"`
     CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+    UpdatedAt time.Time            `bson:"updated_at,omitempty" json:"-"`
+    DeactivatedAt *time.Time       `bson:"deactivated_at,omitempty" json:"-"`
+    AnonymizedAt  *time.Time       `bson:"anonymized_at,omitempty" json:"-"`
+    DeletionDueAt *time.Time       `bson:"deletion_due_at,omitempty" json:"-"` // set while a deletion is scheduled or running
+    DeletionStage string           `bson:"deletion_stage,omitempty" json:"-"`  // last completed deletion stage
 }
+
+// IsActive reports whether the account is usable; deactivated accounts can
+// only log in again through reactivation
+func (u *User) IsActive() bool {
+	return u.DeactivatedAt == nil
+}
+
+// IsDiscoverableByPhone reports whether contacts holding the user's number may
+// find them through discovery
+func (u *User) IsDiscoverableByPhone() bool {
+	return u.DiscoverableByPhone == nil || *u.DiscoverableByPhone
+}
+
+// Roles carried in access tokens
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// EffectiveRole returns the user's role, RoleUser unless one was assigned
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// Who besides the user may see their friend list or phone number
+const (
+	FriendListEveryone = "everyone"
+	FriendListFriends  = "friends"
+	FriendListOnlyMe   = "only_me"
+)
+
+func IsValidFriendListVisibility(v string) bool {
+	return v == FriendListEveryone || v == FriendListFriends || v == FriendListOnlyMe
+}
+
+// CanSeeFriendList reports whether viewerID may see who u's friends are.
+// Blocks are not considered here.
+func (u *User) CanSeeFriendList(viewerID primitive.ObjectID) bool {
+	return u.visibleTo(viewerID, u.FriendListVisibility)
+}
+
+// CanSeePhoneNumber reports whether viewerID may see u's phone number, which
+// only u sees unless they share it. Blocks are not considered here.
+func (u *User) CanSeePhoneNumber(viewerID primitive.ObjectID) bool {
+	if u.PhoneVisibility == "" {
+		return viewerID == u.ID
+	}
+	return u.visibleTo(viewerID, u.PhoneVisibility)
+}
+
+// visibleTo applies a visibility setting to viewerID, treating anything but
+// everyone and only_me as friends
+func (u *User) visibleTo(viewerID primitive.ObjectID, visibility string) bool {
+	if viewerID == u.ID {
+		return true
+	}
+	switch visibility {
+	case FriendListEveryone:
+		return true
+	case FriendListOnlyMe:
+		return false
+	}
+	for _, id := range u.Friends {
+		if id == viewerID {
+			return true
+		}
+	}
+	return false
+}
+
 type Friendship struct {
     ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     RequesterID primitive.ObjectID `bson:"requester_id" json:"requester_id"`
@@ -28,17 +123,188 @@ type Friendship struct {
 type Group struct {
     ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
     Name        string               `bson:"name" json:"name"`
+    Description string               `bson:"description,omitempty" json:"description"`
+    Avatar      string               `bson:"avatar,omitempty" json:"avatar"`
     CreatorID   primitive.ObjectID   `bson:"creator_id" json:"creator_id"`
+    OwnerID     primitive.ObjectID   `bson:"owner_id,omitempty" json:"owner_id"`
     Members     []primitive.ObjectID `bson:"members" json:"members"`
     Admins      []primitive.ObjectID `bson:"admins" json:"admins"`
+    Moderators  []primitive.ObjectID `bson:"moderators,omitempty" json:"moderators"`
+    RequireApproval bool             `bson:"require_approval" json:"require_approval"` // invite joins wait for an admin
+    Settings    GroupSettings        `bson:"settings,omitempty" json:"settings"`
+    PinnedMessages []PinnedMessage   `bson:"pinned_messages,omitempty" json:"pinned_messages,omitempty"`
     CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
     UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"` 
 }
 
+// GroupSettings decide who may do what in a group. Empty values mean the
+// defaults: only admins add members and everyone can send messages.
+type GroupSettings struct {
+	AddMembers   string `bson:"add_members,omitempty" json:"add_members"`
+	SendMessages string `bson:"send_messages,omitempty" json:"send_messages"`
+}
+
+// Who a group setting allows
+const (
+	GroupPermissionAdmins = "admins"
+	GroupPermissionAll    = "all"
+)
+
+// WithDefaults spells out the defaults of unset settings
+func (s GroupSettings) WithDefaults() GroupSettings {
+	if s.AddMembers == "" {
+		s.AddMembers = GroupPermissionAdmins
+	}
+	if s.SendMessages == "" {
+		s.SendMessages = GroupPermissionAll
+	}
+	return s
+}
+
+// IsValidGroupPermission reports whether p can be stored in GroupSettings
+func IsValidGroupPermission(p string) bool {
+	return p == GroupPermissionAdmins || p == GroupPermissionAll
+}
+
+// Group roles, from most to least privileged
+const (
+	GroupRoleOwner     = "owner"
+	GroupRoleAdmin     = "admin"
+	GroupRoleModerator = "moderator"
+	GroupRoleMember    = "member"
+)
+
+// Owner returns the group owner. Groups created before ownership existed are
+// owned by their creator.
+func (g *Group) Owner() primitive.ObjectID {
+	if g.OwnerID.IsZero() {
+		return g.CreatorID
+	}
+	return g.OwnerID
+}
+
+// Role returns the highest role userID holds in the group, or "" for non-members
+func (g *Group) Role(userID primitive.ObjectID) string {
+	switch {
+	case g.Owner() == userID:
+		return GroupRoleOwner
+	case hasID(g.Admins, userID):
+		return GroupRoleAdmin
+	case hasID(g.Moderators, userID):
+		return GroupRoleModerator
+	case hasID(g.Members, userID):
+		return GroupRoleMember
+	default:
+		return ""
+	}
+}
+
+// CanModerateMessages reports whether userID may delete other members' messages
+func (g *Group) CanModerateMessages(userID primitive.ObjectID) bool {
+	switch g.Role(userID) {
+	case GroupRoleOwner, GroupRoleAdmin, GroupRoleModerator:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAdmin reports whether userID is the owner or an admin
+func (g *Group) IsAdmin(userID primitive.ObjectID) bool {
+	role := g.Role(userID)
+	return role == GroupRoleOwner || role == GroupRoleAdmin
+}
+
+// CanAddMembers reports whether userID may add members directly
+func (g *Group) CanAddMembers(userID primitive.ObjectID) bool {
+	if g.Settings.AddMembers == GroupPermissionAll {
+		return g.Role(userID) != ""
+	}
+	return g.IsAdmin(userID)
+}
+
+// CanSendMessages reports whether userID may post; announcement groups only
+// let admins post
+func (g *Group) CanSendMessages(userID primitive.ObjectID) bool {
+	if g.Settings.SendMessages == GroupPermissionAdmins {
+		return g.IsAdmin(userID)
+	}
+	return g.Role(userID) != ""
+}
+
+func hasID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthResponse carries the tokens after a successful login. When the account
+// has two-factor authentication enabled, login instead returns only
+// TwoFactorRequired and a ChallengeToken to exchange at POST /api/auth/2fa.
 type AuthResponse struct {
-	AccessToken  string 			`json:"access_token"`
-	RefreshToken string 			`json:"refresh_token"`
-	User         SafeUserResponse   `json:"user"`
+	AccessToken  string 			`json:"access_token,omitempty"`
+	RefreshToken string 			`json:"refresh_token,omitempty"`
+	User         SafeUserResponse   `json:"user,omitzero"`
+
+	TwoFactorRequired  bool   `json:"2fa_required,omitempty"`
+	ChallengeToken     string `json:"challenge_token,omitempty"`
+	ChallengeExpiresIn int64  `json:"challenge_expires_in,omitempty"` // seconds
+}
+
+// TwoFactorSetupResponse carries the secret to add to an authenticator app.
+// OTPAuthURL is also the QR code payload.
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"` // TOTP or recovery code
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// WSTicketResponse carries a single-use ticket for opening a WebSocket as
+// /ws?ticket=<ticket>, so the access token never appears in a URL
+type WSTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+}
+
+// RegisterRequest is everything a client chooses when signing up; every other
+// user field starts at its default
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
 }
 
 type RefreshRequest struct {
@@ -46,47 +312,188 @@ type RefreshRequest struct {
 }
 
 type UserUpdateRequest struct {
-	Username        string `json:"username,omitempty"`
-	Email           string `json:"email,omitempty"`
-	CurrentPassword string `json:"current_password,omitempty"`
-	NewPassword     string `json:"new_password,omitempty"`
+	Username             string  `json:"username,omitempty"`
+	Email                string  `json:"email,omitempty"`
+	CurrentPassword      string  `json:"current_password,omitempty"`
+	NewPassword          string  `json:"new_password,omitempty"`
+	UndoSendSeconds      *int    `json:"undo_send_seconds,omitempty"`
+	FriendListVisibility *string `json:"friend_list_visibility,omitempty"`
+	IsPrivate            *bool   `json:"is_private,omitempty"` // follows need approval
+	PhoneNumber          *string `json:"phone_number,omitempty"` // international format; empty removes it
+	DiscoverableByPhone  *bool   `json:"discoverable_by_phone,omitempty"`
+	PhoneVisibility      *string `json:"phone_visibility,omitempty"` // everyone, friends or only_me
+}
+
+// MaxDiscoveryHashes bounds the contacts checked in one discovery request
+const MaxDiscoveryHashes = 1000
+
+// PhoneDiscoveryRequest carries hex SHA-256 hashes of the caller's contacts'
+// numbers, normalized to E.164, so raw numbers never leave the device
+type PhoneDiscoveryRequest struct {
+	Hashes []string `json:"hashes" binding:"required"`
+}
+
+// PhoneDiscoveryResponse lists the discoverable users among the contacts. It
+// doesn't say which hash matched whom.
+type PhoneDiscoveryResponse struct {
+	Users []SafeUserResponse `json:"users"`
+}
+
+// AvatarResponse is the stored avatar after an upload: the canonical URL and
+// one URL per generated size
+type AvatarResponse struct {
+	Avatar      string            `json:"avatar"`
+	AvatarSizes map[string]string `json:"avatar_sizes"`
 }
 
 type UserListResponse struct {
-	Users []User `json:"users"`
-	Total int64  `json:"total"`
-	Page  int64  `json:"page"`
-	Limit int64  `json:"limit"`
+	Users      []SafeUserResponse `json:"users"`
+	Total      int64              `json:"total"`
+	Page       int64              `json:"page"`
+	Limit      int64              `json:"limit"`
+	SearchMode string             `json:"search_mode"`
+}
+
+// How GET /api/users matched the search, reported for debugging
+const (
+	UserSearchAll    = "all"    // no search, everyone in username order
+	UserSearchPrefix = "prefix" // single word, username prefix
+	UserSearchText   = "text"   // several words, full-text relevance
+)
+
+// How the viewer of a profile relates to its owner
+const (
+	ProfileRelationshipSelf   = "self"
+	ProfileRelationshipFriend = "friend"
+	ProfileRelationshipNone   = "none"
+)
+
+// ProfileResponse is a user's profile as the viewer may see it. The email is
+// only included on the viewer's own profile and the friend list only for the
+// user and their friends; everyone else gets the counts.
+type ProfileResponse struct {
+	ID                primitive.ObjectID   `json:"id"`
+	Username          string               `json:"username"`
+	Email             string               `json:"email,omitempty"`
+	PhoneNumber       string               `json:"phone_number,omitempty"` // when phone_visibility allows
+	Avatar            string               `json:"avatar"`
+	AvatarSizes       map[string]string    `json:"avatar_sizes,omitempty"`
+	CreatedAt         time.Time            `json:"created_at"`
+	Relationship      string               `json:"relationship"`
+	FriendCount       int                  `json:"friend_count"`
+	Friends           []primitive.ObjectID `json:"friends,omitempty"`
+	MutualFriendCount *int                 `json:"mutual_friend_count,omitempty"` // not on the viewer's own profile
+	IsPrivate         bool                 `json:"is_private"`
+	FollowerCount     int64                `json:"follower_count"`
+	FollowingCount    int64                `json:"following_count"`
+	FollowStatus      string               `json:"follow_status,omitempty"` // the viewer's follow of the user, if any
+}
+
+// UserSuggestion is the lightweight user shape returned for mention autocomplete
+type UserSuggestion struct {
+	ID       primitive.ObjectID `json:"id"`
+	Username string             `json:"username"`
+	Avatar   string             `json:"avatar"`
 }
 
+// FriendSuggestion is a user the current user may know, ranked by mutual friends
+type FriendSuggestion struct {
+	ID                primitive.ObjectID `bson:"_id" json:"id"`
+	Username          string             `bson:"username" json:"username"`
+	Avatar            string             `bson:"avatar" json:"avatar"`
+	MutualFriendCount int                `bson:"mutual_count" json:"mutual_friend_count"`
+	MutualFriends     []string           `bson:"mutual_friends" json:"mutual_friends"` // up to SuggestionMutualNames usernames
+}
+
+// SuggestionMutualNames is how many mutual friends a suggestion names
+const SuggestionMutualNames = 3
+
 type SafeUserResponse struct {
     ID        primitive.ObjectID   `json:"id"`
     Username  string              `json:"username"`
     Email     string              `json:"email"`
+    EmailVerified bool            `json:"email_verified"`
+    TwoFactorEnabled bool         `json:"two_factor_enabled"`
+    PhoneNumber string            `json:"phone_number,omitempty"` // only in listings, when phone_visibility allows
     Avatar    string              `json:"avatar,omitempty"`
+    AvatarSizes map[string]string `json:"avatar_sizes,omitempty"`
     Friends   []primitive.ObjectID `json:"friends,omitempty"`
     CreatedAt time.Time           `json:"created_at"`
 }
 
+// FriendRequestResponse is a friendship row enriched with the other user's profile
+type FriendRequestResponse struct {
+	Friendship
+	Direction string           `json:"direction"` // "incoming" or "outgoing"
+	User      SafeUserResponse `json:"user"`
+}
+
+// FriendshipStatusResponse describes every relationship between the current
+// user and another user. PendingRequestID is set while a request is pending in
+// either direction so the client can respond to or cancel it directly.
+type FriendshipStatusResponse struct {
+	AreFriends       bool                `json:"are_friends"`
+	RequestSent      bool                `json:"request_sent"`
+	RequestReceived  bool                `json:"request_received"`
+	Blocked          bool                `json:"blocked"`    // the current user blocked the other user
+	BlockedBy        bool                `json:"blocked_by"` // the other user blocked the current user
+	PendingRequestID *primitive.ObjectID `json:"pending_request_id,omitempty"`
+}
+
+// DeletedUsername is shown for deleted and anonymized accounts
+const DeletedUsername = "Deleted User"
+
+// What happens to a deleted user's messages: kept under DeletedUsername, or
+// tombstoned like messages deleted by their sender
+const (
+	DeletionPolicyKeep      = "keep"
+	DeletionPolicyTombstone = "tombstone"
+)
+
+// UserSummary is the part of a user that responses embed next to the things
+// the user owns, as cached by services.UserSummaryCache
+type UserSummary struct {
+	ID       primitive.ObjectID `json:"id"`
+	Username string             `json:"username"`
+	Email    string             `json:"email"`
+	Avatar   string             `json:"avatar,omitempty"`
+}
+
+// Summary returns the cacheable part of u
+func (u *User) Summary() UserSummary {
+	return UserSummary{ID: u.ID, Username: u.Username, Email: u.Email, Avatar: u.Avatar}
+}
+
+// DeletedUserPlaceholder stands in for users that no longer exist
+func DeletedUserPlaceholder(id primitive.ObjectID) SafeUserResponse {
+	return SafeUserResponse{
+		ID:       id,
+		Username: DeletedUsername,
+	}
+}
+
 func (u *User) ToSafeResponse() SafeUserResponse {
     return SafeUserResponse{
         ID:        u.ID,
         Username:  u.Username,
         Email:     u.Email,
+        EmailVerified: u.EmailVerified,
+        TwoFactorEnabled: u.TwoFactorEnabled,
         Avatar:    u.Avatar,
+        AvatarSizes: u.AvatarSizes,
         Friends:   u.Friends,
         CreatedAt: u.CreatedAt,
     }
 }
 
+const (
+	FriendRequestIncoming = "incoming"
+	FriendRequestOutgoing = "outgoing"
+)
+
 const (
 	FriendshipStatusPending  = "pending"
 	FriendshipStatusAccepted = "accepted"
 	FriendshipStatusRejected = "rejected"
 	FriendshipStatusBlocked  = "blocked" 
-)
-
-const (
-	GroupRoleMember = "member"
-	GroupRoleAdmin  = "admin"
 )
\ No newline at end of file
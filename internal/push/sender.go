@@ -0,0 +1,209 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken means the provider will never deliver to this token again
+// (the app was uninstalled or the token rotated), so it should be forgotten
+var ErrInvalidToken = errors.New("invalid push token")
+
+// Sender delivers push notifications
+type Sender interface {
+	Send(ctx context.Context, notification models.PushNotification) error
+}
+
+// NewSender returns an FCM sender, or a sender that only logs when no FCM
+// credentials are configured (local development). FCM also delivers to iOS
+// devices through its APNs integration.
+func NewSender(cfg *config.Config) (Sender, error) {
+	if cfg.FCMCredentialsFile == "" {
+		return LogSender{}, nil
+	}
+	return NewFCMSender(cfg.FCMCredentialsFile)
+}
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMSender sends through the FCM HTTP v1 API, authenticating as a Google
+// service account
+type FCMSender struct {
+	projectID   string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	tokenURI    string
+	sendURL     string
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSender reads a service account key file downloaded from the Firebase console
+func NewFCMSender(credentialsFile string) (*FCMSender, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+	}
+	var creds struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM credentials: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(creds.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FCM private key: %w", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &FCMSender{
+		projectID:   creds.ProjectID,
+		clientEmail: creds.ClientEmail,
+		privateKey:  key,
+		tokenURI:    creds.TokenURI,
+		sendURL:     "https://fcm.googleapis.com/v1/projects/" + creds.ProjectID + "/messages:send",
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *FCMSender) Send(ctx context.Context, n models.PushNotification) error {
+	accessToken, err := s.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"token":        n.Token,
+		"notification": map[string]string{"title": n.Title, "body": n.Body},
+		"data":         n.Data,
+	}
+	// Each platform has its own way of replacing an earlier notification
+	switch n.Platform {
+	case models.PlatformAndroid:
+		message["android"] = map[string]string{"collapse_key": n.CollapseKey}
+	case models.PlatformIOS:
+		message["apns"] = map[string]interface{}{"headers": map[string]string{"apns-collapse-id": n.CollapseKey}}
+	case models.PlatformWeb:
+		message["webpush"] = map[string]interface{}{"headers": map[string]string{"Topic": n.CollapseKey}}
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.sendURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var failure struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&failure)
+	for _, d := range failure.Error.Details {
+		if d.ErrorCode == "UNREGISTERED" || d.ErrorCode == "SENDER_ID_MISMATCH" {
+			return ErrInvalidToken
+		}
+	}
+	return fmt.Errorf("FCM returned %d %s: %s", resp.StatusCode, failure.Error.Status, failure.Error.Message)
+}
+
+// token returns a cached OAuth access token, exchanging a signed assertion
+// for a new one shortly before the old one expires
+func (s *FCMSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && time.Now().Before(s.expiresAt.Add(-time.Minute)) {
+		return s.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.clientEmail,
+		"scope": fcmScope,
+		"aud":   s.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	s.accessToken = token.AccessToken
+	s.expiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
+
+// LogSender writes notifications to the log instead of sending them
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, n models.PushNotification) error {
+	log.Printf("Push to %s device: %s: %s", n.Platform, n.Title, n.Body)
+	return nil
+}
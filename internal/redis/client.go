@@ -62,6 +62,10 @@ func (c *ClusterClient) Subscribe(ctx context.Context, channels ...string) *redi
 	return c.ClusterClient.Subscribe(ctx, channels...)
 }
 
+// HubEventsChannel carries typing indicators and read receipts between
+// WebSocket hub instances, so they reach users connected to any of them
+const HubEventsChannel = "hub_events"
+
 // Close terminates the connection
 func (c *ClusterClient) Close() error {
 	return c.ClusterClient.Close()
@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GroupMembersTTL bounds how long a cached member set can outlive a missed invalidation
+const GroupMembersTTL = time.Hour
+
+// GroupMembersKey is the one key scheme for cached group member sets
+func GroupMembersKey(groupID string) string {
+	return "group:" + groupID + ":members"
+}
+
+// GetGroupMembers returns the cached member IDs of a group. ok is false on a
+// cache miss, in which case callers should load the group and cache it.
+func GetGroupMembers(ctx context.Context, client redis.Cmdable, groupID string) (members []string, ok bool, err error) {
+	members, err = client.SMembers(ctx, GroupMembersKey(groupID)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	return members, len(members) > 0, nil
+}
+
+// CacheGroupMembers replaces the cached member set of a group
+func CacheGroupMembers(ctx context.Context, client redis.Cmdable, groupID string, members []primitive.ObjectID) error {
+	if len(members) == 0 {
+		return InvalidateGroupMembers(ctx, client, groupID)
+	}
+
+	ids := make([]interface{}, len(members))
+	for i, m := range members {
+		ids[i] = m.Hex()
+	}
+
+	key := GroupMembersKey(groupID)
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.SAdd(ctx, key, ids...)
+		pipe.Expire(ctx, key, GroupMembersTTL)
+		return nil
+	})
+	return err
+}
+
+// InvalidateGroupMembers drops the cached member set after a membership change
+func InvalidateGroupMembers(ctx context.Context, client redis.Cmdable, groupID string) error {
+	return client.Del(ctx, GroupMembersKey(groupID)).Err()
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyTTL is how long a retry with the same Idempotency-Key replays
+// the original result
+const IdempotencyTTL = 24 * time.Hour
+
+// MaxIdempotencyKeyLength bounds the client-chosen part of the key
+const MaxIdempotencyKeyLength = 255
+
+// idempotencyPending marks a key whose request is still being handled
+const idempotencyPending = "pending"
+
+// Claims the key for a new request, or returns what it holds already
+var claimIdempotencyScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current then
+	return current
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return false`)
+
+// Frees the key of a failed request so a retry can run it again
+var releaseIdempotencyScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// IdempotencyKey scopes a client's key to the user and the endpoint
+func IdempotencyKey(endpoint, userID, key string) string {
+	return "idempotency:" + endpoint + ":" + userID + ":" + key
+}
+
+// ClaimIdempotencyKey atomically claims key for a new request. When another
+// request claimed it first, claimed is false and resourceID is the ID that
+// request created, or empty while it is still in progress.
+func ClaimIdempotencyKey(ctx context.Context, client redis.Scripter, key string) (resourceID string, claimed bool, err error) {
+	current, err := claimIdempotencyScript.Run(ctx, client, []string{key}, idempotencyPending, IdempotencyTTL.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if current == idempotencyPending {
+		return "", false, nil
+	}
+	return current, false, nil
+}
+
+// CompleteIdempotencyKey records the ID of the resource a claimed request created
+func CompleteIdempotencyKey(ctx context.Context, client redis.Cmdable, key, resourceID string) error {
+	return client.Set(ctx, key, resourceID, IdempotencyTTL).Err()
+}
+
+// ReleaseIdempotencyKey gives up a claim whose request failed
+func ReleaseIdempotencyKey(ctx context.Context, client redis.Scripter, key string) error {
+	return releaseIdempotencyScript.Run(ctx, client, []string{key}, idempotencyPending).Err()
+}
@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FriendsTTL bounds how long a cached friend set can outlive a missed invalidation
+const FriendsTTL = time.Hour
+
+// PresenceTTL is how long a user stays online on an instance that stops
+// refreshing, e.g. after a crash. Hubs refresh well within it.
+const PresenceTTL = 2 * time.Minute
+
+// PresenceChannel carries models.PresenceChangedEvent between instances
+const PresenceChannel = "presence"
+
+// FriendsKey is the one key scheme for cached friend sets
+func FriendsKey(userID string) string {
+	return "user:" + userID + ":friends"
+}
+
+// PresenceKey holds the IDs of the instances a user is connected to
+func PresenceKey(userID string) string {
+	return "presence:" + userID
+}
+
+// GetFriends returns the cached friend IDs of a user. ok is false on a cache
+// miss, in which case callers should load the user and cache the list.
+func GetFriends(ctx context.Context, client redis.Cmdable, userID string) (friends []string, ok bool, err error) {
+	friends, err = client.SMembers(ctx, FriendsKey(userID)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	return friends, len(friends) > 0, nil
+}
+
+// CacheFriends replaces the cached friend set of a user
+func CacheFriends(ctx context.Context, client redis.Cmdable, userID string, friends []primitive.ObjectID) error {
+	if len(friends) == 0 {
+		return InvalidateFriends(ctx, client, userID)
+	}
+
+	ids := make([]interface{}, len(friends))
+	for i, f := range friends {
+		ids[i] = f.Hex()
+	}
+
+	key := FriendsKey(userID)
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.SAdd(ctx, key, ids...)
+		pipe.Expire(ctx, key, FriendsTTL)
+		return nil
+	})
+	return err
+}
+
+// InvalidateFriends drops the cached friend sets after a friendship change
+func InvalidateFriends(ctx context.Context, client redis.Cmdable, userIDs ...string) error {
+	// Keys may live on different cluster slots, so delete them one by one
+	for _, id := range userIDs {
+		if err := client.Del(ctx, FriendsKey(id)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkOnline records that instanceID holds a connection of the user and
+// reports whether the user just came online across all instances
+func MarkOnline(ctx context.Context, client redis.Cmdable, userID, instanceID string) (cameOnline bool, err error) {
+	key := PresenceKey(userID)
+	var added *redis.IntCmd
+	var count *redis.IntCmd
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.SAdd(ctx, key, instanceID)
+		count = pipe.SCard(ctx, key)
+		pipe.Expire(ctx, key, PresenceTTL)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return added.Val() == 1 && count.Val() == 1, nil
+}
+
+// MarkOffline records that instanceID no longer holds a connection of the
+// user and reports whether the user is now offline everywhere
+func MarkOffline(ctx context.Context, client redis.Cmdable, userID, instanceID string) (wentOffline bool, err error) {
+	key := PresenceKey(userID)
+	var removed *redis.IntCmd
+	var count *redis.IntCmd
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.SRem(ctx, key, instanceID)
+		count = pipe.SCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() == 1 && count.Val() == 0, nil
+}
+
+// RefreshPresence extends the presence of users connected to instanceID
+func RefreshPresence(ctx context.Context, client redis.Cmdable, instanceID string, userIDs []string) error {
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range userIDs {
+			key := PresenceKey(id)
+			pipe.SAdd(ctx, key, instanceID)
+			pipe.Expire(ctx, key, PresenceTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// OnlineUsers returns the subset of userIDs connected to any instance
+func OnlineUsers(ctx context.Context, client redis.Cmdable, userIDs []string) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	counts := make([]*redis.IntCmd, len(userIDs))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range userIDs {
+			counts[i] = pipe.Exists(ctx, PresenceKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var online []string
+	for i, id := range userIDs {
+		if counts[i].Val() > 0 {
+			online = append(online, id)
+		}
+	}
+	return online, nil
+}
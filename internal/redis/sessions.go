@@ -0,0 +1,35 @@
+package redis
+
+// Keys shared by AuthService, which writes them, and the auth middleware,
+// which checks them on every request
+
+// BlacklistKey marks a logged-out access token
+func BlacklistKey(token string) string {
+	return "blacklist:" + token
+}
+
+// RevokedTokenKey marks a refresh token ID as used
+func RevokedTokenKey(jti string) string {
+	return "revoked:jti:" + jti
+}
+
+// RevokedFamilyKey marks every token of a login session as revoked
+func RevokedFamilyKey(family string) string {
+	return "revoked:family:" + family
+}
+
+// TokenVersionKey caches the user's token version; the users collection
+// holds the authoritative copy
+func TokenVersionKey(userID string) string {
+	return "token_version:" + userID
+}
+
+// DeactivatedUserKey flags a deactivated account
+func DeactivatedUserKey(userID string) string {
+	return "deactivated:" + userID
+}
+
+// WSTicketKey maps a single-use WebSocket ticket to its user
+func WSTicketKey(ticket string) string {
+	return "ws_ticket:" + ticket
+}
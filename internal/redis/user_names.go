@@ -0,0 +1,22 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UserNameTTL bounds how long a cached username can outlive a missed update
+const UserNameTTL = 24 * time.Hour
+
+// UserNameKey is the one key scheme for cached usernames, which messages
+// carry as their sender name so clients can render them without a lookup
+func UserNameKey(userID string) string {
+	return "user:" + userID + ":name"
+}
+
+// CacheUserName records the current username of a user
+func CacheUserName(ctx context.Context, client redis.Cmdable, userID, username string) error {
+	return client.Set(ctx, UserNameKey(userID), username, UserNameTTL).Err()
+}
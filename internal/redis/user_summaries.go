@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UserSummaryTTL is short because instances that miss an invalidation keep
+// serving a stale summary until it expires
+const UserSummaryTTL = 5 * time.Minute
+
+// UserSummaryChannel carries the IDs of users whose cached summaries are stale
+const UserSummaryChannel = "user_summary:invalidate"
+
+// UserSummaryKey is where the JSON summary of a user is cached
+func UserSummaryKey(userID string) string {
+	return "user_summary:" + userID
+}
+
+// InvalidateUserSummary drops the cached summary of a user and tells every
+// instance to drop its in-process copy too
+func InvalidateUserSummary(ctx context.Context, client redis.Cmdable, userID string) error {
+	if err := client.Del(ctx, UserSummaryKey(userID)).Err(); err != nil {
+		return err
+	}
+	return client.Publish(ctx, UserSummaryChannel, userID).Err()
+}
@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AnnouncementRepository struct {
+	announcements *mongo.Collection
+	dismissals    *mongo.Collection
+}
+
+func NewAnnouncementRepository(db *mongo.Database) *AnnouncementRepository {
+	return &AnnouncementRepository{
+		announcements: db.Collection("announcements"),
+		dismissals:    db.Collection("announcement_dismissals"),
+	}
+}
+
+func announcementIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "ends_at", Value: 1}},
+		},
+	}
+}
+
+func announcementDismissalIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One dismissal per user per announcement
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "announcement_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+}
+
+func (r *AnnouncementRepository) CreateAnnouncement(ctx context.Context, announcement *models.Announcement) error {
+	announcement.CreatedAt = time.Now()
+	res, err := r.announcements.InsertOne(ctx, announcement)
+	if err != nil {
+		return err
+	}
+	announcement.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetActiveAnnouncements lists the announcements showing at now that userID
+// hasn't dismissed, newest first
+func (r *AnnouncementRepository) GetActiveAnnouncements(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]models.Announcement, error) {
+	cursor, err := r.announcements.Find(ctx,
+		bson.M{"starts_at": bson.M{"$lte": now}, "ends_at": bson.M{"$gt": now}},
+		options.Find().SetSort(bson.D{{Key: "starts_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var active []models.Announcement
+	if err := cursor.All(ctx, &active); err != nil {
+		return nil, err
+	}
+	if len(active) == 0 {
+		return []models.Announcement{}, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(active))
+	for i, a := range active {
+		ids[i] = a.ID
+	}
+	dismissedIDs, err := r.dismissals.Distinct(ctx, "announcement_id",
+		bson.M{"user_id": userID, "announcement_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	dismissed := make(map[primitive.ObjectID]bool, len(dismissedIDs))
+	for _, id := range dismissedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			dismissed[oid] = true
+		}
+	}
+
+	announcements := make([]models.Announcement, 0, len(active))
+	for _, a := range active {
+		if !dismissed[a.ID] {
+			announcements = append(announcements, a)
+		}
+	}
+	return announcements, nil
+}
+
+func (r *AnnouncementRepository) GetAnnouncement(ctx context.Context, id primitive.ObjectID) (*models.Announcement, error) {
+	var announcement models.Announcement
+	if err := r.announcements.FindOne(ctx, bson.M{"_id": id}).Decode(&announcement); err != nil {
+		return nil, err
+	}
+	return &announcement, nil
+}
+
+// Dismiss hides an announcement from userID; dismissing it again is a no-op
+func (r *AnnouncementRepository) Dismiss(ctx context.Context, announcementID, userID primitive.ObjectID) error {
+	_, err := r.dismissals.UpdateOne(ctx,
+		bson.M{"user_id": userID, "announcement_id": announcementID},
+		bson.M{"$setOnInsert": models.AnnouncementDismissal{
+			AnnouncementID: announcementID,
+			UserID:         userID,
+			DismissedAt:    time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
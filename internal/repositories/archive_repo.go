@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ArchiveRepository struct {
+	collection *mongo.Collection
+}
+
+func NewArchiveRepository(db *mongo.Database) *ArchiveRepository {
+	return &ArchiveRepository{collection: db.Collection("conversation_archives")}
+}
+
+func archiveIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One archive per user per conversation; also lists a user's archives
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "conversation_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// Unarchiving everyone's copy of a group conversation on a new message
+			Keys: bson.D{{Key: "conversation_id", Value: 1}},
+		},
+	}
+}
+
+// Archive archives a conversation for the user as of now. Archiving it again
+// moves the archive time forward.
+func (r *ArchiveRepository) Archive(ctx context.Context, userID, conversationID primitive.ObjectID) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "conversation_id": conversationID},
+		bson.M{"$set": bson.M{"archived_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Unarchive removes the user's archive of a conversation, if there is one
+func (r *ArchiveRepository) Unarchive(ctx context.Context, userID, conversationID primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "conversation_id": conversationID})
+	return err
+}
+
+// GetUserArchives returns every conversation the user has archived
+func (r *ArchiveRepository) GetUserArchives(ctx context.Context, userID primitive.ObjectID) ([]models.ConversationArchive, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	archives := []models.ConversationArchive{}
+	if err := cursor.All(ctx, &archives); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// UnarchiveForMessage removes the archives msg's arrival voids: those of its
+// conversation made before it was sent. Each archive is removed atomically,
+// so when several instances handle messages of one conversation at once,
+// every removed archive is returned by exactly one of them.
+func (r *ArchiveRepository) UnarchiveForMessage(ctx context.Context, msg *models.Message) ([]models.ConversationArchive, error) {
+	filter := bson.M{"conversation_id": msg.GroupID}
+	if msg.GroupID.IsZero() {
+		filter = bson.M{"$or": []bson.M{
+			{"user_id": msg.ReceiverID, "conversation_id": msg.SenderID},
+			{"user_id": msg.SenderID, "conversation_id": msg.ReceiverID},
+		}}
+	}
+	filter["archived_at"] = bson.M{"$lt": msg.CreatedAt}
+
+	var removed []models.ConversationArchive
+	for {
+		var archive models.ConversationArchive
+		err := r.collection.FindOneAndDelete(ctx, filter).Decode(&archive)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return removed, nil
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, archive)
+	}
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditRepository stores security events. Events are only ever inserted;
+// Mongo removes them once they are older than models.AuditRetention.
+type AuditRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	return &AuditRepository{collection: db.Collection("audit_events")}
+}
+
+func auditIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			// Looks up whether a login came from a known device
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "fingerprint", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(models.AuditRetention.Seconds())),
+		},
+	}
+}
+
+func (r *AuditRepository) Insert(ctx context.Context, events ...models.AuditEvent) error {
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		docs[i] = event
+	}
+	_, err := r.collection.InsertMany(ctx, docs)
+	return err
+}
+
+// HasLoggedIn reports whether the user has any recorded login, and whether
+// one came from the device with the given fingerprint
+func (r *AuditRepository) HasLoggedIn(ctx context.Context, userID primitive.ObjectID, fingerprint string) (hasLogins, fromDevice bool, err error) {
+	filter := bson.M{"user_id": userID, "type": models.AuditLogin}
+	if err := r.collection.FindOne(ctx, filter).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+
+	filter["fingerprint"] = fingerprint
+	if err := r.collection.FindOne(ctx, filter).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return true, false, nil
+		}
+		return false, false, err
+	}
+	return true, true, nil
+}
+
+// List returns a page of the user's events, newest first, optionally only of
+// the given types. One more event than limit is loaded so callers can tell
+// whether there are more.
+func (r *AuditRepository) List(ctx context.Context, userID primitive.ObjectID, types []string, page, limit int64) ([]models.AuditEvent, error) {
+	filter := bson.M{"user_id": userID}
+	if len(types) > 0 {
+		filter["type"] = bson.M{"$in": types}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit + 1)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteUserEvents removes a user's whole activity log
+func (r *AuditRepository) DeleteUserEvents(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
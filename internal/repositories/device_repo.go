@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeviceRepository struct {
+	collection *mongo.Collection
+}
+
+func NewDeviceRepository(db *mongo.Database) *DeviceRepository {
+	return &DeviceRepository{collection: db.Collection("devices")}
+}
+
+func deviceIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	}
+}
+
+// RegisterDevice stores token for userID, taking it over from whoever
+// registered it before
+func (r *DeviceRepository) RegisterDevice(ctx context.Context, userID primitive.ObjectID, platform, token string) (*models.Device, error) {
+	now := time.Now()
+	var device models.Device
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"token": token},
+		bson.M{
+			"$set": bson.M{
+				"user_id":    userID,
+				"platform":   platform,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&device)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// UnregisterDevice removes the user's token and reports whether it existed
+func (r *DeviceRepository) UnregisterDevice(ctx context.Context, userID primitive.ObjectID, token string) (bool, error) {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "token": token})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// GetUserDevices lists the devices registered by userID
+func (r *DeviceRepository) GetUserDevices(ctx context.Context, userID primitive.ObjectID) ([]models.Device, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var devices []models.Device
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// DeleteUserDevices forgets every device of a user
+func (r *DeviceRepository) DeleteUserDevices(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// DeleteToken drops a token the push provider no longer accepts
+func (r *DeviceRepository) DeleteToken(ctx context.Context, token string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"token": token})
+	return err
+}
@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrExportInProgress = errors.New("an export is already in progress")
+
+type ExportRepository struct {
+	db *mongo.Database
+}
+
+func NewExportRepository(db *mongo.Database) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+func exportJobIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One active export per user
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"active": true}),
+		},
+		{
+			Keys: bson.D{{Key: "expires_at", Value: 1}},
+		},
+	}
+}
+
+// CreateJob records a pending export, failing with ErrExportInProgress when
+// the user already has one pending or running
+func (r *ExportRepository) CreateJob(ctx context.Context, userID primitive.ObjectID) (*models.DataExportJob, error) {
+	job := &models.DataExportJob{
+		UserID:    userID,
+		Status:    models.ExportStatusPending,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+	result, err := r.db.Collection("export_jobs").InsertOne(ctx, job)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrExportInProgress
+		}
+		return nil, err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return job, nil
+}
+
+func (r *ExportRepository) GetJob(ctx context.Context, id, userID primitive.ObjectID) (*models.DataExportJob, error) {
+	var job models.DataExportJob
+	err := r.db.Collection("export_jobs").FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FailStaleJobs fails the user's active jobs created before cutoff, e.g.
+// because the server running them restarted
+func (r *ExportRepository) FailStaleJobs(ctx context.Context, userID primitive.ObjectID, cutoff time.Time) error {
+	_, err := r.db.Collection("export_jobs").UpdateMany(ctx,
+		bson.M{"user_id": userID, "active": true, "created_at": bson.M{"$lt": cutoff}},
+		bson.M{"$set": bson.M{"status": models.ExportStatusFailed, "active": false, "error": "export interrupted"}},
+	)
+	return err
+}
+
+func (r *ExportRepository) MarkRunning(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.db.Collection("export_jobs").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": models.ExportStatusRunning}},
+	)
+	return err
+}
+
+func (r *ExportRepository) Complete(ctx context.Context, id primitive.ObjectID, storageKey string, expiresAt time.Time) error {
+	_, err := r.db.Collection("export_jobs").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"status":       models.ExportStatusCompleted,
+			"active":       false,
+			"storage_key":  storageKey,
+			"completed_at": time.Now(),
+			"expires_at":   expiresAt,
+		}},
+	)
+	return err
+}
+
+func (r *ExportRepository) Fail(ctx context.Context, id primitive.ObjectID, reason string) error {
+	_, err := r.db.Collection("export_jobs").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": models.ExportStatusFailed, "active": false, "error": reason}},
+	)
+	return err
+}
+
+// FindExpired returns completed jobs whose archives expired before now
+func (r *ExportRepository) FindExpired(ctx context.Context, now time.Time) ([]models.DataExportJob, error) {
+	cursor, err := r.db.Collection("export_jobs").Find(ctx, bson.M{
+		"status":     models.ExportStatusCompleted,
+		"expires_at": bson.M{"$lt": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []models.DataExportJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *ExportRepository) DeleteJob(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.db.Collection("export_jobs").DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FollowRepository struct {
+	collection *mongo.Collection
+}
+
+func NewFollowRepository(db *mongo.Database) *FollowRepository {
+	return &FollowRepository{collection: db.Collection("follows")}
+}
+
+func followIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "followee_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "followee_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+	}
+}
+
+// Follow makes followerID follow followeeID with the given status. Following
+// again keeps the existing follow and returns it unchanged.
+func (r *FollowRepository) Follow(ctx context.Context, followerID, followeeID primitive.ObjectID, status string) (*models.Follow, error) {
+	var follow models.Follow
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID},
+		bson.M{"$setOnInsert": bson.M{
+			"status":     status,
+			"created_at": time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&follow)
+	if err != nil {
+		return nil, err
+	}
+	return &follow, nil
+}
+
+// Unfollow removes a follow or pending request and reports whether one existed
+func (r *FollowRepository) Unfollow(ctx context.Context, followerID, followeeID primitive.ObjectID) (bool, error) {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// AcceptRequest accepts followerID's pending request to follow followeeID
+// and reports whether there was one
+func (r *FollowRepository) AcceptRequest(ctx context.Context, followeeID, followerID primitive.ObjectID) (bool, error) {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowStatusPending},
+		bson.M{"$set": bson.M{"status": models.FollowStatusAccepted}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// RejectRequest deletes followerID's pending request to follow followeeID
+// and reports whether there was one
+func (r *FollowRepository) RejectRequest(ctx context.Context, followeeID, followerID primitive.ObjectID) (bool, error) {
+	res, err := r.collection.DeleteOne(ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowStatusPending},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// AcceptAllRequests accepts every pending request to follow followeeID, for
+// when the account stops being private
+func (r *FollowRepository) AcceptAllRequests(ctx context.Context, followeeID primitive.ObjectID) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"followee_id": followeeID, "status": models.FollowStatusPending},
+		bson.M{"$set": bson.M{"status": models.FollowStatusAccepted}},
+	)
+	return err
+}
+
+// GetStatus returns the status of followerID's follow of followeeID, or ""
+// when there is none
+func (r *FollowRepository) GetStatus(ctx context.Context, followerID, followeeID primitive.ObjectID) (string, error) {
+	var follow models.Follow
+	err := r.collection.FindOne(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID}).Decode(&follow)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return follow.Status, nil
+}
+
+// CountFollowers counts the accepted followers of userID
+func (r *FollowRepository) CountFollowers(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"followee_id": userID, "status": models.FollowStatusAccepted})
+}
+
+// CountFollowing counts the accounts userID follows
+func (r *FollowRepository) CountFollowing(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"follower_id": userID, "status": models.FollowStatusAccepted})
+}
+
+// FollowerIDs returns the users following userID with the given status
+func (r *FollowRepository) FollowerIDs(ctx context.Context, userID primitive.ObjectID, status string) ([]primitive.ObjectID, error) {
+	return r.distinctIDs(ctx, "follower_id", bson.M{"followee_id": userID, "status": status})
+}
+
+// FolloweeIDs returns the accounts userID follows
+func (r *FollowRepository) FolloweeIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return r.distinctIDs(ctx, "followee_id", bson.M{"follower_id": userID, "status": models.FollowStatusAccepted})
+}
+
+func (r *FollowRepository) distinctIDs(ctx context.Context, field string, filter bson.M) ([]primitive.ObjectID, error) {
+	values, err := r.collection.Distinct(ctx, field, filter)
+	if err != nil {
+		return nil, err
+	}
+	// Never nil, so callers can use the result in $in
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// DeleteBetween removes follows and requests between two users in either direction
+func (r *FollowRepository) DeleteBetween(ctx context.Context, userID1, userID2 primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"follower_id": userID1, "followee_id": userID2},
+		{"follower_id": userID2, "followee_id": userID1},
+	}})
+	return err
+}
+
+// DeleteUserFollows removes every follow and request the user is part of
+func (r *FollowRepository) DeleteUserFollows(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"follower_id": userID},
+		{"followee_id": userID},
+	}})
+	return err
+}
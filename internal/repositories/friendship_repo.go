@@ -19,7 +19,11 @@ type FriendshipRepository struct {
 }
 
 func NewFriendshipRepository(db *mongo.Database) *FriendshipRepository {
-	indexes := []mongo.IndexModel{
+	return &FriendshipRepository{db: db}
+}
+
+func friendshipIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
 		// Unique compound index to prevent duplicate requests in either direction
 		{
 			Keys: bson.D{
@@ -28,26 +32,97 @@ func NewFriendshipRepository(db *mongo.Database) *FriendshipRepository {
 			},
 			Options: options.Index().SetUnique(true),
 		},
+		// Delta sync, by side
+		{
+			Keys: bson.D{{Key: "requester_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "receiver_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
 		// Index for quick lookup of all requests involving a user
 		{
 			Keys: bson.D{{Key: "$**", Value: "text"}}, // Wildcard index for flexible queries
 		},
-		// TTL index for auto-expiring pending requests after 30 days
+		// TTL index for auto-expiring pending requests after 30 days. The partial
+		// filter keeps accepted friendships and blocks from ever expiring.
 		{
-			Keys:    bson.D{{Key: "created_at", Value: 1}},
-			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().
+				SetName(pendingRequestTTLIndex).
+				SetExpireAfterSeconds(30 * 24 * 60 * 60).
+				SetPartialFilterExpression(bson.M{"status": models.FriendshipStatusPending}),
 		},
 	}
+}
 
-	_, err := db.Collection("friendships").Indexes().CreateMany(context.Background(), indexes)
+const pendingRequestTTLIndex = "created_at_pending_ttl"
+
+// dropLegacyFriendshipTTLIndex removes the old TTL index that covered every
+// friendship, so existing deployments stop purging accepted friendships.
+func dropLegacyFriendshipTTLIndex(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Indexes().List(ctx)
 	if err != nil {
-		panic("Failed to create friendship indexes: " + err.Error())
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == 26 {
+			// NamespaceNotFound: fresh deployment, nothing to migrate
+			return nil
+		}
+		return err
 	}
+	defer cursor.Close(ctx)
 
-	return &FriendshipRepository{db: db}
+	var specs []bson.M
+	if err := cursor.All(ctx, &specs); err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		_, ttl := spec["expireAfterSeconds"]
+		_, partial := spec["partialFilterExpression"]
+		if !ttl || partial {
+			continue
+		}
+		name, _ := spec["name"].(string)
+		log.Printf("Dropping legacy friendship TTL index %s", name)
+		if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CreateRequest creates a new friend request with conflict prevention
+// GetChangedFriendships returns userID's friend requests and friendships
+// created or changed since, oldest change first, at most limit of them; more
+// reports whether others changed later. Blocks are left out.
+func (r *FriendshipRepository) GetChangedFriendships(ctx context.Context, userID primitive.ObjectID, since time.Time, limit int64) (friendships []models.Friendship, more bool, err error) {
+	changed := bson.M{"$gte": since}
+	cursor, err := r.db.Collection("friendships").Find(ctx,
+		bson.M{
+			"$or": []bson.M{
+				{"requester_id": userID, "updated_at": changed},
+				{"receiver_id": userID, "updated_at": changed},
+			},
+			"status": bson.M{"$ne": models.FriendshipStatusBlocked},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(limit+1),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &friendships); err != nil {
+		return nil, false, err
+	}
+	if int64(len(friendships)) > limit {
+		friendships, more = friendships[:limit], true
+	}
+	return friendships, more, nil
+}
+
 func (r *FriendshipRepository) CreateRequest(ctx context.Context, requesterID, receiverID primitive.ObjectID) (*models.Friendship, error) {
 	// Prevent self-friending
 	if requesterID == receiverID {
@@ -121,6 +196,31 @@ func (r *FriendshipRepository) UpdateStatus(ctx context.Context, friendshipID pr
 	return nil
 }
 
+// GetFriendshipByID returns a friendship row regardless of its status
+func (r *FriendshipRepository) GetFriendshipByID(ctx context.Context, id primitive.ObjectID) (*models.Friendship, error) {
+	var friendship models.Friendship
+	err := r.db.Collection("friendships").FindOne(ctx, bson.M{"_id": id}).Decode(&friendship)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrFriendRequestNotFound
+		}
+		return nil, err
+	}
+	return &friendship, nil
+}
+
+// RevertToPending undoes an accept whose friend list update failed
+func (r *FriendshipRepository) RevertToPending(ctx context.Context, friendshipID primitive.ObjectID) error {
+	_, err := r.db.Collection("friendships").UpdateOne(ctx,
+		bson.M{"_id": friendshipID, "status": models.FriendshipStatusAccepted},
+		bson.M{"$set": bson.M{
+			"status":     models.FriendshipStatusPending,
+			"updated_at": time.Now(),
+		}},
+	)
+	return err
+}
+
 // AreFriends checks if two users have an accepted friendship
 func (r *FriendshipRepository) AreFriends(ctx context.Context, userID1, userID2 primitive.ObjectID) (bool, error) {
 	count, err := r.db.Collection("friendships").CountDocuments(ctx, bson.M{
@@ -235,6 +335,22 @@ func (r *FriendshipRepository) Unfriend(ctx context.Context, userID, friendID pr
     return nil
 }
 
+// DeleteUserFriendships removes every friendship, request and block the user
+// is part of, returning the other users involved
+func (r *FriendshipRepository) DeleteUserFriendships(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	others, err := r.counterparts(ctx, userID, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.db.Collection("friendships").DeleteMany(ctx, bson.M{
+		"$or": []bson.M{{"requester_id": userID}, {"receiver_id": userID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete friendships: %w", err)
+	}
+	return others, nil
+}
+
 // BlockUser blocks a user (creates a blocked status relationship)
 // BlockUser blocks a user with proper verification checks
 func (r *FriendshipRepository) BlockUser(ctx context.Context, blockerID, blockedID primitive.ObjectID) error {
@@ -277,6 +393,17 @@ func (r *FriendshipRepository) BlockUser(ctx context.Context, blockerID, blocked
         }
     }
 
+    // Blocking also ends follows and follow requests in both directions
+    _, err = r.db.Collection("follows").DeleteMany(ctx, bson.M{
+        "$or": []bson.M{
+            {"follower_id": blockerID, "followee_id": blockedID},
+            {"follower_id": blockedID, "followee_id": blockerID},
+        },
+    })
+    if err != nil {
+        return fmt.Errorf("failed to remove follows: %w", err)
+    }
+
     // Create blocked relationship
     blockedFriendship := &models.Friendship{
         RequesterID: blockerID,
@@ -324,6 +451,23 @@ func (r *FriendshipRepository) UnblockUser(ctx context.Context, blockerID, block
     return nil
 }
 
+// GetPendingRequest returns the pending request sent by requesterID to receiverID
+func (r *FriendshipRepository) GetPendingRequest(ctx context.Context, requesterID, receiverID primitive.ObjectID) (*models.Friendship, error) {
+	var friendship models.Friendship
+	err := r.db.Collection("friendships").FindOne(ctx, bson.M{
+		"requester_id": requesterID,
+		"receiver_id":  receiverID,
+		"status":       models.FriendshipStatusPending,
+	}).Decode(&friendship)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrFriendRequestNotFound
+		}
+		return nil, err
+	}
+	return &friendship, nil
+}
+
 // IsBlockedBy checks if blockerID has specifically blocked blockedID
 func (r *FriendshipRepository) IsBlockedBy(ctx context.Context, blockedID, blockerID primitive.ObjectID) (bool, error) {
     count, err := r.db.Collection("friendships").CountDocuments(ctx, bson.M{
@@ -382,6 +526,47 @@ func (r *FriendshipRepository) GetBlockedUsers(ctx context.Context, userID primi
     return blockedUsers, nil
 }
 
+// GetBlockRelations returns everyone userID has blocked or been blocked by
+func (r *FriendshipRepository) GetBlockRelations(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return r.counterparts(ctx, userID, bson.M{"status": models.FriendshipStatusBlocked})
+}
+
+// GetRelatedUserIDs returns everyone userID shares a friendship row with:
+// friends, pending or rejected requests in either direction and blocks
+func (r *FriendshipRepository) GetRelatedUserIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return r.counterparts(ctx, userID, bson.M{})
+}
+
+// counterparts returns the other user of every friendship row of userID
+// matching filter
+func (r *FriendshipRepository) counterparts(ctx context.Context, userID primitive.ObjectID, filter bson.M) ([]primitive.ObjectID, error) {
+	filter["$or"] = []bson.M{
+		{"requester_id": userID},
+		{"receiver_id": userID},
+	}
+	cursor, err := r.db.Collection("friendships").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	// Never nil, so callers can use the result in $in and $nin
+	ids := []primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var friendship models.Friendship
+		if err := cursor.Decode(&friendship); err != nil {
+			return nil, err
+		}
+		if friendship.RequesterID == userID {
+			ids = append(ids, friendship.ReceiverID)
+		} else {
+			ids = append(ids, friendship.RequesterID)
+		}
+	}
+
+	return ids, cursor.Err()
+}
+
 // Custom errors
 var (
 	ErrCannotFriendSelf      = errors.New("cannot send friend request to yourself")
@@ -393,3 +578,30 @@ var (
     ErrBlockNotFound      = errors.New("block relationship not found")
 	ErrNotFriends = errors.New("users are not friends")
 )
+
+// StreamUserFriendships calls fn for every friendship row the user is part of,
+// in either direction and any status
+func (r *FriendshipRepository) StreamUserFriendships(ctx context.Context, userID primitive.ObjectID, fn func(models.Friendship) error) error {
+	filter := bson.M{"$or": []bson.M{
+		{"requester_id": userID},
+		{"receiver_id": userID},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetBatchSize(exportBatchSize)
+
+	cursor, err := r.db.Collection("friendships").Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var friendship models.Friendship
+		if err := cursor.Decode(&friendship); err != nil {
+			return err
+		}
+		if err := fn(friendship); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
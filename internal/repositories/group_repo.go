@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"fmt"
 	"messaging-app/internal/models"
 	"time"
 
@@ -16,7 +17,11 @@ type GroupRepository struct {
 }
 
 func NewGroupRepository(db *mongo.Database) *GroupRepository {
-	indexes := []mongo.IndexModel{
+	return &GroupRepository{db: db}
+}
+
+func groupIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
 		{
 			Keys:    bson.D{{Key: "name", Value: 1}},
 			Options: options.Index().SetUnique(false),
@@ -29,14 +34,39 @@ func NewGroupRepository(db *mongo.Database) *GroupRepository {
 			Keys:    bson.D{{Key: "admins", Value: 1}},
 			Options: options.Index().SetSparse(true),
 		},
+		{
+			Keys:    bson.D{{Key: "moderators", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
 	}
+}
 
-	_, err := db.Collection("groups").Indexes().CreateMany(context.Background(), indexes)
-	if err != nil {
-		panic("Failed to create group indexes: " + err.Error())
+func groupInviteIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "group_id", Value: 1}},
+		},
+		{
+			// Expired invites are useless, let Mongo clean them up
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
 	}
+}
 
-	return &GroupRepository{db: db}
+func groupJoinRequestIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One pending request per user and group
+			Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.JoinRequestPending}),
+		},
+	}
 }
 
 func (r *GroupRepository) CreateGroup(ctx context.Context, group *models.Group) (*models.Group, error) {
@@ -50,6 +80,9 @@ func (r *GroupRepository) CreateGroup(ctx context.Context, group *models.Group)
 	if !containsID(group.Members, group.CreatorID) {
 		group.Members = append(group.Members, group.CreatorID)
 	}
+	if group.OwnerID.IsZero() {
+		group.OwnerID = group.CreatorID
+	}
 
 	result, err := r.db.Collection("groups").InsertOne(ctx, group)
 	if err != nil {
@@ -100,8 +133,9 @@ func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID prim
 		bson.M{"_id": groupID},
 		bson.M{
 			"$pull": bson.M{
-				"members": userID,
-				"admins":  userID,
+				"members":    userID,
+				"admins":     userID,
+				"moderators": userID,
 			},
 			"$set": bson.M{"updated_at": time.Now()},
 		},
@@ -109,6 +143,64 @@ func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID prim
 	return err
 }
 
+func (r *GroupRepository) RemoveAdmin(ctx context.Context, groupID, userID primitive.ObjectID) error {
+	_, err := r.db.Collection("groups").UpdateOne(
+		ctx,
+		bson.M{"_id": groupID},
+		bson.M{
+			"$pull": bson.M{"admins": userID},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+func (r *GroupRepository) AddModerator(ctx context.Context, groupID, userID primitive.ObjectID) error {
+	_, err := r.db.Collection("groups").UpdateOne(
+		ctx,
+		bson.M{"_id": groupID},
+		bson.M{
+			"$addToSet": bson.M{"moderators": userID},
+			"$set":      bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+func (r *GroupRepository) RemoveModerator(ctx context.Context, groupID, userID primitive.ObjectID) error {
+	_, err := r.db.Collection("groups").UpdateOne(
+		ctx,
+		bson.M{"_id": groupID},
+		bson.M{
+			"$pull": bson.M{"moderators": userID},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// TransferOwnership makes newOwnerID the owner; owners are always admins
+func (r *GroupRepository) TransferOwnership(ctx context.Context, groupID, newOwnerID primitive.ObjectID) error {
+	_, err := r.db.Collection("groups").UpdateOne(
+		ctx,
+		bson.M{"_id": groupID},
+		bson.M{
+			"$addToSet": bson.M{"admins": newOwnerID},
+			"$pull":     bson.M{"moderators": newOwnerID},
+			"$set": bson.M{
+				"owner_id":   newOwnerID,
+				"updated_at": time.Now(),
+			},
+		},
+	)
+	return err
+}
+
+func (r *GroupRepository) DeleteGroup(ctx context.Context, groupID primitive.ObjectID) error {
+	_, err := r.db.Collection("groups").DeleteOne(ctx, bson.M{"_id": groupID})
+	return err
+}
+
 func (r *GroupRepository) UpdateGroup(ctx context.Context, groupID primitive.ObjectID, update bson.M) error {
 	update["updated_at"] = time.Now()
 	_, err := r.db.Collection("groups").UpdateOne(
@@ -135,6 +227,177 @@ func (r *GroupRepository) GetUserGroups(ctx context.Context, userID primitive.Ob
 }
 
 // Helper function
+// PinMessage appends a pin, failing with mongo.ErrNoDocuments if the message
+// is already pinned or the group already has MaxPinnedMessages pins
+func (r *GroupRepository) PinMessage(ctx context.Context, groupID primitive.ObjectID, pin models.PinnedMessage) error {
+	filter := bson.M{
+		"_id":                        groupID,
+		"pinned_messages.message_id": bson.M{"$ne": pin.MessageID},
+	}
+	// The last slot being empty means there is room for one more
+	filter[fmt.Sprintf("pinned_messages.%d", models.MaxPinnedMessages-1)] = bson.M{"$exists": false}
+
+	result, err := r.db.Collection("groups").UpdateOne(ctx, filter,
+		bson.M{
+			"$push": bson.M{"pinned_messages": pin},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UnpinMessage removes a pin and reports whether the message was pinned
+func (r *GroupRepository) UnpinMessage(ctx context.Context, groupID, messageID primitive.ObjectID) (bool, error) {
+	result, err := r.db.Collection("groups").UpdateOne(ctx,
+		bson.M{"_id": groupID, "pinned_messages.message_id": messageID},
+		bson.M{
+			"$pull": bson.M{"pinned_messages": bson.M{"message_id": messageID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *GroupRepository) CreateInvite(ctx context.Context, invite *models.GroupInvite) (*models.GroupInvite, error) {
+	invite.CreatedAt = time.Now()
+	result, err := r.db.Collection("group_invites").InsertOne(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+	invite.ID = result.InsertedID.(primitive.ObjectID)
+	return invite, nil
+}
+
+func (r *GroupRepository) GetInviteByToken(ctx context.Context, token string) (*models.GroupInvite, error) {
+	var invite models.GroupInvite
+	err := r.db.Collection("group_invites").FindOne(ctx, bson.M{"token": token}).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (r *GroupRepository) ListInvites(ctx context.Context, groupID primitive.ObjectID) ([]models.GroupInvite, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.db.Collection("group_invites").Find(ctx, bson.M{"group_id": groupID, "revoked": false}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invites := []models.GroupInvite{}
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// UseInvite counts one use of an invite, failing with mongo.ErrNoDocuments if
+// it was revoked, expired or used up in the meantime
+func (r *GroupRepository) UseInvite(ctx context.Context, inviteID primitive.ObjectID) error {
+	result, err := r.db.Collection("group_invites").UpdateOne(ctx,
+		bson.M{
+			"_id":        inviteID,
+			"revoked":    false,
+			"expires_at": bson.M{"$gt": time.Now()},
+			"$expr": bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{"$max_uses", 0}},
+				bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+			}},
+		},
+		bson.M{"$inc": bson.M{"uses": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *GroupRepository) RevokeInvite(ctx context.Context, groupID, inviteID primitive.ObjectID) error {
+	result, err := r.db.Collection("group_invites").UpdateOne(ctx,
+		bson.M{"_id": inviteID, "group_id": groupID},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *GroupRepository) CreateJoinRequest(ctx context.Context, request *models.GroupJoinRequest) (*models.GroupJoinRequest, error) {
+	now := time.Now()
+	request.Status = models.JoinRequestPending
+	request.CreatedAt = now
+	request.UpdatedAt = now
+	result, err := r.db.Collection("group_join_requests").InsertOne(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	request.ID = result.InsertedID.(primitive.ObjectID)
+	return request, nil
+}
+
+// GetPendingJoinRequest finds the user's open request for a group
+func (r *GroupRepository) GetPendingJoinRequest(ctx context.Context, groupID, userID primitive.ObjectID) (*models.GroupJoinRequest, error) {
+	var request models.GroupJoinRequest
+	err := r.db.Collection("group_join_requests").FindOne(ctx, bson.M{
+		"group_id": groupID,
+		"user_id":  userID,
+		"status":   models.JoinRequestPending,
+	}).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *GroupRepository) ListPendingJoinRequests(ctx context.Context, groupID primitive.ObjectID) ([]models.GroupJoinRequest, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.db.Collection("group_join_requests").Find(ctx, bson.M{
+		"group_id": groupID,
+		"status":   models.JoinRequestPending,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []models.GroupJoinRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// ResolveJoinRequest moves a pending request to status and returns it
+func (r *GroupRepository) ResolveJoinRequest(ctx context.Context, groupID, requestID primitive.ObjectID, status string) (*models.GroupJoinRequest, error) {
+	var request models.GroupJoinRequest
+	err := r.db.Collection("group_join_requests").FindOneAndUpdate(ctx,
+		bson.M{"_id": requestID, "group_id": groupID, "status": models.JoinRequestPending},
+		bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
 func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
 	for _, i := range ids {
 		if i == id {
@@ -0,0 +1,275 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collectionIndexes is what one collection needs before the repositories use it
+type collectionIndexes struct {
+	name    string
+	indexes []mongo.IndexModel
+	// prepare runs first, for data fixes and index migrations that must
+	// happen whatever INDEX_MIGRATE says
+	prepare func(ctx context.Context, collection *mongo.Collection) error
+}
+
+func allIndexes(linkPreviewTTL time.Duration) []collectionIndexes {
+	return []collectionIndexes{
+		{name: "users", indexes: userIndexes(), prepare: backfillUsernameLower},
+		{name: "messages", indexes: messageIndexes()},
+		{name: "groups", indexes: groupIndexes()},
+		{name: "group_invites", indexes: groupInviteIndexes()},
+		{name: "group_join_requests", indexes: groupJoinRequestIndexes()},
+		{name: "friendships", indexes: friendshipIndexes(), prepare: dropLegacyFriendshipTTLIndex},
+		{name: "media", indexes: mediaIndexes()},
+		{name: "export_jobs", indexes: exportJobIndexes()},
+		{name: "link_previews", indexes: linkPreviewIndexes(linkPreviewTTL)},
+		{name: "outbox", indexes: outboxIndexes()},
+		{name: "devices", indexes: deviceIndexes()},
+		{name: "follows", indexes: followIndexes()},
+		{name: "poll_votes", indexes: pollVoteIndexes()},
+		{name: "audit_events", indexes: auditIndexes()},
+		{name: "announcements", indexes: announcementIndexes()},
+		{name: "announcement_dismissals", indexes: announcementDismissalIndexes()},
+		{name: "message_stars", indexes: starIndexes()},
+		{name: "conversation_archives", indexes: archiveIndexes()},
+	}
+}
+
+// IndexReport lists what EnsureIndexes changed, as "collection.index" names
+type IndexReport struct {
+	Created   []string
+	Rebuilt   []string
+	Conflicts []string
+}
+
+// EnsureIndexes creates the indexes the repositories rely on. Indexes that
+// already exist as specified are left alone, so running it again is a no-op.
+// An existing index that differs from its spec is kept and reported as a
+// conflict unless migrate is set, in which case it is dropped and rebuilt.
+// A failure on one collection doesn't stop the others; all errors are
+// returned together.
+func EnsureIndexes(ctx context.Context, db *mongo.Database, linkPreviewTTL time.Duration, migrate bool) (*IndexReport, error) {
+	report := &IndexReport{}
+	var errs []error
+	for _, c := range allIndexes(linkPreviewTTL) {
+		if err := ensureCollectionIndexes(ctx, db.Collection(c.name), c, migrate, report); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+func ensureCollectionIndexes(ctx context.Context, collection *mongo.Collection, c collectionIndexes, migrate bool, report *IndexReport) error {
+	if c.prepare != nil {
+		if err := c.prepare(ctx, collection); err != nil {
+			return err
+		}
+	}
+
+	existing, err := listIndexes(ctx, collection)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, model := range c.indexes {
+		name := indexName(model)
+		qualified := c.name + "." + name
+
+		current, found := existing[name]
+		if !found {
+			// The same keys may be indexed under another name
+			current, found = findByKeys(existing, model)
+		}
+		if found && indexMatches(current, model) {
+			continue
+		}
+		if found && !migrate {
+			log.Printf("Index %s differs from its spec; keeping the existing index (set INDEX_MIGRATE=true to rebuild it)", qualified)
+			report.Conflicts = append(report.Conflicts, qualified)
+			continue
+		}
+		if found {
+			log.Printf("Rebuilding index %s", qualified)
+			if _, err := collection.Indexes().DropOne(ctx, current.Name); err != nil {
+				errs = append(errs, fmt.Errorf("dropping index %s: %w", current.Name, err))
+				continue
+			}
+		}
+
+		if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+			if isIndexConflict(err) {
+				log.Printf("Index %s conflicts with an existing index: %v", qualified, err)
+				report.Conflicts = append(report.Conflicts, qualified)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("creating index %s: %w", name, err))
+			continue
+		}
+		if found {
+			report.Rebuilt = append(report.Rebuilt, qualified)
+		} else {
+			report.Created = append(report.Created, qualified)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// indexSpec is an index as listIndexes describes it
+type indexSpec struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+	DefaultLanguage         string   `bson:"default_language"`
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]indexSpec, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == 26 {
+			// NamespaceNotFound: the collection doesn't exist yet
+			return map[string]indexSpec{}, nil
+		}
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var specs []indexSpec
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	existing := make(map[string]indexSpec, len(specs))
+	for _, spec := range specs {
+		existing[spec.Name] = spec
+	}
+	return existing, nil
+}
+
+// indexName is the name Mongo gives the index: its explicit name, or its
+// keys and directions joined by underscores
+func indexName(model mongo.IndexModel) string {
+	if model.Options != nil && model.Options.Name != nil {
+		return *model.Options.Name
+	}
+	keys := model.Keys.(bson.D)
+	parts := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		parts = append(parts, k.Key, fmt.Sprint(k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func isTextIndex(keys bson.D) bool {
+	for _, k := range keys {
+		if k.Value == "text" || k.Key == "_fts" {
+			return true
+		}
+	}
+	return false
+}
+
+func findByKeys(existing map[string]indexSpec, model mongo.IndexModel) (indexSpec, bool) {
+	keys := model.Keys.(bson.D)
+	for _, spec := range existing {
+		// A collection has at most one text index, whatever its fields
+		if isTextIndex(keys) && isTextIndex(spec.Key) {
+			return spec, true
+		}
+		if sameKeys(spec.Key, keys) {
+			return spec, true
+		}
+	}
+	return indexSpec{}, false
+}
+
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		// Directions come back as int32 or double, so compare them as text
+		if a[i].Key != b[i].Key || fmt.Sprint(a[i].Value) != fmt.Sprint(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexMatches reports whether an existing index has the keys and the
+// options the repositories depend on
+func indexMatches(spec indexSpec, model mongo.IndexModel) bool {
+	keys := model.Keys.(bson.D)
+	opts := model.Options
+
+	if isTextIndex(keys) {
+		// Text indexes are listed by their internal _fts keys
+		if !isTextIndex(spec.Key) {
+			return false
+		}
+		language := "english"
+		if opts != nil && opts.DefaultLanguage != nil {
+			language = *opts.DefaultLanguage
+		}
+		if spec.DefaultLanguage != language {
+			return false
+		}
+	} else if !sameKeys(spec.Key, keys) {
+		return false
+	}
+
+	var unique, sparse bool
+	var ttl *int64
+	var partial interface{}
+	if opts != nil {
+		unique = opts.Unique != nil && *opts.Unique
+		sparse = opts.Sparse != nil && *opts.Sparse
+		if opts.ExpireAfterSeconds != nil {
+			seconds := int64(*opts.ExpireAfterSeconds)
+			ttl = &seconds
+		}
+		partial = opts.PartialFilterExpression
+	}
+
+	if spec.Unique != unique || spec.Sparse != sparse {
+		return false
+	}
+	if (spec.ExpireAfterSeconds == nil) != (ttl == nil) {
+		return false
+	}
+	if ttl != nil && *spec.ExpireAfterSeconds != *ttl {
+		return false
+	}
+	if partial == nil {
+		return len(spec.PartialFilterExpression) == 0
+	}
+	want, err := bson.Marshal(partial)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(want, spec.PartialFilterExpression)
+}
+
+// isIndexConflict reports whether err is Mongo refusing an index because an
+// index with the same name or keys but other options exists
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		// IndexOptionsConflict, IndexKeySpecsConflict
+		return cmdErr.Code == 85 || cmdErr.Code == 86
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LinkPreviewRepository struct {
+	db *mongo.Database
+}
+
+// NewLinkPreviewRepository stores previews keyed by normalized URL
+func NewLinkPreviewRepository(db *mongo.Database) *LinkPreviewRepository {
+	return &LinkPreviewRepository{db: db}
+}
+
+// linkPreviewIndexes expires previews after ttl so stale pages are fetched again
+func linkPreviewIndexes(ttl time.Duration) []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "fetched_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+		},
+	}
+}
+
+// GetPreview returns the stored preview of url, or mongo.ErrNoDocuments
+func (r *LinkPreviewRepository) GetPreview(ctx context.Context, url string) (*models.LinkPreview, error) {
+	var preview models.LinkPreview
+	if err := r.db.Collection("link_previews").FindOne(ctx, bson.M{"_id": url}).Decode(&preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// SavePreview stores preview, replacing an older fetch of the same URL
+func (r *LinkPreviewRepository) SavePreview(ctx context.Context, preview *models.LinkPreview) error {
+	_, err := r.db.Collection("link_previews").ReplaceOne(ctx,
+		bson.M{"_id": preview.URL},
+		preview,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
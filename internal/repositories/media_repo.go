@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"context"
+	"messaging-app/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MediaRepository struct {
+	db *mongo.Database
+}
+
+func NewMediaRepository(db *mongo.Database) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+func mediaIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "url", Value: 1}},
+		},
+	}
+}
+
+func (r *MediaRepository) CreateMedia(ctx context.Context, media *models.Media) (*models.Media, error) {
+	media.CreatedAt = time.Now()
+	result, err := r.db.Collection("media").InsertOne(ctx, media)
+	if err != nil {
+		return nil, err
+	}
+	media.ID = result.InsertedID.(primitive.ObjectID)
+	return media, nil
+}
+
+func (r *MediaRepository) GetMediaByKey(ctx context.Context, key string) (*models.Media, error) {
+	var media models.Media
+	err := r.db.Collection("media").FindOne(ctx, bson.M{"key": key}).Decode(&media)
+	if err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+func (r *MediaRepository) MarkUploaded(ctx context.Context, id primitive.ObjectID, size int64) error {
+	_, err := r.db.Collection("media").UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"status":      models.MediaStatusUploaded,
+			"size":        size,
+			"uploaded_at": time.Now(),
+		}},
+	)
+	return err
+}
+
+// CountOwnedUploads counts how many of urls point at uploaded media owned by ownerID
+func (r *MediaRepository) CountOwnedUploads(ctx context.Context, ownerID primitive.ObjectID, urls []string) (int64, error) {
+	return r.db.Collection("media").CountDocuments(ctx, bson.M{
+		"owner_id": ownerID,
+		"url":      bson.M{"$in": urls},
+		"status":   models.MediaStatusUploaded,
+	})
+}
+
+func (r *MediaRepository) GetMediaByURLs(ctx context.Context, urls []string) ([]models.Media, error) {
+	cursor, err := r.db.Collection("media").Find(ctx, bson.M{"url": bson.M{"$in": urls}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var media []models.Media
+	if err := cursor.All(ctx, &media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func (r *MediaRepository) DeleteMediaByIDs(ctx context.Context, ids []primitive.ObjectID) error {
+	_, err := r.db.Collection("media").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"messaging-app/internal/models"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,13 +18,20 @@ import (
 type MessageRepository struct {
 	db         *mongo.Database
 	collection *mongo.Collection
+	sequences  *mongo.Collection // one counter per conversation key
 }
 
 func NewMessageRepository(db *mongo.Database) *MessageRepository {
-	collection := db.Collection("messages")
-	
+	return &MessageRepository{
+		db:         db,
+		collection: db.Collection("messages"),
+		sequences:  db.Collection("conversation_sequences"),
+	}
+}
+
+func messageIndexes() []mongo.IndexModel {
 	// Compound indexes for faster queries
-	indexes := []mongo.IndexModel{
+	return []mongo.IndexModel{
 		{
 			Keys: bson.D{
 				{Key: "sender_id", Value: 1},
@@ -40,26 +48,59 @@ func NewMessageRepository(db *mongo.Database) *MessageRepository {
 		{
 			Keys: bson.D{{Key: "content_type", Value: 1}},
 		},
+		{
+			Keys: bson.D{
+				{Key: "reply_to_id", Value: 1},
+				{Key: "created_at", Value: -1},
+			},
+			Options: options.Index().SetSparse(true),
+		},
+		// Forwarded messages share media, so deletes check for other references
+		{
+			Keys:    bson.D{{Key: "media_urls", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		// Conversation search; "none" skips stemming and stop words, which
+		// only suit one language
+		{
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+		// Messages held back for undo send, by when they are due
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "dispatch_at", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		// Delta sync, by conversation side
+		{
+			Keys: bson.D{{Key: "sender_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "receiver_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+		// The moderation queue of flagged messages, newest first
+		{
+			Keys: bson.D{{Key: "flagged", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().
+				SetPartialFilterExpression(bson.M{"flagged": true}),
+		},
 		// TTL index for auto-deleting messages after 1 year
 		{
 			Keys:    bson.D{{Key: "created_at", Value: 1}},
 			Options: options.Index().SetExpireAfterSeconds(365 * 24 * 60 * 60),
 		},
 	}
-
-	_, err := collection.Indexes().CreateMany(context.Background(), indexes)
-	if err != nil {
-		panic("Failed to create message indexes: " + err.Error())
-	}
-
-	return &MessageRepository{
-		db:         db,
-		collection: collection,
-	}
 }
 
-func (r *MessageRepository) GetMessages(ctx context.Context, query models.MessageQuery) ([]models.Message, error) {
+// messageQueryFilter selects the messages of the conversation a query names,
+// as its SenderID, the reader, sees them
+func messageQueryFilter(query models.MessageQuery) (bson.M, error) {
 	filter := bson.M{}
+	// Without a valid viewer no held-back messages are shown
+	viewerID, _ := primitive.ObjectIDFromHex(query.SenderID)
 
 	if query.GroupID != "" {
 		groupID, err := primitive.ObjectIDFromHex(query.GroupID)
@@ -73,13 +114,31 @@ func (r *MessageRepository) GetMessages(ctx context.Context, query models.Messag
 			return nil, errors.New("invalid receiver ID")
 		}
 		filter["$or"] = []bson.M{
-			{"sender_id": query.SenderID, "receiver_id": receiverID},
-			{"sender_id": receiverID, "receiver_id": query.SenderID},
+			{"sender_id": viewerID, "receiver_id": receiverID},
+			{"sender_id": receiverID, "receiver_id": viewerID},
 		}
 	} else {
 		return nil, errors.New("either group_id or receiver_id must be provided")
 	}
 
+	if query.ThreadID != "" {
+		threadID, err := primitive.ObjectIDFromHex(query.ThreadID)
+		if err != nil {
+			return nil, errors.New("invalid thread ID")
+		}
+		filter["reply_to_id"] = threadID
+	}
+
+	filter["$and"] = []bson.M{visibleTo(viewerID)}
+	return filter, nil
+}
+
+func (r *MessageRepository) GetMessages(ctx context.Context, query models.MessageQuery) ([]models.Message, error) {
+	filter, err := messageQueryFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
 	opts := options.Find().
 		SetSort(bson.D{{Key: "created_at", Value: -1}}).
 		SetSkip(int64((query.Page - 1) * query.Limit)).
@@ -95,12 +154,177 @@ func (r *MessageRepository) GetMessages(ctx context.Context, query models.Messag
 	if err = cursor.All(ctx, &messages); err != nil {
 		return nil, err
 	}
+	// Deleted messages stay in the page as tombstones so offsets don't shift
+	for i := range messages {
+		if messages[i].IsDeleted {
+			messages[i] = messages[i].Tombstone()
+		}
+	}
+	return messages, nil
+}
+
+// visibleTo hides messages held back in their sender's undo window from
+// everyone but the sender
+func visibleTo(userID primitive.ObjectID) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"status": bson.M{"$ne": models.MessageStatusPendingDispatch}},
+		{"sender_id": userID},
+	}}
+}
+
+// conversationFilter matches the messages of a group, or of the direct
+// conversation between userID and receiverID when groupID is zero
+func conversationFilter(userID, groupID, receiverID primitive.ObjectID) bson.M {
+	if !groupID.IsZero() {
+		return bson.M{"group_id": groupID}
+	}
+	return bson.M{"$or": []bson.M{
+		{"sender_id": userID, "receiver_id": receiverID},
+		{"sender_id": receiverID, "receiver_id": userID},
+	}}
+}
+
+// SearchMessages returns the conversation's messages whose content matches
+// text, newest first, skipping deleted messages
+func (r *MessageRepository) SearchMessages(
+	ctx context.Context,
+	userID, groupID, receiverID primitive.ObjectID,
+	text string,
+	skip, limit int64,
+) ([]models.Message, error) {
+	filter := conversationFilter(userID, groupID, receiverID)
+	filter["$text"] = bson.M{"$search": text}
+	filter["is_deleted"] = bson.M{"$ne": true}
+	filter["$and"] = []bson.M{visibleTo(userID)}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
+// GetAdjacentMessages returns the messages sent just before and just after
+// msg in its conversation, as viewerID sees it; either is nil at the ends
+func (r *MessageRepository) GetAdjacentMessages(ctx context.Context, viewerID primitive.ObjectID, msg models.Message) (before, after *models.Message, err error) {
+	userID, receiverID := msg.SenderID, msg.ReceiverID
+	find := func(op string, order int) (*models.Message, error) {
+		filter := conversationFilter(userID, msg.GroupID, receiverID)
+		filter["created_at"] = bson.M{op: msg.CreatedAt}
+		filter["$and"] = []bson.M{visibleTo(viewerID)}
+
+		var adjacent models.Message
+		err := r.collection.FindOne(ctx, filter,
+			options.FindOne().SetSort(bson.D{{Key: "created_at", Value: order}}),
+		).Decode(&adjacent)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if adjacent.IsDeleted {
+			adjacent = adjacent.Tombstone()
+		}
+		return &adjacent, nil
+	}
+
+	if before, err = find("$lt", -1); err != nil {
+		return nil, nil, err
+	}
+	if after, err = find("$gt", 1); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+func (r *MessageRepository) GetMessageByID(ctx context.Context, id primitive.ObjectID) (*models.Message, error) {
+	var msg models.Message
+	if err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// GetMessagesByIDs fetches several messages in one query. Missing IDs are simply absent from the result.
+func (r *MessageRepository) GetMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Message, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetFlaggedMessages pages through the flagged messages that are still
+// around, newest first. It returns up to limit+1 messages so the caller can
+// tell whether there are more.
+func (r *MessageRepository) GetFlaggedMessages(ctx context.Context, page, limit int64) ([]models.Message, error) {
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"flagged": true, "is_deleted": bson.M{"$ne": true}},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetSkip((page-1)*limit).
+			SetLimit(limit+1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MediaURLsInUse returns the subset of urls still attached to a message that
+// hasn't been deleted
+func (r *MessageRepository) MediaURLsInUse(ctx context.Context, urls []string) ([]string, error) {
+	values, err := r.collection.Distinct(ctx, "media_urls", bson.M{
+		"media_urls": bson.M{"$in": urls},
+		"is_deleted": bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		wanted[u] = true
+	}
+	var inUse []string
+	for _, v := range values {
+		if u, ok := v.(string); ok && wanted[u] {
+			inUse = append(inUse, u)
+		}
+	}
+	return inUse, nil
+}
+
 func (r *MessageRepository) CreateMessage(ctx context.Context, msg *models.Message) (*models.Message, error) {
 	msg.CreatedAt = time.Now()
 	msg.UpdatedAt = time.Now()
+	if msg.SeenBy == nil {
+		msg.SeenBy = []models.SeenReceipt{}
+	}
 
 	res, err := r.collection.InsertOne(ctx, msg)
 	if err != nil {
@@ -111,40 +335,291 @@ func (r *MessageRepository) CreateMessage(ctx context.Context, msg *models.Messa
 	return msg, nil
 }
 
-func (r *MessageRepository) MarkMessagesAsSeen(ctx context.Context, userID primitive.ObjectID, messageIDs []primitive.ObjectID) error {
+// ClaimDueMessage dispatches one held-back message whose undo window has
+// passed by clearing its pending status, and returns it. It returns
+// mongo.ErrNoDocuments when nothing is due. The claim is atomic, so a message
+// is dispatched once and never after CancelPendingMessage removed it.
+func (r *MessageRepository) ClaimDueMessage(ctx context.Context, now time.Time) (*models.Message, error) {
+	var msg models.Message
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":      models.MessageStatusPendingDispatch,
+			"dispatch_at": bson.M{"$lte": now},
+		},
+		bson.M{
+			"$unset": bson.M{"status": "", "dispatch_at": ""},
+			"$set":   bson.M{"updated_at": now},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "dispatch_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// NextSequence returns the next sequence number of the conversation with the
+// given key, starting at 1. Inside a transaction the increment is undone
+// with it, so committed messages don't leave gaps.
+func (r *MessageRepository) NextSequence(ctx context.Context, conversationKey string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := r.sequences.FindOneAndUpdate(ctx,
+		bson.M{"_id": conversationKey},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// SetSequence numbers a message that was stored before it was delivered,
+// such as one held back for undo send
+func (r *MessageRepository) SetSequence(ctx context.Context, id primitive.ObjectID, seq int64) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"seq": seq}})
+	return err
+}
+
+// CancelPendingMessage removes a message still held back in its sender's
+// undo window. It reports false when the message was already dispatched.
+func (r *MessageRepository) CancelPendingMessage(ctx context.Context, id, senderID primitive.ObjectID) (bool, error) {
+	res, err := r.collection.DeleteOne(ctx, bson.M{
+		"_id":       id,
+		"sender_id": senderID,
+		"status":    models.MessageStatusPendingDispatch,
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// SetLinkPreview attaches a link preview to a message that hasn't been
+// deleted and returns the updated message
+func (r *MessageRepository) SetLinkPreview(ctx context.Context, id primitive.ObjectID, preview *models.LinkPreview) (*models.Message, error) {
+	var msg models.Message
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "is_deleted": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"link_preview": preview}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// unseenFilter matches the given messages that userID received but has not
+// seen yet; groupIDs are the groups userID is a member of. Messages of other
+// conversations never match, whoever's IDs are passed in.
+func unseenFilter(userID primitive.ObjectID, groupIDs, messageIDs []primitive.ObjectID) bson.M {
+	if groupIDs == nil {
+		groupIDs = []primitive.ObjectID{}
+	}
+	return bson.M{
+		"_id": bson.M{"$in": messageIDs},
+		"$or": []bson.M{
+			{"receiver_id": userID},
+			{"group_id": bson.M{"$in": groupIDs}},
+		},
+		"sender_id":       bson.M{"$ne": userID},
+		"seen_by.user_id": bson.M{"$ne": userID},
+		"status":          bson.M{"$ne": models.MessageStatusPendingDispatch},
+	}
+}
+
+// GetUnseenMessages returns the subset of messageIDs not yet seen by userID
+// in its direct conversations and groupIDs
+func (r *MessageRepository) GetUnseenMessages(ctx context.Context, userID primitive.ObjectID, groupIDs, messageIDs []primitive.ObjectID) ([]models.Message, error) {
+	cursor, err := r.collection.Find(ctx, unseenFilter(userID, groupIDs, messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *MessageRepository) MarkMessagesAsSeen(ctx context.Context, userID primitive.ObjectID, groupIDs, messageIDs []primitive.ObjectID, seenAt time.Time) error {
 	_, err := r.collection.UpdateMany(
 		ctx,
-		bson.M{"_id": bson.M{"$in": messageIDs}},
+		unseenFilter(userID, groupIDs, messageIDs),
 		bson.M{
-			"$addToSet": bson.M{"seen_by": userID},
-			"$set":      bson.M{"updated_at": time.Now()},
+			"$push": bson.M{"seen_by": models.SeenReceipt{UserID: userID, SeenAt: seenAt}},
+			"$set":  bson.M{"updated_at": time.Now()},
 		},
 	)
 	return err
 }
 
-func (r *MessageRepository) GetUnreadCount(ctx context.Context, userID primitive.ObjectID) (int64, error) {
-	return r.collection.CountDocuments(ctx, bson.M{
-		"receiver_id": userID,
-		"seen_by":     bson.M{"$ne": userID},
-	})
+// MarkDelivered adds a delivery receipt at deliveredAt for each of userIDs
+// that doesn't have one yet, returning the message as it was before
+func (r *MessageRepository) MarkDelivered(ctx context.Context, messageID primitive.ObjectID, userIDs []primitive.ObjectID, deliveredAt time.Time) (*models.Message, error) {
+	undelivered := bson.M{"$filter": bson.M{
+		"input": userIDs,
+		"cond": bson.M{"$not": bson.A{bson.M{"$in": bson.A{
+			"$$this", bson.M{"$ifNull": bson.A{"$delivered_to.user_id", bson.A{}}},
+		}}}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"delivered_to": bson.M{"$concatArrays": bson.A{
+			bson.M{"$ifNull": bson.A{"$delivered_to", bson.A{}}},
+			bson.M{"$map": bson.M{
+				"input": undelivered,
+				"in":    bson.M{"user_id": "$$this", "delivered_at": deliveredAt},
+			}},
+		}},
+		// Only new receipts count as a change for delta sync
+		"updated_at": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$size": undelivered}, 0}}, deliveredAt, "$updated_at",
+		}},
+	}}}}
+
+	var msg models.Message
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": messageID, "status": bson.M{"$ne": models.MessageStatusPendingDispatch}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
 }
 
-func (r *MessageRepository) GetConversationMessageCount(
-    ctx context.Context,
-    conversationID primitive.ObjectID,
-    isGroup bool,
-) (int64, error) {
-    filter := bson.M{
-    }
+// GetUnreadCounts counts the messages userID hasn't seen, and those of them
+// that mention userID, keyed by conversation (the sender's ID for direct
+// messages, otherwise the group ID)
+func (r *MessageRepository) GetUnreadCounts(ctx context.Context, userID primitive.ObjectID, groupIDs []primitive.ObjectID) (*models.UnreadCounts, error) {
+	if groupIDs == nil {
+		groupIDs = []primitive.ObjectID{}
+	}
 
-    if isGroup {
-        filter["group_id"] = conversationID
-    } else {
-        filter["$or"] = []bson.M{
-            {"sender_id": conversationID},
-            {"receiver_id": conversationID},
-        }
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or": []bson.M{
+				{"receiver_id": userID},
+				{"group_id": bson.M{"$in": groupIDs}},
+			},
+			"sender_id":       bson.M{"$ne": userID},
+			"seen_by.user_id": bson.M{"$ne": userID},
+			"is_deleted":      bson.M{"$ne": true},
+			"status":          bson.M{"$ne": models.MessageStatusPendingDispatch},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$group_id", "$sender_id"}},
+			"count": bson.M{"$sum": 1},
+			"mentions": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$in": bson.A{userID, bson.M{"$ifNull": bson.A{"$mentions", bson.A{}}}}}, 1, 0,
+			}}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Count    int64              `bson:"count"`
+		Mentions int64              `bson:"mentions"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := &models.UnreadCounts{
+		Messages: make(map[string]int64, len(rows)),
+		Mentions: make(map[string]int64),
+	}
+	for _, row := range rows {
+		counts.Messages[row.ID.Hex()] = row.Count
+		if row.Mentions > 0 {
+			counts.Mentions[row.ID.Hex()] = row.Mentions
+		}
+	}
+	return counts, nil
+}
+
+// GetChangedMessages returns the messages of userID's conversations created or
+// changed since, grouped by conversation with at most perConversation of the
+// latest changes each. Conversations come in the order they last changed, at
+// most limit of them; more reports whether others changed later.
+func (r *MessageRepository) GetChangedMessages(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	groupIDs []primitive.ObjectID,
+	since time.Time,
+	limit, perConversation int64,
+) (changes []models.ConversationChanges, more bool, err error) {
+	if groupIDs == nil {
+		groupIDs = []primitive.ObjectID{}
+	}
+
+	changed := bson.M{"$gte": since}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": []bson.M{
+			{"$or": []bson.M{
+				{"sender_id": userID, "receiver_id": bson.M{"$exists": true}, "updated_at": changed},
+				{"receiver_id": userID, "updated_at": changed},
+				{"group_id": bson.M{"$in": groupIDs}, "updated_at": changed},
+			}},
+			visibleTo(userID),
+		}}}},
+		{{Key: "$project", Value: bson.M{"original_content": 0}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"$ifNull": bson.A{
+				"$group_id",
+				bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$sender_id", userID}}, "$receiver_id", "$sender_id"}},
+			}},
+			"is_group":   bson.M{"$max": bson.M{"$gt": bson.A{"$group_id", nil}}},
+			"changed_at": bson.M{"$max": "$updated_at"},
+			"count":      bson.M{"$sum": 1},
+			"messages": bson.M{"$topN": bson.M{
+				"n":      perConversation,
+				"sortBy": bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}},
+				"output": "$$ROOT",
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "changed_at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit + 1}},
+		{{Key: "$addFields", Value: bson.M{"has_more": bson.M{"$gt": bson.A{"$count", perConversation}}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, false, err
+	}
+	if int64(len(changes)) > limit {
+		changes, more = changes[:limit], true
+	}
+	for _, c := range changes {
+		slices.Reverse(c.Messages)
+	}
+	return changes, more, nil
+}
+
+// CountMessages counts the messages GetMessages pages through for query
+func (r *MessageRepository) CountMessages(ctx context.Context, query models.MessageQuery) (int64, error) {
+    filter, err := messageQueryFilter(query)
+    if err != nil {
+        return 0, err
     }
 
     count, err := r.collection.CountDocuments(ctx, filter)
@@ -158,29 +633,33 @@ func (r *MessageRepository) GetConversationMessageCount(
 func (r *MessageRepository) DeleteMessage(
     ctx context.Context,
     messageID primitive.ObjectID,
-    requesterID primitive.ObjectID,
+    senderID primitive.ObjectID,
     mediaDeleter func(ctx context.Context, urls []string) error,
 ) (*models.Message, error) {
-	log.Printf("Deleting message with ID: %s by user: %s", messageID.Hex(), requesterID.Hex())
+	log.Printf("Deleting message with ID: %s sent by user: %s", messageID.Hex(), senderID.Hex())
     var deletedMessage models.Message
+    now := time.Now()
+    // The pre-update document is returned so the media URLs are still known.
+    // The update is a pipeline so original_content can copy the old content.
     err := r.collection.FindOneAndUpdate(
         ctx,
         bson.M{
-            "_id":       messageID,
-            "sender_id": requesterID, 
-        },
-        bson.M{
-            "$set": bson.M{
-                "deleted_at":     time.Now(),
-                "is_deleted":     true,
-                "original_content": "$content", 
-                "content":        "[deleted]",
-                "media_urls":     []string{},
-                "content_type":   models.ContentTypeDeleted,
-            },
+            "_id":        messageID,
+            "sender_id":  senderID,
+            "is_deleted": bson.M{"$ne": true},
         },
+        mongo.Pipeline{{{Key: "$set", Value: bson.M{
+            "deleted_at":       now,
+            "updated_at":       now,
+            "is_deleted":       true,
+            "original_content": "$content",
+            "content":          "",
+            "media_urls":       bson.A{},
+            "link_preview":     "$$REMOVE",
+            "content_type":     models.ContentTypeDeleted,
+        }}}},
         options.FindOneAndUpdate().
-            SetReturnDocument(options.After).
+            SetReturnDocument(options.Before).
             SetProjection(bson.M{
                 "original_content": 0, 
             }),
@@ -193,17 +672,199 @@ func (r *MessageRepository) DeleteMessage(
         return nil, err
     }
 
+    mediaURLs := deletedMessage.MediaURLs
+    deletedMessage.DeletedAt = &now
+    deletedMessage.IsDeleted = true
+    deletedMessage = deletedMessage.Tombstone()
+
+    // Replies keep a preview of the message; blank it as well
+    if _, err := r.collection.UpdateMany(ctx,
+        bson.M{"reply_to_id": messageID},
+        bson.M{"$set": bson.M{"reply_to.content": ""}},
+    ); err != nil {
+        log.Printf("Failed to clear reply previews of message %s: %v", messageID.Hex(), err)
+    }
+
     // Async media cleanup
-    if len(deletedMessage.MediaURLs) > 0 && mediaDeleter != nil {
+    if len(mediaURLs) > 0 && mediaDeleter != nil {
         go func() {
             ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
             defer cancel()
             
-            if err := mediaDeleter(ctx, deletedMessage.MediaURLs); err != nil {
+            if err := mediaDeleter(ctx, mediaURLs); err != nil {
                 log.Printf("Failed to cleanup media for message %s: %v", messageID.Hex(), err)
             }
         }()
     }
 
     return &deletedMessage, nil
-}
\ No newline at end of file
+}
+
+// AnonymizeSender credits every message of a deleted user, and the reply
+// previews quoting them, to models.DeletedUsername
+func (r *MessageRepository) AnonymizeSender(ctx context.Context, senderID primitive.ObjectID) error {
+	if _, err := r.collection.UpdateMany(ctx,
+		bson.M{"sender_id": senderID},
+		bson.M{"$set": bson.M{"sender_name": models.DeletedUsername}},
+	); err != nil {
+		return err
+	}
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"reply_to.sender_id": senderID},
+		bson.M{"$set": bson.M{"reply_to.sender_name": models.DeletedUsername}},
+	)
+	return err
+}
+
+// TombstoneSenderMessages deletes every message of a deleted user the way
+// DeleteMessage does, blanking the reply previews quoting them as well
+func (r *MessageRepository) TombstoneSenderMessages(ctx context.Context, senderID primitive.ObjectID) error {
+	if _, err := r.collection.UpdateMany(ctx,
+		bson.M{"sender_id": senderID, "is_deleted": bson.M{"$ne": true}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"deleted_at":       time.Now(),
+			"is_deleted":       true,
+			"original_content": "$content",
+			"content":          "",
+			"media_urls":       bson.A{},
+			"link_preview":     "$$REMOVE",
+			"content_type":     models.ContentTypeDeleted,
+			"sender_name":      models.DeletedUsername,
+		}}}},
+	); err != nil {
+		return err
+	}
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"reply_to.sender_id": senderID},
+		bson.M{"$set": bson.M{"reply_to.sender_name": models.DeletedUsername, "reply_to.content": ""}},
+	)
+	return err
+}
+
+// GetConversations groups the user's direct messages and the messages of the
+// given groups by conversation, returning the latest message and unread count
+// of each, most recently active first. Conversations in archives are left out
+// unless they have had activity since they were archived.
+func (r *MessageRepository) GetConversations(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	groupIDs []primitive.ObjectID,
+	archives []models.ConversationArchive,
+	page, limit int64,
+) ([]models.ConversationSummary, int64, error) {
+	if groupIDs == nil {
+		// $in rejects null
+		groupIDs = []primitive.ObjectID{}
+	}
+
+	unread := bson.M{"$and": bson.A{
+		bson.M{"$ne": bson.A{"$sender_id", userID}},
+		bson.M{"$not": bson.A{bson.M{"$in": bson.A{userID, bson.M{"$ifNull": bson.A{"$seen_by.user_id", bson.A{}}}}}}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": []bson.M{
+			{"$or": []bson.M{
+				{"sender_id": userID, "receiver_id": bson.M{"$exists": true}},
+				{"receiver_id": userID},
+				{"group_id": bson.M{"$in": groupIDs}},
+			}},
+			visibleTo(userID),
+		}}}},
+		{{Key: "$addFields", Value: bson.M{
+			"conversation_id": bson.M{"$ifNull": bson.A{
+				"$group_id",
+				bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$sender_id", userID}}, "$receiver_id", "$sender_id"}},
+			}},
+			"unread": bson.M{"$cond": bson.A{unread, 1, 0}},
+			"mentioned": bson.M{"$cond": bson.A{
+				bson.M{"$and": bson.A{
+					unread,
+					bson.M{"$in": bson.A{userID, bson.M{"$ifNull": bson.A{"$mentions", bson.A{}}}}},
+				}},
+				1,
+				0,
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$conversation_id",
+			"is_group":      bson.M{"$last": bson.M{"$gt": bson.A{"$group_id", nil}}},
+			"last_message":  bson.M{"$last": "$$ROOT"},
+			"last_activity": bson.M{"$last": "$created_at"},
+			"unread_count":  bson.M{"$sum": "$unread"},
+			"mention_count": bson.M{"$sum": "$mentioned"},
+		}}},
+	}
+	if len(archives) > 0 {
+		// Archived conversations stay hidden until they have newer activity
+		hidden := make(bson.A, len(archives))
+		for i, a := range archives {
+			hidden[i] = bson.M{"_id": a.ConversationID, "last_activity": bson.M{"$lte": a.ArchivedAt}}
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$nor": hidden}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "last_activity", Value: -1}}}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total":         bson.A{bson.M{"$count": "count"}},
+			"conversations": bson.A{bson.M{"$skip": (page - 1) * limit}, bson.M{"$limit": limit}},
+		}}},
+	)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Conversations []models.ConversationSummary `bson:"conversations"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+	if len(result) == 0 || len(result[0].Total) == 0 {
+		return []models.ConversationSummary{}, 0, nil
+	}
+
+	return result[0].Conversations, result[0].Total[0].Count, nil
+}
+
+// exportBatchSize is how many documents each cursor batch fetches while exporting
+const exportBatchSize = 500
+
+// StreamUserMessages calls fn for every message the user sent, and every
+// direct message they received, oldest first. Messages are fetched in
+// batches so large histories aren't loaded into memory at once.
+func (r *MessageRepository) StreamUserMessages(ctx context.Context, userID primitive.ObjectID, fn func(models.Message) error) error {
+	filter := bson.M{"$or": []bson.M{
+		{"sender_id": userID},
+		{"receiver_id": userID},
+	}, "status": bson.M{"$ne": models.MessageStatusPendingDispatch}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetBatchSize(exportBatchSize)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var msg models.Message
+		if err := cursor.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.IsDeleted {
+			msg = msg.Tombstone()
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
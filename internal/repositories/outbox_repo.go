@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sent events are kept for a while to help trace deliveries
+const outboxSentRetention = 7 * 24 * time.Hour
+
+type OutboxRepository struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func NewOutboxRepository(db *mongo.Database) *OutboxRepository {
+	return &OutboxRepository{db: db, collection: db.Collection("outbox")}
+}
+
+func outboxIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{
+			// Only sent events have sent_at, so pending ones never expire
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxSentRetention.Seconds())),
+		},
+	}
+}
+
+// WithTransaction runs fn in a transaction so the outbox event and the write
+// it announces are stored together. Standalone servers don't support
+// transactions; there fn runs without one and a crash between its writes can
+// still lose the event.
+func (r *OutboxRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(20) {
+		// IllegalOperation: transactions need a replica set or mongos
+		return fn(ctx)
+	}
+	return err
+}
+
+// Add stores a pending event that becomes due at event.NextAttemptAt
+func (r *OutboxRepository) Add(ctx context.Context, event *models.OutboxEvent) error {
+	event.CreatedAt = time.Now()
+	res, err := r.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+	event.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListDue returns up to limit pending events due by now, oldest first
+func (r *OutboxRepository) ListDue(ctx context.Context, now time.Time, limit int64) ([]models.OutboxEvent, error) {
+	cursor, err := r.collection.Find(ctx,
+		bson.M{
+			"sent_at":         bson.M{"$exists": false},
+			"next_attempt_at": bson.M{"$lte": now},
+		},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.OutboxEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarkSent records that an event was published
+func (r *OutboxRepository) MarkSent(ctx context.Context, id primitive.ObjectID, sentAt time.Time) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "sent_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"sent_at": sentAt}},
+	)
+	return err
+}
+
+// MarkFailed records a failed publish and when to try again
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastErr string) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$inc": bson.M{"attempts": 1},
+			"$set": bson.M{"next_attempt_at": nextAttemptAt, "last_error": lastErr},
+		},
+	)
+	return err
+}
+
+// Backlog returns the number of pending events and when the oldest was
+// created, which is zero when nothing is pending
+func (r *OutboxRepository) Backlog(ctx context.Context) (int64, time.Time, error) {
+	pending := bson.M{"sent_at": bson.M{"$exists": false}}
+	count, err := r.collection.CountDocuments(ctx, pending)
+	if err != nil || count == 0 {
+		return count, time.Time{}, err
+	}
+
+	var oldest models.OutboxEvent
+	err = r.collection.FindOne(ctx, pending,
+		options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Sent since it was counted
+		return count, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, oldest.CreatedAt, nil
+}
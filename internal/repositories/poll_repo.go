@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PollRepository struct {
+	polls *mongo.Collection
+	votes *mongo.Collection
+}
+
+func NewPollRepository(db *mongo.Database) *PollRepository {
+	return &PollRepository{
+		polls: db.Collection("polls"),
+		votes: db.Collection("poll_votes"),
+	}
+}
+
+func pollVoteIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One vote document per user per poll
+			Keys:    bson.D{{Key: "poll_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+}
+
+func (r *PollRepository) CreatePoll(ctx context.Context, poll *models.Poll) error {
+	poll.CreatedAt = time.Now()
+	res, err := r.polls.InsertOne(ctx, poll)
+	if err != nil {
+		return err
+	}
+	poll.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// DeletePoll removes a poll and its votes
+func (r *PollRepository) DeletePoll(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := r.votes.DeleteMany(ctx, bson.M{"poll_id": id}); err != nil {
+		return err
+	}
+	_, err := r.polls.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *PollRepository) GetPoll(ctx context.Context, id primitive.ObjectID) (*models.Poll, error) {
+	var poll models.Poll
+	if err := r.polls.FindOne(ctx, bson.M{"_id": id}).Decode(&poll); err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// ClosePoll closes a poll early and reports whether it was still open
+func (r *PollRepository) ClosePoll(ctx context.Context, id primitive.ObjectID, now time.Time) (bool, error) {
+	res, err := r.polls.UpdateOne(ctx,
+		bson.M{
+			"_id":       id,
+			"closed_at": bson.M{"$exists": false},
+			"$or": []bson.M{
+				{"closes_at": bson.M{"$exists": false}},
+				{"closes_at": bson.M{"$gt": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"closed_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Vote records the user's choices, replacing any earlier ones
+func (r *PollRepository) Vote(ctx context.Context, pollID, userID primitive.ObjectID, choices []int) error {
+	_, err := r.votes.UpdateOne(ctx,
+		bson.M{"poll_id": pollID, "user_id": userID},
+		bson.M{
+			"$set":         bson.M{"options": choices},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RetractVote removes the user's vote and reports whether there was one
+func (r *PollRepository) RetractVote(ctx context.Context, pollID, userID primitive.ObjectID) (bool, error) {
+	res, err := r.votes.DeleteOne(ctx, bson.M{"poll_id": pollID, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// GetVote returns the user's vote, or nil when they haven't voted
+func (r *PollRepository) GetVote(ctx context.Context, pollID, userID primitive.ObjectID) (*models.PollVote, error) {
+	var vote models.PollVote
+	err := r.votes.FindOne(ctx, bson.M{"poll_id": pollID, "user_id": userID}).Decode(&vote)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &vote, nil
+}
+
+// CountVotes returns the votes per option of a poll with optionCount options,
+// and how many users voted
+func (r *PollRepository) CountVotes(ctx context.Context, pollID primitive.ObjectID, optionCount int) ([]int64, int64, error) {
+	voters, err := r.votes.CountDocuments(ctx, bson.M{"poll_id": pollID})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := r.votes.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"poll_id": pollID}}},
+		{{Key: "$unwind", Value: "$options"}},
+		{{Key: "$group", Value: bson.M{"_id": "$options", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Option int   `bson:"_id"`
+		Count  int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, 0, err
+	}
+	counts := make([]int64, optionCount)
+	for _, row := range rows {
+		if row.Option >= 0 && row.Option < optionCount {
+			counts[row.Option] = row.Count
+		}
+	}
+	return counts, voters, nil
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type StarRepository struct {
+	collection *mongo.Collection
+}
+
+func NewStarRepository(db *mongo.Database) *StarRepository {
+	return &StarRepository{collection: db.Collection("message_stars")}
+}
+
+func starIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// One star per user per message; also serves the per-page lookup
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "message_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// The user's stars, most recent first
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			// Removing the stars of a deleted message
+			Keys: bson.D{{Key: "message_id", Value: 1}},
+		},
+	}
+}
+
+// Star stars a message for the user; starring it again is a no-op
+func (r *StarRepository) Star(ctx context.Context, userID primitive.ObjectID, msg *models.Message) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": userID, "message_id": msg.ID},
+		bson.M{"$setOnInsert": models.Star{
+			UserID:          userID,
+			MessageID:       msg.ID,
+			ConversationKey: msg.ConversationKey(),
+			CreatedAt:       time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Unstar removes the user's star from a message, if there is one
+func (r *StarRepository) Unstar(ctx context.Context, userID, messageID primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "message_id": messageID})
+	return err
+}
+
+// StarredAmong returns which of messageIDs the user has starred
+func (r *StarRepository) StarredAmong(ctx context.Context, userID primitive.ObjectID, messageIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	starred := make(map[primitive.ObjectID]bool)
+	if len(messageIDs) == 0 {
+		return starred, nil
+	}
+	ids, err := r.collection.Distinct(ctx, "message_id",
+		bson.M{"user_id": userID, "message_id": bson.M{"$in": messageIDs}})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			starred[oid] = true
+		}
+	}
+	return starred, nil
+}
+
+// ListStars pages through the user's stars, most recent first. It returns up
+// to limit+1 stars so the caller can tell whether there are more.
+func (r *StarRepository) ListStars(ctx context.Context, userID primitive.ObjectID, page, limit int64) ([]models.Star, error) {
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip((page-1)*limit).
+			SetLimit(limit+1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	stars := []models.Star{}
+	if err := cursor.All(ctx, &stars); err != nil {
+		return nil, err
+	}
+	return stars, nil
+}
+
+// DeleteMessageStars removes every user's star from a message
+func (r *StarRepository) DeleteMessageStars(ctx context.Context, messageID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"message_id": messageID})
+	return err
+}
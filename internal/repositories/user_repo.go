@@ -2,6 +2,9 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"messaging-app/internal/models"
@@ -17,8 +20,11 @@ type UserRepository struct {
 }
 
 func NewUserRepository(db *mongo.Database) *UserRepository {
-	// Create indexes
-	_, err := db.Collection("users").Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+	return &UserRepository{db: db}
+}
+
+func userIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
 		{
 			Keys:    bson.D{{Key: "email", Value: 1}},
 			Options: options.Index().SetUnique(true),
@@ -26,18 +32,35 @@ func NewUserRepository(db *mongo.Database) *UserRepository {
 		{
 			Keys: bson.D{{Key: "username", Value: 1}},
 		},
-	})
-	if err != nil {
-		panic("Failed to create user indexes: " + err.Error())
+		{
+			Keys: bson.D{{Key: "username_lower", Value: 1}},
+		},
+		{
+			// Multi-word directory searches
+			Keys: bson.D{{Key: "username", Value: "text"}, {Key: "email", Value: "text"}},
+		},
+		{
+			// Friend suggestions look up the friends of friends
+			Keys: bson.D{{Key: "friends", Value: 1}},
+		},
+		{
+			// Contact discovery
+			Keys: bson.D{{Key: "phone_hash", Value: 1}},
+		},
 	}
+}
 
-	return &UserRepository{db: db}
+// backfillUsernameLower sets the search field of users created before it existed
+func backfillUsernameLower(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"username_lower": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"username_lower": bson.M{"$toLower": "$username"}}}}},
+	)
+	return err
 }
 
 func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
+	user.UsernameLower = strings.ToLower(user.Username)
 	result, err := r.db.Collection("users").InsertOne(ctx, user)
 	if err != nil {
 		return nil, err
@@ -48,9 +71,6 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) (*mo
 }
 
 func (r *UserRepository) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
 	var user models.User
 	err := r.db.Collection("users").FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
@@ -60,9 +80,6 @@ func (r *UserRepository) FindUserByEmail(ctx context.Context, email string) (*mo
 }
 
 func (r *UserRepository) FindUserByUserName(ctx context.Context, username string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
 	var user models.User
 	err := r.db.Collection("users").FindOne(ctx, bson.M{"username": username}).Decode(&user)
 	if err != nil {
@@ -73,9 +90,6 @@ func (r *UserRepository) FindUserByUserName(ctx context.Context, username string
 
 
 func (r *UserRepository) FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
 	var user models.User
 	err := r.db.Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
@@ -85,12 +99,85 @@ func (r *UserRepository) FindUserByID(ctx context.Context, id primitive.ObjectID
 	return &user, nil
 }
 
-func (r *UserRepository) UpdateUser(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
+// FindUsersByUsernames fetches the users with the given usernames, ignoring
+// case. Unknown usernames are simply absent from the result.
+func (r *UserRepository) FindUsersByUsernames(ctx context.Context, usernames []string) ([]models.User, error) {
+	if len(usernames) == 0 {
+		return []models.User{}, nil
+	}
+
+	lower := make([]string, len(usernames))
+	for i, u := range usernames {
+		lower[i] = strings.ToLower(u)
+	}
+	cursor, err := r.db.Collection("users").Find(ctx, bson.M{"username_lower": bson.M{"$in": lower}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// FindUsersChangedSince returns those of ids whose profile changed since,
+// oldest change first, at most limit of them; more reports whether others
+// changed later
+func (r *UserRepository) FindUsersChangedSince(ctx context.Context, ids []primitive.ObjectID, since time.Time, limit int64) (users []models.User, more bool, err error) {
+	if len(ids) == 0 {
+		return []models.User{}, false, nil
+	}
+
+	cursor, err := r.db.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "updated_at": bson.M{"$gte": since}},
+		options.Find().
+			SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(limit+1),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, false, err
+	}
+	if int64(len(users)) > limit {
+		users, more = users[:limit], true
+	}
+	return users, more, nil
+}
 
+// FindUsersByIDs fetches several users in one query. Missing IDs are simply absent from the result.
+func (r *UserRepository) FindUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.User, error) {
+	if len(ids) == 0 {
+		return []models.User{}, nil
+	}
+
+	cursor, err := r.db.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *UserRepository) UpdateUser(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.User, error) {
 	// Ensure updated_at is always set
 	update["updated_at"] = time.Now()
+	if username, ok := update["username"].(string); ok {
+		update["username_lower"] = strings.ToLower(username)
+	}
 
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	result := r.db.Collection("users").FindOneAndUpdate(
@@ -108,10 +195,56 @@ func (r *UserRepository) UpdateUser(ctx context.Context, id primitive.ObjectID,
 	return &updatedUser, nil
 }
 
-func (r *UserRepository) CountUsers(ctx context.Context, filter bson.M) (int64, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
+// IncrementTokenVersion bumps the user's token version, which invalidates
+// every token issued before, and returns the new version
+func (r *UserRepository) IncrementTokenVersion(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var user models.User
+	err := r.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"token_version": 1}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
 
+// GetTokenVersion returns only the user's token version
+func (r *UserRepository) GetTokenVersion(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var user models.User
+	err := r.db.Collection("users").FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"token_version": 1}),
+	).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
+// SetAvatar replaces the user's avatar and returns the storage keys of the
+// previous one so its files can be removed
+func (r *UserRepository) SetAvatar(ctx context.Context, id primitive.ObjectID, avatar string, sizes map[string]string, keys []string) ([]string, error) {
+	var previous models.User
+	err := r.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"avatar":       avatar,
+			"avatar_sizes": sizes,
+			"avatar_keys":  keys,
+			"updated_at":   time.Now(),
+		}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.Before).
+			SetProjection(bson.M{"avatar_keys": 1}),
+	).Decode(&previous)
+	if err != nil {
+		return nil, err
+	}
+	return previous.AvatarKeys, nil
+}
+
+func (r *UserRepository) CountUsers(ctx context.Context, filter bson.M) (int64, error) {
 	count, err := r.db.Collection("users").CountDocuments(ctx, filter)
 	if err != nil {
 		return 0, err
@@ -121,9 +254,6 @@ func (r *UserRepository) CountUsers(ctx context.Context, filter bson.M) (int64,
 }
 
 func (r *UserRepository) FindUsers(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]models.User, error) {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
 	cursor, err := r.db.Collection("users").Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
@@ -139,9 +269,6 @@ func (r *UserRepository) FindUsers(ctx context.Context, filter bson.M, opts *opt
 }
 
 func (r *UserRepository) AddFriend(ctx context.Context, userID1, userID2 primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-
 	// Start a session for transaction
 	session, err := r.db.Client().StartSession()
 	if err != nil {
@@ -175,4 +302,295 @@ func (r *UserRepository) AddFriend(ctx context.Context, userID1, userID2 primiti
 	})
 
 	return err
-}
\ No newline at end of file
+}
+
+// RemoveFriend removes each user from the other's friend list
+func (r *UserRepository) RemoveFriend(ctx context.Context, userID1, userID2 primitive.ObjectID) error {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if _, err := r.db.Collection("users").UpdateOne(sessCtx,
+			bson.M{"_id": userID1},
+			bson.M{"$pull": bson.M{"friends": userID2}},
+		); err != nil {
+			return nil, err
+		}
+		if _, err := r.db.Collection("users").UpdateOne(sessCtx,
+			bson.M{"_id": userID2},
+			bson.M{"$pull": bson.M{"friends": userID1}},
+		); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// GetFriendIDs returns only the friend list of a user. Missing users have no friends.
+func (r *UserRepository) GetFriendIDs(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var user models.User
+	err := r.db.Collection("users").FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"friends": 1}),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user.Friends, nil
+}
+
+// DeactivateUser marks an active account as deactivated at the given time
+func (r *UserRepository) DeactivateUser(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "deactivated_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deactivated_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ReactivateUser clears the deactivation, or cancels the scheduled deletion,
+// of an account that hasn't been anonymized and whose deletion hasn't started
+func (r *UserRepository) ReactivateUser(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{
+			"_id":            id,
+			"anonymized_at":  bson.M{"$exists": false},
+			"deletion_stage": bson.M{"$exists": false},
+		},
+		bson.M{"$unset": bson.M{"deactivated_at": "", "deletion_due_at": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AnonymizeDeactivatedBefore permanently strips the personal data of accounts
+// deactivated before cutoff. The email is replaced with a unique placeholder
+// to keep the unique index satisfied.
+func (r *UserRepository) AnonymizeDeactivatedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := bson.M{
+		"deactivated_at":  bson.M{"$lt": cutoff},
+		"anonymized_at":   bson.M{"$exists": false},
+		"deletion_due_at": bson.M{"$exists": false}, // left to the deletion job
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"username":      models.DeletedUsername,
+			"username_lower": strings.ToLower(models.DeletedUsername),
+			"email":         bson.M{"$concat": bson.A{"deleted-", bson.M{"$toString": "$_id"}, "@deleted.invalid"}},
+			"password":      "",
+			"email_verified": false,
+			"avatar":        "",
+			"avatar_sizes":  "$$REMOVE",
+			"phone_number":  "$$REMOVE",
+			"phone_hash":    "$$REMOVE",
+			"friends":       bson.A{},
+			"anonymized_at": "$$NOW",
+		}}},
+	}
+
+	result, err := r.db.Collection("users").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ScheduleDeletion deactivates an active account at the given time and marks
+// it for deletion at dueAt
+func (r *UserRepository) ScheduleDeletion(ctx context.Context, id primitive.ObjectID, at, dueAt time.Time) error {
+	result, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "deactivated_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deactivated_at": at, "deletion_due_at": dueAt}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ClaimDueDeletion picks one account whose deletion is due and pushes its due
+// time lease into the future, so other instances leave it alone while it is
+// processed. A deletion that crashed is claimed again once the lease runs out
+// and resumes after its last completed stage. Returns nil when none are due.
+func (r *UserRepository) ClaimDueDeletion(ctx context.Context, now time.Time, lease time.Duration) (*models.User, error) {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"deletion_due_at": now.Add(lease),
+			"deletion_stage":  bson.M{"$ifNull": bson.A{"$deletion_stage", ""}},
+		}}},
+	}
+	var user models.User
+	err := r.db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"deletion_due_at": bson.M{"$lte": now}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetDeletionStage records the last completed stage of a running deletion
+func (r *UserRepository) SetDeletionStage(ctx context.Context, id primitive.ObjectID, stage string) error {
+	_, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"deletion_stage": stage}},
+	)
+	return err
+}
+
+// FinishDeletion clears the deletion bookkeeping once every stage has run
+func (r *UserRepository) FinishDeletion(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"deletion_due_at": "", "deletion_stage": ""}},
+	)
+	return err
+}
+
+// RemoveFromFriendLists takes id out of every other user's friend list
+func (r *UserRepository) RemoveFromFriendLists(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.db.Collection("users").UpdateMany(ctx,
+		bson.M{"friends": id},
+		bson.M{"$pull": bson.M{"friends": id}},
+	)
+	return err
+}
+
+// AnonymizeUser permanently strips the personal data of one account, giving
+// it the placeholder username. The account can't be logged into afterwards.
+func (r *UserRepository) AnonymizeUser(ctx context.Context, id primitive.ObjectID, username string) error {
+	_, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"username":           username,
+				"username_lower":     strings.ToLower(username),
+				"email":              "deleted-" + id.Hex() + "@deleted.invalid",
+				"password":           "",
+				"email_verified":     false,
+				"two_factor_enabled": false,
+				"avatar":             "",
+				"friends":            bson.A{},
+				"blocked":            bson.A{},
+				"anonymized_at":      time.Now(),
+			},
+			"$unset": bson.M{
+				"two_factor_secret": "",
+				"recovery_codes":    "",
+				"avatar_sizes":      "",
+				"avatar_keys":       "",
+				"phone_number":      "",
+				"phone_hash":        "",
+			},
+		},
+	)
+	return err
+}
+
+// SuggestUsers returns active users whose username starts with prefix
+// (already lowercased), sorted by username. When include is non-nil only those
+// IDs are considered; exclude is always applied.
+func (r *UserRepository) SuggestUsers(ctx context.Context, prefix string, include, exclude []primitive.ObjectID, limit int64) ([]models.User, error) {
+	idFilter := bson.M{"$nin": exclude}
+	if include != nil {
+		idFilter["$in"] = include
+	}
+	filter := bson.M{
+		"_id":            idFilter,
+		"username_lower": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"deactivated_at": bson.M{"$exists": false},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "username_lower", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"username": 1, "avatar": 1})
+
+	return r.FindUsers(ctx, filter, opts)
+}
+
+// SuggestFriends ranks active users outside exclude by how many of friendIDs
+// they are friends with, keeping up to three mutual friend names for each
+func (r *UserRepository) SuggestFriends(ctx context.Context, friendIDs, exclude []primitive.ObjectID, limit int64) ([]models.FriendSuggestion, error) {
+	if len(friendIDs) == 0 {
+		return []models.FriendSuggestion{}, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"_id":            bson.M{"$nin": exclude},
+			"friends":        bson.M{"$in": friendIDs},
+			"deactivated_at": bson.M{"$exists": false},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"username": 1,
+			"avatar":   1,
+			"mutual":   bson.M{"$setIntersection": bson.A{"$friends", friendIDs}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"mutual_count":  bson.M{"$size": "$mutual"},
+			"mutual_sample": bson.M{"$slice": bson.A{"$mutual", models.SuggestionMutualNames}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "mutual_count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "mutual_sample",
+			"foreignField": "_id",
+			"as":           "mutual_users",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"username":       1,
+			"avatar":         1,
+			"mutual_count":   1,
+			"mutual_friends": "$mutual_users.username",
+		}}},
+	}
+
+	cursor, err := r.db.Collection("users").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := []models.FriendSuggestion{}
+	if err := cursor.All(ctx, &suggestions); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// ConsumeRecoveryCode removes a two-factor recovery code hash, reporting
+// whether it was present. Each code works once.
+func (r *UserRepository) ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	result, err := r.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"recovery_codes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
+	"messaging-app/internal/repositories"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deletionLease is how long a claimed deletion is left to one instance before
+// another may resume it
+const deletionLease = 15 * time.Minute
+
+// The stages of an account deletion, in the order they run. The user document
+// records the last completed one, so a deletion that was interrupted resumes
+// where it stopped. Every stage is safe to run twice.
+const (
+	deletionStageFriendships = "friendships"
+	deletionStageMessages    = "messages"
+	deletionStageDevices     = "devices"
+	deletionStageNotified    = "notified"
+	deletionStageProfile     = "profile"
+	deletionStageCache       = "cache"
+)
+
+// AccountDeletionService permanently deletes accounts once the grace period
+// of their deletion request has passed
+type AccountDeletionService struct {
+	userRepo       *repositories.UserRepository
+	friendshipRepo *repositories.FriendshipRepository
+	followRepo     *repositories.FollowRepository
+	messageRepo    *repositories.MessageRepository
+	deviceRepo     *repositories.DeviceRepository
+	auditRepo      *repositories.AuditRepository
+	redisClient    *redis.ClusterClient
+	emails         EmailQueue
+	cfg            *config.Config
+}
+
+func NewAccountDeletionService(
+	userRepo *repositories.UserRepository,
+	friendshipRepo *repositories.FriendshipRepository,
+	followRepo *repositories.FollowRepository,
+	messageRepo *repositories.MessageRepository,
+	deviceRepo *repositories.DeviceRepository,
+	auditRepo *repositories.AuditRepository,
+	redisClient *redis.ClusterClient,
+	emails EmailQueue,
+	cfg *config.Config,
+) *AccountDeletionService {
+	return &AccountDeletionService{
+		userRepo:       userRepo,
+		friendshipRepo: friendshipRepo,
+		followRepo:     followRepo,
+		messageRepo:    messageRepo,
+		deviceRepo:     deviceRepo,
+		auditRepo:      auditRepo,
+		redisClient:    redisClient,
+		emails:         emails,
+		cfg:            cfg,
+	}
+}
+
+// DeleteDueAccounts deletes every account whose deletion is due, returning how
+// many were completed. It stops at the first failure; the failed account is
+// retried once its lease runs out.
+func (s *AccountDeletionService) DeleteDueAccounts(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		user, err := s.userRepo.ClaimDueDeletion(ctx, time.Now(), deletionLease)
+		if err != nil {
+			return deleted, err
+		}
+		if user == nil {
+			return deleted, nil
+		}
+		if err := s.deleteAccount(ctx, user); err != nil {
+			return deleted, fmt.Errorf("failed to delete account %s: %w", user.ID.Hex(), err)
+		}
+		deleted++
+	}
+}
+
+// RunAccountDeleter calls DeleteDueAccounts every interval until ctx is cancelled
+func (s *AccountDeletionService) RunAccountDeleter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.DeleteDueAccounts(ctx)
+			if err != nil {
+				log.Printf("Failed to delete accounts: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d accounts", deleted)
+			}
+		}
+	}
+}
+
+// deleteAccount runs the stages after the last completed one
+func (s *AccountDeletionService) deleteAccount(ctx context.Context, user *models.User) error {
+	stages := []struct {
+		name string
+		run  func(context.Context, *models.User) error
+	}{
+		{deletionStageFriendships, s.deleteFriendships},
+		{deletionStageMessages, s.deleteMessages},
+		{deletionStageDevices, s.deleteDevices},
+		{deletionStageNotified, s.notifyDeletion},
+		{deletionStageProfile, s.anonymizeProfile},
+		{deletionStageCache, s.purgeCache},
+	}
+
+	done := user.DeletionStage == ""
+	for _, stage := range stages {
+		if !done {
+			done = stage.name == user.DeletionStage
+			continue
+		}
+		if err := stage.run(ctx, user); err != nil {
+			return fmt.Errorf("%s: %w", stage.name, err)
+		}
+		if err := s.userRepo.SetDeletionStage(ctx, user.ID, stage.name); err != nil {
+			return err
+		}
+	}
+	return s.userRepo.FinishDeletion(ctx, user.ID)
+}
+
+// deleteFriendships drops the user's friendships, requests, blocks and
+// follows and takes them out of their friends' friend lists
+func (s *AccountDeletionService) deleteFriendships(ctx context.Context, user *models.User) error {
+	related, err := s.friendshipRepo.DeleteUserFriendships(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.RemoveFromFriendLists(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.followRepo.DeleteUserFollows(ctx, user.ID); err != nil {
+		return err
+	}
+
+	for _, id := range related {
+		if err := appredis.InvalidateFriends(ctx, s.redisClient, id.Hex()); err != nil {
+			log.Printf("Failed to invalidate friends cache of %s: %v", id.Hex(), err)
+		}
+		if err := s.redisClient.Del(ctx, friendSuggestionsKey(id.Hex())).Err(); err != nil {
+			log.Printf("Failed to invalidate friend suggestions of %s: %v", id.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// deleteMessages keeps or tombstones the user's messages according to
+// AccountDeletionContentPolicy; either way they no longer carry the name
+func (s *AccountDeletionService) deleteMessages(ctx context.Context, user *models.User) error {
+	if s.cfg.AccountDeletionContentPolicy == models.DeletionPolicyTombstone {
+		return s.messageRepo.TombstoneSenderMessages(ctx, user.ID)
+	}
+	return s.messageRepo.AnonymizeSender(ctx, user.ID)
+}
+
+// deleteDevices drops the user's push devices and their activity log, which
+// records the IPs and clients they signed in from
+func (s *AccountDeletionService) deleteDevices(ctx context.Context, user *models.User) error {
+	if err := s.deviceRepo.DeleteUserDevices(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.auditRepo.DeleteUserEvents(ctx, user.ID)
+}
+
+// notifyDeletion confirms the deletion while the address is still known
+func (s *AccountDeletionService) notifyDeletion(ctx context.Context, user *models.User) error {
+	return s.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "Your account has been deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nAs you requested, your account and personal data have been deleted. This can't be undone.\n",
+			user.Username),
+	})
+}
+
+func (s *AccountDeletionService) anonymizeProfile(ctx context.Context, user *models.User) error {
+	return s.userRepo.AnonymizeUser(ctx, user.ID, deletedUsernameFor(user.ID))
+}
+
+// purgeCache removes what Redis still holds about the user. The deactivated
+// flag stays so tokens issued before the deletion remain rejected.
+func (s *AccountDeletionService) purgeCache(ctx context.Context, user *models.User) error {
+	userID := user.ID.Hex()
+	keys := []string{
+		"refresh:" + userID,
+		appredis.UserNameKey(userID),
+		emailVerificationUserKey(userID),
+		appredis.FriendsKey(userID),
+		appredis.PresenceKey(userID),
+		friendSuggestionsKey(userID),
+		unreadKey(userID),
+		conversationsCacheKey(userID),
+	}
+	// Keys may live on different cluster slots, so delete them one by one
+	for _, key := range keys {
+		if err := s.redisClient.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+	return appredis.InvalidateUserSummary(ctx, s.redisClient, userID)
+}
+
+// deletedUsernameFor is the username a deleted account is left with; derived
+// from the ID so it is stable across retries
+func deletedUsernameFor(id primitive.ObjectID) string {
+	sum := sha256.Sum256(id[:])
+	return "deleted_user_" + hex.EncodeToString(sum[:6])
+}
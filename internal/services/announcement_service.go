@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"messaging-app/internal/kafka"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/contentfilter"
+	"messaging-app/pkg/logging"
+	"messaging-app/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// announcementEventKey partitions announcement events; they are rare enough
+// to share one partition
+const announcementEventKey = "announcements"
+
+// AnnouncementService lets operators announce things, such as maintenance
+// windows, to every user. An announcement is stored once rather than copied
+// per user: connected clients get it over their WebSocket and the others
+// fetch the active announcements when they connect.
+type AnnouncementService struct {
+	announcementRepo *repositories.AnnouncementRepository
+	producer         *kafka.MessageProducer
+	contentFilter    contentfilter.Filter
+	filterPolicy     string
+}
+
+func NewAnnouncementService(announcementRepo *repositories.AnnouncementRepository, producer *kafka.MessageProducer) *AnnouncementService {
+	return &AnnouncementService{
+		announcementRepo: announcementRepo,
+		producer:         producer,
+	}
+}
+
+// SetContentFilter screens announcements with filter. Announcements have no
+// moderation queue, so only the block policy rejects them; under flag a
+// match is just logged.
+func (s *AnnouncementService) SetContentFilter(filter contentfilter.Filter, policy string) {
+	s.contentFilter = filter
+	s.filterPolicy = policy
+}
+
+// Broadcast stores an announcement and pushes it to every connected client.
+// One scheduled to start later isn't pushed; clients find it among the
+// active announcements once it starts.
+func (s *AnnouncementService) Broadcast(ctx context.Context, adminID primitive.ObjectID, req models.AnnouncementRequest) (*models.Announcement, error) {
+	now := time.Now()
+	announcement := &models.Announcement{
+		Title:     utils.SanitizeText(req.Title),
+		Body:      utils.SanitizeText(req.Body),
+		Level:     req.Level,
+		StartsAt:  now,
+		EndsAt:    req.EndsAt,
+		CreatedBy: adminID,
+	}
+	if announcement.Level == "" {
+		announcement.Level = models.AnnouncementInfo
+	}
+	if req.StartsAt != nil {
+		announcement.StartsAt = *req.StartsAt
+	}
+	if err := validateAnnouncement(announcement, now); err != nil {
+		return nil, err
+	}
+	ctx = logging.With(ctx, "admin_id", adminID.Hex())
+	if _, err := screenText(ctx, s.contentFilter, s.filterPolicy, "announcement", announcement.Title+"\n"+announcement.Body); err != nil {
+		return nil, err
+	}
+
+	if err := s.announcementRepo.CreateAnnouncement(ctx, announcement); err != nil {
+		return nil, err
+	}
+
+	if !announcement.StartsAt.After(now) {
+		s.publish(ctx, *announcement)
+	}
+	return announcement, nil
+}
+
+func validateAnnouncement(a *models.Announcement, now time.Time) error {
+	switch a.Level {
+	case models.AnnouncementInfo, models.AnnouncementWarning, models.AnnouncementCritical:
+	default:
+		return apperrors.Validation("level must be info, warning or critical")
+	}
+	// Checked after sanitizing, so text made only of invisible characters counts as empty
+	if a.Title == "" || a.Body == "" {
+		return apperrors.Validation("title and body are required")
+	}
+	if utf8.RuneCountInString(a.Title) > models.MaxAnnouncementTitleLength {
+		return apperrors.Validation("title is too long")
+	}
+	if utf8.RuneCountInString(a.Body) > models.MaxAnnouncementBodyLength {
+		return apperrors.Validation("body is too long")
+	}
+	if !a.EndsAt.After(a.StartsAt) {
+		return apperrors.Validation("ends_at must be after starts_at")
+	}
+	if !a.EndsAt.After(now) {
+		return apperrors.Validation("ends_at must be in the future")
+	}
+	return nil
+}
+
+// publish sends the announcement to the hub, which hands it to every client.
+// Failures are only logged: clients still find it among the active ones.
+func (s *AnnouncementService) publish(ctx context.Context, announcement models.Announcement) {
+	log := logging.FromContext(ctx).With("announcement_id", announcement.ID.Hex())
+	event, err := events.NewSystemAnnouncement(announcement)
+	if err != nil {
+		log.Error("Failed to marshal announcement event", "error", err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, announcementEventKey, event); err != nil {
+		log.Error("Failed to publish announcement event", "error", err)
+	}
+}
+
+// GetActive lists the announcements showing now that userID hasn't dismissed
+func (s *AnnouncementService) GetActive(ctx context.Context, userID primitive.ObjectID) ([]models.Announcement, error) {
+	return s.announcementRepo.GetActiveAnnouncements(ctx, userID, time.Now())
+}
+
+// Dismiss stops showing an announcement to userID
+func (s *AnnouncementService) Dismiss(ctx context.Context, userID, announcementID primitive.ObjectID) error {
+	if _, err := s.announcementRepo.GetAnnouncement(ctx, announcementID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return apperrors.NotFound("announcement not found")
+		}
+		return err
+	}
+	return s.announcementRepo.Dismiss(ctx, announcementID, userID)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/clientinfo"
+	"messaging-app/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditQueueSize bounds the events waiting to be written. When the queue is
+// full, events are dropped rather than slowing down logins.
+const auditQueueSize = 1024
+
+// maxAuditUserAgentLength keeps oversized User-Agent headers out of the log
+const maxAuditUserAgentLength = 512
+
+// AuditLog records security events in the background. Recording never blocks
+// the request; a worker writes the events and emails users about logins from
+// new devices.
+type AuditLog struct {
+	auditRepo *repositories.AuditRepository
+	userRepo  *repositories.UserRepository
+	emails    EmailQueue
+	queue     chan models.AuditEvent
+}
+
+func NewAuditLog(auditRepo *repositories.AuditRepository, userRepo *repositories.UserRepository, emails EmailQueue) *AuditLog {
+	return &AuditLog{
+		auditRepo: auditRepo,
+		userRepo:  userRepo,
+		emails:    emails,
+		queue:     make(chan models.AuditEvent, auditQueueSize),
+	}
+}
+
+// Record queues an event for userID with the client the request came from.
+// A nil AuditLog records nothing.
+func (l *AuditLog) Record(ctx context.Context, userID primitive.ObjectID, eventType string) {
+	if l == nil {
+		return
+	}
+	client := clientinfo.FromContext(ctx)
+	userAgent := client.UserAgent
+	if len(userAgent) > maxAuditUserAgentLength {
+		userAgent = userAgent[:maxAuditUserAgentLength]
+	}
+
+	event := models.AuditEvent{
+		UserID:      userID,
+		Type:        eventType,
+		IP:          client.IP,
+		UserAgent:   userAgent,
+		Fingerprint: deviceFingerprint(client.IP, userAgent),
+		CreatedAt:   time.Now(),
+	}
+	select {
+	case l.queue <- event:
+	default:
+		logging.FromContext(ctx).Warn("Audit queue full, dropping event", "user_id", userID.Hex(), "type", eventType)
+	}
+}
+
+// Run writes queued events until ctx is done
+func (l *AuditLog) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-l.queue:
+			if err := l.write(ctx, event); err != nil {
+				logging.FromContext(ctx).Error("Failed to write audit event", "user_id", event.UserID.Hex(), "type", event.Type, "error", err)
+			}
+		}
+	}
+}
+
+// write stores an event. A login from a device the account hasn't logged in
+// from before is also recorded as a new device, except for the first login.
+func (l *AuditLog) write(ctx context.Context, event models.AuditEvent) error {
+	if event.Type != models.AuditLogin {
+		return l.auditRepo.Insert(ctx, event)
+	}
+
+	hasLogins, fromDevice, err := l.auditRepo.HasLoggedIn(ctx, event.UserID, event.Fingerprint)
+	if err != nil {
+		return err
+	}
+	if !hasLogins || fromDevice {
+		return l.auditRepo.Insert(ctx, event)
+	}
+
+	if err := l.notifyNewDevice(ctx, event); err != nil {
+		logging.FromContext(ctx).Error("Failed to queue new device email", "user_id", event.UserID.Hex(), "error", err)
+	}
+	newDevice := event
+	newDevice.Type = models.AuditNewDevice
+	return l.auditRepo.Insert(ctx, event, newDevice)
+}
+
+func (l *AuditLog) notifyNewDevice(ctx context.Context, event models.AuditEvent) error {
+	user, err := l.userRepo.FindUserByID(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+	return l.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account was signed in to from a new device at %s.\n\nIP address: %s\nDevice: %s\n\nIf this wasn't you, change your password and sign out of all sessions.\n",
+			user.Username, event.CreatedAt.UTC().Format(time.RFC1123), event.IP, event.UserAgent),
+	})
+}
+
+// List pages through userID's events, newest first, optionally only of the
+// given types
+func (l *AuditLog) List(ctx context.Context, userID primitive.ObjectID, types []string, page, limit int64) (*models.AuditEventListResponse, error) {
+	for _, t := range types {
+		if !models.ValidAuditEventTypes[t] {
+			return nil, apperrors.Validation("invalid activity type: " + t)
+		}
+	}
+
+	events, err := l.auditRepo.List(ctx, userID, types, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := int64(len(events)) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	return &models.AuditEventListResponse{Events: events, Page: page, Limit: limit, HasMore: hasMore}, nil
+}
+
+// deviceFingerprint identifies a client by its IP and User-Agent
+func deviceFingerprint(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:16])
+}
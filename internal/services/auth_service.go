@@ -2,38 +2,55 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"messaging-app/config"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// EmailQueue hands emails to the background sender so SMTP outages don't
+// block requests; implemented by the Kafka producer
+type EmailQueue interface {
+	QueueEmail(ctx context.Context, message models.EmailMessage) error
+}
+
 type AuthService struct {
 	userRepo     *repositories.UserRepository
 	jwtSecret    string
 	redisClient  *redis.ClusterClient
+	emails       EmailQueue
 	cfg          *config.Config
+	audit        *AuditLog
 }
 
 func NewAuthService(
 	userRepo *repositories.UserRepository,
 	jwtSecret string,
 	redisClient *redis.ClusterClient,
+	emails EmailQueue,
 	cfg *config.Config,
+	audit *AuditLog,
 ) *AuthService {
 	return &AuthService{
 		userRepo:     userRepo,
 		jwtSecret:    jwtSecret,
 		redisClient:  redisClient,
+		emails:       emails,
 		cfg:          cfg,
+		audit:        audit,
 	}
 }
 
@@ -54,12 +71,21 @@ func (s *AuthService) Register(ctx context.Context, user *models.User) (*models.
 	}
 
 	user.Password = string(hashedPassword)
+	user.EmailVerified = false
+	// Avatars are only set by uploading one, never by URL
+	user.Avatar = ""
+	user.AvatarSizes = nil
+	user.AvatarKeys = nil
 
 	createdUser, err := s.userRepo.CreateUser(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.sendVerificationEmail(ctx, createdUser); err != nil {
+		log.Printf("Failed to queue verification email for user %s: %v", createdUser.ID.Hex(), err)
+	}
+
 	accessToken, refreshToken, err := s.generateTokens(ctx, createdUser)
 	if err != nil {
 		return nil, err
@@ -82,13 +108,35 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (*model
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		log.Printf("err in pass matching: %s", err)
+		s.audit.Record(ctx, user.ID, models.AuditLoginFailed)
 		return nil, errors.New("invalid credentials: please check password")
 	}
 
+	if !user.IsActive() {
+		if user.DeletionDueAt == nil {
+			return nil, ErrAccountDeactivated
+		}
+		// Logging in during the grace period cancels a scheduled deletion
+		if err := s.reactivate(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// completeLogin issues tokens once the password checked out, or a two-factor
+// challenge when the account requires a second factor
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User) (*models.AuthResponse, error) {
+	if user.TwoFactorEnabled {
+		return s.createTwoFactorChallenge(ctx, user)
+	}
+
 	accessToken, refreshToken, err := s.generateTokens(ctx, user)
 	if err != nil {
 		return nil, err
 	}
+	s.audit.Record(ctx, user.ID, models.AuditLogin)
 
 	return &models.AuthResponse{
 		AccessToken:  accessToken,
@@ -97,6 +145,18 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (*model
 	}, nil
 }
 
+var (
+	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token reuse detected")
+	ErrAccountDeactivated    = errors.New("account is deactivated")
+	ErrAccountNotDeactivated = errors.New("account is not deactivated")
+	ErrReactivationExpired   = errors.New("account can no longer be reactivated")
+	ErrIncorrectPassword     = errors.New("incorrect password")
+)
+
+// RefreshToken exchanges a refresh token for a new token pair. Refresh tokens
+// are single use: the presented token is revoked, and presenting a revoked
+// token again revokes every token issued from the same login (its family).
 func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.AuthResponse, error) {
 	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -105,22 +165,50 @@ func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*m
 		return []byte(s.jwtSecret), nil
 	})
 	if err != nil {
-		return nil, errors.New("invalid refresh token")
+		return nil, ErrInvalidRefreshToken
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return nil, errors.New("invalid refresh token")
+		return nil, ErrInvalidRefreshToken
 	}
 
 	if claims["type"] != "refresh" {
 		return nil, errors.New("invalid token type")
 	}
 
-	userID := claims["id"].(string)
+	userID, _ := claims["id"].(string)
+	jti, _ := claims["jti"].(string)
+	family, _ := claims["fam"].(string)
+	if userID == "" || jti == "" || family == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Claiming the token ID is atomic, so of concurrent requests with one
+	// token only the first gets through. A token that was already claimed is
+	// being replayed: assume it was stolen and kill the whole family.
+	claimed, err := s.claimToken(ctx, jti, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		if err := s.revokeFamily(ctx, userID, family); err != nil {
+			log.Printf("Failed to revoke token family %s: %v", family, err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	revoked, err := s.redisClient.Exists(ctx, appredis.RevokedFamilyKey(family)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if revoked > 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
 	storedToken, err := s.redisClient.Get(ctx, "refresh:"+userID).Result()
 	if err != nil || storedToken != refreshToken {
-		return nil, errors.New("invalid refresh token")
+		return nil, ErrInvalidRefreshToken
 	}
 
 	objID, err := primitive.ObjectIDFromHex(userID)
@@ -132,8 +220,14 @@ func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*m
 	if err != nil {
 		return nil, errors.New("user not found")
 	}
+	if !user.IsActive() {
+		return nil, ErrAccountDeactivated
+	}
+	if version, _ := claims["ver"].(float64); int64(version) != user.TokenVersion {
+		return nil, ErrInvalidRefreshToken
+	}
 
-	newAccessToken, newRefreshToken, err := s.generateTokens(ctx, user)
+	newAccessToken, newRefreshToken, err := s.generateTokenPair(ctx, user, family)
 	if err != nil {
 		return nil, err
 	}
@@ -145,16 +239,34 @@ func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*m
 	}, nil
 }
 
+// Logout revokes the access token, the current refresh token and its family
 func (s *AuthService) Logout(ctx context.Context, userID, accessToken string) error {
 	remainingTTL := s.getRemainingTTL(accessToken)
 	if remainingTTL > 0 {
-		err := s.redisClient.Set(ctx, "blacklist:"+accessToken, "1", time.Duration(remainingTTL)*time.Second).Err()
+		err := s.redisClient.Set(ctx, appredis.BlacklistKey(accessToken), "1", time.Duration(remainingTTL)*time.Second).Err()
 		if err != nil {
 			return err
 		}
 	}
 
-	err := s.redisClient.Del(ctx, "refresh:"+userID).Err()
+	storedToken, err := s.redisClient.Get(ctx, "refresh:"+userID).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if storedToken != "" {
+		if claims, ok := parseUnverifiedClaims(storedToken); ok {
+			if jti, _ := claims["jti"].(string); jti != "" {
+				if err := s.revokeToken(ctx, jti, storedToken); err != nil {
+					return err
+				}
+			}
+			if family, _ := claims["fam"].(string); family != "" {
+				return s.revokeFamily(ctx, userID, family)
+			}
+		}
+	}
+
+	err = s.redisClient.Del(ctx, "refresh:"+userID).Err()
 	if err != nil {
 		return err
 	}
@@ -162,11 +274,179 @@ func (s *AuthService) Logout(ctx context.Context, userID, accessToken string) er
 	return nil
 }
 
+// LogoutEverywhere ends every session of the user, on all devices
+func (s *AuthService) LogoutEverywhere(ctx context.Context, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	return revokeAllSessions(ctx, s.userRepo, s.redisClient, objID)
+}
+
+// wsTicketTTL only has to cover the time between asking for a ticket and
+// opening the socket
+const wsTicketTTL = 30 * time.Second
+
+// IssueWSTicket returns a single-use ticket that authenticates one WebSocket
+// connection as userID
+func (s *AuthService) IssueWSTicket(ctx context.Context, userID string) (*models.WSTicketResponse, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	ticket := hex.EncodeToString(raw)
+	if err := s.redisClient.Set(ctx, appredis.WSTicketKey(ticket), userID, wsTicketTTL).Err(); err != nil {
+		return nil, err
+	}
+	return &models.WSTicketResponse{Ticket: ticket, ExpiresIn: int64(wsTicketTTL.Seconds())}, nil
+}
+
+// DeactivateAccount disables the account and ends the current session. The
+// Redis flag makes the auth middleware reject access tokens issued earlier.
+func (s *AuthService) DeactivateAccount(ctx context.Context, userID, accessToken string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	if err := s.userRepo.DeactivateUser(ctx, objID, time.Now()); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrAccountDeactivated
+		}
+		return err
+	}
+
+	if err := s.redisClient.Set(ctx, appredis.DeactivatedUserKey(userID), "1", 0).Err(); err != nil {
+		return err
+	}
+
+	return s.Logout(ctx, userID, accessToken)
+}
+
+// ReactivateAccount restores a deactivated account within the grace period
+// and logs the user in
+func (s *AuthService) ReactivateAccount(ctx context.Context, email, password string) (*models.AuthResponse, error) {
+	user, err := s.userRepo.FindUserByEmail(ctx, email)
+	if err != nil {
+		return nil, errors.New("invalid credentials: please check email")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		s.audit.Record(ctx, user.ID, models.AuditLoginFailed)
+		return nil, errors.New("invalid credentials: please check password")
+	}
+
+	if user.IsActive() {
+		return nil, ErrAccountNotDeactivated
+	}
+	// A scheduled deletion can be cancelled until it runs
+	if user.DeletionDueAt == nil && time.Since(*user.DeactivatedAt) > s.cfg.AccountReactivationGrace {
+		return nil, ErrReactivationExpired
+	}
+
+	if err := s.reactivate(ctx, user); err != nil {
+		if errors.Is(err, ErrAccountDeactivated) {
+			return nil, ErrReactivationExpired
+		}
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// ScheduleDeletion deactivates the account after checking the password and
+// schedules its permanent deletion once AccountDeletionGrace has passed.
+// Logging in before then cancels the deletion.
+func (s *AuthService) ScheduleDeletion(ctx context.Context, userID, accessToken, password string) (time.Time, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return time.Time{}, errors.New("invalid user ID")
+	}
+	user, err := s.userRepo.FindUserByID(ctx, objID)
+	if err != nil {
+		return time.Time{}, errors.New("user not found")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return time.Time{}, ErrIncorrectPassword
+	}
+
+	now := time.Now()
+	dueAt := now.Add(s.cfg.AccountDeletionGrace)
+	if err := s.userRepo.ScheduleDeletion(ctx, objID, now, dueAt); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, ErrAccountDeactivated
+		}
+		return time.Time{}, err
+	}
+
+	if err := s.redisClient.Set(ctx, appredis.DeactivatedUserKey(userID), "1", 0).Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	return dueAt, s.Logout(ctx, userID, accessToken)
+}
+
+// reactivate clears the deactivation, or cancels the scheduled deletion, of
+// user. Returns ErrAccountDeactivated once the account was anonymized or its
+// deletion has started.
+func (s *AuthService) reactivate(ctx context.Context, user *models.User) error {
+	if err := s.userRepo.ReactivateUser(ctx, user.ID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrAccountDeactivated
+		}
+		return err
+	}
+	if err := s.redisClient.Del(ctx, appredis.DeactivatedUserKey(user.ID.Hex())).Err(); err != nil {
+		return err
+	}
+	user.DeactivatedAt = nil
+	user.DeletionDueAt = nil
+	return nil
+}
+
+// PurgeDeactivatedAccounts anonymizes accounts whose reactivation grace period has passed
+func (s *AuthService) PurgeDeactivatedAccounts(ctx context.Context) (int64, error) {
+	return s.userRepo.AnonymizeDeactivatedBefore(ctx, time.Now().Add(-s.cfg.AccountReactivationGrace))
+}
+
+// RunAccountPurger calls PurgeDeactivatedAccounts every interval until ctx is cancelled
+func (s *AuthService) RunAccountPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeDeactivatedAccounts(ctx)
+			if err != nil {
+				log.Printf("Failed to purge deactivated accounts: %v", err)
+			} else if purged > 0 {
+				log.Printf("Anonymized %d deactivated accounts", purged)
+			}
+		}
+	}
+}
+
+// generateTokens starts a new token family, used on register and login. It
+// also warms the username cache messages take their sender name from.
 func (s *AuthService) generateTokens(ctx context.Context, user *models.User) (string, string, error) {
+	if err := appredis.CacheUserName(ctx, s.redisClient, user.ID.Hex(), user.Username); err != nil {
+		log.Printf("Failed to cache username of %s: %v", user.ID.Hex(), err)
+	}
+	return s.generateTokenPair(ctx, user, uuid.NewString())
+}
+
+func (s *AuthService) generateTokenPair(ctx context.Context, user *models.User, family string) (string, string, error) {
 	accessClaims := jwt.MapClaims{
 		"id":    user.ID.Hex(),
 		"email": user.Email,
 		"type":  "access",
+		"jti":   uuid.NewString(),
+		"fam":   family,
+		"ver":   user.TokenVersion,
+		"role":  user.EffectiveRole(),
 		"exp":   time.Now().Add(s.cfg.AccessTokenTTL).Unix(),
 	}
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
@@ -178,6 +458,9 @@ func (s *AuthService) generateTokens(ctx context.Context, user *models.User) (st
 	refreshClaims := jwt.MapClaims{
 		"id":   user.ID.Hex(),
 		"type": "refresh",
+		"jti":  uuid.NewString(),
+		"fam":  family,
+		"ver":  user.TokenVersion,
 		"exp":  time.Now().Add(s.cfg.RefreshTokenTTL).Unix(),
 	}
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
@@ -190,17 +473,68 @@ func (s *AuthService) generateTokens(ctx context.Context, user *models.User) (st
 	if err != nil {
 		return "", "", err
 	}
+	// Restores the cached version if Redis lost it. SETNX so a version bumped
+	// since user was loaded isn't overwritten with this older one.
+	if err := s.redisClient.SetNX(ctx, appredis.TokenVersionKey(user.ID.Hex()), user.TokenVersion, 0).Err(); err != nil {
+		return "", "", err
+	}
 
 	return accessTokenString, refreshTokenString, nil
 }
 
-func (s *AuthService) getRemainingTTL(tokenString string) int64 {
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+// claimToken marks a refresh token ID as used until the token would have
+// expired anyway. It reports false if the ID was already used.
+func (s *AuthService) claimToken(ctx context.Context, jti, tokenString string) (bool, error) {
+	// A token in its last second still needs a claim that outlives the check
+	ttl := max(s.getRemainingTTL(tokenString), 1)
+	return s.redisClient.SetNX(ctx, appredis.RevokedTokenKey(jti), "1", time.Duration(ttl)*time.Second).Result()
+}
+
+// revokeToken remembers a token ID until the token would have expired anyway
+func (s *AuthService) revokeToken(ctx context.Context, jti, tokenString string) error {
+	ttl := s.getRemainingTTL(tokenString)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.redisClient.Set(ctx, appredis.RevokedTokenKey(jti), "1", time.Duration(ttl)*time.Second).Err()
+}
+
+// revokeFamily invalidates every access and refresh token of a login session
+func (s *AuthService) revokeFamily(ctx context.Context, userID, family string) error {
+	ttl := s.cfg.RefreshTokenTTL
+	if ttl < s.cfg.AccessTokenTTL {
+		ttl = s.cfg.AccessTokenTTL
+	}
+	if err := s.redisClient.Set(ctx, appredis.RevokedFamilyKey(family), "1", ttl).Err(); err != nil {
+		return err
+	}
+	return s.redisClient.Del(ctx, "refresh:"+userID).Err()
+}
+
+// revokeAllSessions bumps the user's token version so the auth middleware and
+// RefreshToken reject every token issued before
+func revokeAllSessions(ctx context.Context, userRepo *repositories.UserRepository, redisClient *redis.ClusterClient, userID primitive.ObjectID) error {
+	version, err := userRepo.IncrementTokenVersion(ctx, userID)
 	if err != nil {
-		return 0
+		return err
+	}
+	if err := redisClient.Set(ctx, appredis.TokenVersionKey(userID.Hex()), version, 0).Err(); err != nil {
+		return err
 	}
+	return redisClient.Del(ctx, "refresh:"+userID.Hex()).Err()
+}
 
+func parseUnverifiedClaims(tokenString string) (jwt.MapClaims, bool) {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, false
+	}
 	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, ok
+}
+
+func (s *AuthService) getRemainingTTL(tokenString string) int64 {
+	claims, ok := parseUnverifiedClaims(tokenString)
 	if !ok {
 		return 0
 	}
@@ -212,4 +546,4 @@ func (s *AuthService) getRemainingTTL(tokenString string) int64 {
 
 	expTime := time.Unix(int64(exp), 0)
 	return int64(time.Until(expTime).Seconds())
-}
\ No newline at end of file
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"messaging-app/config"
+	"messaging-app/internal/imaging"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/storage"
+	"messaging-app/pkg/apperrors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Avatars are stored as square variants; the largest is the canonical avatar
+var avatarSizes = []int{64, 256}
+
+var avatarLimits = imaging.Limits{MinSide: 32, MaxSide: 4096}
+
+var avatarExtensions = map[string]string{"image/jpeg": ".jpg", "image/png": ".png"}
+
+var ErrAvatarDimensions = apperrors.Validation(fmt.Sprintf(
+	"avatar must be between %d and %d pixels on each side", avatarLimits.MinSide, avatarLimits.MaxSide))
+
+type AvatarService struct {
+	userRepo *repositories.UserRepository
+	storage  storage.Storage
+	maxSize  int64
+
+	summaries *UserSummaryCache
+}
+
+func NewAvatarService(userRepo *repositories.UserRepository, store storage.Storage, cfg *config.Config) *AvatarService {
+	return &AvatarService{
+		userRepo: userRepo,
+		storage:  store,
+		maxSize:  cfg.AvatarMaxSize,
+	}
+}
+
+// SetUserSummaryCache makes avatar changes invalidate cached user summaries
+func (s *AvatarService) SetUserSummaryCache(cache *UserSummaryCache) {
+	s.summaries = cache
+}
+
+// MaxSize is the largest accepted upload in bytes
+func (s *AvatarService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// UploadAvatar checks that body really is an image of acceptable size, stores
+// resized variants and makes them the user's avatar. The files of the
+// previous avatar are removed.
+func (s *AvatarService) UploadAvatar(ctx context.Context, userID primitive.ObjectID, body io.Reader) (*models.AvatarResponse, error) {
+	// Read one byte past the limit so oversized bodies are detected
+	data, err := io.ReadAll(io.LimitReader(body, s.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil, ErrMediaTooLarge
+	}
+
+	img, contentType, err := imaging.Decode(data, avatarLimits)
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		return nil, ErrMediaTypeNotAllowed
+	case errors.Is(err, imaging.ErrDimensions):
+		return nil, ErrAvatarDimensions
+	case err != nil:
+		return nil, err
+	}
+
+	// Every upload gets fresh keys so cached copies of the old avatar don't linger
+	uploadID := primitive.NewObjectID().Hex()
+	response := &models.AvatarResponse{AvatarSizes: make(map[string]string, len(avatarSizes))}
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		encodedType, err := imaging.Encode(&buf, imaging.Square(img, size), contentType)
+		if err != nil {
+			s.deleteKeys(ctx, keys)
+			return nil, err
+		}
+
+		key := fmt.Sprintf("avatar-%s-%s-%d%s", userID.Hex(), uploadID, size, avatarExtensions[encodedType])
+		if err := s.storage.Put(ctx, key, &buf); err != nil {
+			s.deleteKeys(ctx, keys)
+			return nil, err
+		}
+		keys = append(keys, key)
+		response.AvatarSizes[strconv.Itoa(size)] = s.storage.URL(key)
+		response.Avatar = s.storage.URL(key)
+	}
+
+	previousKeys, err := s.userRepo.SetAvatar(ctx, userID, response.Avatar, response.AvatarSizes, keys)
+	if err != nil {
+		s.deleteKeys(ctx, keys)
+		return nil, err
+	}
+	s.deleteKeys(ctx, previousKeys)
+	if s.summaries != nil {
+		s.summaries.Invalidate(ctx, userID)
+	}
+
+	return response, nil
+}
+
+// deleteKeys removes avatar files; a leftover file only wastes space, so
+// failures are logged
+func (s *AvatarService) deleteKeys(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete avatar file %s: %v", key, err)
+		}
+	}
+}
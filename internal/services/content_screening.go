@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"messaging-app/internal/models"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/contentfilter"
+	"messaging-app/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// screenContent runs the text of a message through the content filter. Under
+// the block policy a match rejects the message; under flag it is sent and
+// reported flagged.
+func (s *MessageService) screenContent(ctx context.Context, senderID primitive.ObjectID, req models.MessageRequest) (flagged bool, err error) {
+	texts := []string{req.Content}
+	if req.Poll != nil {
+		texts = append(texts, req.Poll.Question)
+		texts = append(texts, req.Poll.Options...)
+	}
+	ctx = logging.With(ctx, "sender_id", senderID.Hex())
+	return screenText(ctx, s.contentFilter, s.filterPolicy, "message", strings.Join(texts, "\n"))
+}
+
+// screenText checks the text of a kind of content, such as a message,
+// against filter. Under the block policy a match is an error; under flag it
+// is reported as flagged. What matched is never logged, only how much.
+func screenText(ctx context.Context, filter contentfilter.Filter, policy, kind, text string) (flagged bool, err error) {
+	if filter == nil || policy == "" || policy == contentfilter.PolicyOff {
+		return false, nil
+	}
+
+	verdict, matches := filter.Check(ctx, text)
+	if verdict != contentfilter.Matched {
+		return false, nil
+	}
+
+	logging.FromContext(ctx).Info("Content matched the content filter",
+		"kind", kind, "policy", policy, "matches", len(matches))
+	if policy == contentfilter.PolicyBlock {
+		return false, apperrors.Unprocessable(kind + " content is not allowed")
+	}
+	return true, nil
+}
+
+// GetFlaggedMessages pages through the moderation queue of flagged messages,
+// newest first
+func (s *MessageService) GetFlaggedMessages(ctx context.Context, page, limit int64) (*models.FlaggedMessageListResponse, error) {
+	messages, err := s.messageRepo.GetFlaggedMessages(ctx, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := int64(len(messages)) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	return &models.FlaggedMessageListResponse{
+		Messages: messages,
+		Page:     page,
+		Limit:    limit,
+		HasMore:  hasMore,
+	}, nil
+}
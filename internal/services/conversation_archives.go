@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/websocket/events"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ArchiveConversation hides a conversation from the user's conversation list
+// until it gets a new message. conversationID is a group the user belongs to,
+// or the other user of a direct conversation. Other participants' lists are
+// unaffected.
+func (s *MessageService) ArchiveConversation(ctx context.Context, userID, conversationID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, conversationID)
+	switch {
+	case err == nil:
+		if group.Role(userID) == "" {
+			return apperrors.Forbidden("not a participant of this conversation")
+		}
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if conversationID == userID {
+			return apperrors.Validation("invalid conversation ID")
+		}
+	default:
+		return err
+	}
+
+	if err := s.archiveRepo.Archive(ctx, userID, conversationID); err != nil {
+		return err
+	}
+	s.invalidateConversations(ctx, userID.Hex())
+	return nil
+}
+
+// UnarchiveConversation brings a conversation back to the user's list. It
+// succeeds whether or not the conversation was archived.
+func (s *MessageService) UnarchiveConversation(ctx context.Context, userID, conversationID primitive.ObjectID) error {
+	if err := s.archiveRepo.Unarchive(ctx, userID, conversationID); err != nil {
+		return err
+	}
+	s.invalidateConversations(ctx, userID.Hex())
+	return nil
+}
+
+// markArchived flags the conversations that are still archived, i.e. have
+// had no activity since
+func markArchived(conversations []models.ConversationSummary, archives []models.ConversationArchive) {
+	if len(archives) == 0 {
+		return
+	}
+	archivedAt := make(map[primitive.ObjectID]models.ConversationArchive, len(archives))
+	for _, a := range archives {
+		archivedAt[a.ConversationID] = a
+	}
+	for i := range conversations {
+		c := &conversations[i]
+		if a, ok := archivedAt[c.ID]; ok && !c.LastActivity.After(a.ArchivedAt) {
+			c.Archived = true
+		}
+	}
+}
+
+// unarchiveForMessage removes the archives of msg's conversation and tells
+// their owners' connections, so clients move the conversation back. Listing
+// already ignores archives older than the conversation's last activity, so
+// a failure here only delays the event.
+func (s *MessageService) unarchiveForMessage(ctx context.Context, msg *models.Message) {
+	removed, err := s.archiveRepo.UnarchiveForMessage(ctx, msg)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to unarchive conversation", "message_id", msg.ID.Hex(), "error", err)
+	}
+	for _, archive := range removed {
+		event, err := events.NewConversationUnarchived(models.ConversationUnarchivedEvent{
+			UserID:         archive.UserID,
+			ConversationID: archive.ConversationID,
+			IsGroup:        !msg.GroupID.IsZero(),
+			MessageID:      msg.ID,
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to marshal unarchive event", "error", err)
+			continue
+		}
+		if err := s.producer.ProduceEvent(ctx, msg.ConversationKey(), event); err != nil {
+			logging.FromContext(ctx).Error("Failed to publish unarchive event", "user_id", archive.UserID.Hex(), "error", err)
+		}
+	}
+}
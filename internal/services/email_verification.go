@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"messaging-app/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailVerificationTTL is how long a verification link stays valid
+const EmailVerificationTTL = 24 * time.Hour
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrEmailNotVerified         = errors.New("email must be verified first")
+)
+
+// SendVerificationEmail issues a new verification token for the user and
+// queues the email. Earlier tokens stop working.
+func (s *AuthService) SendVerificationEmail(ctx context.Context, userID primitive.ObjectID) error {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	return s.sendVerificationEmail(ctx, user)
+}
+
+func (s *AuthService) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	hash := hashVerificationToken(token)
+	userID := user.ID.Hex()
+
+	// Only the hash is stored; the value ties the token to the address it was sent to
+	previous, err := s.redisClient.GetSet(ctx, emailVerificationUserKey(userID), hash).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if previous != "" {
+		if err := s.redisClient.Del(ctx, emailVerificationKey(previous)).Err(); err != nil {
+			return err
+		}
+	}
+	if err := s.redisClient.Expire(ctx, emailVerificationUserKey(userID), EmailVerificationTTL).Err(); err != nil {
+		return err
+	}
+	if err := s.redisClient.Set(ctx, emailVerificationKey(hash), userID+":"+user.Email, EmailVerificationTTL).Err(); err != nil {
+		return err
+	}
+
+	return s.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link within 24 hours:\n\n%s?token=%s\n",
+			user.Username, s.cfg.EmailVerificationURL, token),
+	})
+}
+
+// VerifyEmail consumes a verification token. Tokens are single use and only
+// valid for the address they were sent to.
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerificationToken
+	}
+
+	value, err := s.redisClient.GetDel(ctx, emailVerificationKey(hashVerificationToken(token))).Result()
+	if err == redis.Nil {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+
+	userIDHex, email, ok := strings.Cut(value, ":")
+	if !ok {
+		return ErrInvalidVerificationToken
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil || user.Email != email {
+		return ErrInvalidVerificationToken
+	}
+
+	if _, err := s.userRepo.UpdateUser(ctx, userID, bson.M{"email_verified": true}); err != nil {
+		return err
+	}
+	return s.redisClient.Del(ctx, emailVerificationUserKey(userIDHex)).Err()
+}
+
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func emailVerificationKey(hash string) string {
+	return "email_verify:" + hash
+}
+
+// emailVerificationUserKey points at the user's current token hash
+func emailVerificationUserKey(userID string) string {
+	return "email_verify:user:" + userID
+}
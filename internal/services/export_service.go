@@ -0,0 +1,314 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/storage"
+	"messaging-app/pkg/apperrors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// exportTimeout bounds a single export run
+	exportTimeout = 30 * time.Minute
+	// Active jobs older than this were interrupted and no longer block new exports
+	exportStaleAfter = 2 * exportTimeout
+)
+
+var (
+	ErrExportInProgress = apperrors.Conflict("an export is already in progress")
+	ErrExportNotFound   = apperrors.NotFound("export not found")
+	ErrExportExpired    = apperrors.NotFound("export has expired")
+	ErrInvalidDownload  = apperrors.Forbidden("invalid or expired download link")
+)
+
+// ExportService builds downloadable archives of everything a user has stored
+type ExportService struct {
+	exportRepo     *repositories.ExportRepository
+	userRepo       *repositories.UserRepository
+	messageRepo    *repositories.MessageRepository
+	friendshipRepo *repositories.FriendshipRepository
+	groupRepo      *repositories.GroupRepository
+	storage        storage.Storage
+	emails         EmailQueue
+	linkTTL        time.Duration
+}
+
+func NewExportService(
+	exportRepo *repositories.ExportRepository,
+	userRepo *repositories.UserRepository,
+	messageRepo *repositories.MessageRepository,
+	friendshipRepo *repositories.FriendshipRepository,
+	groupRepo *repositories.GroupRepository,
+	store storage.Storage,
+	emails EmailQueue,
+	cfg *config.Config,
+) *ExportService {
+	return &ExportService{
+		exportRepo:     exportRepo,
+		userRepo:       userRepo,
+		messageRepo:    messageRepo,
+		friendshipRepo: friendshipRepo,
+		groupRepo:      groupRepo,
+		storage:        store,
+		emails:         emails,
+		linkTTL:        cfg.ExportLinkTTL,
+	}
+}
+
+// StartExport queues an export of the user's data and generates it in the
+// background. Only one export per user can be pending or running.
+func (s *ExportService) StartExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExportJob, error) {
+	if err := s.exportRepo.FailStaleJobs(ctx, userID, time.Now().Add(-exportStaleAfter)); err != nil {
+		return nil, err
+	}
+
+	job, err := s.exportRepo.CreateJob(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrExportInProgress) {
+			return nil, ErrExportInProgress
+		}
+		return nil, err
+	}
+
+	go s.run(context.WithoutCancel(ctx), job)
+	return job, nil
+}
+
+// GetExport returns one of the user's export jobs, with a fresh download link
+// once it has completed
+func (s *ExportService) GetExport(ctx context.Context, userID, jobID primitive.ObjectID) (*models.DataExportJob, error) {
+	job, err := s.exportRepo.GetJob(ctx, jobID, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	if job.Status == models.ExportStatusCompleted {
+		if !time.Now().Before(*job.ExpiresAt) {
+			return nil, ErrExportExpired
+		}
+		job.DownloadURL, err = s.storage.PresignDownload(job.StorageKey, *job.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// OpenDownload checks a signed download link and opens the archive it points to
+func (s *ExportService) OpenDownload(ctx context.Context, key, expires, signature string) (io.ReadCloser, error) {
+	server, ok := s.storage.(storage.DownloadServer)
+	if !ok {
+		return nil, errors.New("storage does not serve downloads")
+	}
+	if err := server.VerifyDownload(key, expires, signature); err != nil {
+		return nil, ErrInvalidDownload
+	}
+	f, err := server.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrExportExpired
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// PurgeExpiredExports deletes archives whose links have expired
+func (s *ExportService) PurgeExpiredExports(ctx context.Context) (int, error) {
+	jobs, err := s.exportRepo.FindExpired(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		if err := s.storage.Delete(ctx, job.StorageKey); err != nil {
+			return 0, err
+		}
+		if err := s.exportRepo.DeleteJob(ctx, job.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// RunExportPurger periodically deletes expired archives until ctx is done
+func (s *ExportService) RunExportPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeExpiredExports(ctx)
+			if err != nil {
+				log.Printf("Failed to purge expired exports: %v", err)
+			} else if purged > 0 {
+				log.Printf("Deleted %d expired data exports", purged)
+			}
+		}
+	}
+}
+
+func (s *ExportService) run(ctx context.Context, job *models.DataExportJob) {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	if err := s.exportRepo.MarkRunning(ctx, job.ID); err != nil {
+		log.Printf("Failed to start export %s: %v", job.ID.Hex(), err)
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, job.UserID)
+	if err == nil {
+		err = s.generate(ctx, job, user)
+	}
+	if err != nil {
+		log.Printf("Export %s failed: %v", job.ID.Hex(), err)
+		if err := s.exportRepo.Fail(ctx, job.ID, "export failed"); err != nil {
+			log.Printf("Failed to record export %s failure: %v", job.ID.Hex(), err)
+		}
+	}
+}
+
+func (s *ExportService) generate(ctx context.Context, job *models.DataExportJob, user *models.User) error {
+	key := fmt.Sprintf("export-%s-%s.zip", user.ID.Hex(), job.ID.Hex())
+
+	// The archive is streamed straight into storage
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.writeArchive(ctx, pw, user))
+	}()
+	if err := s.storage.Put(ctx, key, pr); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.linkTTL)
+	if err := s.exportRepo.Complete(ctx, job.ID, key, expiresAt); err != nil {
+		return err
+	}
+
+	link, err := s.storage.PresignDownload(key, expiresAt)
+	if err != nil {
+		return err
+	}
+	if err := s.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("Your data export is ready. Download it here until %s:\n\n%s\n",
+			expiresAt.UTC().Format(time.RFC1123), link),
+	}); err != nil {
+		log.Printf("Failed to queue export email for user %s: %v", user.ID.Hex(), err)
+	}
+	return nil
+}
+
+// exportedGroup is the user's view of a group they belong to
+type exportedGroup struct {
+	ID        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	Role      string             `json:"role"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// writeArchive writes the user's data to w as a zip of JSON files
+func (s *ExportService) writeArchive(ctx context.Context, w io.Writer, user *models.User) error {
+	archive := zip.NewWriter(w)
+
+	if err := writeJSONFile(archive, "profile.json", user.ToSafeResponse()); err != nil {
+		return err
+	}
+
+	err := writeJSONArrayFile(archive, "messages.json", func(emit func(any) error) error {
+		return s.messageRepo.StreamUserMessages(ctx, user.ID, func(msg models.Message) error {
+			return emit(msg)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArrayFile(archive, "friendships.json", func(emit func(any) error) error {
+		return s.friendshipRepo.StreamUserFriendships(ctx, user.ID, func(friendship models.Friendship) error {
+			return emit(friendship)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	exported := make([]exportedGroup, len(groups))
+	for i, g := range groups {
+		exported[i] = exportedGroup{ID: g.ID, Name: g.Name, Role: g.Role(user.ID), CreatedAt: g.CreatedAt}
+	}
+	if err := writeJSONFile(archive, "groups.json", exported); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func writeJSONFile(archive *zip.Writer, name string, v any) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeJSONArrayFile writes a JSON array whose elements are produced one at a
+// time by each, so it never has to hold the whole list
+func writeJSONArrayFile(archive *zip.Writer, name string, each func(emit func(any) error) error) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err = each(func(v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(f, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = f.Write(append([]byte("\n"), data...))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(f, "\n]\n")
+	return err
+}
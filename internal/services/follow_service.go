@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/pkg/apperrors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FollowService lets users follow accounts without being friends. Following
+// a private account sends a request the account has to accept.
+type FollowService struct {
+	followRepo     *repositories.FollowRepository
+	userRepo       *repositories.UserRepository
+	friendshipRepo *repositories.FriendshipRepository
+}
+
+func NewFollowService(followRepo *repositories.FollowRepository, userRepo *repositories.UserRepository, friendshipRepo *repositories.FriendshipRepository) *FollowService {
+	return &FollowService{
+		followRepo:     followRepo,
+		userRepo:       userRepo,
+		friendshipRepo: friendshipRepo,
+	}
+}
+
+// Follow makes followerID follow followeeID, or requests to when the account
+// is private. Blocked and deactivated accounts look like they don't exist.
+func (s *FollowService) Follow(ctx context.Context, followerID, followeeID primitive.ObjectID) (*models.FollowResponse, error) {
+	if followerID == followeeID {
+		return nil, apperrors.Validation("cannot follow yourself")
+	}
+	followee, err := s.visibleUser(ctx, followerID, followeeID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := models.FollowStatusAccepted
+	if followee.IsPrivate {
+		status = models.FollowStatusPending
+	}
+	follow, err := s.followRepo.Follow(ctx, followerID, followeeID, status)
+	if err != nil {
+		return nil, err
+	}
+	return &models.FollowResponse{Status: follow.Status}, nil
+}
+
+// Unfollow stops following followeeID, or withdraws a pending request
+func (s *FollowService) Unfollow(ctx context.Context, followerID, followeeID primitive.ObjectID) error {
+	removed, err := s.followRepo.Unfollow(ctx, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return apperrors.NotFound("not following this user")
+	}
+	return nil
+}
+
+// ListFollowers pages through ownerID's followers as viewerID may see them.
+// A private account's lists are only shown to the account and its followers.
+func (s *FollowService) ListFollowers(ctx context.Context, viewerID, ownerID primitive.ObjectID, page, limit int64, prefix string) (*models.UserListResponse, error) {
+	if err := s.checkListAccess(ctx, viewerID, ownerID); err != nil {
+		return nil, err
+	}
+	ids, err := s.followRepo.FollowerIDs(ctx, ownerID, models.FollowStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return pageUsers(ctx, s.userRepo, s.friendshipRepo, viewerID, ids, page, limit, prefix)
+}
+
+// ListFollowing pages through the accounts ownerID follows, with the same
+// visibility as ListFollowers
+func (s *FollowService) ListFollowing(ctx context.Context, viewerID, ownerID primitive.ObjectID, page, limit int64, prefix string) (*models.UserListResponse, error) {
+	if err := s.checkListAccess(ctx, viewerID, ownerID); err != nil {
+		return nil, err
+	}
+	ids, err := s.followRepo.FolloweeIDs(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return pageUsers(ctx, s.userRepo, s.friendshipRepo, viewerID, ids, page, limit, prefix)
+}
+
+// ListFollowRequests pages through the users waiting for userID to accept
+// their follow requests
+func (s *FollowService) ListFollowRequests(ctx context.Context, userID primitive.ObjectID, page, limit int64) (*models.UserListResponse, error) {
+	ids, err := s.followRepo.FollowerIDs(ctx, userID, models.FollowStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	return pageUsers(ctx, s.userRepo, s.friendshipRepo, userID, ids, page, limit, "")
+}
+
+// RespondToFollowRequest accepts or rejects followerID's request to follow userID
+func (s *FollowService) RespondToFollowRequest(ctx context.Context, userID, followerID primitive.ObjectID, accept bool) error {
+	respond := s.followRepo.RejectRequest
+	if accept {
+		respond = s.followRepo.AcceptRequest
+	}
+	found, err := respond(ctx, userID, followerID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return apperrors.NotFound("follow request not found")
+	}
+	return nil
+}
+
+// visibleUser loads targetID unless it is deactivated or blocked in either
+// direction with viewerID
+func (s *FollowService) visibleUser(ctx context.Context, viewerID, targetID primitive.ObjectID) (*models.User, error) {
+	target, err := s.userRepo.FindUserByID(ctx, targetID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if viewerID == targetID {
+		return target, nil
+	}
+	if !target.IsActive() {
+		return nil, apperrors.NotFound("user not found")
+	}
+	blocked, err := s.friendshipRepo.IsBlocked(ctx, viewerID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, apperrors.NotFound("user not found")
+	}
+	return target, nil
+}
+
+func (s *FollowService) checkListAccess(ctx context.Context, viewerID, ownerID primitive.ObjectID) error {
+	owner, err := s.visibleUser(ctx, viewerID, ownerID)
+	if err != nil {
+		return err
+	}
+	if viewerID == ownerID || !owner.IsPrivate {
+		return nil
+	}
+	status, err := s.followRepo.GetStatus(ctx, viewerID, ownerID)
+	if err != nil {
+		return err
+	}
+	if status != models.FollowStatusAccepted {
+		return apperrors.Forbidden("this account is private")
+	}
+	return nil
+}
@@ -2,23 +2,31 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FriendshipService struct {
-	friendshipRepo *repositories.FriendshipRepository
-	userRepo       *repositories.UserRepository
+	friendshipRepo FriendshipStore
+	userRepo       UserStore
+	redisClient    *redis.ClusterClient
 }
 
-func NewFriendshipService(fr *repositories.FriendshipRepository, ur *repositories.UserRepository) *FriendshipService {
+func NewFriendshipService(fr FriendshipStore, ur UserStore, redisClient *redis.ClusterClient) *FriendshipService {
 	return &FriendshipService{
 		friendshipRepo: fr,
 		userRepo:       ur,
+		redisClient:    redisClient,
 	}
 }
 
@@ -41,55 +49,232 @@ func (s *FriendshipService) SendRequest(ctx context.Context, requesterID, receiv
 	}
 
 	// Repository handles all other validation (self-friending, existing requests)
-	return s.friendshipRepo.CreateRequest(ctx, requesterID, receiverID)
+	friendship, err := s.friendshipRepo.CreateRequest(ctx, requesterID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidateSuggestions(ctx, requesterID.Hex(), receiverID.Hex())
+	return friendship, nil
+}
+
+const (
+	maxFriendSuggestions = 50
+	friendSuggestionsTTL = time.Hour
+)
+
+func friendSuggestionsKey(userID string) string {
+	return "friend_suggestions:" + userID
+}
+
+// GetFriendSuggestions returns users the user may know, ranked by mutual
+// friends. Existing friends, pending requests and blocks in either direction
+// are left out. The top maxFriendSuggestions are cached for an hour.
+func (s *FriendshipService) GetFriendSuggestions(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.FriendSuggestion, error) {
+	if limit < 1 || limit > maxFriendSuggestions {
+		limit = maxFriendSuggestions
+	}
+
+	cacheKey := friendSuggestionsKey(userID.Hex())
+	var suggestions []models.FriendSuggestion
+	if cached, err := s.redisClient.Get(ctx, cacheKey).Bytes(); err == nil && json.Unmarshal(cached, &suggestions) == nil {
+		return suggestions[:min(limit, len(suggestions))], nil
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := s.friendshipRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude = append(exclude, userID)
+	exclude = append(exclude, user.Friends...)
+
+	suggestions, err = s.userRepo.SuggestFriends(ctx, user.Friends, exclude, maxFriendSuggestions)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(suggestions); err == nil {
+		s.redisClient.Set(ctx, cacheKey, data, friendSuggestionsTTL)
+	}
+	return suggestions[:min(limit, len(suggestions))], nil
+}
+
+// invalidateSuggestions drops the cached suggestions of the given users
+func (s *FriendshipService) invalidateSuggestions(ctx context.Context, userIDs ...string) {
+	// Keys live in different cluster slots, so delete them one by one
+	for _, id := range userIDs {
+		if err := s.redisClient.Del(ctx, friendSuggestionsKey(id)).Err(); err != nil {
+			log.Printf("Failed to invalidate friend suggestions for %s: %v", id, err)
+		}
+	}
 }
 
 func (s *FriendshipService) RespondToRequest(ctx context.Context, friendshipID primitive.ObjectID, receiverID primitive.ObjectID, accept bool) error {
 	// First verify the request exists and belongs to this user
-	friendships, _, err := s.friendshipRepo.GetFriendRequests(ctx, receiverID, models.FriendshipStatusPending, 1, 1)
+	request, err := s.friendshipRepo.GetFriendshipByID(ctx, friendshipID)
 	if err != nil {
 		return err
 	}
+	if request.ReceiverID != receiverID {
+		return repositories.ErrFriendRequestNotFound
+	}
 
-	var targetRequest *models.Friendship
-	for _, f := range friendships {
-		if f.ID == friendshipID {
-			targetRequest = &f
-			break
+	switch {
+	case accept && request.Status == models.FriendshipStatusAccepted:
+		// Accepting twice succeeds; AddFriend is idempotent
+		return s.userRepo.AddFriend(ctx, request.RequesterID, request.ReceiverID)
+	case request.Status != models.FriendshipStatusPending:
+		return repositories.ErrFriendRequestNotFound
+	}
+
+	if !accept {
+		return s.friendshipRepo.UpdateStatus(ctx, friendshipID, receiverID, models.FriendshipStatusRejected)
+	}
+
+	// Claim the request first so concurrent responses can't both go through,
+	// then update both users' friend lists
+	if err := s.friendshipRepo.UpdateStatus(ctx, friendshipID, receiverID, models.FriendshipStatusAccepted); err != nil {
+		return err
+	}
+	if err := s.userRepo.AddFriend(ctx, request.RequesterID, request.ReceiverID); err != nil {
+		if revertErr := s.friendshipRepo.RevertToPending(ctx, friendshipID); revertErr != nil {
+			log.Printf("Failed to revert friend request %s: %v", friendshipID.Hex(), revertErr)
 		}
+		return err
 	}
+	s.friendsChanged(ctx, request.RequesterID, request.ReceiverID)
+	return nil
+}
 
-	if targetRequest == nil {
-		return repositories.ErrFriendRequestNotFound
+// friendsChanged drops the cached friend lists the WebSocket hub uses for
+// presence and the users' friend suggestions. A failure only delays the
+// change until the cache expires.
+func (s *FriendshipService) friendsChanged(ctx context.Context, userID1, userID2 primitive.ObjectID) {
+	if err := appredis.InvalidateFriends(ctx, s.redisClient, userID1.Hex(), userID2.Hex()); err != nil {
+		log.Printf("Failed to invalidate friends of %s and %s: %v", userID1.Hex(), userID2.Hex(), err)
 	}
+	s.invalidateSuggestions(ctx, userID1.Hex(), userID2.Hex())
+}
 
-	if targetRequest.ReceiverID != receiverID {
-		return repositories.ErrFriendRequestNotFound 
+func (s *FriendshipService) ListFriendships(ctx context.Context, userID primitive.ObjectID, status string, page, limit int64) ([]models.FriendRequestResponse, int64, error) {
+	friendships, total, err := s.friendshipRepo.GetFriendRequests(ctx, userID, status, page, limit)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	status := models.FriendshipStatusRejected
-	if accept {
-		status = models.FriendshipStatusAccepted
-		// Update both users' friend lists
-		if err := s.userRepo.AddFriend(ctx, targetRequest.RequesterID, targetRequest.ReceiverID); err != nil {
-			return err
+	responses, err := s.enrichFriendships(ctx, userID, friendships)
+	if err != nil {
+		return nil, 0, err
+	}
+	return responses, total, nil
+}
+
+// enrichFriendships attaches the other party's profile and the request direction
+// to each row, fetching all users with a single query.
+func (s *FriendshipService) enrichFriendships(ctx context.Context, userID primitive.ObjectID, friendships []models.Friendship) ([]models.FriendRequestResponse, error) {
+	otherIDs := make([]primitive.ObjectID, 0, len(friendships))
+	for _, f := range friendships {
+		otherIDs = append(otherIDs, otherParty(f, userID))
+	}
+
+	users, err := s.userRepo.FindUsersByIDs(ctx, otherIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	byID := make(map[primitive.ObjectID]models.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+
+	responses := make([]models.FriendRequestResponse, len(friendships))
+	for i, f := range friendships {
+		otherID := otherParty(f, userID)
+		direction := models.FriendRequestOutgoing
+		if f.ReceiverID == userID {
+			direction = models.FriendRequestIncoming
 		}
-		if err := s.userRepo.AddFriend(ctx, targetRequest.ReceiverID, targetRequest.RequesterID); err != nil {
-			return err
+
+		user := models.DeletedUserPlaceholder(otherID)
+		if u, ok := byID[otherID]; ok {
+			user = u.ToSafeResponse()
 		}
-	}
 
-	return s.friendshipRepo.UpdateStatus(ctx, friendshipID, receiverID, status)
+		responses[i] = models.FriendRequestResponse{
+			Friendship: f,
+			Direction:  direction,
+			User:       user,
+		}
+	}
+	return responses, nil
 }
 
-func (s *FriendshipService) ListFriendships(ctx context.Context, userID primitive.ObjectID, status string, page, limit int64) ([]models.Friendship, int64, error) {
-	return s.friendshipRepo.GetFriendRequests(ctx, userID, status, page, limit)
+// otherParty returns the participant of f that isn't userID
+func otherParty(f models.Friendship, userID primitive.ObjectID) primitive.ObjectID {
+	if f.RequesterID == userID {
+		return f.ReceiverID
+	}
+	return f.RequesterID
 }
 
 func (s *FriendshipService) CheckFriendship(ctx context.Context, userID1, userID2 primitive.ObjectID) (bool, error) {
 	return s.friendshipRepo.AreFriends(ctx, userID1, userID2)
 }
 
+// GetDetailedFriendshipStatus gathers friendship, pending request and block
+// state in both directions for a profile page
+func (s *FriendshipService) GetDetailedFriendshipStatus(ctx context.Context, userID, otherUserID primitive.ObjectID) (*models.FriendshipStatusResponse, error) {
+	if _, err := s.userRepo.FindUserByID(ctx, otherUserID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	status := &models.FriendshipStatusResponse{}
+	var err error
+
+	if status.AreFriends, err = s.friendshipRepo.AreFriends(ctx, userID, otherUserID); err != nil {
+		return nil, err
+	}
+
+	sent, err := s.pendingRequest(ctx, userID, otherUserID)
+	if err != nil {
+		return nil, err
+	}
+	received, err := s.pendingRequest(ctx, otherUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sent != nil {
+		status.RequestSent = true
+		status.PendingRequestID = &sent.ID
+	}
+	if received != nil {
+		status.RequestReceived = true
+		status.PendingRequestID = &received.ID
+	}
+
+	if status.Blocked, err = s.friendshipRepo.IsBlockedBy(ctx, otherUserID, userID); err != nil {
+		return nil, err
+	}
+	if status.BlockedBy, err = s.friendshipRepo.IsBlockedBy(ctx, userID, otherUserID); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// pendingRequest is GetPendingRequest with "no request" mapped to nil
+func (s *FriendshipService) pendingRequest(ctx context.Context, requesterID, receiverID primitive.ObjectID) (*models.Friendship, error) {
+	request, err := s.friendshipRepo.GetPendingRequest(ctx, requesterID, receiverID)
+	if errors.Is(err, repositories.ErrFriendRequestNotFound) {
+		return nil, nil
+	}
+	return request, err
+}
+
 // Unfriend removes a friendship between two users after validation
 func (s *FriendshipService) Unfriend(ctx context.Context, userID, friendID primitive.ObjectID) error {
     // Verify friend exists
@@ -106,16 +291,15 @@ func (s *FriendshipService) Unfriend(ctx context.Context, userID, friendID primi
         return repositories.ErrNotFriends
     }
 
-    // Remove from both users' friend lists
+    // Delete the friendship record, then remove from both users' friend lists
     if err := s.friendshipRepo.Unfriend(ctx, userID, friendID); err != nil {
-        return fmt.Errorf("failed to remove from friend list: %w", err)
+        return err
     }
-    if err := s.friendshipRepo.Unfriend(ctx, friendID, userID); err != nil {
-        return fmt.Errorf("failed to remove reciprocal friend: %w", err)
+    if err := s.userRepo.RemoveFriend(ctx, userID, friendID); err != nil {
+        return fmt.Errorf("failed to remove from friend list: %w", err)
     }
-
-    // Delete the friendship record
-    return s.friendshipRepo.Unfriend(ctx, userID, friendID)
+    s.friendsChanged(ctx, userID, friendID)
+    return nil
 }
 
 // BlockUser blocks another user with comprehensive validation
@@ -134,8 +318,15 @@ func (s *FriendshipService) BlockUser(ctx context.Context, blockerID, blockedID
         return repositories.ErrAlreadyBlocked
     }
 
-    // Perform the block
-    return s.friendshipRepo.BlockUser(ctx, blockerID, blockedID)
+    // Perform the block; it also ends any friendship
+    if err := s.friendshipRepo.BlockUser(ctx, blockerID, blockedID); err != nil {
+        return err
+    }
+    if err := s.userRepo.RemoveFriend(ctx, blockerID, blockedID); err != nil {
+        return fmt.Errorf("failed to remove from friend list: %w", err)
+    }
+    s.friendsChanged(ctx, blockerID, blockedID)
+    return nil
 }
 
 // UnblockUser removes a block between users with validation
@@ -154,7 +345,11 @@ func (s *FriendshipService) UnblockUser(ctx context.Context, blockerID, blockedI
         return repositories.ErrBlockNotFound
     }
 
-    return s.friendshipRepo.UnblockUser(ctx, blockerID, blockedID)
+    if err := s.friendshipRepo.UnblockUser(ctx, blockerID, blockedID); err != nil {
+        return err
+    }
+    s.invalidateSuggestions(ctx, blockerID.Hex(), blockedID.Hex())
+    return nil
 }
 
 // IsBlocked checks if a block exists between two users
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"messaging-app/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newPendingRequest(store *fakeFriendshipStore) *models.Friendship {
+	request := &models.Friendship{
+		ID:          primitive.NewObjectID(),
+		RequesterID: primitive.NewObjectID(),
+		ReceiverID:  primitive.NewObjectID(),
+		Status:      models.FriendshipStatusPending,
+	}
+	store.friendships[request.ID] = request
+	return request
+}
+
+func TestRespondToRequestAccepts(t *testing.T) {
+	friendships := &fakeFriendshipStore{friendships: map[primitive.ObjectID]*models.Friendship{}}
+	users := &fakeUserStore{}
+	service := NewFriendshipService(friendships, users, unreachableRedis())
+	request := newPendingRequest(friendships)
+
+	require.NoError(t, service.RespondToRequest(context.Background(), request.ID, request.ReceiverID, true))
+	assert.Equal(t, []string{models.FriendshipStatusAccepted}, friendships.statuses)
+	assert.Equal(t, [][2]primitive.ObjectID{{request.RequesterID, request.ReceiverID}}, users.friendPairs)
+
+	// Accepting again is a no-op that still succeeds
+	require.NoError(t, service.RespondToRequest(context.Background(), request.ID, request.ReceiverID, true))
+	assert.Len(t, friendships.statuses, 1)
+}
+
+func TestRespondToRequestRejects(t *testing.T) {
+	friendships := &fakeFriendshipStore{friendships: map[primitive.ObjectID]*models.Friendship{}}
+	users := &fakeUserStore{}
+	service := NewFriendshipService(friendships, users, unreachableRedis())
+	request := newPendingRequest(friendships)
+
+	require.NoError(t, service.RespondToRequest(context.Background(), request.ID, request.ReceiverID, false))
+	assert.Equal(t, []string{models.FriendshipStatusRejected}, friendships.statuses)
+	assert.Empty(t, users.friendPairs)
+
+	// A rejected request can no longer be accepted
+	err := service.RespondToRequest(context.Background(), request.ID, request.ReceiverID, true)
+	assert.ErrorIs(t, err, ErrFriendRequestNotFound)
+}
+
+func TestRespondToRequestOnlyByReceiver(t *testing.T) {
+	friendships := &fakeFriendshipStore{friendships: map[primitive.ObjectID]*models.Friendship{}}
+	service := NewFriendshipService(friendships, &fakeUserStore{}, unreachableRedis())
+	request := newPendingRequest(friendships)
+
+	for _, userID := range []primitive.ObjectID{request.RequesterID, primitive.NewObjectID()} {
+		err := service.RespondToRequest(context.Background(), request.ID, userID, true)
+		assert.ErrorIs(t, err, ErrFriendRequestNotFound)
+	}
+	assert.Empty(t, friendships.statuses)
+
+	err := service.RespondToRequest(context.Background(), primitive.NewObjectID(), request.ReceiverID, true)
+	assert.ErrorIs(t, err, ErrFriendRequestNotFound)
+}
+
+func TestRespondToRequestRevertsWhenFriendListsFail(t *testing.T) {
+	friendships := &fakeFriendshipStore{friendships: map[primitive.ObjectID]*models.Friendship{}}
+	users := &fakeUserStore{addFriendErr: errors.New("write failed")}
+	service := NewFriendshipService(friendships, users, unreachableRedis())
+	request := newPendingRequest(friendships)
+
+	err := service.RespondToRequest(context.Background(), request.ID, request.ReceiverID, true)
+	assert.EqualError(t, err, "write failed")
+	assert.True(t, friendships.reverted)
+	assert.Equal(t, models.FriendshipStatusPending, request.Status)
+}
@@ -2,24 +2,44 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"messaging-app/internal/kafka"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	inviteTokenBytes = 16
 )
 
 type GroupService struct {
-	groupRepo *repositories.GroupRepository
-	userRepo  *repositories.UserRepository
+	groupRepo   *repositories.GroupRepository
+	userRepo    *repositories.UserRepository
+	messageRepo *repositories.MessageRepository
+	redisClient *redis.ClusterClient
+	producer    *kafka.MessageProducer
 }
 
-func NewGroupService(groupRepo *repositories.GroupRepository, userRepo *repositories.UserRepository) *GroupService {
+func NewGroupService(groupRepo *repositories.GroupRepository, userRepo *repositories.UserRepository, messageRepo *repositories.MessageRepository, redisClient *redis.ClusterClient, producer *kafka.MessageProducer) *GroupService {
 	return &GroupService{
-		groupRepo: groupRepo,
-		userRepo:  userRepo,
+		groupRepo:   groupRepo,
+		userRepo:    userRepo,
+		messageRepo: messageRepo,
+		redisClient: redisClient,
+		producer:    producer,
 	}
 }
 
@@ -53,7 +73,16 @@ func (s *GroupService) CreateGroup(ctx context.Context, creatorID primitive.Obje
 }
 
 func (s *GroupService) GetGroup(ctx context.Context, id primitive.ObjectID) (*models.Group, error) {
-	return s.groupRepo.GetGroup(ctx, id)
+	group, err := s.groupRepo.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write-through so message sends can check membership without Mongo
+	if err := appredis.CacheGroupMembers(ctx, s.redisClient, id.Hex(), group.Members); err != nil {
+		log.Printf("Failed to cache members of group %s: %v", id.Hex(), err)
+	}
+	return group, nil
 }
 
 func (s *GroupService) AddMember(ctx context.Context, groupID, requesterID, newMemberID primitive.ObjectID) error {
@@ -62,8 +91,8 @@ func (s *GroupService) AddMember(ctx context.Context, groupID, requesterID, newM
 		return fmt.Errorf("group not found")
 	}
 
-	// Check if requester is admin
-	if !containsID(group.Admins, requesterID) {
+	// Admins only, unless the group lets every member add people
+	if !group.CanAddMembers(requesterID) {
 		return errors.New("only admins can add members")
 	}
 
@@ -77,7 +106,11 @@ func (s *GroupService) AddMember(ctx context.Context, groupID, requesterID, newM
 		return fmt.Errorf("user not found")
 	}
 
-	return s.groupRepo.AddMember(ctx, groupID, newMemberID)
+	if err := s.groupRepo.AddMember(ctx, groupID, newMemberID); err != nil {
+		return err
+	}
+	s.membershipChanged(ctx, groupID, newMemberID, requesterID, true)
+	return nil
 }
 
 func (s *GroupService) AddAdmin(ctx context.Context, groupID, requesterID, newAdminID primitive.ObjectID) error {
@@ -115,12 +148,166 @@ func (s *GroupService) RemoveMember(ctx context.Context, groupID, requesterID, m
 		return errors.New("only admins can remove members")
 	}
 
+	if group.Owner() == memberID {
+		return errors.New("cannot remove the group owner")
+	}
+
 	// Check if trying to remove last admin
 	if containsID(group.Admins, memberID) && len(group.Admins) == 1 {
 		return errors.New("cannot remove the last admin")
 	}
 
-	return s.groupRepo.RemoveMember(ctx, groupID, memberID)
+	if err := s.groupRepo.RemoveMember(ctx, groupID, memberID); err != nil {
+		return err
+	}
+	s.membershipChanged(ctx, groupID, memberID, requesterID, false)
+	return nil
+}
+
+// LeaveGroup removes the requester from a group. The owner has to transfer
+// ownership first and the last admin has to hand admin rights to someone else.
+func (s *GroupService) LeaveGroup(ctx context.Context, groupID, userID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Members, userID) {
+		return errors.New("not a group member")
+	}
+
+	othersRemain := len(group.Members) > 1
+	if group.Owner() == userID && othersRemain {
+		return errors.New("owner must transfer ownership before leaving")
+	}
+
+	isAdmin := containsID(group.Admins, userID)
+	if isAdmin && len(group.Admins) == 1 && othersRemain {
+		return errors.New("last admin must transfer admin rights before leaving")
+	}
+
+	if err := s.groupRepo.RemoveMember(ctx, groupID, userID); err != nil {
+		return err
+	}
+	s.membershipChanged(ctx, groupID, userID, userID, false)
+	return nil
+}
+
+// TransferOwnership hands the group to another member, who also becomes an admin
+func (s *GroupService) TransferOwnership(ctx context.Context, groupID, requesterID, newOwnerID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if group.Owner() != requesterID {
+		return errors.New("only the owner can transfer ownership")
+	}
+	if !containsID(group.Members, newOwnerID) {
+		return errors.New("new owner must be a group member")
+	}
+	if newOwnerID == requesterID {
+		return nil
+	}
+
+	return s.groupRepo.TransferOwnership(ctx, groupID, newOwnerID)
+}
+
+// RemoveAdmin demotes an admin back to a regular member (owner only)
+func (s *GroupService) RemoveAdmin(ctx context.Context, groupID, requesterID, adminID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if group.Owner() != requesterID {
+		return errors.New("only the owner can demote admins")
+	}
+	if adminID == group.Owner() {
+		return errors.New("cannot demote the group owner")
+	}
+	if !containsID(group.Admins, adminID) {
+		return errors.New("user is not an admin")
+	}
+
+	return s.groupRepo.RemoveAdmin(ctx, groupID, adminID)
+}
+
+func (s *GroupService) AddModerator(ctx context.Context, groupID, requesterID, userID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return errors.New("only admins can manage moderators")
+	}
+	if !containsID(group.Members, userID) {
+		return errors.New("user must be a member before becoming a moderator")
+	}
+	if containsID(group.Moderators, userID) {
+		return errors.New("user is already a moderator")
+	}
+
+	return s.groupRepo.AddModerator(ctx, groupID, userID)
+}
+
+func (s *GroupService) RemoveModerator(ctx context.Context, groupID, requesterID, userID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return errors.New("only admins can manage moderators")
+	}
+	if !containsID(group.Moderators, userID) {
+		return errors.New("user is not a moderator")
+	}
+
+	return s.groupRepo.RemoveModerator(ctx, groupID, userID)
+}
+
+// DeleteGroup removes the group entirely (owner only)
+func (s *GroupService) DeleteGroup(ctx context.Context, groupID, requesterID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if group.Owner() != requesterID {
+		return errors.New("only the owner can delete the group")
+	}
+
+	if err := s.groupRepo.DeleteGroup(ctx, groupID); err != nil {
+		return err
+	}
+	s.invalidateMembers(ctx, groupID)
+	return nil
+}
+
+// GetGroupMembers returns one page of member IDs along with the total member count
+func (s *GroupService) GetGroupMembers(ctx context.Context, groupID, requesterID primitive.ObjectID, page, limit int64) ([]primitive.ObjectID, *models.Group, int64, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Members, requesterID) {
+		return nil, nil, 0, errors.New("not a group member")
+	}
+
+	total := int64(len(group.Members))
+	// Compare page counts first; (page-1)*limit overflows for huge pages
+	if page-1 >= (total+limit-1)/limit {
+		return []primitive.ObjectID{}, group, total, nil
+	}
+	start := (page - 1) * limit
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return group.Members[start:end], group, total, nil
 }
 
 func (s *GroupService) UpdateGroup(ctx context.Context, groupID, requesterID primitive.ObjectID, updates map[string]interface{}) error {
@@ -136,8 +323,13 @@ func (s *GroupService) UpdateGroup(ctx context.Context, groupID, requesterID pri
 
 	// Filter allowed fields to update
 	allowedFields := map[string]bool{
-		"name":       true,
-		"updated_at": true,
+		"name":                   true,
+		"description":            true,
+		"avatar":                 true,
+		"require_approval":       true,
+		"settings.add_members":   true,
+		"settings.send_messages": true,
+		"updated_at":             true,
 	}
 
 	filteredUpdates := bson.M{}
@@ -150,8 +342,49 @@ func (s *GroupService) UpdateGroup(ctx context.Context, groupID, requesterID pri
 	if len(filteredUpdates) == 0 {
 		return errors.New("no valid fields to update")
 	}
+	for _, key := range []string{"settings.add_members", "settings.send_messages"} {
+		if value, ok := filteredUpdates[key]; ok {
+			if p, _ := value.(string); !models.IsValidGroupPermission(p) {
+				return errors.New("invalid group settings")
+			}
+		}
+	}
+
+	if err := s.groupRepo.UpdateGroup(ctx, groupID, filteredUpdates); err != nil {
+		return err
+	}
 
-	return s.groupRepo.UpdateGroup(ctx, groupID, filteredUpdates)
+	// Message sends cache the name and announcement mode
+	if err := s.redisClient.Del(ctx, "group:"+groupID.Hex()+":name", "group:"+groupID.Hex()+":send_messages").Err(); err != nil {
+		log.Printf("Failed to invalidate cached settings of group %s: %v", groupID.Hex(), err)
+	}
+	s.publishGroupUpdated(ctx, groupID, requesterID)
+	return nil
+}
+
+// publishGroupUpdated sends the group's current details to its open connections
+func (s *GroupService) publishGroupUpdated(ctx context.Context, groupID, updatedBy primitive.ObjectID) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		log.Printf("Failed to load group %s for update event: %v", groupID.Hex(), err)
+		return
+	}
+	event, err := events.NewGroupUpdated(models.GroupUpdatedEvent{
+		GroupID:         group.ID,
+		UpdatedBy:       updatedBy,
+		Name:            group.Name,
+		Description:     group.Description,
+		Avatar:          group.Avatar,
+		RequireApproval: group.RequireApproval,
+		Settings:        group.Settings.WithDefaults(),
+	})
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", models.EventGroupUpdated, err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, groupID.Hex(), event); err != nil {
+		log.Printf("Failed to publish %s event for group %s: %v", models.EventGroupUpdated, groupID.Hex(), err)
+	}
 }
 
 func (s *GroupService) GetUserGroups(ctx context.Context, userID primitive.ObjectID) ([]*models.Group, error) {
@@ -162,6 +395,352 @@ func (s *GroupService) GetUserGroups(ctx context.Context, userID primitive.Objec
 	return groups, err
 }
 
+// CreateInvite creates a shareable join link for a group. A zero ttl uses the
+// default of seven days and maxUses 0 means unlimited.
+func (s *GroupService) CreateInvite(ctx context.Context, groupID, requesterID primitive.ObjectID, ttl time.Duration, maxUses int) (*models.GroupInvite, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return nil, errors.New("only admins can manage invites")
+	}
+
+	raw := make([]byte, inviteTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultInviteTTL
+	}
+	invite := &models.GroupInvite{
+		GroupID:   groupID,
+		Token:     hex.EncodeToString(raw),
+		CreatedBy: requesterID,
+		MaxUses:   maxUses,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	return s.groupRepo.CreateInvite(ctx, invite)
+}
+
+// ListInvites returns a group's unrevoked invites
+func (s *GroupService) ListInvites(ctx context.Context, groupID, requesterID primitive.ObjectID) ([]models.GroupInvite, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return nil, errors.New("only admins can manage invites")
+	}
+
+	return s.groupRepo.ListInvites(ctx, groupID)
+}
+
+func (s *GroupService) RevokeInvite(ctx context.Context, groupID, requesterID, inviteID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return errors.New("only admins can manage invites")
+	}
+
+	if err := s.groupRepo.RevokeInvite(ctx, groupID, inviteID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errors.New("invite not found")
+		}
+		return err
+	}
+	return nil
+}
+
+// GetInvitePreview shows what group an invite leads to without joining it
+func (s *GroupService) GetInvitePreview(ctx context.Context, token string) (*models.GroupInvitePreview, error) {
+	invite, group, err := s.usableInvite(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.GroupInvitePreview{
+		GroupID:         group.ID,
+		Name:            group.Name,
+		MemberCount:     len(group.Members),
+		RequireApproval: group.RequireApproval,
+		ExpiresAt:       invite.ExpiresAt,
+	}, nil
+}
+
+// JoinWithInvite adds the user to the invite's group, or files a join request
+// when the group requires approval. Either way the invite use is counted.
+func (s *GroupService) JoinWithInvite(ctx context.Context, token string, userID primitive.ObjectID) (*models.JoinGroupResponse, error) {
+	invite, group, err := s.usableInvite(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if containsID(group.Members, userID) {
+		return nil, errors.New("user is already a group member")
+	}
+
+	if group.RequireApproval {
+		if _, err := s.groupRepo.GetPendingJoinRequest(ctx, group.ID, userID); err == nil {
+			return nil, errors.New("join request already pending")
+		}
+	}
+
+	if err := s.groupRepo.UseInvite(ctx, invite.ID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("invite is no longer valid")
+		}
+		return nil, err
+	}
+
+	if group.RequireApproval {
+		request, err := s.groupRepo.CreateJoinRequest(ctx, &models.GroupJoinRequest{
+			GroupID:  group.ID,
+			UserID:   userID,
+			InviteID: invite.ID,
+		})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, errors.New("join request already pending")
+			}
+			return nil, err
+		}
+		return &models.JoinGroupResponse{Status: models.JoinRequestPending, GroupID: group.ID, RequestID: &request.ID}, nil
+	}
+
+	if err := s.groupRepo.AddMember(ctx, group.ID, userID); err != nil {
+		return nil, err
+	}
+	s.membershipChanged(ctx, group.ID, userID, userID, true)
+	return &models.JoinGroupResponse{Status: "joined", GroupID: group.ID}, nil
+}
+
+func (s *GroupService) ListJoinRequests(ctx context.Context, groupID, requesterID primitive.ObjectID) ([]models.GroupJoinRequest, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return nil, errors.New("only admins can review join requests")
+	}
+
+	return s.groupRepo.ListPendingJoinRequests(ctx, groupID)
+}
+
+// ResolveJoinRequest approves or rejects a pending join request. Approval adds
+// the requester to the group.
+func (s *GroupService) ResolveJoinRequest(ctx context.Context, groupID, requesterID, requestID primitive.ObjectID, approve bool) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return errors.New("only admins can review join requests")
+	}
+
+	status := models.JoinRequestRejected
+	if approve {
+		status = models.JoinRequestApproved
+	}
+	request, err := s.groupRepo.ResolveJoinRequest(ctx, groupID, requestID, status)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errors.New("join request not found")
+		}
+		return err
+	}
+
+	if !approve || containsID(group.Members, request.UserID) {
+		return nil
+	}
+	if err := s.groupRepo.AddMember(ctx, groupID, request.UserID); err != nil {
+		return err
+	}
+	s.membershipChanged(ctx, groupID, request.UserID, requesterID, true)
+	return nil
+}
+
+// usableInvite loads an invite and its group, rejecting invites that can no
+// longer be used
+func (s *GroupService) usableInvite(ctx context.Context, token string) (*models.GroupInvite, *models.Group, error) {
+	invite, err := s.groupRepo.GetInviteByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, errors.New("invite not found")
+		}
+		return nil, nil, err
+	}
+	if !invite.Usable(time.Now()) {
+		return nil, nil, errors.New("invite is no longer valid")
+	}
+
+	group, err := s.groupRepo.GetGroup(ctx, invite.GroupID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("group not found")
+	}
+	return invite, group, nil
+}
+
+// PinMessage pins one of the group's messages (admins only)
+func (s *GroupService) PinMessage(ctx context.Context, groupID, requesterID, messageID primitive.ObjectID) (*models.PinnedMessage, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return nil, errors.New("only admins can pin messages")
+	}
+
+	msg, err := s.messageRepo.GetMessageByID(ctx, messageID)
+	if err != nil || msg.IsDeleted {
+		return nil, errors.New("message not found")
+	}
+	if msg.GroupID != groupID {
+		return nil, errors.New("message does not belong to this group")
+	}
+
+	for _, pin := range group.PinnedMessages {
+		if pin.MessageID == messageID {
+			return nil, errors.New("message is already pinned")
+		}
+	}
+	if len(group.PinnedMessages) >= models.MaxPinnedMessages {
+		return nil, errors.New("pinned message limit reached")
+	}
+
+	pin := models.PinnedMessage{MessageID: messageID, PinnedBy: requesterID, PinnedAt: time.Now()}
+	if err := s.groupRepo.PinMessage(ctx, groupID, pin); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Another admin pinned it, or filled the last slot, concurrently
+			return nil, errors.New("pinned message limit reached")
+		}
+		return nil, err
+	}
+
+	s.publishPinEvent(ctx, models.EventMessagePinned, models.MessagePinEvent{
+		GroupID:   groupID,
+		MessageID: messageID,
+		PinnedBy:  requesterID,
+		PinnedAt:  &pin.PinnedAt,
+	})
+	return &pin, nil
+}
+
+// UnpinMessage removes a pin (admins only)
+func (s *GroupService) UnpinMessage(ctx context.Context, groupID, requesterID, messageID primitive.ObjectID) error {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Admins, requesterID) {
+		return errors.New("only admins can pin messages")
+	}
+
+	unpinned, err := s.groupRepo.UnpinMessage(ctx, groupID, messageID)
+	if err != nil {
+		return err
+	}
+	if !unpinned {
+		return errors.New("message is not pinned")
+	}
+
+	s.publishPinEvent(ctx, models.EventMessageUnpinned, models.MessagePinEvent{GroupID: groupID, MessageID: messageID})
+	return nil
+}
+
+// ListPinnedMessages returns the group's pins with their messages, most
+// recently pinned first (members only)
+func (s *GroupService) ListPinnedMessages(ctx context.Context, groupID, requesterID primitive.ObjectID) ([]models.PinnedMessageResponse, error) {
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("group not found")
+	}
+
+	if !containsID(group.Members, requesterID) {
+		return nil, errors.New("not a group member")
+	}
+
+	pins := []models.PinnedMessageResponse{}
+	if len(group.PinnedMessages) == 0 {
+		return pins, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(group.PinnedMessages))
+	for i, pin := range group.PinnedMessages {
+		ids[i] = pin.MessageID
+	}
+	messages, err := s.messageRepo.GetMessagesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+
+	for i := len(group.PinnedMessages) - 1; i >= 0; i-- {
+		pin := group.PinnedMessages[i]
+		msg, ok := byID[pin.MessageID]
+		if !ok || msg.IsDeleted {
+			// Expired by the message TTL, or deleted before the unpin landed
+			continue
+		}
+		pins = append(pins, models.PinnedMessageResponse{PinnedMessage: pin, Message: msg})
+	}
+	return pins, nil
+}
+
+// publishPinEvent tells the group's open connections about a pin change
+func (s *GroupService) publishPinEvent(ctx context.Context, eventType string, pin models.MessagePinEvent) {
+	event, err := events.New(eventType, pin)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", eventType, err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, pin.GroupID.Hex(), event); err != nil {
+		log.Printf("Failed to publish %s event for group %s: %v", eventType, pin.GroupID.Hex(), err)
+	}
+}
+
+// membershipChanged drops the cached member set and tells the group's open
+// connections, which also makes the WebSocket hub start or stop routing the
+// group's messages to the user's connections
+func (s *GroupService) membershipChanged(ctx context.Context, groupID, userID, actorID primitive.ObjectID, joined bool) {
+	s.invalidateMembers(ctx, groupID)
+
+	change := models.GroupMembershipEvent{GroupID: groupID, UserID: userID, ActorID: actorID}
+	newEvent := events.NewGroupMemberRemoved
+	if joined {
+		newEvent = events.NewGroupMemberAdded
+	}
+	event, err := newEvent(change)
+	if err != nil {
+		log.Printf("Failed to marshal membership event: %v", err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, groupID.Hex(), event); err != nil {
+		log.Printf("Failed to publish membership event for group %s: %v", groupID.Hex(), err)
+	}
+}
+
+// invalidateMembers drops the cached member set so removed members can't keep
+// posting until the TTL runs out
+func (s *GroupService) invalidateMembers(ctx context.Context, groupID primitive.ObjectID) {
+	if err := appredis.InvalidateGroupMembers(ctx, s.redisClient, groupID.Hex()); err != nil {
+		log.Printf("Failed to invalidate members of group %s: %v", groupID.Hex(), err)
+	}
+}
+
 func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
 	for _, i := range ids {
 		if i == id {
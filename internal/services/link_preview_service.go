@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"messaging-app/internal/kafka"
+	"messaging-app/internal/linkpreview"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LinkPreviewQueue hands preview jobs to the background worker so sending a
+// message never waits on a third-party site; implemented by the Kafka producer
+type LinkPreviewQueue interface {
+	QueueLinkPreview(ctx context.Context, job models.LinkPreviewJob) error
+}
+
+// LinkPreviewService is the worker side of link previews: it fetches or
+// reuses the preview of a queued link and attaches it to the message
+type LinkPreviewService struct {
+	previewRepo *repositories.LinkPreviewRepository
+	messageRepo *repositories.MessageRepository
+	fetcher     *linkpreview.Fetcher
+	producer    *kafka.MessageProducer
+	ttl         time.Duration
+}
+
+func NewLinkPreviewService(
+	previewRepo *repositories.LinkPreviewRepository,
+	messageRepo *repositories.MessageRepository,
+	fetcher *linkpreview.Fetcher,
+	producer *kafka.MessageProducer,
+	ttl time.Duration,
+) *LinkPreviewService {
+	return &LinkPreviewService{
+		previewRepo: previewRepo,
+		messageRepo: messageRepo,
+		fetcher:     fetcher,
+		producer:    producer,
+		ttl:         ttl,
+	}
+}
+
+// GeneratePreview handles one job. Links that can't be previewed are dropped;
+// only storage errors are returned, so the consumer retries just those.
+func (s *LinkPreviewService) GeneratePreview(ctx context.Context, job models.LinkPreviewJob) error {
+	link, err := linkpreview.Normalize(job.URL)
+	if err != nil {
+		log.Printf("Skipping link preview for message %s: %v", job.MessageID.Hex(), err)
+		return nil
+	}
+
+	preview, err := s.previewRepo.GetPreview(ctx, link)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	// The TTL monitor runs about once a minute, so expired previews can linger
+	if preview == nil || time.Since(preview.FetchedAt) > s.ttl {
+		preview, err = s.fetcher.Fetch(ctx, link)
+		if err != nil {
+			log.Printf("No link preview for %s: %v", link, err)
+			return nil
+		}
+		if err := s.previewRepo.SavePreview(ctx, preview); err != nil {
+			return err
+		}
+	}
+
+	msg, err := s.messageRepo.SetLinkPreview(ctx, job.MessageID, preview)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Deleted while the preview was being fetched
+			return nil
+		}
+		return err
+	}
+
+	s.publishPreviewReady(ctx, msg)
+	return nil
+}
+
+func (s *LinkPreviewService) publishPreviewReady(ctx context.Context, msg *models.Message) {
+	event, err := events.NewPreviewReady(models.PreviewReadyEvent{
+		MessageID:  msg.ID,
+		SenderID:   msg.SenderID,
+		ReceiverID: msg.ReceiverID,
+		GroupID:    msg.GroupID,
+		Preview:    *msg.LinkPreview,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", models.EventPreviewReady, err)
+		return
+	}
+
+	key := msg.ReceiverID.Hex()
+	if !msg.GroupID.IsZero() {
+		key = msg.GroupID.Hex()
+	}
+	if err := s.producer.ProduceEvent(ctx, key, event); err != nil {
+		log.Printf("Failed to publish %s event for message %s: %v", models.EventPreviewReady, msg.ID.Hex(), err)
+	}
+}
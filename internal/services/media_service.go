@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/storage"
+	"messaging-app/pkg/apperrors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnsupportedMediaKind = apperrors.Validation("unsupported media kind")
+	ErrMediaTypeNotAllowed  = apperrors.Validation("media type not allowed")
+	ErrMediaTooLarge        = apperrors.Validation("media exceeds size limit")
+	ErrMediaNotFound        = apperrors.NotFound("media not found")
+	ErrMediaNotOwned        = apperrors.Forbidden("media URLs must reference your own uploads")
+)
+
+type MediaService struct {
+	mediaRepo    *repositories.MediaRepository
+	storage      storage.Storage
+	maxSizes     map[string]int64
+	allowedTypes map[string][]string
+	uploadTTL    time.Duration
+}
+
+func NewMediaService(mediaRepo *repositories.MediaRepository, store storage.Storage, cfg *config.Config) *MediaService {
+	return &MediaService{
+		mediaRepo:    mediaRepo,
+		storage:      store,
+		maxSizes:     cfg.MediaMaxSizes,
+		allowedTypes: cfg.MediaAllowedTypes,
+		uploadTTL:    cfg.MediaUploadURLTTL,
+	}
+}
+
+// Presign validates the declared upload against the per-kind limits, records a
+// pending media object and returns where the client should upload the bytes.
+func (s *MediaService) Presign(ctx context.Context, ownerID primitive.ObjectID, req models.PresignRequest) (*models.PresignResponse, error) {
+	contentType, err := s.checkType(req.Kind, req.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	if req.Size <= 0 || req.Size > s.maxSizes[req.Kind] {
+		return nil, ErrMediaTooLarge
+	}
+
+	mediaID := primitive.NewObjectID()
+	key := ownerID.Hex() + "-" + mediaID.Hex() + extensionFor(contentType)
+	expiresAt := time.Now().Add(s.uploadTTL)
+
+	upload, err := s.storage.PresignUpload(key, contentType, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err := s.mediaRepo.CreateMedia(ctx, &models.Media{
+		ID:          mediaID,
+		OwnerID:     ownerID,
+		Key:         key,
+		Kind:        req.Kind,
+		ContentType: contentType,
+		Size:        req.Size,
+		URL:         s.storage.URL(key),
+		Status:      models.MediaStatusPending,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PresignResponse{
+		MediaID:   media.ID.Hex(),
+		UploadURL: upload.URL,
+		Method:    upload.Method,
+		Fields:    upload.Fields,
+		MediaURL:  media.URL,
+		ExpiresAt: upload.ExpiresAt,
+	}, nil
+}
+
+// CompleteUpload is the upload callback: it checks the signature issued by
+// Presign, enforces the size limit and sniffs the real content type before
+// storing the object.
+func (s *MediaService) CompleteUpload(ctx context.Context, key, expires, signature string, body io.Reader) (*models.Media, error) {
+	verifier, ok := s.storage.(storage.UploadVerifier)
+	if !ok {
+		return nil, errors.New("storage does not accept direct uploads")
+	}
+	if err := verifier.VerifyUpload(key, expires, signature); err != nil {
+		return nil, err
+	}
+
+	media, err := s.mediaRepo.GetMediaByKey(ctx, key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrMediaNotFound
+		}
+		return nil, err
+	}
+	if media.Status == models.MediaStatusUploaded {
+		return media, nil
+	}
+
+	// Read one byte past the limit so oversized bodies are detected
+	limit := s.maxSizes[media.Kind]
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrMediaTooLarge
+	}
+
+	if !s.sniffMatches(media, data) {
+		return nil, ErrMediaTypeNotAllowed
+	}
+
+	if err := s.storage.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err := s.mediaRepo.MarkUploaded(ctx, media.ID, int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	media.Status = models.MediaStatusUploaded
+	media.Size = int64(len(data))
+	return media, nil
+}
+
+// ValidateOwnership checks that every URL references an uploaded media object
+// owned by ownerID.
+func (s *MediaService) ValidateOwnership(ctx context.Context, ownerID primitive.ObjectID, urls []string) error {
+	unique := uniqueStrings(urls)
+	if len(unique) == 0 {
+		return nil
+	}
+
+	count, err := s.mediaRepo.CountOwnedUploads(ctx, ownerID, unique)
+	if err != nil {
+		return err
+	}
+	if count != int64(len(unique)) {
+		return ErrMediaNotOwned
+	}
+	return nil
+}
+
+// DeleteByURLs removes the stored objects and their records
+func (s *MediaService) DeleteByURLs(ctx context.Context, urls []string) error {
+	media, err := s.mediaRepo.GetMediaByURLs(ctx, uniqueStrings(urls))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(media))
+	for _, m := range media {
+		if err := s.storage.Delete(ctx, m.Key); err != nil {
+			log.Printf("Failed to delete media object %s: %v", m.Key, err)
+			continue
+		}
+		ids = append(ids, m.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.mediaRepo.DeleteMediaByIDs(ctx, ids)
+}
+
+func (s *MediaService) checkType(kind, contentType string) (string, error) {
+	allowed, ok := s.allowedTypes[kind]
+	if !ok {
+		return "", ErrUnsupportedMediaKind
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ErrMediaTypeNotAllowed
+	}
+	for _, t := range allowed {
+		if strings.EqualFold(t, mediaType) {
+			return mediaType, nil
+		}
+	}
+	return "", ErrMediaTypeNotAllowed
+}
+
+// sniffMatches compares the declared type with what the bytes look like.
+// Generic files are only checked against the allow-list since many formats
+// sniff as application/octet-stream.
+func (s *MediaService) sniffMatches(media *models.Media, data []byte) bool {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if media.Kind == models.ContentTypeFile {
+		if sniffed == "application/octet-stream" {
+			return true
+		}
+		_, err := s.checkType(media.Kind, sniffed)
+		return err == nil
+	}
+	return strings.EqualFold(sniffed, media.ContentType)
+}
+
+func extensionFor(contentType string) string {
+	exts, err := mime.ExtensionsByType(contentType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
@@ -2,95 +2,390 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"messaging-app/internal/kafka"
+	"messaging-app/internal/linkpreview"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/contentfilter"
+	"messaging-app/pkg/logging"
+	"messaging-app/pkg/utils"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type MessageService struct {
-	messageRepo    *repositories.MessageRepository
+	messageRepo    MessageStore
 	groupRepo      *repositories.GroupRepository
-	friendshipRepo *repositories.FriendshipRepository
+	friendshipRepo FriendshipStore
+	userRepo       UserStore
 	producer       *kafka.MessageProducer
 	redisClient    *redis.ClusterClient
+	mediaService   *MediaService
+	previews       LinkPreviewQueue
+	outbox         *OutboxRelay
+	pollRepo       *repositories.PollRepository
+	starRepo       *repositories.StarRepository
+	archiveRepo    *repositories.ArchiveRepository
+	summaries      *UserSummaryCache
+
+	maxContentLength int // in characters
+	contentFilter    contentfilter.Filter
+	filterPolicy     string
 }
 
 func NewMessageService(
-	messageRepo *repositories.MessageRepository,
+	messageRepo MessageStore,
 	groupRepo *repositories.GroupRepository,
-	friendshipRepo *repositories.FriendshipRepository,
+	friendshipRepo FriendshipStore,
+	userRepo UserStore,
 	producer *kafka.MessageProducer,
 	redisClient *redis.ClusterClient,
+	mediaService *MediaService,
+	previews LinkPreviewQueue,
+	outbox *OutboxRelay,
+	pollRepo *repositories.PollRepository,
+	starRepo *repositories.StarRepository,
+	archiveRepo *repositories.ArchiveRepository,
 ) *MessageService {
 	return &MessageService{
 		messageRepo:    messageRepo,
 		groupRepo:      groupRepo,
 		friendshipRepo: friendshipRepo,
+		userRepo:       userRepo,
 		producer:       producer,
 		redisClient:    redisClient,
+		mediaService:   mediaService,
+		previews:       previews,
+		outbox:         outbox,
+		pollRepo:       pollRepo,
+		starRepo:       starRepo,
+		archiveRepo:    archiveRepo,
+
+		maxContentLength: models.DefaultMaxMessageLength,
+		summaries:        NewUserSummaryCache(userRepo, redisClient),
 	}
 }
 
+// SetUserSummaryCache replaces the service's own summary cache with one
+// shared with other services
+func (s *MessageService) SetUserSummaryCache(cache *UserSummaryCache) {
+	s.summaries = cache
+}
+
+// SetMaxContentLength caps message content at n characters
+func (s *MessageService) SetMaxContentLength(n int) {
+	s.maxContentLength = n
+}
+
+// SetContentFilter screens sent messages with filter, flagging or blocking
+// the ones that match as policy says
+func (s *MessageService) SetContentFilter(filter contentfilter.Filter, policy string) {
+	s.contentFilter = filter
+	s.filterPolicy = policy
+}
+
 func (s *MessageService) SendMessage(ctx context.Context, senderID primitive.ObjectID, req models.MessageRequest) (*models.Message, error) {
+	req = sanitizeMessageRequest(req)
+	if err := validateMessageRequest(req, s.maxContentLength); err != nil {
+		return nil, err
+	}
+	flagged, err := s.screenContent(ctx, senderID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attachments must have been uploaded through the media service by the sender
+	if err := s.mediaService.ValidateOwnership(ctx, senderID, req.MediaURLs); err != nil {
+		return nil, err
+	}
+
 	msg := &models.Message{
 		SenderID:    senderID,
 		Content:     req.Content,
 		ContentType: req.ContentType,
 		MediaURLs:   req.MediaURLs,
+		Flagged:     flagged,
+	}
+
+	if req.ReplyTo != "" {
+		replyToID, err := primitive.ObjectIDFromHex(req.ReplyTo)
+		if err != nil {
+			return nil, apperrors.Validation("invalid reply_to message ID")
+		}
+		msg.ReplyToID = replyToID
 	}
 
 	if req.GroupID != "" {
-		return s.handleGroupMessage(ctx, msg, req.GroupID)
+		return s.handleGroupMessage(ctx, msg, req.GroupID, req.Poll)
 	}
 	return s.handleDirectMessage(ctx, msg, req.ReceiverID)
 }
 
-func (s *MessageService) handleGroupMessage(ctx context.Context, msg *models.Message, groupID string) (*models.Message, error) {
-	gID, err := primitive.ObjectIDFromHex(groupID)
-	if err != nil {
-		return nil, errors.New("invalid group ID")
+// SendMessageIdempotent sends a message at most once per idempotency key, so
+// clients can safely retry. A retry with a key the user already sent with
+// returns the original message and replayed is true. Without a key it is
+// SendMessage.
+func (s *MessageService) SendMessageIdempotent(ctx context.Context, senderID primitive.ObjectID, idempotencyKey string, req models.MessageRequest) (msg *models.Message, replayed bool, err error) {
+	if idempotencyKey == "" {
+		msg, err = s.SendMessage(ctx, senderID, req)
+		return msg, false, err
+	}
+	if len(idempotencyKey) > appredis.MaxIdempotencyKeyLength {
+		return nil, false, apperrors.Validation(fmt.Sprintf("Idempotency-Key must be at most %d characters", appredis.MaxIdempotencyKeyLength))
 	}
 
-	// Check group membership using Redis cache first
-	cacheKey := "group:" + groupID + ":members"
-	members, err := s.redisClient.SMembers(ctx, cacheKey).Result()
-	if err == nil && len(members) > 0 {
-		// Check cache
-		found := false
-		for _, m := range members {
-			if m == msg.SenderID.Hex() {
-				found = true
-				break
-			}
+	key := appredis.IdempotencyKey("messages", senderID.Hex(), idempotencyKey)
+	messageID, claimed, err := appredis.ClaimIdempotencyKey(ctx, s.redisClient, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !claimed {
+		if messageID == "" {
+			return nil, false, apperrors.Conflict("a request with this Idempotency-Key is still in progress")
 		}
-		if !found {
-			return nil, errors.New("not a group member")
+		id, err := primitive.ObjectIDFromHex(messageID)
+		if err != nil {
+			return nil, false, err
+		}
+		// Messages withdrawn in their undo window are gone entirely, later
+		// deletions leave a tombstone; neither is replayed as if it were live
+		original, err := s.messageRepo.GetMessageByID(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && original.IsDeleted) {
+			return nil, false, apperrors.NotFound("the message sent with this Idempotency-Key was deleted")
 		}
-	} else {
-		// Fallback to database
-		group, err := s.groupRepo.GetGroup(ctx, gID)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
+		return original, true, nil
+	}
 
-		isMember := false
-		for _, m := range group.Members {
-			if m == msg.SenderID {
-				isMember = true
-				break
-			}
+	msg, err = s.SendMessage(ctx, senderID, req)
+	if err != nil {
+		if err := appredis.ReleaseIdempotencyKey(ctx, s.redisClient, key); err != nil {
+			logging.FromContext(ctx).Warn("Failed to release idempotency key", "error", err)
+		}
+		return nil, false, err
+	}
+	if err := appredis.CompleteIdempotencyKey(ctx, s.redisClient, key, msg.ID.Hex()); err != nil {
+		// The message is sent; a retry will see the key as in progress until it expires
+		logging.FromContext(ctx).Error("Failed to record idempotency key", "message_id", msg.ID.Hex(), "error", err)
+	}
+	return msg, false, nil
+}
+
+// ForwardMessage copies a message the requester can read into another
+// conversation. The copy credits the original author but not the source
+// conversation, reuses the same media objects and goes through the
+// destination's usual authorization checks.
+func (s *MessageService) ForwardMessage(ctx context.Context, requesterID, messageID primitive.ObjectID, req models.ForwardMessageRequest) (*models.Message, error) {
+	if req.ReceiverID == "" && req.GroupID == "" {
+		return nil, apperrors.Validation("either receiverID or groupID must be provided")
+	}
+	if req.ReceiverID != "" && req.GroupID != "" {
+		return nil, apperrors.Validation("cannot specify both receiverID and groupID")
+	}
+
+	original, err := s.messageRepo.GetMessageByID(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperrors.NotFound("message not found")
+		}
+		return nil, err
+	}
+	if original.IsDeleted {
+		return nil, apperrors.NotFound("message not found")
+	}
+	if err := s.checkCanRead(ctx, requesterID, original); err != nil {
+		return nil, err
+	}
+	if original.ContentType == models.ContentTypePoll {
+		return nil, apperrors.Validation("polls cannot be forwarded")
+	}
+	// Screened again: the list or policy may have changed since the original
+	// was sent, and forwarding must not get around a block
+	flagged, err := s.screenContent(ctx, requesterID, models.MessageRequest{Content: original.Content})
+	if err != nil {
+		return nil, err
+	}
+
+	// Forwarding a forward keeps crediting the original author
+	forwardedFrom := original.ForwardedFrom
+	if forwardedFrom == nil {
+		senderName := models.DeletedUsername
+		if sender, err := s.userRepo.FindUserByID(ctx, original.SenderID); err == nil && sender.AnonymizedAt == nil {
+			senderName = sender.Username
+		}
+		forwardedFrom = &models.ForwardedFrom{SenderName: senderName, SentAt: original.CreatedAt}
+	}
+
+	msg := &models.Message{
+		SenderID:      requesterID,
+		Content:       original.Content,
+		ContentType:   original.ContentType,
+		MediaURLs:     original.MediaURLs,
+		ForwardedFrom: forwardedFrom,
+		Flagged:       original.Flagged || flagged,
+	}
+	if req.GroupID != "" {
+		return s.handleGroupMessage(ctx, msg, req.GroupID, nil)
+	}
+	return s.handleDirectMessage(ctx, msg, req.ReceiverID)
+}
+
+// checkCanRead verifies userID is a participant of the message's conversation
+func (s *MessageService) checkCanRead(ctx context.Context, userID primitive.ObjectID, msg *models.Message) error {
+	// Only the sender knows about a message still in its undo window
+	if msg.Status == models.MessageStatusPendingDispatch && msg.SenderID != userID {
+		return apperrors.NotFound("message not found")
+	}
+	if msg.GroupID.IsZero() {
+		if msg.SenderID != userID && msg.ReceiverID != userID {
+			return apperrors.Forbidden("not a participant of this conversation")
+		}
+		return nil
+	}
+
+	group, err := s.groupRepo.GetGroup(ctx, msg.GroupID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return apperrors.NotFound("message not found")
 		}
+		return err
+	}
+	if group.Role(userID) == "" {
+		return apperrors.Forbidden("not a participant of this conversation")
+	}
+	return nil
+}
 
-		if !isMember {
-			return nil, errors.New("not a group member")
+// sanitizeMessageRequest cleans up the text of a message before it is
+// validated, so content made only of invisible characters counts as empty
+func sanitizeMessageRequest(req models.MessageRequest) models.MessageRequest {
+	req.Content = utils.SanitizeText(req.Content)
+	if req.Poll != nil {
+		poll := *req.Poll
+		poll.Question = utils.SanitizeText(poll.Question)
+		poll.Options = make([]string, len(req.Poll.Options))
+		for i, option := range req.Poll.Options {
+			poll.Options[i] = utils.SanitizeText(option)
 		}
+		req.Poll = &poll
+	}
+	return req
+}
+
+// validateMessageRequest checks the request shape shared by the REST and
+// WebSocket send paths
+func validateMessageRequest(req models.MessageRequest, maxContentLength int) error {
+	if n := utf8.RuneCountInString(req.Content); n > maxContentLength {
+		return apperrors.Validation("message content is too long").WithDetails(map[string]string{
+			"content": fmt.Sprintf("must be at most %d characters, got %d", maxContentLength, n),
+		})
+	}
+	if req.ContentType == models.ContentTypePoll {
+		if err := validatePollRequest(req.Poll); err != nil {
+			return err
+		}
+	} else if req.Poll != nil {
+		return apperrors.Validation("a poll requires content_type poll")
+	} else if req.Content == "" && len(req.MediaURLs) == 0 {
+		return apperrors.Validation("message content or media URLs required")
+	}
+	if err := validateMediaURLs(req.MediaURLs); err != nil {
+		return err
+	}
+	if !models.IsValidContentType(req.ContentType) {
+		return apperrors.Validation("invalid content type")
+	}
+	// Exactly one of receiverID and groupID
+	if req.ReceiverID == "" && req.GroupID == "" {
+		return apperrors.Validation("either receiverID or groupID must be provided")
+	}
+	if req.ReceiverID != "" && req.GroupID != "" {
+		return apperrors.Validation("cannot specify both receiverID and groupID")
+	}
+	if req.Poll != nil && req.GroupID == "" {
+		return apperrors.Validation("polls can only be sent to groups")
+	}
+	return nil
+}
+
+// validateMediaURLs checks the shape of attachment URLs; whether they are
+// the sender's uploads is checked by MediaService.ValidateOwnership
+func validateMediaURLs(urls []string) error {
+	if len(urls) > models.MaxMediaURLs {
+		return apperrors.Validation("too many media URLs").WithDetails(map[string]string{
+			"media_urls": fmt.Sprintf("must be at most %d, got %d", models.MaxMediaURLs, len(urls)),
+		})
+	}
+	for i, raw := range urls {
+		u, err := url.Parse(raw)
+		if len(raw) > models.MaxMediaURLLength || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return apperrors.Validation("invalid media URL").WithDetails(map[string]string{
+				fmt.Sprintf("media_urls[%d]", i): fmt.Sprintf("must be an http or https URL of at most %d bytes", models.MaxMediaURLLength),
+			})
+		}
+	}
+	return nil
+}
+
+func validatePollRequest(poll *models.PollRequest) error {
+	if poll == nil {
+		return apperrors.Validation("poll is required")
+	}
+	question := strings.TrimSpace(poll.Question)
+	if question == "" || utf8.RuneCountInString(question) > models.MaxPollQuestionLength {
+		return apperrors.Validation(fmt.Sprintf("poll question must be 1 to %d characters", models.MaxPollQuestionLength))
+	}
+	if len(poll.Options) < models.MinPollOptions || len(poll.Options) > models.MaxPollOptions {
+		return apperrors.Validation(fmt.Sprintf("a poll needs %d to %d options", models.MinPollOptions, models.MaxPollOptions))
+	}
+	for _, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || utf8.RuneCountInString(option) > models.MaxPollOptionLength {
+			return apperrors.Validation(fmt.Sprintf("poll options must be 1 to %d characters", models.MaxPollOptionLength))
+		}
+	}
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		return apperrors.Validation("closes_at must be in the future")
+	}
+	switch poll.ResultsVisibility {
+	case "", models.PollResultsAlways, models.PollResultsAfterVoting:
+	default:
+		return apperrors.Validation("results_visibility must be always or after_voting")
+	}
+	return nil
+}
+
+func (s *MessageService) handleGroupMessage(ctx context.Context, msg *models.Message, groupID string, pollReq *models.PollRequest) (*models.Message, error) {
+	gID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid group ID")
+	}
+
+	memberIDs, err := s.groupMemberIDs(ctx, gID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(memberIDs, msg.SenderID.Hex()) {
+		return nil, apperrors.Forbidden("not a group member")
+	}
+	if err := s.checkCanPost(ctx, gID, msg.SenderID); err != nil {
+		return nil, err
 	}
 
 	msg.GroupID = gID
@@ -107,33 +402,312 @@ func (s *MessageService) handleGroupMessage(ctx context.Context, msg *models.Mes
 	}
 	msg.GroupName = groupName
 
-	// Get sender info from cache or DB
-	senderName, err := s.redisClient.Get(ctx, "user:"+msg.SenderID.Hex()+":name").Result()
+	senderName, err := s.senderName(ctx, msg.SenderID)
 	if err != nil {
-		// In a real app, you'd fetch from user repo
-		senderName = "Unknown"
+		return nil, err
 	}
 	msg.SenderName = senderName
 
-	// Save to database
-	createdMsg, err := s.messageRepo.CreateMessage(ctx, msg)
+	if msg.Mentions, err = s.resolveMentions(ctx, msg, memberIDs); err != nil {
+		return nil, err
+	}
+
+	if err := s.attachReplyPreview(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	var poll *models.Poll
+	if pollReq != nil {
+		if poll, err = s.createPoll(ctx, msg, pollReq); err != nil {
+			return nil, err
+		}
+	}
+
+	createdMsg, err := s.storeMessage(ctx, msg)
+	if err != nil {
+		if poll != nil {
+			if err := s.pollRepo.DeletePoll(ctx, poll.ID); err != nil {
+				logging.FromContext(ctx).Error("Failed to delete poll of unsent message", "poll_id", poll.ID.Hex(), "error", err)
+			}
+		}
+		return nil, err
+	}
+	if createdMsg.Status != models.MessageStatusPendingDispatch {
+		s.announceMessage(ctx, createdMsg, memberIDs)
+	}
+
+	return createdMsg, nil
+}
+
+// resolveMentions turns the @usernames in a group message into the IDs of the
+// members they name, looking all of them up in one query. The sender and
+// people outside the group are left out.
+func (s *MessageService) resolveMentions(ctx context.Context, msg *models.Message, memberIDs []string) ([]primitive.ObjectID, error) {
+	usernames := utils.ExtractMentions(msg.Content)
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	users, err := s.userRepo.FindUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return nil, err
+	}
+	var mentions []primitive.ObjectID
+	for _, u := range users {
+		if u.ID != msg.SenderID && slices.Contains(memberIDs, u.ID.Hex()) {
+			mentions = append(mentions, u.ID)
+		}
+	}
+	return mentions, nil
+}
+
+// groupMemberIDs returns the group's member IDs, from the Redis cache when it
+// has them and otherwise from the database, repopulating the cache
+func (s *MessageService) groupMemberIDs(ctx context.Context, groupID primitive.ObjectID) ([]string, error) {
+	memberIDs, cached, err := appredis.GetGroupMembers(ctx, s.redisClient, groupID.Hex())
+	if err == nil && cached {
+		return memberIDs, nil
+	}
+
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperrors.NotFound("group not found")
+		}
+		return nil, err
+	}
+	if err := appredis.CacheGroupMembers(ctx, s.redisClient, groupID.Hex(), group.Members); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache group members", "group_id", groupID.Hex(), "error", err)
+	}
+
+	memberIDs = make([]string, len(group.Members))
+	for i, m := range group.Members {
+		memberIDs[i] = m.Hex()
+	}
+	return memberIDs, nil
+}
+
+// checkCanReadConversation is the one guard for reading a conversation's
+// history, counts and search results. Group conversations need membership.
+// Direct conversations are always looked up together with the reader's ID, so
+// they can only be the reader's own.
+func (s *MessageService) checkCanReadConversation(ctx context.Context, readerID primitive.ObjectID, groupID, receiverID string) error {
+	if groupID == "" && receiverID == "" {
+		return apperrors.Validation("must specify either groupID or receiverID")
+	}
+	if groupID != "" && receiverID != "" {
+		return apperrors.Validation("cannot specify both groupID and receiverID")
+	}
+
+	if groupID == "" {
+		if _, err := primitive.ObjectIDFromHex(receiverID); err != nil {
+			return apperrors.Validation("invalid receiver ID")
+		}
+		return nil
+	}
+
+	gID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return apperrors.Validation("invalid group ID")
+	}
+	memberIDs, err := s.groupMemberIDs(ctx, gID)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(memberIDs, readerID.Hex()) {
+		return apperrors.Forbidden("not a participant of this conversation")
+	}
+	return nil
+}
+
+// checkCanPost enforces announcement mode, where only admins may post. The
+// mode is cached; the admin list is only loaded for announcement groups.
+func (s *MessageService) checkCanPost(ctx context.Context, groupID, senderID primitive.ObjectID) error {
+	cacheKey := "group:" + groupID.Hex() + ":send_messages"
+	mode, err := s.redisClient.Get(ctx, cacheKey).Result()
+	if err == nil && mode != models.GroupPermissionAdmins {
+		return nil
+	}
+
+	group, err := s.groupRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return apperrors.NotFound("group not found")
+		}
+		return err
+	}
+	s.redisClient.Set(ctx, cacheKey, group.Settings.WithDefaults().SendMessages, 24*time.Hour)
+
+	if !group.CanSendMessages(senderID) {
+		return apperrors.Forbidden("only admins can send messages in this group")
+	}
+	return nil
+}
+
+// storeMessage saves msg together with the Kafka event that delivers it, then
+// publishes the event. The outbox relay retries events that couldn't be
+// published, so a stored message always reaches its recipients eventually.
+//
+// Senders with an undo-send window get their message held back instead; the
+// dispatcher delivers it once the window has passed.
+func (s *MessageService) storeMessage(ctx context.Context, msg *models.Message) (*models.Message, error) {
+	window, err := s.undoSendWindow(ctx, msg.SenderID)
 	if err != nil {
 		return nil, err
 	}
+	if window > 0 {
+		dispatchAt := time.Now().Add(window)
+		msg.Status = models.MessageStatusPendingDispatch
+		msg.DispatchAt = &dispatchAt
+		createdMsg, err := s.messageRepo.CreateMessage(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		s.invalidateConversations(ctx, msg.SenderID.Hex())
+		return createdMsg, nil
+	}
 
-	// Publish to Kafka
-	if err := s.producer.ProduceMessage(ctx, *createdMsg); err != nil {
-		// Log error but don't fail the operation
-		log.Printf("Failed to produce message to Kafka: %v", err)
+	var createdMsg *models.Message
+	var event *models.OutboxEvent
+	err = s.outbox.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if msg.Seq, err = s.messageRepo.NextSequence(ctx, msg.ConversationKey()); err != nil {
+			return err
+		}
+		createdMsg, err = s.messageRepo.CreateMessage(ctx, msg)
+		if err != nil {
+			return err
+		}
+		event, err = s.outbox.Enqueue(ctx, createdMsg.ConversationKey(), createdMsg)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	s.outbox.Deliver(ctx, event)
 	return createdMsg, nil
 }
 
+// undoSendWindow is how long the sender's messages are held back before
+// delivery; zero when the sender hasn't enabled undo send
+func (s *MessageService) undoSendWindow(ctx context.Context, senderID primitive.ObjectID) (time.Duration, error) {
+	sender, err := s.userRepo.FindUserByID(ctx, senderID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return time.Duration(sender.UndoSendSeconds) * time.Second, nil
+}
+
+// announceMessage updates link previews, unread counters and cached
+// conversation lists once a message has been delivered. memberIDs lists the
+// group's members and is ignored for direct messages.
+func (s *MessageService) announceMessage(ctx context.Context, msg *models.Message, memberIDs []string) {
+	s.queueLinkPreview(ctx, msg)
+	s.unarchiveForMessage(ctx, msg)
+
+	senderID := msg.SenderID.Hex()
+	if !msg.GroupID.IsZero() {
+		recipients := make([]string, 0, len(memberIDs))
+		for _, id := range memberIDs {
+			if id != senderID {
+				recipients = append(recipients, id)
+			}
+		}
+		s.incrementUnread(ctx, msg.GroupID.Hex(), recipients...)
+		if len(msg.Mentions) > 0 {
+			mentioned := make([]string, len(msg.Mentions))
+			for i, id := range msg.Mentions {
+				mentioned[i] = id.Hex()
+			}
+			s.incrementUnread(ctx, mentionField(msg.GroupID.Hex()), mentioned...)
+		}
+		s.invalidateConversations(ctx, memberIDs...)
+		return
+	}
+
+	receiverID := msg.ReceiverID.Hex()
+	// Update last message cache
+	s.redisClient.Set(ctx, "last_msg:"+senderID+":"+receiverID, msg.ID.Hex(), 24*time.Hour)
+	s.incrementUnread(ctx, senderID, receiverID)
+	s.invalidateConversations(ctx, senderID, receiverID)
+}
+
+// DispatchDueMessages delivers held-back messages whose undo window has
+// passed. Each message is claimed atomically together with its outbox event,
+// so several instances can run this and a message cancelled at the last
+// moment is never delivered.
+func (s *MessageService) DispatchDueMessages(ctx context.Context) (int, error) {
+	dispatched := 0
+	for {
+		var msg *models.Message
+		var event *models.OutboxEvent
+		err := s.outbox.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			msg, err = s.messageRepo.ClaimDueMessage(ctx, time.Now())
+			if err != nil {
+				return err
+			}
+			// Numbered on delivery, after whatever was sent during the window
+			if msg.Seq, err = s.messageRepo.NextSequence(ctx, msg.ConversationKey()); err != nil {
+				return err
+			}
+			if err := s.messageRepo.SetSequence(ctx, msg.ID, msg.Seq); err != nil {
+				return err
+			}
+			event, err = s.outbox.Enqueue(ctx, msg.ConversationKey(), msg)
+			return err
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dispatched, nil
+		}
+		if err != nil {
+			return dispatched, err
+		}
+
+		s.outbox.Deliver(ctx, event)
+
+		var memberIDs []string
+		if !msg.GroupID.IsZero() {
+			group, err := s.groupRepo.GetGroup(ctx, msg.GroupID)
+			if err != nil {
+				logging.FromContext(ctx).Error("Failed to load group for dispatched message",
+					"group_id", msg.GroupID.Hex(), "message_id", msg.ID.Hex(), "error", err)
+			} else {
+				for _, m := range group.Members {
+					memberIDs = append(memberIDs, m.Hex())
+				}
+			}
+		}
+		s.announceMessage(ctx, msg, memberIDs)
+		dispatched++
+	}
+}
+
+// RunDispatcher calls DispatchDueMessages every interval until ctx is cancelled
+func (s *MessageService) RunDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DispatchDueMessages(ctx); err != nil {
+				logging.FromContext(ctx).Error("Failed to dispatch held-back messages", "error", err)
+			}
+		}
+	}
+}
+
 func (s *MessageService) handleDirectMessage(ctx context.Context, msg *models.Message, receiverID string) (*models.Message, error) {
 	rID, err := primitive.ObjectIDFromHex(receiverID)
 	if err != nil {
-		return nil, errors.New("invalid receiver ID")
+		return nil, apperrors.Validation("invalid receiver ID")
 	}
 
 	// Check friendship status with cache
@@ -146,7 +720,7 @@ func (s *MessageService) handleDirectMessage(ctx context.Context, msg *models.Me
 			return nil, err
 		}
 		if !areFriendsDB {
-			return nil, errors.New("can only message friends")
+			return nil, apperrors.Forbidden("can only message friends")
 		}
 		// Update cache
 		s.redisClient.Set(ctx, cacheKey, "true", 1*time.Hour)
@@ -154,34 +728,220 @@ func (s *MessageService) handleDirectMessage(ctx context.Context, msg *models.Me
 
 	msg.ReceiverID = rID
 
-	// Get sender info from cache or DB
-	senderName, err := s.redisClient.Get(ctx, "user:"+msg.SenderID.Hex()+":name").Result()
+	senderName, err := s.senderName(ctx, msg.SenderID)
 	if err != nil {
-		// In a real app, you'd fetch from user repo
-		senderName = "Unknown"
+		return nil, err
 	}
 	msg.SenderName = senderName
 
-	// Save to database
-	createdMsg, err := s.messageRepo.CreateMessage(ctx, msg)
+	if err := s.attachReplyPreview(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	createdMsg, err := s.storeMessage(ctx, msg)
 	if err != nil {
 		return nil, err
 	}
+	if createdMsg.Status != models.MessageStatusPendingDispatch {
+		s.announceMessage(ctx, createdMsg, nil)
+	}
 
-	// Publish to Kafka
-	if err := s.producer.ProduceMessage(ctx, *createdMsg); err != nil {
-		// Log error but don't fail the operation
-		log.Printf("Failed to produce message to Kafka: %v", err)
+	return createdMsg, nil
+}
+
+// queueLinkPreview asks the preview worker for a preview of the first link in the message
+func (s *MessageService) queueLinkPreview(ctx context.Context, msg *models.Message) {
+	link := linkpreview.FirstURL(msg.Content)
+	if link == "" {
+		return
+	}
+	if err := s.previews.QueueLinkPreview(ctx, models.LinkPreviewJob{MessageID: msg.ID, URL: link}); err != nil {
+		logging.FromContext(ctx).Warn("Failed to queue link preview", "message_id", msg.ID.Hex(), "error", err)
 	}
+}
 
-	// Update last message cache
-	s.redisClient.Set(ctx, 
-		"last_msg:"+msg.SenderID.Hex()+":"+receiverID, 
-		createdMsg.ID.Hex(), 
-		24*time.Hour,
-	)
+// createPoll stores the poll a group message carries. The message gets its ID
+// here so the poll can point to it; its content is the question.
+func (s *MessageService) createPoll(ctx context.Context, msg *models.Message, req *models.PollRequest) (*models.Poll, error) {
+	msg.ID = primitive.NewObjectID()
+	options := make([]string, len(req.Options))
+	for i, option := range req.Options {
+		options[i] = strings.TrimSpace(option)
+	}
+	visibility := req.ResultsVisibility
+	if visibility == "" {
+		visibility = models.PollResultsAlways
+	}
 
-	return createdMsg, nil
+	poll := &models.Poll{
+		MessageID:         msg.ID,
+		GroupID:           msg.GroupID,
+		CreatorID:         msg.SenderID,
+		Question:          strings.TrimSpace(req.Question),
+		Options:           options,
+		MultiChoice:       req.MultiChoice,
+		ResultsVisibility: visibility,
+		ClosesAt:          req.ClosesAt,
+	}
+	if err := s.pollRepo.CreatePoll(ctx, poll); err != nil {
+		return nil, err
+	}
+	msg.PollID = poll.ID
+	msg.Content = poll.Question
+	return poll, nil
+}
+
+// senderName returns the name messages from senderID carry, so clients can
+// render them without looking the sender up. Login and username changes keep
+// the cache warm; a miss falls back to the user record.
+func (s *MessageService) senderName(ctx context.Context, senderID primitive.ObjectID) (string, error) {
+	name, err := s.redisClient.Get(ctx, appredis.UserNameKey(senderID.Hex())).Result()
+	if err == nil {
+		return name, nil
+	}
+
+	sender, err := s.userRepo.FindUserByID(ctx, senderID)
+	if err != nil {
+		return "", err
+	}
+	if sender.AnonymizedAt != nil {
+		return models.DeletedUsername, nil
+	}
+	if err := appredis.CacheUserName(ctx, s.redisClient, senderID.Hex(), sender.Username); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache username", "user_id", senderID.Hex(), "error", err)
+	}
+	return sender.Username, nil
+}
+
+// attachReplyPreview checks that the message being replied to exists in the
+// same conversation and embeds a short preview of it
+func (s *MessageService) attachReplyPreview(ctx context.Context, msg *models.Message) error {
+	if msg.ReplyToID.IsZero() {
+		return nil
+	}
+
+	original, err := s.messageRepo.GetMessageByID(ctx, msg.ReplyToID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return apperrors.NotFound("reply_to message not found")
+		}
+		return err
+	}
+	// As in checkCanRead, a message in its undo window exists only for its sender
+	if original.IsDeleted || (original.Status == models.MessageStatusPendingDispatch && original.SenderID != msg.SenderID) {
+		return apperrors.NotFound("reply_to message not found")
+	}
+
+	sameConversation := original.GroupID == msg.GroupID
+	if msg.GroupID.IsZero() {
+		sameConversation = original.GroupID.IsZero() &&
+			((original.SenderID == msg.SenderID && original.ReceiverID == msg.ReceiverID) ||
+				(original.SenderID == msg.ReceiverID && original.ReceiverID == msg.SenderID))
+	}
+	if !sameConversation {
+		return apperrors.Validation("reply_to message belongs to another conversation")
+	}
+
+	content := []rune(original.Content)
+	if len(content) > models.ReplyPreviewLength {
+		content = content[:models.ReplyPreviewLength]
+	}
+	msg.ReplyTo = &models.ReplyPreview{
+		MessageID:  original.ID,
+		SenderID:   original.SenderID,
+		SenderName: original.SenderName,
+		Content:    string(content),
+	}
+	return nil
+}
+
+// MarkDelivered records that the devices of recipientIDs received a message
+// and tells the sender's connections about the recipients that are new.
+// Failures are only logged; a missed receipt doesn't affect the message.
+func (s *MessageService) MarkDelivered(ctx context.Context, messageID primitive.ObjectID, recipientIDs []primitive.ObjectID) {
+	log := logging.FromContext(ctx)
+	deliveredAt := time.Now()
+	msg, err := s.messageRepo.MarkDelivered(ctx, messageID, recipientIDs, deliveredAt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	if err != nil {
+		log.Error("Failed to record delivery", "message_id", messageID.Hex(), "error", err)
+		return
+	}
+
+	var delivered []primitive.ObjectID
+	for _, id := range recipientIDs {
+		if id == msg.SenderID || slices.ContainsFunc(msg.DeliveredTo, func(r models.DeliveryReceipt) bool { return r.UserID == id }) {
+			continue
+		}
+		delivered = append(delivered, id)
+	}
+	if len(delivered) == 0 {
+		return
+	}
+
+	event, err := events.NewMessageDelivered(models.MessageDeliveredEvent{
+		MessageID:    msg.ID,
+		SenderID:     msg.SenderID,
+		ReceiverID:   msg.ReceiverID,
+		GroupID:      msg.GroupID,
+		RecipientIDs: delivered,
+		DeliveredAt:  deliveredAt,
+	})
+	if err != nil {
+		log.Error("Failed to marshal event", "type", models.EventMessageDelivered, "error", err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, msg.ConversationKey(), event); err != nil {
+		log.Error("Failed to publish event", "type", models.EventMessageDelivered, "message_id", msg.ID.Hex(), "error", err)
+	}
+}
+
+// GetReceipts returns when each recipient of a message received and saw it.
+// Only the sender may see them.
+func (s *MessageService) GetReceipts(ctx context.Context, requesterID, messageID primitive.ObjectID) (*models.MessageReceiptsResponse, error) {
+	msg, err := s.messageRepo.GetMessageByID(ctx, messageID)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && msg.IsDeleted) {
+		return nil, apperrors.NotFound("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.SenderID != requesterID {
+		return nil, apperrors.Forbidden("only the sender can see a message's receipts")
+	}
+
+	recipients := []primitive.ObjectID{msg.ReceiverID}
+	if !msg.GroupID.IsZero() {
+		memberIDs, err := s.groupMemberIDs(ctx, msg.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		recipients = recipients[:0]
+		for _, id := range memberIDs {
+			if memberID, err := primitive.ObjectIDFromHex(id); err == nil && memberID != msg.SenderID {
+				recipients = append(recipients, memberID)
+			}
+		}
+	}
+
+	receipts := make([]models.MessageReceipt, len(recipients))
+	for i, id := range recipients {
+		receipts[i].UserID = id
+		for _, seen := range msg.SeenBy {
+			if seen.UserID == id {
+				receipts[i].SeenAt = &seen.SeenAt
+				receipts[i].DeliveredAt = &seen.SeenAt
+			}
+		}
+		for _, delivered := range msg.DeliveredTo {
+			if delivered.UserID == id {
+				receipts[i].DeliveredAt = &delivered.DeliveredAt
+			}
+		}
+	}
+	return &models.MessageReceiptsResponse{MessageID: msg.ID, Receipts: receipts}, nil
 }
 
 func (s *MessageService) MarkMessagesAsSeen(ctx context.Context, userID primitive.ObjectID, messageIDs []primitive.ObjectID) error {
@@ -189,47 +949,329 @@ func (s *MessageService) MarkMessagesAsSeen(ctx context.Context, userID primitiv
 		return nil
 	}
 
-	// Update in database
-	err := s.messageRepo.MarkMessagesAsSeen(ctx, userID, messageIDs)
+	// Only messages this user received and hasn't read yet produce receipts;
+	// IDs of conversations the user isn't part of are ignored
+	groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+	if err != nil {
+		return err
+	}
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	for i, g := range groups {
+		groupIDs[i] = g.ID
+	}
+	unseen, err := s.messageRepo.GetUnseenMessages(ctx, userID, groupIDs, messageIDs)
 	if err != nil {
 		return err
 	}
+	if len(unseen) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(unseen))
+	for i, m := range unseen {
+		ids[i] = m.ID
+	}
 
-	// Update unread count in Redis
-	for _, msgID := range messageIDs {
-		s.redisClient.Decr(ctx, "unread:"+userID.Hex()+":"+msgID.Hex())
+	seenAt := time.Now()
+	if err := s.messageRepo.MarkMessagesAsSeen(ctx, userID, groupIDs, ids, seenAt); err != nil {
+		return err
 	}
 
+	// Group receipts per conversation so each sender gets one event, keyed by
+	// the reader's side of the conversation (sender or group ID)
+	receipts := make(map[string]*models.MessagesSeenEvent)
+	mentions := make(map[string]int64)
+	for _, m := range unseen {
+		key, conversationID, isGroup := m.SenderID.Hex(), m.ReceiverID.Hex(), false
+		if !m.GroupID.IsZero() {
+			key, conversationID, isGroup = m.GroupID.Hex(), m.GroupID.Hex(), true
+		}
+
+		ev, ok := receipts[key]
+		if !ok {
+			ev = &models.MessagesSeenEvent{
+				ConversationID: conversationID,
+				IsGroup:        isGroup,
+				ReaderID:       userID,
+				SeenCounts:     make(map[string]int),
+				SeenAt:         seenAt,
+			}
+			receipts[key] = ev
+		}
+		ev.MessageIDs = append(ev.MessageIDs, m.ID)
+		if containsID(m.Mentions, userID) {
+			mentions[key]++
+		}
+		ev.SeenCounts[m.ID.Hex()] = len(m.SeenBy) + 1
+		if !containsID(ev.SenderIDs, m.SenderID) {
+			ev.SenderIDs = append(ev.SenderIDs, m.SenderID)
+		}
+	}
+
+	for key, ev := range receipts {
+		// The reader's unread counter is kept per conversation
+		s.decrementUnread(ctx, userID, key, int64(len(ev.MessageIDs)))
+		if n := mentions[key]; n > 0 {
+			s.decrementUnread(ctx, userID, mentionField(key), n)
+		}
+
+		event, err := events.NewMessagesSeen(*ev)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to marshal seen event", "conversation_id", key, "error", err)
+			continue
+		}
+		if err := s.producer.ProduceEvent(ctx, ev.ConversationID, event); err != nil {
+			logging.FromContext(ctx).Error("Failed to publish seen event", "conversation_id", ev.ConversationID, "error", err)
+		}
+	}
+
+	s.invalidateConversations(ctx, userID.Hex())
+
 	return nil
 }
 
+// Unread counters live in one hash per user, unread:<userID>, with a field per
+// conversation (the other user's ID for direct chats, the group ID otherwise)
+// and one per group with unread mentions of the user, the group ID prefixed
+// with mentionFieldPrefix. The sync marker field tells a complete hash apart from one recreated by a
+// stray HINCRBY after expiry or eviction.
+const (
+	unreadSyncedField  = "_synced"
+	mentionFieldPrefix = "@"
+)
+
+func mentionField(conversationID string) string {
+	return mentionFieldPrefix + conversationID
+}
+
+func unreadKey(userID string) string {
+	return "unread:" + userID
+}
+
+// incrementUnread bumps the counter field of every recipient
+func (s *MessageService) incrementUnread(ctx context.Context, field string, recipientIDs ...string) {
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range recipientIDs {
+			pipe.HIncrBy(ctx, unreadKey(id), field, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to update unread counts", "field", field, "error", err)
+	}
+}
+
+// decrementUnread lowers one of the user's counter fields, never letting it
+// drop below zero.
+func (s *MessageService) decrementUnread(ctx context.Context, userID primitive.ObjectID, field string, n int64) {
+	key := unreadKey(userID.Hex())
+	count, err := s.redisClient.HIncrBy(ctx, key, field, -n).Result()
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to update unread count",
+			"user_id", userID.Hex(), "field", field, "error", err)
+		return
+	}
+	if count <= 0 {
+		s.redisClient.HDel(ctx, key, field)
+	}
+}
+
+// GetConversations returns the user's chat sidebar: direct and group
+// conversations with their last message and unread count. Pages are cached
+// briefly per user and dropped whenever the user's conversations change.
+func (s *MessageService) GetConversations(ctx context.Context, userID primitive.ObjectID, page, limit int64, includeArchived bool) (*models.ConversationListResponse, error) {
+	cacheKey := conversationsCacheKey(userID.Hex())
+	field := fmt.Sprintf("%d:%d:%t", page, limit, includeArchived)
+	if cached, err := s.redisClient.HGet(ctx, cacheKey, field).Bytes(); err == nil {
+		var resp models.ConversationListResponse
+		if err := json.Unmarshal(cached, &resp); err == nil {
+			return &resp, nil
+		}
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	groupsByID := make(map[primitive.ObjectID]*models.Group, len(groups))
+	for i, g := range groups {
+		groupIDs[i] = g.ID
+		groupsByID[g.ID] = g
+	}
+
+	archives, err := s.archiveRepo.GetUserArchives(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	hidden := archives
+	if includeArchived {
+		hidden = nil
+	}
+	conversations, total, err := s.messageRepo.GetConversations(ctx, userID, groupIDs, hidden, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	markArchived(conversations, archives)
+
+	var userIDs []primitive.ObjectID
+	for _, c := range conversations {
+		if !c.IsGroup {
+			userIDs = append(userIDs, c.ID)
+		}
+	}
+	usersByID, err := s.summaries.Get(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range conversations {
+		c := &conversations[i]
+		if c.IsGroup {
+			if g, ok := groupsByID[c.ID]; ok {
+				c.Name = g.Name
+			}
+			continue
+		}
+		u := usersByID[c.ID]
+		c.Name, c.Avatar = u.Username, u.Avatar
+	}
+
+	resp := &models.ConversationListResponse{
+		Conversations: conversations,
+		Total:         total,
+		Page:          page,
+		Limit:         limit,
+		HasMore:       page*limit < total,
+	}
+
+	if data, err := json.Marshal(resp); err == nil {
+		s.redisClient.HSet(ctx, cacheKey, field, data)
+		s.redisClient.Expire(ctx, cacheKey, conversationsCacheTTL)
+	}
+
+	return resp, nil
+}
+
+const conversationsCacheTTL = 30 * time.Second
+
+func conversationsCacheKey(userID string) string {
+	return "conversations:" + userID
+}
+
+// invalidateConversations drops the cached conversation lists of the given users
+func (s *MessageService) invalidateConversations(ctx context.Context, userIDs ...string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	// Keys live in different cluster slots, so delete them one by one
+	for _, id := range userIDs {
+		if err := s.redisClient.Del(ctx, conversationsCacheKey(id)).Err(); err != nil {
+			logging.FromContext(ctx).Warn("Failed to invalidate conversations cache", "user_id", id, "error", err)
+		}
+	}
+}
+
 func (s *MessageService) GetUnreadCount(ctx context.Context, userID primitive.ObjectID) (int64, error) {
-	// Try Redis first
-	count, err := s.redisClient.Get(ctx, "unread:"+userID.Hex()).Int64()
+	counts, err := s.GetUnreadCountsByConversation(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	return total, nil
+}
+
+// GetUnreadCountsByConversation returns the per-conversation unread counts
+func (s *MessageService) GetUnreadCountsByConversation(ctx context.Context, userID primitive.ObjectID) (map[string]int64, error) {
+	counts, err := s.GetUnreadCounts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return counts.Messages, nil
+}
+
+// GetUnreadCounts returns the per-conversation unread message and mention
+// counts, rebuilding the Redis hash from the database when it is missing or
+// partial.
+func (s *MessageService) GetUnreadCounts(ctx context.Context, userID primitive.ObjectID) (*models.UnreadCounts, error) {
+	key := unreadKey(userID.Hex())
+	fields, err := s.redisClient.HGetAll(ctx, key).Result()
 	if err == nil {
-		return count, nil
+		if _, synced := fields[unreadSyncedField]; synced {
+			counts := &models.UnreadCounts{
+				Messages: make(map[string]int64, len(fields)),
+				Mentions: make(map[string]int64),
+			}
+			for field, v := range fields {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if field == unreadSyncedField || err != nil || n <= 0 {
+					continue
+				}
+				if conversationID, ok := strings.CutPrefix(field, mentionFieldPrefix); ok {
+					counts.Mentions[conversationID] = n
+				} else {
+					counts.Messages[field] = n
+				}
+			}
+			return counts, nil
+		}
 	}
 
 	// Fallback to database
-	return s.messageRepo.GetUnreadCount(ctx, userID)
+	groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	for i, g := range groups {
+		groupIDs[i] = g.ID
+	}
+
+	counts, err := s.messageRepo.GetUnreadCounts(ctx, userID, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Repair the hash so the next read is served from Redis
+	values := map[string]interface{}{unreadSyncedField: 1}
+	for conversationID, n := range counts.Messages {
+		values[conversationID] = n
+	}
+	for conversationID, n := range counts.Mentions {
+		values[mentionField(conversationID)] = n
+	}
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, values)
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to repair unread counts", "user_id", userID.Hex(), "error", err)
+	}
+
+	return counts, nil
 }
 
+// GetConversationMessageTotalCount counts the messages GetAllMessages pages
+// through for query, whose SenderID is the reader
 func (s *MessageService) GetConversationMessageTotalCount(
     ctx context.Context,
     query models.MessageQuery,
 ) (int64, error) {
-    // Validate required parameters
-	var isGroup bool = false
-    if query.ConversationID == "" {
-        return 0, errors.New("conversation ID is required")
+    readerID, err := primitive.ObjectIDFromHex(query.SenderID)
+    if err != nil {
+        return 0, apperrors.Validation("invalid user ID")
+    }
+    if err := s.checkCanReadConversation(ctx, readerID, query.GroupID, query.ReceiverID); err != nil {
+        return 0, err
     }
-
-	if query.GroupID != "" {
-		isGroup = true
-	}
 
     // Generate cache key
-    cacheKey := fmt.Sprintf("msg_count:%s:%t", query.ConversationID, isGroup)
+    cacheKey := fmt.Sprintf("msg_count:%s:%s:%s:%s", query.SenderID, query.GroupID, query.ReceiverID, query.ThreadID)
     
     // Try Redis first
     count, err := s.redisClient.Get(ctx, cacheKey).Int64()
@@ -237,14 +1279,8 @@ func (s *MessageService) GetConversationMessageTotalCount(
         return count, nil
     }
 
-    // Convert string ID to ObjectID
-    objID, err := primitive.ObjectIDFromHex(query.ConversationID)
-    if err != nil {
-        return 0, errors.New("invalid conversation ID format")
-    }
-
     // Get count from repository
-    count, err = s.messageRepo.GetConversationMessageCount(ctx, objID, isGroup)
+    count, err = s.messageRepo.CountMessages(ctx, query)
     if err != nil {
         return 0, fmt.Errorf("failed to count messages: %w", err)
     }
@@ -254,12 +1290,79 @@ func (s *MessageService) GetConversationMessageTotalCount(
     return count, nil
 }
 
+// GetAllMessages pages through a conversation's history. query.SenderID is
+// the reader, who must be a participant.
 func (s *MessageService) GetAllMessages(ctx context.Context, query models.MessageQuery) ([]models.Message, error) {
-    return s.messageRepo.GetMessages(ctx, query)
+    readerID, err := primitive.ObjectIDFromHex(query.SenderID)
+    if err != nil {
+        return nil, apperrors.Validation("invalid user ID")
+    }
+    if err := s.checkCanReadConversation(ctx, readerID, query.GroupID, query.ReceiverID); err != nil {
+        return nil, err
+    }
+    messages, err := s.messageRepo.GetMessages(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.markStarred(ctx, readerID, messages); err != nil {
+        return nil, err
+    }
+    return messages, nil
+}
+
+// SearchMessages finds messages of one conversation the user participates in
+// whose content matches the query, with one message of context on each side
+func (s *MessageService) SearchMessages(ctx context.Context, userID primitive.ObjectID, query models.MessageSearchQuery) (*models.MessageSearchResponse, error) {
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		return nil, apperrors.Validation("search query required")
+	}
+	if len([]rune(text)) > models.MaxSearchQueryLength {
+		return nil, apperrors.Validation(fmt.Sprintf("search query must be at most %d characters", models.MaxSearchQueryLength))
+	}
+	if err := s.checkCanReadConversation(ctx, userID, query.GroupID, query.ReceiverID); err != nil {
+		return nil, err
+	}
+
+	// Both parse; the guard checked them
+	var groupID, receiverID primitive.ObjectID
+	if query.GroupID != "" {
+		groupID, _ = primitive.ObjectIDFromHex(query.GroupID)
+	} else {
+		receiverID, _ = primitive.ObjectIDFromHex(query.ReceiverID)
+	}
+
+	// One extra row tells whether there is another page
+	skip := int64((query.Page - 1) * query.Limit)
+	matches, err := s.messageRepo.SearchMessages(ctx, userID, groupID, receiverID, text, skip, int64(query.Limit)+1)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := len(matches) > query.Limit
+	if hasMore {
+		matches = matches[:query.Limit]
+	}
+
+	results := make([]models.MessageSearchResult, len(matches))
+	for i, msg := range matches {
+		before, after, err := s.messageRepo.GetAdjacentMessages(ctx, userID, msg)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = models.MessageSearchResult{Message: msg, Before: before, After: after}
+	}
+
+	return &models.MessageSearchResponse{
+		Results: results,
+		Page:    int64(query.Page),
+		Limit:   int64(query.Limit),
+		HasMore: hasMore,
+	}, nil
 }
 
 // DeleteMessage handles message deletion with these features:
-// 1. Validates message ownership
+// 1. Validates message ownership (group owners, admins and moderators may
+//    delete other members' group messages)
 // 2. Performs soft-delete in database
 // 3. Cleans up media files asynchronously
 // 4. Publishes deletion event to Kafka
@@ -271,43 +1374,86 @@ func (s *MessageService) DeleteMessage(
 ) (*models.Message, error) {
     messageID, err := primitive.ObjectIDFromHex(messageIDStr)
     if err != nil {
-        return nil, errors.New("invalid message ID format")
+        return nil, apperrors.Validation("invalid message ID format")
     }
 
-    // TODO: Media deletion function using storage service
     mediaDeleter := func(ctx context.Context, urls []string) error {
+        // Forwards reference the same media, so keep anything still in use
+        inUse, err := s.messageRepo.MediaURLsInUse(ctx, urls)
+        if err != nil {
+            return err
+        }
+        urls = withoutStrings(urls, inUse)
         if len(urls) == 0 {
             return nil
         }
-        
-        // TODO: In production, will use actual media service:
-        // return s.mediaService.DeleteFiles(ctx, urls)
-        
-        // Mock implementation:
-        log.Printf("Deleting media files: %v", urls)
-        return nil
+        return s.mediaService.DeleteByURLs(ctx, urls)
+    }
+
+    original, err := s.messageRepo.GetMessageByID(ctx, messageID)
+    if err != nil {
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return nil, apperrors.NotFound("message not found")
+        }
+        return nil, err
+    }
+    if original.IsDeleted {
+        return nil, apperrors.NotFound("message not found")
     }
 
-    deletedMsg, err := s.messageRepo.DeleteMessage(ctx, messageID, requesterID, mediaDeleter)
+    // Within the undo window the message is simply withdrawn; nobody else
+    // has seen it. If the dispatcher got there first it is deleted normally.
+    if original.Status == models.MessageStatusPendingDispatch {
+        if original.SenderID != requesterID {
+            return nil, apperrors.NotFound("message not found")
+        }
+        cancelled, err := s.messageRepo.CancelPendingMessage(ctx, messageID, requesterID)
+        if err != nil {
+            return nil, err
+        }
+        if cancelled {
+            s.removeStars(ctx, messageID)
+            s.invalidateConversations(ctx, requesterID.Hex())
+            now := time.Now()
+            tombstone := original.Tombstone()
+            tombstone.DeletedAt = &now
+            return &tombstone, nil
+        }
+    }
+
+    if original.SenderID != requesterID {
+        if original.GroupID.IsZero() {
+            return nil, apperrors.Forbidden("not authorized to delete this message")
+        }
+        group, err := s.groupRepo.GetGroup(ctx, original.GroupID)
+        if err != nil || !group.CanModerateMessages(requesterID) {
+            return nil, apperrors.Forbidden("not authorized to delete this message")
+        }
+    }
+
+    deletedMsg, err := s.messageRepo.DeleteMessage(ctx, messageID, original.SenderID, mediaDeleter)
     if err != nil {
         return nil, err
     }
 
     // Publish deletion event to Kafka
-    if err := s.producer.ProduceMessage(ctx, models.Message{
+    if err := s.producer.ProduceMessage(ctx, deletedMsg.ConversationKey(), models.Message{
         ID:          deletedMsg.ID,
         SenderID:    deletedMsg.SenderID,
         ReceiverID:  deletedMsg.ReceiverID,
         GroupID:     deletedMsg.GroupID,
+        Seq:         deletedMsg.Seq,
         ContentType: models.ContentTypeDeleted,
         DeletedAt:   deletedMsg.DeletedAt,
     }); err != nil {
-        log.Printf("Failed to publish deletion event: %v", err)
+        logging.FromContext(ctx).Error("Failed to publish deletion event", "message_id", deletedMsg.ID.Hex(), "error", err)
     }
+    s.removeStars(ctx, deletedMsg.ID)
 
     if !deletedMsg.GroupID.IsZero() {
         cacheKey := "group_last_msg:" + deletedMsg.GroupID.Hex()
         s.redisClient.Del(ctx, cacheKey)
+        s.unpinDeleted(ctx, deletedMsg.GroupID, deletedMsg.ID)
     } else {
         cacheKey := fmt.Sprintf("last_msg:%s:%s", 
             deletedMsg.SenderID.Hex(),
@@ -316,4 +1462,46 @@ func (s *MessageService) DeleteMessage(
     }
 
     return deletedMsg, nil
-}
\ No newline at end of file
+}
+
+// unpinDeleted removes a deleted message from its group's pins and tells the
+// members' clients
+func (s *MessageService) unpinDeleted(ctx context.Context, groupID, messageID primitive.ObjectID) {
+	unpinned, err := s.groupRepo.UnpinMessage(ctx, groupID, messageID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to unpin deleted message",
+			"group_id", groupID.Hex(), "message_id", messageID.Hex(), "error", err)
+		return
+	}
+	if !unpinned {
+		return
+	}
+
+	event, err := events.NewMessageUnpinned(models.MessagePinEvent{GroupID: groupID, MessageID: messageID})
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to marshal event", "type", models.EventMessageUnpinned, "error", err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, groupID.Hex(), event); err != nil {
+		logging.FromContext(ctx).Error("Failed to publish event",
+			"type", models.EventMessageUnpinned, "group_id", groupID.Hex(), "error", err)
+	}
+}
+
+// withoutStrings returns values minus any in remove
+func withoutStrings(values, remove []string) []string {
+	if len(remove) == 0 {
+		return values
+	}
+	skip := make(map[string]bool, len(remove))
+	for _, r := range remove {
+		skip[r] = true
+	}
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if !skip[v] {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"messaging-app/internal/models"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/contentfilter"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newDirectMessageService(friendships *fakeFriendshipStore) *MessageService {
+	return NewMessageService(nil, nil, friendships, &fakeUserStore{}, nil, unreachableRedis(), nil, nil, nil, nil, nil, nil)
+}
+
+func TestDirectMessageRejectsInvalidReceiver(t *testing.T) {
+	service := newDirectMessageService(&fakeFriendshipStore{friends: true})
+
+	_, err := service.handleDirectMessage(context.Background(), &models.Message{SenderID: primitive.NewObjectID()}, "not-an-id")
+	assert.Equal(t, http.StatusBadRequest, apperrors.Status(err))
+}
+
+func TestDirectMessageRequiresFriendship(t *testing.T) {
+	// Blocking a user ends the friendship, so this also covers blocked senders
+	service := newDirectMessageService(&fakeFriendshipStore{friends: false})
+
+	msg := &models.Message{SenderID: primitive.NewObjectID()}
+	_, err := service.handleDirectMessage(context.Background(), msg, primitive.NewObjectID().Hex())
+	assert.ErrorIs(t, err, apperrors.ErrForbidden)
+	assert.True(t, msg.ReceiverID.IsZero(), "a rejected message must not be addressed")
+}
+
+func TestDirectMessageFailsWhenFriendshipUnknown(t *testing.T) {
+	service := newDirectMessageService(&fakeFriendshipStore{err: errors.New("mongo unavailable")})
+
+	msg := &models.Message{SenderID: primitive.NewObjectID()}
+	_, err := service.handleDirectMessage(context.Background(), msg, primitive.NewObjectID().Hex())
+	assert.EqualError(t, err, "mongo unavailable")
+}
+
+func TestResolveMentionsLooksUpUsernamesOnce(t *testing.T) {
+	sender := models.User{ID: primitive.NewObjectID(), Username: "Sender"}
+	alice := models.User{ID: primitive.NewObjectID(), Username: "Alice"}
+	bob := models.User{ID: primitive.NewObjectID(), Username: "bob"}
+	outsider := models.User{ID: primitive.NewObjectID(), Username: "carol"}
+	users := &fakeUserStore{users: []models.User{sender, alice, bob, outsider}}
+	service := NewMessageService(nil, nil, nil, users, nil, unreachableRedis(), nil, nil, nil, nil, nil, nil)
+
+	msg := &models.Message{
+		SenderID: sender.ID,
+		Content:  "@alice @Bob @carol @nobody and @sender, @alice again",
+	}
+	members := []string{sender.ID.Hex(), alice.ID.Hex(), bob.ID.Hex()}
+	mentions, err := service.resolveMentions(context.Background(), msg, members)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []primitive.ObjectID{alice.ID, bob.ID}, mentions)
+	assert.Equal(t, [][]string{{"alice", "bob", "carol", "nobody", "sender"}}, users.lookups)
+}
+
+func TestResolveMentionsSkipsLookupWithoutMentions(t *testing.T) {
+	users := &fakeUserStore{}
+	service := NewMessageService(nil, nil, nil, users, nil, unreachableRedis(), nil, nil, nil, nil, nil, nil)
+
+	mentions, err := service.resolveMentions(context.Background(), &models.Message{Content: "mail me at a@example.com"}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, mentions)
+	assert.Empty(t, users.lookups)
+}
+
+func TestValidateMessageRequestLimitsContentLength(t *testing.T) {
+	req := models.MessageRequest{
+		ReceiverID:  primitive.NewObjectID().Hex(),
+		ContentType: models.ContentTypeText,
+		Content:     strings.Repeat("\U0001F600", 10), // 10 characters, 40 bytes
+	}
+	assert.NoError(t, validateMessageRequest(req, 10))
+
+	req.Content += "!"
+	err := validateMessageRequest(req, 10)
+	assert.Equal(t, http.StatusBadRequest, apperrors.Status(err))
+	var appErr *apperrors.Error
+	if assert.ErrorAs(t, err, &appErr) {
+		assert.Equal(t, "must be at most 10 characters, got 11", appErr.Details["content"])
+	}
+}
+
+func TestValidateMessageRequestChecksMediaURLs(t *testing.T) {
+	req := models.MessageRequest{
+		ReceiverID:  primitive.NewObjectID().Hex(),
+		ContentType: models.ContentTypeImage,
+	}
+	for i := 0; i < models.MaxMediaURLs; i++ {
+		req.MediaURLs = append(req.MediaURLs, "https://cdn.example.com/media/"+strconv.Itoa(i)+".png")
+	}
+	assert.NoError(t, validateMessageRequest(req, models.DefaultMaxMessageLength))
+
+	tooMany := req
+	tooMany.MediaURLs = append(slices.Clone(req.MediaURLs), "https://cdn.example.com/media/extra.png")
+	assert.EqualError(t, validateMessageRequest(tooMany, models.DefaultMaxMessageLength), "too many media URLs")
+
+	for _, bad := range []string{"javascript:alert(1)", "ftp://cdn.example.com/a.png", "/media/a.png", "https://" + strings.Repeat("a", models.MaxMediaURLLength)} {
+		invalid := req
+		invalid.MediaURLs = []string{"https://cdn.example.com/media/ok.png", bad}
+		err := validateMessageRequest(invalid, models.DefaultMaxMessageLength)
+		var appErr *apperrors.Error
+		if assert.ErrorAs(t, err, &appErr, bad) {
+			assert.Contains(t, appErr.Details, "media_urls[1]", bad)
+		}
+	}
+}
+
+func TestSanitizedMessageWithOnlyInvisibleContentIsEmpty(t *testing.T) {
+	req := sanitizeMessageRequest(models.MessageRequest{
+		ReceiverID:  primitive.NewObjectID().Hex(),
+		ContentType: models.ContentTypeText,
+		Content:     " \u200B\u2060\x00 ",
+	})
+	assert.Empty(t, req.Content)
+	assert.EqualError(t, validateMessageRequest(req, models.DefaultMaxMessageLength), "message content or media URLs required")
+}
+
+func TestSanitizeMessageRequestLeavesCallerPollAlone(t *testing.T) {
+	poll := &models.PollRequest{Question: " Lunch?\u200B ", Options: []string{"pizza\x00", " sushi "}}
+	req := sanitizeMessageRequest(models.MessageRequest{ContentType: models.ContentTypePoll, Poll: poll})
+	assert.Equal(t, "Lunch?", req.Poll.Question)
+	assert.Equal(t, []string{"pizza", "sushi"}, req.Poll.Options)
+	assert.Equal(t, []string{"pizza\x00", " sushi "}, poll.Options)
+}
+
+// fakeContentFilter matches any text containing word and records what it saw
+type fakeContentFilter struct {
+	word    string
+	checked []string
+}
+
+func (f *fakeContentFilter) Check(ctx context.Context, text string) (contentfilter.Verdict, []string) {
+	f.checked = append(f.checked, text)
+	if strings.Contains(text, f.word) {
+		return contentfilter.Matched, []string{f.word}
+	}
+	return contentfilter.Clean, nil
+}
+
+func TestScreenContentFollowsPolicy(t *testing.T) {
+	sender := primitive.NewObjectID()
+	bad := models.MessageRequest{Content: "something badword"}
+	clean := models.MessageRequest{Content: "hello"}
+
+	tests := []struct {
+		policy      string
+		req         models.MessageRequest
+		wantFlagged bool
+		wantStatus  int
+	}{
+		{contentfilter.PolicyFlag, bad, true, 0},
+		{contentfilter.PolicyFlag, clean, false, 0},
+		{contentfilter.PolicyBlock, bad, false, http.StatusUnprocessableEntity},
+		{contentfilter.PolicyBlock, clean, false, 0},
+		{contentfilter.PolicyOff, bad, false, 0},
+	}
+	for _, tt := range tests {
+		filter := &fakeContentFilter{word: "badword"}
+		service := newDirectMessageService(nil)
+		service.SetContentFilter(filter, tt.policy)
+
+		flagged, err := service.screenContent(context.Background(), sender, tt.req)
+		assert.Equal(t, tt.wantFlagged, flagged, "%s %q", tt.policy, tt.req.Content)
+		if tt.wantStatus == 0 {
+			assert.NoError(t, err, "%s %q", tt.policy, tt.req.Content)
+		} else {
+			assert.Equal(t, tt.wantStatus, apperrors.Status(err), "%s %q", tt.policy, tt.req.Content)
+		}
+		if tt.policy == contentfilter.PolicyOff {
+			assert.Empty(t, filter.checked, "the filter runs with the policy off")
+		}
+	}
+}
+
+func TestScreenContentChecksPolls(t *testing.T) {
+	service := newDirectMessageService(nil)
+	service.SetContentFilter(&fakeContentFilter{word: "badword"}, contentfilter.PolicyBlock)
+
+	_, err := service.screenContent(context.Background(), primitive.NewObjectID(), models.MessageRequest{
+		ContentType: models.ContentTypePoll,
+		Poll:        &models.PollRequest{Question: "pick one", Options: []string{"fine", "badword"}},
+	})
+	assert.ErrorIs(t, err, apperrors.ErrUnprocessable)
+}
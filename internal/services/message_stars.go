@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"messaging-app/internal/models"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// StarMessage stars a message the user can read. Starring it again is a no-op.
+func (s *MessageService) StarMessage(ctx context.Context, userID, messageID primitive.ObjectID) error {
+	msg, err := s.messageRepo.GetMessageByID(ctx, messageID)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && msg.IsDeleted) {
+		return apperrors.NotFound("message not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkCanRead(ctx, userID, msg); err != nil {
+		return err
+	}
+	return s.starRepo.Star(ctx, userID, msg)
+}
+
+// UnstarMessage removes the user's star. Like starring, it is idempotent, and
+// works on messages the user can no longer read.
+func (s *MessageService) UnstarMessage(ctx context.Context, userID, messageID primitive.ObjectID) error {
+	return s.starRepo.Unstar(ctx, userID, messageID)
+}
+
+// GetStarredMessages pages through the user's starred messages, most recently
+// starred first, with their senders and conversations. Messages of groups the
+// user has since left are left out.
+func (s *MessageService) GetStarredMessages(ctx context.Context, userID primitive.ObjectID, page, limit int64) (*models.StarredMessageListResponse, error) {
+	stars, err := s.starRepo.ListStars(ctx, userID, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	hasMore := int64(len(stars)) > limit
+	if hasMore {
+		stars = stars[:limit]
+	}
+
+	resp := &models.StarredMessageListResponse{
+		Messages: []models.StarredMessage{},
+		Page:     page,
+		Limit:    limit,
+		HasMore:  hasMore,
+	}
+	if len(stars) == 0 {
+		return resp, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(stars))
+	for i, star := range stars {
+		ids[i] = star.MessageID
+	}
+	messages, err := s.messageRepo.GetMessagesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.Message, len(messages))
+	var userIDs []primitive.ObjectID
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+		userIDs = append(userIDs, msg.SenderID)
+		if msg.GroupID.IsZero() {
+			userIDs = append(userIDs, msg.ReceiverID)
+		}
+	}
+
+	groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	groupsByID := make(map[primitive.ObjectID]*models.Group, len(groups))
+	for _, g := range groups {
+		groupsByID[g.ID] = g
+	}
+	usersByID, err := s.summaries.Get(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, star := range stars {
+		msg, ok := byID[star.MessageID]
+		if !ok || msg.IsDeleted {
+			// Expired by the message TTL
+			continue
+		}
+		starred := models.StarredMessage{
+			Message:   msg,
+			Sender:    usersByID[msg.SenderID],
+			StarredAt: star.CreatedAt,
+		}
+		starred.Message.Starred = true
+		if msg.GroupID.IsZero() {
+			other := msg.ReceiverID
+			if other == userID {
+				other = msg.SenderID
+			}
+			u := usersByID[other]
+			starred.Conversation = models.StarredConversation{ID: other, Name: u.Username, Avatar: u.Avatar}
+		} else {
+			g, ok := groupsByID[msg.GroupID]
+			if !ok {
+				continue
+			}
+			starred.Conversation = models.StarredConversation{ID: g.ID, IsGroup: true, Name: g.Name, Avatar: g.Avatar}
+		}
+		resp.Messages = append(resp.Messages, starred)
+	}
+	return resp, nil
+}
+
+// markStarred flags the messages the reader has starred, with one lookup for
+// the whole page
+func (s *MessageService) markStarred(ctx context.Context, readerID primitive.ObjectID, messages []models.Message) error {
+	ids := make([]primitive.ObjectID, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	starred, err := s.starRepo.StarredAmong(ctx, readerID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Starred = starred[messages[i].ID]
+	}
+	return nil
+}
+
+// removeStars drops every star of a deleted message. A failure leaves stars
+// that GetStarredMessages already skips, so it is only logged.
+func (s *MessageService) removeStars(ctx context.Context, messageID primitive.ObjectID) {
+	if err := s.starRepo.DeleteMessageStars(ctx, messageID); err != nil {
+		logging.FromContext(ctx).Warn("Failed to remove stars of deleted message", "message_id", messageID.Hex(), "error", err)
+	}
+}
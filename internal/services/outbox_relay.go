@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/pkg/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	outboxLeaderKey = "outbox:relay:leader"
+	outboxBatchSize = 100
+	// Events are published right after they are stored; the relay leaves them
+	// alone this long so it doesn't publish them a second time
+	outboxDeliveryGrace = 10 * time.Second
+	outboxMinBackoff    = time.Second
+	outboxMaxBackoff    = 5 * time.Minute
+)
+
+var (
+	outboxPublished = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Total number of outbox events published to Kafka",
+	})
+	outboxPublishFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "outbox_publish_failures_total",
+		Help: "Total number of failed attempts to publish outbox events",
+	})
+	outboxPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_pending_events",
+		Help: "Number of outbox events not yet published",
+	})
+	outboxLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_lag_seconds",
+		Help: "Age of the oldest outbox event not yet published",
+	})
+	outboxMetricsOnce sync.Once
+)
+
+// Renews the leader lock only while this instance still holds it
+var renewLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseLeaderScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// OutboxPublisher writes outbox records to Kafka. Publish must only return
+// once the broker has the record.
+type OutboxPublisher interface {
+	Publish(ctx context.Context, key string, value []byte) error
+}
+
+// OutboxRelay stores Kafka events with the writes they announce and makes
+// sure they are published eventually, even across broker outages
+type OutboxRelay struct {
+	repo        *repositories.OutboxRepository
+	publisher   OutboxPublisher
+	redisClient *redis.ClusterClient
+	instanceID  string
+}
+
+func NewOutboxRelay(repo *repositories.OutboxRepository, publisher OutboxPublisher, redisClient *redis.ClusterClient) *OutboxRelay {
+	outboxMetricsOnce.Do(func() {
+		prometheus.MustRegister(outboxPublished, outboxPublishFailures, outboxPending, outboxLag)
+	})
+	return &OutboxRelay{
+		repo:        repo,
+		publisher:   publisher,
+		redisClient: redisClient,
+		instanceID:  primitive.NewObjectID().Hex(),
+	}
+}
+
+// WithTransaction runs fn so that the events it enqueues are stored if and
+// only if its other writes are
+func (r *OutboxRelay) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.repo.WithTransaction(ctx, fn)
+}
+
+// Enqueue stores value as a pending event keyed by key. Call Deliver once the
+// surrounding transaction has committed.
+func (r *OutboxRelay) Enqueue(ctx context.Context, key string, value interface{}) (*models.OutboxEvent, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	event := &models.OutboxEvent{
+		Key:           key,
+		Value:         data,
+		RequestID:     logging.RequestID(ctx),
+		NextAttemptAt: time.Now().Add(outboxDeliveryGrace),
+	}
+	if err := r.repo.Add(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Deliver publishes a freshly enqueued event. If that fails the relay
+// retries it later, so the error is only logged.
+func (r *OutboxRelay) Deliver(ctx context.Context, event *models.OutboxEvent) {
+	if err := r.publisher.Publish(ctx, event.Key, event.Value); err != nil {
+		outboxPublishFailures.Inc()
+		logging.FromContext(ctx).Warn("Failed to publish outbox event, leaving it to the relay", "event_id", event.ID.Hex(), "error", err)
+		return
+	}
+	outboxPublished.Inc()
+	if err := r.repo.MarkSent(ctx, event.ID, time.Now()); err != nil {
+		logging.FromContext(ctx).Error("Failed to mark outbox event as sent", "event_id", event.ID.Hex(), "error", err)
+	}
+}
+
+// Run relays due events every interval until ctx is done. Every instance
+// runs it, but only the one holding the Redis leader lock relays.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer r.releaseLeadership()
+
+	for {
+		if r.holdLeadership(ctx, 3*interval) {
+			if err := r.RelayPending(ctx); err != nil {
+				log.Printf("Outbox relay stopped early: %v", err)
+			}
+			r.recordBacklog(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes due events oldest first. It stops at the first
+// failure so events sharing a key stay in order.
+func (r *OutboxRelay) RelayPending(ctx context.Context) error {
+	for {
+		events, err := r.repo.ListDue(ctx, time.Now(), outboxBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			// The record keeps the ID of the request that stored it
+			if err := r.publisher.Publish(logging.WithRequestID(ctx, event.RequestID), event.Key, event.Value); err != nil {
+				outboxPublishFailures.Inc()
+				next := time.Now().Add(outboxBackoff(event.Attempts + 1))
+				if markErr := r.repo.MarkFailed(ctx, event.ID, next, err.Error()); markErr != nil {
+					log.Printf("Failed to record outbox failure for %s: %v", event.ID.Hex(), markErr)
+				}
+				return err
+			}
+			outboxPublished.Inc()
+			if err := r.repo.MarkSent(ctx, event.ID, time.Now()); err != nil {
+				return err
+			}
+		}
+		if len(events) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// outboxBackoff doubles the wait after each failed attempt, up to a limit
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return backoff
+}
+
+func (r *OutboxRelay) recordBacklog(ctx context.Context) {
+	pending, oldest, err := r.repo.Backlog(ctx)
+	if err != nil {
+		log.Printf("Failed to measure outbox backlog: %v", err)
+		return
+	}
+	outboxPending.Set(float64(pending))
+	if oldest.IsZero() {
+		outboxLag.Set(0)
+	} else {
+		outboxLag.Set(time.Since(oldest).Seconds())
+	}
+}
+
+// holdLeadership takes or renews the relay lock. A crashed leader's lock
+// expires after ttl and another instance takes over.
+func (r *OutboxRelay) holdLeadership(ctx context.Context, ttl time.Duration) bool {
+	acquired, err := r.redisClient.SetNX(ctx, outboxLeaderKey, r.instanceID, ttl).Result()
+	if err != nil {
+		log.Printf("Failed to acquire outbox relay lock: %v", err)
+		return false
+	}
+	if acquired {
+		return true
+	}
+	renewed, err := renewLeaderScript.Run(ctx, r.redisClient, []string{outboxLeaderKey}, r.instanceID, ttl.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("Failed to renew outbox relay lock: %v", err)
+		return false
+	}
+	return renewed == 1
+}
+
+// releaseLeadership lets another instance take over without waiting for the
+// lock to expire
+func (r *OutboxRelay) releaseLeadership() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := releaseLeaderScript.Run(ctx, r.redisClient, []string{outboxLeaderKey}, r.instanceID).Err(); err != nil {
+		log.Printf("Failed to release outbox relay lock: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"messaging-app/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetTTL is how long a password reset link stays valid
+const PasswordResetTTL = time.Hour
+
+// Passwords must be at least this long; bcrypt ignores anything past 72 bytes
+const (
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrWeakPassword      = fmt.Errorf("password must be %d to %d characters and contain a letter and a digit", MinPasswordLength, MaxPasswordBytes)
+)
+
+// RequestPasswordReset emails a reset link to the account with this address.
+// It reports success whether or not such an account exists, so callers can't
+// use it to find out which addresses are registered. Earlier links stop
+// working.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindUserByEmail(ctx, strings.TrimSpace(email))
+	if err != nil || user.AnonymizedAt != nil {
+		return nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	hash := hashVerificationToken(token)
+	userID := user.ID.Hex()
+
+	// Only the hash is stored, as with email verification tokens
+	previous, err := s.redisClient.GetSet(ctx, passwordResetUserKey(userID), hash).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if previous != "" {
+		if err := s.redisClient.Del(ctx, passwordResetKey(previous)).Err(); err != nil {
+			return err
+		}
+	}
+	if err := s.redisClient.Expire(ctx, passwordResetUserKey(userID), PasswordResetTTL).Err(); err != nil {
+		return err
+	}
+	if err := s.redisClient.Set(ctx, passwordResetKey(hash), userID, PasswordResetTTL).Err(); err != nil {
+		return err
+	}
+
+	return s.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. Choose a new password by opening this link within an hour:\n\n%s?token=%s\n\nIf this wasn't you, ignore this email; your password stays the same.\n",
+			user.Username, s.cfg.PasswordResetURL, token),
+	})
+}
+
+// ResetPassword consumes a reset token and sets a new password. Every
+// session of the account ends, as with a password change.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	// Checked first so a rejected password doesn't use up the token
+	if err := validatePasswordStrength(newPassword); err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+
+	userIDHex, err := s.redisClient.GetDel(ctx, passwordResetKey(hashVerificationToken(token))).Result()
+	if err == redis.Nil {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil || user.AnonymizedAt != nil {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if _, err := s.userRepo.UpdateUser(ctx, userID, bson.M{"password": string(hashedPassword)}); err != nil {
+		return err
+	}
+	if err := s.redisClient.Del(ctx, passwordResetUserKey(userIDHex)).Err(); err != nil {
+		return err
+	}
+	if err := revokeAllSessions(ctx, s.userRepo, s.redisClient, userID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, userID, models.AuditPasswordReset)
+
+	return s.emails.QueueEmail(ctx, models.EmailMessage{
+		To:      user.Email,
+		Subject: "Your password was reset",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was reset at %s and every session was signed out.\n\nIf this wasn't you, reset your password again right away.\n",
+			user.Username, time.Now().UTC().Format(time.RFC1123)),
+	})
+}
+
+func validatePasswordStrength(password string) error {
+	if len([]rune(password)) < MinPasswordLength || len(password) > MaxPasswordBytes {
+		return ErrWeakPassword
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+func passwordResetKey(hash string) string {
+	return "password_reset:" + hash
+}
+
+// passwordResetUserKey points at the user's current token hash
+func passwordResetUserKey(userID string) string {
+	return "password_reset:user:" + userID
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"messaging-app/internal/kafka"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PollService handles voting on polls sent to groups. Polls are created along
+// with their message by MessageService.
+type PollService struct {
+	pollRepo    *repositories.PollRepository
+	groupRepo   *repositories.GroupRepository
+	messageRepo *repositories.MessageRepository
+	producer    *kafka.MessageProducer
+}
+
+func NewPollService(pollRepo *repositories.PollRepository, groupRepo *repositories.GroupRepository, messageRepo *repositories.MessageRepository, producer *kafka.MessageProducer) *PollService {
+	return &PollService{
+		pollRepo:    pollRepo,
+		groupRepo:   groupRepo,
+		messageRepo: messageRepo,
+		producer:    producer,
+	}
+}
+
+// GetResults returns the poll as viewerID sees it. Polls showing results
+// after voting hide the counts until the viewer votes or the poll closes.
+func (s *PollService) GetResults(ctx context.Context, viewerID, pollID primitive.ObjectID) (*models.PollResults, error) {
+	poll, err := s.visiblePoll(ctx, viewerID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	vote, err := s.pollRepo.GetVote(ctx, pollID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	counts, voters, err := s.pollRepo.CountVotes(ctx, pollID, len(poll.Options))
+	if err != nil {
+		return nil, err
+	}
+
+	results := &models.PollResults{
+		Poll:        *poll,
+		Closed:      poll.IsClosed(time.Now()),
+		TotalVoters: voters,
+		Voted:       vote != nil,
+	}
+	if vote != nil {
+		results.MyOptions = vote.Options
+	}
+	if results.Voted || results.Closed || poll.ResultsVisibility != models.PollResultsAfterVoting {
+		results.Counts = counts
+	}
+	return results, nil
+}
+
+// Vote records userID's choices, replacing any earlier vote
+func (s *PollService) Vote(ctx context.Context, userID, pollID primitive.ObjectID, choices []int) (*models.PollResults, error) {
+	poll, err := s.visiblePoll(ctx, userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.IsClosed(time.Now()) {
+		return nil, apperrors.Conflict("poll is closed")
+	}
+	if err := validateChoices(poll, choices); err != nil {
+		return nil, err
+	}
+
+	if err := s.pollRepo.Vote(ctx, pollID, userID, choices); err != nil {
+		return nil, err
+	}
+	s.publishPollEvent(ctx, models.EventPollVoted, poll)
+	return s.GetResults(ctx, userID, pollID)
+}
+
+// RetractVote removes userID's vote from an open poll
+func (s *PollService) RetractVote(ctx context.Context, userID, pollID primitive.ObjectID) error {
+	poll, err := s.visiblePoll(ctx, userID, pollID)
+	if err != nil {
+		return err
+	}
+	if poll.IsClosed(time.Now()) {
+		return apperrors.Conflict("poll is closed")
+	}
+
+	removed, err := s.pollRepo.RetractVote(ctx, pollID, userID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return apperrors.NotFound("you have not voted in this poll")
+	}
+	s.publishPollEvent(ctx, models.EventPollVoted, poll)
+	return nil
+}
+
+// ClosePoll stops a poll from taking votes (creator only)
+func (s *PollService) ClosePoll(ctx context.Context, userID, pollID primitive.ObjectID) (*models.PollResults, error) {
+	poll, err := s.visiblePoll(ctx, userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.CreatorID != userID {
+		return nil, apperrors.Forbidden("only the poll's creator can close it")
+	}
+
+	now := time.Now()
+	closed, err := s.pollRepo.ClosePoll(ctx, pollID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !closed {
+		return nil, apperrors.Conflict("poll is closed")
+	}
+	poll.ClosedAt = &now
+	s.publishPollEvent(ctx, models.EventPollClosed, poll)
+	return s.GetResults(ctx, userID, pollID)
+}
+
+// visiblePoll loads a poll whose message userID can see in the group
+func (s *PollService) visiblePoll(ctx context.Context, userID, pollID primitive.ObjectID) (*models.Poll, error) {
+	poll, err := s.pollRepo.GetPoll(ctx, pollID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("poll not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupRepo.GetGroup(ctx, poll.GroupID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("poll not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if group.Role(userID) == "" {
+		return nil, apperrors.Forbidden("not a group member")
+	}
+
+	msg, err := s.messageRepo.GetMessageByID(ctx, poll.MessageID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("poll not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.IsDeleted || (msg.Status == models.MessageStatusPendingDispatch && msg.SenderID != userID) {
+		return nil, apperrors.NotFound("poll not found")
+	}
+	return poll, nil
+}
+
+func validateChoices(poll *models.Poll, choices []int) error {
+	if len(choices) == 0 {
+		return apperrors.Validation("pick at least one option")
+	}
+	if !poll.MultiChoice && len(choices) != 1 {
+		return apperrors.Validation("this poll takes a single option")
+	}
+	seen := make(map[int]bool, len(choices))
+	for _, choice := range choices {
+		if choice < 0 || choice >= len(poll.Options) {
+			return apperrors.Validation("invalid poll option")
+		}
+		if seen[choice] {
+			return apperrors.Validation("duplicate poll option")
+		}
+		seen[choice] = true
+	}
+	return nil
+}
+
+// publishPollEvent tells the group's open connections about new totals. The
+// counts stay out of the event while the poll hides them until voting.
+func (s *PollService) publishPollEvent(ctx context.Context, eventType string, poll *models.Poll) {
+	log := logging.FromContext(ctx)
+	counts, voters, err := s.pollRepo.CountVotes(ctx, poll.ID, len(poll.Options))
+	if err != nil {
+		log.Error("Failed to count poll votes", "poll_id", poll.ID.Hex(), "error", err)
+		return
+	}
+
+	closed := poll.IsClosed(time.Now())
+	pollEvent := models.PollEvent{
+		PollID:      poll.ID,
+		MessageID:   poll.MessageID,
+		GroupID:     poll.GroupID,
+		TotalVoters: voters,
+		Closed:      closed,
+	}
+	if closed || poll.ResultsVisibility != models.PollResultsAfterVoting {
+		pollEvent.Counts = counts
+	}
+
+	event, err := events.New(eventType, pollEvent)
+	if err != nil {
+		log.Error("Failed to marshal poll event", "type", eventType, "error", err)
+		return
+	}
+	if err := s.producer.ProduceEvent(ctx, poll.GroupID.Hex(), event); err != nil {
+		log.Error("Failed to publish poll event", "type", eventType, "group_id", poll.GroupID.Hex(), "error", err)
+	}
+}
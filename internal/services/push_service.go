@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/push"
+	"messaging-app/internal/repositories"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pushPreviewLength caps how much of a message a notification shows
+const pushPreviewLength = 100
+
+// pushSentTTL is how long the devices a job reached are remembered, which
+// covers the consumer's retries and a redelivery after a restart
+const pushSentTTL = time.Hour
+
+var (
+	pushDeliveryLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "push_delivery_latency_seconds",
+		Help:    "Time from queueing a push notification to the first device accepting it",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	pushMetricsOnce sync.Once
+)
+
+// PushQueue hands push jobs to the background worker so the hub never waits
+// on the push provider; implemented by the Kafka producer
+type PushQueue interface {
+	QueuePush(ctx context.Context, job models.PushJob) error
+}
+
+// DeliveryRecorder records that recipients' devices received a message;
+// implemented by MessageService
+type DeliveryRecorder interface {
+	MarkDelivered(ctx context.Context, messageID primitive.ObjectID, recipientIDs []primitive.ObjectID)
+}
+
+// PushService manages device tokens and notifies users who were offline when
+// a message arrived
+type PushService struct {
+	deviceRepo  *repositories.DeviceRepository
+	queue       PushQueue
+	sender      push.Sender
+	redisClient *redis.ClusterClient
+	deliveries  DeliveryRecorder // optional
+}
+
+func NewPushService(deviceRepo *repositories.DeviceRepository, queue PushQueue, sender push.Sender, redisClient *redis.ClusterClient, deliveries DeliveryRecorder) *PushService {
+	pushMetricsOnce.Do(func() {
+		prometheus.MustRegister(pushDeliveryLatency)
+	})
+	return &PushService{
+		deviceRepo:  deviceRepo,
+		queue:       queue,
+		sender:      sender,
+		redisClient: redisClient,
+		deliveries:  deliveries,
+	}
+}
+
+// RegisterDevice lets userID receive push notifications on a device
+func (s *PushService) RegisterDevice(ctx context.Context, userID primitive.ObjectID, req models.DeviceRequest) (*models.Device, error) {
+	return s.deviceRepo.RegisterDevice(ctx, userID, req.Platform, req.Token)
+}
+
+// UnregisterDevice stops push notifications to a device, e.g. on logout
+func (s *PushService) UnregisterDevice(ctx context.Context, userID primitive.ObjectID, token string) error {
+	removed, err := s.deviceRepo.UnregisterDevice(ctx, userID, token)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return apperrors.NotFound("device not found")
+	}
+	return nil
+}
+
+// NotifyOffline queues a notification of msg for each of userIDs. Queueing
+// failures are logged; the message still waits in the pending set.
+func (s *PushService) NotifyOffline(ctx context.Context, msg models.Message, userIDs []string) {
+	title, body := msg.SenderName, pushPreview(msg)
+	// One notification per conversation is enough on the lock screen
+	collapseKey := msg.SenderID.Hex()
+	if !msg.GroupID.IsZero() {
+		title = msg.GroupName
+		body = msg.SenderName + ": " + body
+		collapseKey = msg.GroupID.Hex()
+	}
+
+	for _, id := range userIDs {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		job := models.PushJob{
+			UserID:      userID,
+			MessageID:   msg.ID,
+			CollapseKey: collapseKey,
+			Title:       title,
+			Body:        body,
+			QueuedAt:    time.Now(),
+		}
+		if err := s.queue.QueuePush(ctx, job); err != nil {
+			log.Printf("Failed to queue push for %s: %v", id, err)
+		}
+	}
+}
+
+// DeliverPush sends a queued notification to every device of its user.
+// Tokens the provider rejects for good are removed; other failures are
+// returned so the consumer retries. Devices that already accepted the job
+// are remembered in Redis and skipped when it is handled again. The message
+// counts as delivered once any device accepts it.
+func (s *PushService) DeliverPush(ctx context.Context, job models.PushJob) error {
+	devices, err := s.deviceRepo.GetUserDevices(ctx, job.UserID)
+	if err != nil {
+		return err
+	}
+	sent := s.sentTokens(ctx, job)
+
+	var errs []error
+	accepted := false
+	for _, device := range devices {
+		if sent[device.Token] {
+			continue
+		}
+		err := s.sender.Send(ctx, models.PushNotification{
+			Token:       device.Token,
+			Platform:    device.Platform,
+			CollapseKey: job.CollapseKey,
+			Title:       job.Title,
+			Body:        job.Body,
+			Data: map[string]string{
+				"message_id":      job.MessageID.Hex(),
+				"conversation_id": job.CollapseKey,
+			},
+		})
+		switch {
+		case errors.Is(err, push.ErrInvalidToken):
+			if err := s.deviceRepo.DeleteToken(ctx, device.Token); err != nil {
+				log.Printf("Failed to prune push token of user %s: %v", job.UserID.Hex(), err)
+			}
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			s.markSent(ctx, job, device.Token)
+			accepted = true
+		}
+	}
+	if accepted {
+		// Only the first time a device accepts the job; retries would skew it
+		if len(sent) == 0 && !job.QueuedAt.IsZero() {
+			pushDeliveryLatency.Observe(time.Since(job.QueuedAt).Seconds())
+		}
+		if s.deliveries != nil {
+			s.deliveries.MarkDelivered(ctx, job.MessageID, []primitive.ObjectID{job.UserID})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sentTokens returns the devices that already accepted job. If Redis can't
+// tell, every device is tried again: a duplicate beats a lost notification,
+// and the collapse key keeps it from showing twice.
+func (s *PushService) sentTokens(ctx context.Context, job models.PushJob) map[string]bool {
+	sent := make(map[string]bool)
+	if s.redisClient == nil {
+		return sent
+	}
+	tokens, err := s.redisClient.SMembers(ctx, pushSentKey(job)).Result()
+	if err != nil {
+		log.Printf("Failed to read sent pushes of user %s: %v", job.UserID.Hex(), err)
+		return sent
+	}
+	for _, token := range tokens {
+		sent[token] = true
+	}
+	return sent
+}
+
+func (s *PushService) markSent(ctx context.Context, job models.PushJob, token string) {
+	if s.redisClient == nil {
+		return
+	}
+	key := pushSentKey(job)
+	pipe := s.redisClient.TxPipeline()
+	pipe.SAdd(ctx, key, token)
+	pipe.Expire(ctx, key, pushSentTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record sent push of user %s: %v", job.UserID.Hex(), err)
+	}
+}
+
+// pushSentKey holds the tokens of the devices that accepted a job
+func pushSentKey(job models.PushJob) string {
+	return "push_sent:" + job.MessageID.Hex() + ":" + job.UserID.Hex()
+}
+
+// pushPreview is the notification text for msg: the start of its content, or
+// what kind of attachment it has
+func pushPreview(msg models.Message) string {
+	if content := []rune(msg.Content); len(content) > 0 {
+		if len(content) > pushPreviewLength {
+			return string(content[:pushPreviewLength]) + "…"
+		}
+		return string(content)
+	}
+	switch msg.ContentType {
+	case models.ContentTypeImage:
+		return "Sent a photo"
+	case models.ContentTypeVideo:
+		return "Sent a video"
+	case models.ContentTypeAudio:
+		return "Sent a voice message"
+	default:
+		return "Sent an attachment"
+	}
+}
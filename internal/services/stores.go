@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The store interfaces list the repository methods the services use, so the
+// services can be unit tested against fakes instead of a live Mongo instance.
+// The repositories satisfy them as they are.
+
+// MessageStore is the message storage MessageService needs
+type MessageStore interface {
+	CancelPendingMessage(ctx context.Context, id, senderID primitive.ObjectID) (bool, error)
+	ClaimDueMessage(ctx context.Context, now time.Time) (*models.Message, error)
+	CountMessages(ctx context.Context, query models.MessageQuery) (int64, error)
+	CreateMessage(ctx context.Context, msg *models.Message) (*models.Message, error)
+	DeleteMessage(ctx context.Context, messageID, senderID primitive.ObjectID, mediaDeleter func(ctx context.Context, urls []string) error) (*models.Message, error)
+	GetAdjacentMessages(ctx context.Context, viewerID primitive.ObjectID, msg models.Message) (before, after *models.Message, err error)
+	GetConversations(ctx context.Context, userID primitive.ObjectID, groupIDs []primitive.ObjectID, archives []models.ConversationArchive, page, limit int64) ([]models.ConversationSummary, int64, error)
+	GetFlaggedMessages(ctx context.Context, page, limit int64) ([]models.Message, error)
+	GetMessageByID(ctx context.Context, id primitive.ObjectID) (*models.Message, error)
+	GetMessages(ctx context.Context, query models.MessageQuery) ([]models.Message, error)
+	GetMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Message, error)
+	GetUnreadCounts(ctx context.Context, userID primitive.ObjectID, groupIDs []primitive.ObjectID) (*models.UnreadCounts, error)
+	GetUnseenMessages(ctx context.Context, userID primitive.ObjectID, groupIDs, messageIDs []primitive.ObjectID) ([]models.Message, error)
+	MarkDelivered(ctx context.Context, messageID primitive.ObjectID, userIDs []primitive.ObjectID, deliveredAt time.Time) (*models.Message, error)
+	MarkMessagesAsSeen(ctx context.Context, userID primitive.ObjectID, groupIDs, messageIDs []primitive.ObjectID, seenAt time.Time) error
+	MediaURLsInUse(ctx context.Context, urls []string) ([]string, error)
+	NextSequence(ctx context.Context, conversationKey string) (int64, error)
+	SearchMessages(ctx context.Context, userID, groupID, receiverID primitive.ObjectID, text string, skip, limit int64) ([]models.Message, error)
+	SetSequence(ctx context.Context, id primitive.ObjectID, seq int64) error
+}
+
+// FriendshipStore is the friendship and block storage the services need
+type FriendshipStore interface {
+	AreFriends(ctx context.Context, userID1, userID2 primitive.ObjectID) (bool, error)
+	BlockUser(ctx context.Context, blockerID, blockedID primitive.ObjectID) error
+	CreateRequest(ctx context.Context, requesterID, receiverID primitive.ObjectID) (*models.Friendship, error)
+	GetBlockedUsers(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error)
+	GetFriendRequests(ctx context.Context, userID primitive.ObjectID, direction string, page, limit int64) ([]models.Friendship, int64, error)
+	GetFriendshipByID(ctx context.Context, id primitive.ObjectID) (*models.Friendship, error)
+	GetPendingRequest(ctx context.Context, requesterID, receiverID primitive.ObjectID) (*models.Friendship, error)
+	GetRelatedUserIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error)
+	IsBlocked(ctx context.Context, userID1, userID2 primitive.ObjectID) (bool, error)
+	IsBlockedBy(ctx context.Context, blockedID, blockerID primitive.ObjectID) (bool, error)
+	RevertToPending(ctx context.Context, friendshipID primitive.ObjectID) error
+	UnblockUser(ctx context.Context, blockerID, blockedID primitive.ObjectID) error
+	Unfriend(ctx context.Context, userID, friendID primitive.ObjectID) error
+	UpdateStatus(ctx context.Context, friendshipID primitive.ObjectID, receiverID primitive.ObjectID, status string) error
+}
+
+// UserStore is the user storage the message and friendship services need
+type UserStore interface {
+	AddFriend(ctx context.Context, userID1, userID2 primitive.ObjectID) error
+	FindUserByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	FindUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.User, error)
+	FindUsersByUsernames(ctx context.Context, usernames []string) ([]models.User, error)
+	RemoveFriend(ctx context.Context, userID1, userID2 primitive.ObjectID) error
+	SuggestFriends(ctx context.Context, friendIDs, exclude []primitive.ObjectID, limit int64) ([]models.FriendSuggestion, error)
+}
+
+var (
+	_ MessageStore    = (*repositories.MessageRepository)(nil)
+	_ FriendshipStore = (*repositories.FriendshipRepository)(nil)
+	_ UserStore       = (*repositories.UserRepository)(nil)
+)
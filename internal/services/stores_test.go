@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"messaging-app/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The fakes embed the store interface so a test only implements the methods
+// its code path reaches; calling any other method panics.
+
+type fakeFriendshipStore struct {
+	FriendshipStore
+	friendships map[primitive.ObjectID]*models.Friendship
+	friends     bool
+	err         error
+	statuses    []string
+	reverted    bool
+}
+
+func (f *fakeFriendshipStore) GetFriendshipByID(ctx context.Context, id primitive.ObjectID) (*models.Friendship, error) {
+	friendship, ok := f.friendships[id]
+	if !ok {
+		return nil, ErrFriendRequestNotFound
+	}
+	return friendship, nil
+}
+
+func (f *fakeFriendshipStore) UpdateStatus(ctx context.Context, friendshipID primitive.ObjectID, receiverID primitive.ObjectID, status string) error {
+	f.statuses = append(f.statuses, status)
+	if friendship, ok := f.friendships[friendshipID]; ok {
+		friendship.Status = status
+	}
+	return nil
+}
+
+func (f *fakeFriendshipStore) RevertToPending(ctx context.Context, friendshipID primitive.ObjectID) error {
+	f.reverted = true
+	f.friendships[friendshipID].Status = models.FriendshipStatusPending
+	return nil
+}
+
+func (f *fakeFriendshipStore) AreFriends(ctx context.Context, userID1, userID2 primitive.ObjectID) (bool, error) {
+	return f.friends, f.err
+}
+
+type fakeUserStore struct {
+	UserStore
+	addFriendErr error
+	friendPairs  [][2]primitive.ObjectID
+	users        []models.User
+	lookups      [][]string // usernames passed to each FindUsersByUsernames call
+	idLookups    [][]primitive.ObjectID
+}
+
+func (f *fakeUserStore) FindUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.User, error) {
+	f.idLookups = append(f.idLookups, ids)
+	var found []models.User
+	for _, u := range f.users {
+		if slices.Contains(ids, u.ID) {
+			found = append(found, u)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeUserStore) FindUsersByUsernames(ctx context.Context, usernames []string) ([]models.User, error) {
+	f.lookups = append(f.lookups, usernames)
+	var found []models.User
+	for _, u := range f.users {
+		if slices.Contains(usernames, strings.ToLower(u.Username)) {
+			found = append(found, u)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeUserStore) AddFriend(ctx context.Context, userID1, userID2 primitive.ObjectID) error {
+	if f.addFriendErr != nil {
+		return f.addFriendErr
+	}
+	f.friendPairs = append(f.friendPairs, [2]primitive.ObjectID{userID1, userID2})
+	return nil
+}
+
+// unreachableRedis fails every command at once, so the services take their
+// database fallbacks and only log cache invalidation failures
+func unreachableRedis() *redis.ClusterClient {
+	return redis.NewClusterClient(&redis.ClusterOptions{})
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/pkg/apperrors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/errgroup"
+)
+
+// SyncService tells reconnecting clients what changed while they were away,
+// so they don't refetch whole conversations
+type SyncService struct {
+	messageRepo    *repositories.MessageRepository
+	groupRepo      *repositories.GroupRepository
+	friendshipRepo *repositories.FriendshipRepository
+	userRepo       *repositories.UserRepository
+}
+
+func NewSyncService(
+	messageRepo *repositories.MessageRepository,
+	groupRepo *repositories.GroupRepository,
+	friendshipRepo *repositories.FriendshipRepository,
+	userRepo *repositories.UserRepository,
+) *SyncService {
+	return &SyncService{
+		messageRepo:    messageRepo,
+		groupRepo:      groupRepo,
+		friendshipRepo: friendshipRepo,
+		userRepo:       userRepo,
+	}
+}
+
+// ParseSyncToken reads a since cursor: a token from an earlier sync or any
+// RFC 3339 timestamp
+func ParseSyncToken(token string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, apperrors.Validation("since is required")
+	}
+	since, err := time.Parse(time.RFC3339Nano, token)
+	if err != nil {
+		return time.Time{}, apperrors.Validation("since must be a sync token or an RFC 3339 timestamp")
+	}
+	return since, nil
+}
+
+func syncToken(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Sync collects what changed for userID since the cursor: messages per
+// conversation, friend requests and friendships, the user's groups and their
+// friends' profiles. Each kind is bounded; when one is cut short the returned
+// token points at the last change included, so the next sync picks up there.
+func (s *SyncService) Sync(ctx context.Context, userID primitive.ObjectID, since time.Time) (*models.SyncResponse, error) {
+	now := time.Now()
+	if since.After(now) {
+		return nil, apperrors.Validation("since is in the future")
+	}
+
+	resp := &models.SyncResponse{}
+	var messagesMore, friendshipsMore, groupsMore, usersMore bool
+	var usersLast time.Time // safeUsersFor drops the change times
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		groups, err := s.groupRepo.GetUserGroups(ctx, userID)
+		if err != nil {
+			return err
+		}
+		groupIDs := make([]primitive.ObjectID, len(groups))
+		for i, group := range groups {
+			groupIDs[i] = group.ID
+			if !group.UpdatedAt.Before(since) {
+				resp.Groups = append(resp.Groups, *group)
+			}
+		}
+		slices.SortFunc(resp.Groups, func(a, b models.Group) int {
+			return a.UpdatedAt.Compare(b.UpdatedAt)
+		})
+		if len(resp.Groups) > models.MaxSyncGroups {
+			resp.Groups, groupsMore = resp.Groups[:models.MaxSyncGroups], true
+		}
+
+		resp.Conversations, messagesMore, err = s.messageRepo.GetChangedMessages(ctx, userID, groupIDs, since,
+			models.MaxSyncConversations, models.MaxSyncMessagesPerConversation)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		resp.Friendships, friendshipsMore, err = s.friendshipRepo.GetChangedFriendships(ctx, userID, since, models.MaxSyncFriendships)
+		return err
+	})
+	g.Go(func() error {
+		friendIDs, err := s.userRepo.GetFriendIDs(ctx, userID)
+		if err != nil {
+			return err
+		}
+		users, more, err := s.userRepo.FindUsersChangedSince(ctx, friendIDs, since, models.MaxSyncUsers)
+		if err != nil {
+			return err
+		}
+		resp.Users, usersMore = safeUsersFor(userID, users), more
+		if len(users) > 0 {
+			usersLast = users[len(users)-1].UpdatedAt
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// The next sync starts at the earliest point a kind was cut short
+	cursor := now
+	cut := func(more bool, last time.Time) {
+		if more && last.Before(cursor) {
+			cursor = last
+		}
+	}
+	if n := len(resp.Conversations); n > 0 {
+		cut(messagesMore, resp.Conversations[n-1].ChangedAt)
+	}
+	if n := len(resp.Friendships); n > 0 {
+		cut(friendshipsMore, resp.Friendships[n-1].UpdatedAt)
+	}
+	if n := len(resp.Groups); n > 0 {
+		cut(groupsMore, resp.Groups[n-1].UpdatedAt)
+	}
+	cut(usersMore, usersLast)
+
+	resp.HasMore = messagesMore || friendshipsMore || groupsMore || usersMore
+	resp.SyncToken = syncToken(cursor)
+	if resp.Conversations == nil {
+		resp.Conversations = []models.ConversationChanges{}
+	}
+	if resp.Friendships == nil {
+		resp.Friendships = []models.Friendship{}
+	}
+	if resp.Groups == nil {
+		resp.Groups = []models.Group{}
+	}
+	return resp, nil
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncToken(t *testing.T) {
+	at := time.Date(2025, 1, 10, 2, 30, 0, 123456789, time.UTC)
+
+	since, err := ParseSyncToken(syncToken(at))
+	assert.NoError(t, err)
+	assert.True(t, since.Equal(at), "tokens keep nanoseconds")
+
+	since, err = ParseSyncToken("2025-01-10T04:30:00+02:00")
+	assert.NoError(t, err)
+	assert.True(t, since.Equal(at.Truncate(time.Second)))
+
+	for _, token := range []string{"", "yesterday", "1736476200"} {
+		_, err := ParseSyncToken(token)
+		assert.Error(t, err, token)
+	}
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/pkg/totp"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// TwoFactorChallengeTTL is how long a login challenge can be completed
+	TwoFactorChallengeTTL = 5 * time.Minute
+	// maxTwoFactorAttempts wrong codes invalidate a challenge
+	maxTwoFactorAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotSetUp       = errors.New("two-factor setup has not been started")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired two-factor challenge")
+)
+
+// SetupTwoFactor generates a new TOTP secret for the user. Two-factor stays
+// disabled until EnableTwoFactor confirms a code from the authenticator app.
+func (s *AuthService) SetupTwoFactor(ctx context.Context, userID primitive.ObjectID) (*models.TwoFactorSetupResponse, error) {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.encryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.UpdateUser(ctx, userID, bson.M{"two_factor_secret": encrypted}); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURL: totp.URL(s.cfg.TwoFactorIssuer, user.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor turns two-factor on once code matches the pending secret
+// and returns the one-time recovery codes. They are only shown this once.
+func (s *AuthService) EnableTwoFactor(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TwoFactorSecret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	if err := s.checkTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(raw)
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if _, err := s.userRepo.UpdateUser(ctx, userID, bson.M{
+		"two_factor_enabled": true,
+		"recovery_codes":     hashes,
+	}); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, userID, models.AuditTwoFactorEnabled)
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor off after checking the password and a
+// current TOTP or recovery code
+func (s *AuthService) DisableTwoFactor(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return errors.New("invalid credentials: please check password")
+	}
+	if err := s.checkSecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+
+	if _, err := s.userRepo.UpdateUser(ctx, userID, bson.M{
+		"two_factor_enabled": false,
+		"two_factor_secret":  "",
+		"recovery_codes":     []string{},
+	}); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, userID, models.AuditTwoFactorDisabled)
+	return nil
+}
+
+// CompleteTwoFactorLogin exchanges a login challenge and a TOTP or recovery
+// code for tokens
+func (s *AuthService) CompleteTwoFactorLogin(ctx context.Context, challengeToken, code string) (*models.AuthResponse, error) {
+	key := twoFactorChallengeKey(challengeToken)
+	userIDHex, err := s.redisClient.HGet(ctx, key, "user").Result()
+	if err == redis.Nil {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil || !user.IsActive() || !user.TwoFactorEnabled {
+		return nil, ErrInvalidChallenge
+	}
+
+	if err := s.checkSecondFactor(ctx, user, code); err != nil {
+		s.audit.Record(ctx, user.ID, models.AuditLoginFailed)
+		attempts, incrErr := s.redisClient.HIncrBy(ctx, key, "attempts", 1).Result()
+		if incrErr == nil && attempts >= maxTwoFactorAttempts {
+			s.redisClient.Del(ctx, key)
+		}
+		return nil, err
+	}
+
+	// Challenges are single use
+	deleted, err := s.redisClient.Del(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, ErrInvalidChallenge
+	}
+
+	accessToken, refreshToken, err := s.generateTokens(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, user.ID, models.AuditLogin)
+
+	return &models.AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		User:         user.ToSafeResponse(),
+	}, nil
+}
+
+func (s *AuthService) createTwoFactorChallenge(ctx context.Context, user *models.User) (*models.AuthResponse, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	challenge := hex.EncodeToString(raw)
+	key := twoFactorChallengeKey(challenge)
+
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user", user.ID.Hex(), "attempts", 0)
+		pipe.Expire(ctx, key, TwoFactorChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResponse{
+		TwoFactorRequired:  true,
+		ChallengeToken:     challenge,
+		ChallengeExpiresIn: int64(TwoFactorChallengeTTL.Seconds()),
+	}, nil
+}
+
+// checkSecondFactor accepts a TOTP code or, failing that, an unused recovery code
+func (s *AuthService) checkSecondFactor(ctx context.Context, user *models.User, code string) error {
+	err := s.checkTOTP(ctx, user, code)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		return err
+	}
+
+	consumed, consumeErr := s.userRepo.ConsumeRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+	if consumeErr != nil {
+		return consumeErr
+	}
+	if !consumed {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// checkTOTP validates code against the user's secret. A code is accepted
+// once, so an intercepted code can't be replayed within its window.
+func (s *AuthService) checkTOTP(ctx context.Context, user *models.User, code string) error {
+	secret, err := s.decryptSecret(user.TwoFactorSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	fresh, err := s.redisClient.SetNX(ctx, twoFactorUsedKey(user.ID.Hex(), step), "1", 3*totp.Period).Result()
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func (s *AuthService) encryptSecret(secret string) (string, error) {
+	gcm, err := s.secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *AuthService) decryptSecret(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("invalid two-factor secret: %w", err)
+	}
+	gcm, err := s.secretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid two-factor secret")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid two-factor secret: %w", err)
+	}
+	return string(plain), nil
+}
+
+func (s *AuthService) secretCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(s.cfg.TwoFactorEncryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+func twoFactorChallengeKey(challenge string) string {
+	return "2fa_challenge:" + challenge
+}
+
+func twoFactorUsedKey(userID string, step int64) string {
+	return "2fa_used:" + userID + ":" + strconv.FormatInt(step, 10)
+}
@@ -3,28 +3,209 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+	"messaging-app/pkg/phone"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService struct {
-	userRepo *repositories.UserRepository
+	userRepo       *repositories.UserRepository
+	friendshipRepo *repositories.FriendshipRepository
+	followRepo     *repositories.FollowRepository
+	redisClient    *redis.ClusterClient
+	audit          *AuditLog
+	summaries      *UserSummaryCache
 }
 
-func NewUserService(userRepo *repositories.UserRepository) *UserService {
-	return &UserService{userRepo: userRepo}
+func NewUserService(userRepo *repositories.UserRepository, friendshipRepo *repositories.FriendshipRepository, followRepo *repositories.FollowRepository, redisClient *redis.ClusterClient, audit *AuditLog) *UserService {
+	return &UserService{userRepo: userRepo, friendshipRepo: friendshipRepo, followRepo: followRepo, redisClient: redisClient, audit: audit,
+		summaries: NewUserSummaryCache(userRepo, redisClient)}
+}
+
+// SetUserSummaryCache replaces the service's own summary cache with one
+// shared with other services
+func (s *UserService) SetUserSummaryCache(cache *UserSummaryCache) {
+	s.summaries = cache
 }
 
 func (s *UserService) GetUserByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
 	return s.userRepo.FindUserByID(ctx, id)
 }
 
+// GetUsersByIDs loads several users with a single query
+func (s *UserService) GetUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.User, error) {
+	return s.userRepo.FindUsersByIDs(ctx, ids)
+}
+
+// GetUserSummaries resolves users to the summaries embedded in responses,
+// usually without querying Mongo
+func (s *UserService) GetUserSummaries(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]models.UserSummary, error) {
+	return s.summaries.Get(ctx, ids)
+}
+
+// GetProfile returns targetID's profile as viewerID may see it. Users blocked
+// in either direction and deactivated accounts look like they don't exist.
+func (s *UserService) GetProfile(ctx context.Context, viewerID, targetID primitive.ObjectID) (*models.ProfileResponse, error) {
+	target, err := s.userRepo.FindUserByID(ctx, targetID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &models.ProfileResponse{
+		ID:          target.ID,
+		Username:    target.Username,
+		Avatar:      target.Avatar,
+		AvatarSizes: target.AvatarSizes,
+		CreatedAt:   target.CreatedAt,
+		FriendCount: len(target.Friends),
+		IsPrivate:   target.IsPrivate,
+	}
+	if profile.FollowerCount, err = s.followRepo.CountFollowers(ctx, targetID); err != nil {
+		return nil, err
+	}
+	if profile.FollowingCount, err = s.followRepo.CountFollowing(ctx, targetID); err != nil {
+		return nil, err
+	}
+
+	if viewerID == targetID {
+		profile.Relationship = models.ProfileRelationshipSelf
+		profile.Email = target.Email
+		profile.PhoneNumber = target.PhoneNumber
+		profile.Friends = target.Friends
+		return profile, nil
+	}
+
+	if !target.IsActive() {
+		return nil, apperrors.NotFound("user not found")
+	}
+	blocked, err := s.friendshipRepo.IsBlocked(ctx, viewerID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, apperrors.NotFound("user not found")
+	}
+
+	viewerFriends, err := s.userRepo.GetFriendIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	mutual := 0
+	for _, id := range viewerFriends {
+		if containsID(target.Friends, id) {
+			mutual++
+		}
+	}
+	profile.MutualFriendCount = &mutual
+
+	profile.Relationship = models.ProfileRelationshipNone
+	if containsID(target.Friends, viewerID) {
+		profile.Relationship = models.ProfileRelationshipFriend
+	}
+	if target.CanSeeFriendList(viewerID) {
+		profile.Friends = target.Friends
+	}
+	if target.CanSeePhoneNumber(viewerID) {
+		profile.PhoneNumber = target.PhoneNumber
+	}
+	if profile.FollowStatus, err = s.followRepo.GetStatus(ctx, viewerID, targetID); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// ListFriends pages through ownerID's friends as viewerID may see them,
+// optionally narrowed to usernames starting with prefix. Hidden friend lists
+// are Forbidden; blocked and deactivated owners look like they don't exist.
+func (s *UserService) ListFriends(ctx context.Context, viewerID, ownerID primitive.ObjectID, page, limit int64, prefix string) (*models.UserListResponse, error) {
+	owner, err := s.userRepo.FindUserByID(ctx, ownerID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperrors.NotFound("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if viewerID != ownerID {
+		if !owner.IsActive() {
+			return nil, apperrors.NotFound("user not found")
+		}
+		blocked, err := s.friendshipRepo.IsBlocked(ctx, viewerID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, apperrors.NotFound("user not found")
+		}
+		if !owner.CanSeeFriendList(viewerID) {
+			return nil, apperrors.Forbidden("this user's friend list is hidden")
+		}
+	}
+
+	return pageUsers(ctx, s.userRepo, s.friendshipRepo, viewerID, owner.Friends, page, limit, prefix)
+}
+
+// pageUsers pages through the active users among ids as viewerID may see
+// them, in username order, optionally narrowed to usernames starting with
+// prefix. The viewer doesn't see users they blocked or who blocked them,
+// even in someone else's list.
+func pageUsers(ctx context.Context, userRepo *repositories.UserRepository, friendshipRepo *repositories.FriendshipRepository, viewerID primitive.ObjectID, ids []primitive.ObjectID, page, limit int64, prefix string) (*models.UserListResponse, error) {
+	exclude, err := friendshipRepo.GetBlockRelations(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if ids == nil {
+		// $in rejects null
+		ids = []primitive.ObjectID{}
+	}
+	filter := bson.M{
+		"_id":            bson.M{"$in": ids, "$nin": exclude},
+		"deactivated_at": bson.M{"$exists": false},
+	}
+	mode := models.UserSearchAll
+	if prefix = strings.ToLower(strings.TrimSpace(prefix)); prefix != "" {
+		mode = models.UserSearchPrefix
+		filter["username_lower"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	}
+
+	total, err := userRepo.CountUsers(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	users, err := userRepo.FindUsers(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "username_lower", Value: 1}}).
+		SetSkip((page-1)*limit).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UserListResponse{
+		Users:      safeUsersFor(viewerID, users),
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		SearchMode: mode,
+	}, nil
+}
+
 func (s *UserService) UpdateUser(ctx context.Context, id primitive.ObjectID, update *models.UserUpdateRequest) (*models.User, error) {
 	updateData := bson.M{
 		"updated_at": time.Now(),
@@ -39,11 +220,21 @@ func (s *UserService) UpdateUser(ctx context.Context, id primitive.ObjectID, upd
 	}
 
 	if update.Email != "" {
+		// Changing the address again requires control of the current one
+		user, err := s.userRepo.FindUserByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !user.EmailVerified {
+			return nil, ErrEmailNotVerified
+		}
+
 		existingUserEmail, _ := s.userRepo.FindUserByEmail(ctx, update.Email)
 		if existingUserEmail != nil {
 			return nil, errors.New("user email already exists")
 		}
 		updateData["email"] = update.Email
+		updateData["email_verified"] = false
 	}
 
 	// Only update password if new password provided
@@ -64,51 +255,234 @@ func (s *UserService) UpdateUser(ctx context.Context, id primitive.ObjectID, upd
 		updateData["password"] = string(hashedPassword)
 	}
 
+	if update.UndoSendSeconds != nil {
+		if *update.UndoSendSeconds < 0 || *update.UndoSendSeconds > models.MaxUndoSendSeconds {
+			return nil, fmt.Errorf("undo_send_seconds must be between 0 and %d", models.MaxUndoSendSeconds)
+		}
+		updateData["undo_send_seconds"] = *update.UndoSendSeconds
+	}
+
+	if update.FriendListVisibility != nil {
+		if !models.IsValidFriendListVisibility(*update.FriendListVisibility) {
+			return nil, errors.New("friend_list_visibility must be everyone, friends or only_me")
+		}
+		updateData["friend_list_visibility"] = *update.FriendListVisibility
+	}
+
+	if update.IsPrivate != nil {
+		updateData["is_private"] = *update.IsPrivate
+	}
+
+	if update.PhoneNumber != nil {
+		if *update.PhoneNumber == "" {
+			updateData["phone_number"] = ""
+			updateData["phone_hash"] = ""
+		} else {
+			number, err := phone.Normalize(*update.PhoneNumber)
+			if err != nil {
+				return nil, err
+			}
+			updateData["phone_number"] = number
+			updateData["phone_hash"] = phone.Hash(number)
+		}
+	}
+
+	if update.PhoneVisibility != nil {
+		if !models.IsValidFriendListVisibility(*update.PhoneVisibility) {
+			return nil, errors.New("phone_visibility must be everyone, friends or only_me")
+		}
+		updateData["phone_visibility"] = *update.PhoneVisibility
+	}
+
+	if update.DiscoverableByPhone != nil {
+		updateData["discoverable_by_phone"] = *update.DiscoverableByPhone
+	}
+
 	updatedUser, err := s.userRepo.UpdateUser(ctx, id, updateData)
 	if err != nil {
 		return nil, err
 	}
+	s.summaries.Invalidate(ctx, id)
+
+	// A public account has no use for follow requests
+	if update.IsPrivate != nil && !*update.IsPrivate {
+		if err := s.followRepo.AcceptAllRequests(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// A new password ends every session, including this one
+	if _, ok := updateData["password"]; ok {
+		s.audit.Record(ctx, id, models.AuditPasswordChanged)
+		if err := revokeAllSessions(ctx, s.userRepo, s.redisClient, id); err != nil {
+			return nil, err
+		}
+	}
+	if update.Email != "" {
+		s.audit.Record(ctx, id, models.AuditEmailChanged)
+	}
+
+	// Messages sent from now on carry the new name
+	if update.Username != "" {
+		if err := appredis.CacheUserName(ctx, s.redisClient, id.Hex(), updatedUser.Username); err != nil {
+			logging.FromContext(ctx).Warn("Failed to cache username", "user_id", id.Hex(), "error", err)
+		}
+	}
 
 	// Clear password before returning
 	updatedUser.Password = ""
 	return updatedUser, nil
 }
 
-func (s *UserService) ListUsers(ctx context.Context, page, limit int64, search string) (*models.UserListResponse, error) {
-	filter := bson.M{}
-	if search != "" {
-		filter["$or"] = []bson.M{
-			{"username": bson.M{"$regex": search, "$options": "i"}},
-			{"email": bson.M{"$regex": search, "$options": "i"}},
-		}
+// ListUsers pages through the user directory as viewerID sees it. A single
+// word matches username prefixes and several words use the text index, so
+// neither scans the whole collection.
+func (s *UserService) ListUsers(ctx context.Context, viewerID primitive.ObjectID, page, limit int64, search string) (*models.UserListResponse, error) {
+	blocked, err := s.friendshipRepo.GetBlockRelations(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deactivated accounts and blocked users are hidden from listings and search
+	filter := bson.M{
+		"_id":            bson.M{"$nin": blocked},
+		"deactivated_at": bson.M{"$exists": false},
+	}
+	opts := options.Find().
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	mode := models.UserSearchAll
+	search = strings.TrimSpace(search)
+	switch {
+	case search == "":
+		opts.SetSort(bson.D{{Key: "username_lower", Value: 1}})
+	case len(strings.Fields(search)) > 1:
+		mode = models.UserSearchText
+		filter["$text"] = bson.M{"$search": search}
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+			SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "username_lower", Value: 1}})
+	default:
+		mode = models.UserSearchPrefix
+		filter["username_lower"] = bson.M{"$regex": "^" + regexp.QuoteMeta(strings.ToLower(search))}
+		opts.SetSort(bson.D{{Key: "username_lower", Value: 1}})
 	}
 
-	// Get total count
 	total, err := s.userRepo.CountUsers(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	// Pagination options
-	opts := options.Find().
-		SetSkip((page - 1) * limit).
-		SetLimit(limit).
-		SetSort(bson.D{{Key: "username", Value: 1}})
+	users, err := s.userRepo.FindUsers(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UserListResponse{
+		Users:      safeUsersFor(viewerID, users),
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		SearchMode: mode,
+	}, nil
+}
 
+// DiscoverByPhone finds the users whose phone number hashes are among the
+// caller's contacts. Users who opted out of discovery, deactivated accounts
+// and blocked users are left out exactly as if they had no account, and the
+// response doesn't say which hash matched.
+func (s *UserService) DiscoverByPhone(ctx context.Context, viewerID primitive.ObjectID, hashes []string) (*models.PhoneDiscoveryResponse, error) {
+	if len(hashes) == 0 || len(hashes) > models.MaxDiscoveryHashes {
+		return nil, apperrors.Validation(fmt.Sprintf("send between 1 and %d hashes", models.MaxDiscoveryHashes))
+	}
+	seen := make(map[string]bool, len(hashes))
+	unique := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		h = strings.ToLower(h)
+		if !phone.IsHash(h) {
+			return nil, apperrors.Validation("hashes must be hex-encoded SHA-256")
+		}
+		if !seen[h] {
+			seen[h] = true
+			unique = append(unique, h)
+		}
+	}
+
+	blocked, err := s.friendshipRepo.GetBlockRelations(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":                   bson.M{"$nin": append(blocked, viewerID)},
+		"phone_hash":            bson.M{"$in": unique},
+		"discoverable_by_phone": bson.M{"$ne": false},
+		"deactivated_at":        bson.M{"$exists": false},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "username_lower", Value: 1}})
 	users, err := s.userRepo.FindUsers(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
+	return &models.PhoneDiscoveryResponse{Users: safeUsersFor(viewerID, users)}, nil
+}
 
-	// Clear passwords
+// safeUsersFor converts users for a listing shown to viewerID, leaving out
+// the friend lists and phone numbers the viewer may not see
+func safeUsersFor(viewerID primitive.ObjectID, users []models.User) []models.SafeUserResponse {
+	safeUsers := make([]models.SafeUserResponse, len(users))
 	for i := range users {
-		users[i].Password = ""
+		safeUsers[i] = users[i].ToSafeResponse()
+		if !users[i].CanSeeFriendList(viewerID) {
+			safeUsers[i].Friends = nil
+		}
+		if users[i].CanSeePhoneNumber(viewerID) {
+			safeUsers[i].PhoneNumber = users[i].PhoneNumber
+		}
 	}
+	return safeUsers
+}
 
-	return &models.UserListResponse{
-		Users: users,
-		Total: total,
-		Page:  page,
-		Limit: limit,
-	}, nil
-}
\ No newline at end of file
+// SuggestUsers autocompletes usernames for mentions. The requester's friends
+// come first, then everyone else; users blocked in either direction and the
+// requester are left out.
+func (s *UserService) SuggestUsers(ctx context.Context, userID primitive.ObjectID, prefix string, limit int64) ([]models.UserSuggestion, error) {
+	prefix = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(prefix), "@"))
+	if prefix == "" {
+		return []models.UserSuggestion{}, nil
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := s.friendshipRepo.GetBlockRelations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude = append(exclude, userID)
+
+	friends := user.Friends
+	if friends == nil {
+		friends = []primitive.ObjectID{}
+	}
+	matches, err := s.userRepo.SuggestUsers(ctx, prefix, friends, exclude, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if remaining := limit - int64(len(matches)); remaining > 0 {
+		others, err := s.userRepo.SuggestUsers(ctx, prefix, nil, append(exclude, friends...), remaining)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, others...)
+	}
+
+	suggestions := make([]models.UserSuggestion, len(matches))
+	for i, u := range matches {
+		suggestions[i] = models.UserSuggestion{ID: u.ID, Username: u.Username, Avatar: u.Avatar}
+	}
+	return suggestions, nil
+}
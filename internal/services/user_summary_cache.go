@@ -0,0 +1,244 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
+	"messaging-app/pkg/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	userSummaryCacheSize = 10000
+	// In-process entries live shorter than the Redis ones so an instance
+	// that missed an invalidation catches up quickly
+	userSummaryLocalTTL = time.Minute
+)
+
+var (
+	userSummaryLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_summary_cache_lookups_total",
+		Help: "User summary lookups by where they were answered: local, redis or miss",
+	}, []string{"result"})
+	userSummaryMetricsOnce sync.Once
+)
+
+type userSummaryEntry struct {
+	summary   models.UserSummary
+	expiresAt time.Time
+}
+
+// UserSummaryCache resolves user IDs to the summaries responses embed. It
+// looks in an in-process LRU first, then Redis, and loads whatever is left
+// with a single query. Run keeps the LRU in step with profile updates made
+// on other instances.
+type UserSummaryCache struct {
+	users       UserStore
+	redisClient *redis.ClusterClient
+	size        int
+
+	mu      sync.Mutex
+	entries map[primitive.ObjectID]*list.Element
+	order   *list.List // front is the most recently used
+}
+
+func NewUserSummaryCache(users UserStore, redisClient *redis.ClusterClient) *UserSummaryCache {
+	userSummaryMetricsOnce.Do(func() {
+		prometheus.MustRegister(userSummaryLookups)
+	})
+	return &UserSummaryCache{
+		users:       users,
+		redisClient: redisClient,
+		size:        userSummaryCacheSize,
+		entries:     make(map[primitive.ObjectID]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// Get returns a summary for every ID. Users that no longer exist get the
+// deleted user placeholder; they aren't cached, so an ID that is never
+// found costs a query each time.
+func (c *UserSummaryCache) Get(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]models.UserSummary, error) {
+	result := make(map[primitive.ObjectID]models.UserSummary, len(ids))
+	var missing []primitive.ObjectID
+	for _, id := range ids {
+		if _, ok := result[id]; ok {
+			continue
+		}
+		if summary, ok := c.getLocal(id); ok {
+			result[id] = summary
+			userSummaryLookups.WithLabelValues("local").Inc()
+			continue
+		}
+		result[id] = models.UserSummary{}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	missing = c.getRemote(ctx, missing, result)
+	if len(missing) == 0 {
+		return result, nil
+	}
+	userSummaryLookups.WithLabelValues("miss").Add(float64(len(missing)))
+
+	users, err := c.users.FindUsersByIDs(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make([]models.UserSummary, 0, len(users))
+	for i := range users {
+		summary := users[i].Summary()
+		result[summary.ID] = summary
+		c.putLocal(summary)
+		loaded = append(loaded, summary)
+	}
+	c.putRemote(ctx, loaded)
+
+	for _, id := range missing {
+		if result[id].ID.IsZero() {
+			result[id] = models.UserSummary{ID: id, Username: models.DeletedUsername}
+		}
+	}
+	return result, nil
+}
+
+// Invalidate drops the cached summaries of ids everywhere
+func (c *UserSummaryCache) Invalidate(ctx context.Context, ids ...primitive.ObjectID) {
+	for _, id := range ids {
+		c.forget(id)
+		if c.redisClient == nil {
+			continue
+		}
+		if err := appredis.InvalidateUserSummary(ctx, c.redisClient, id.Hex()); err != nil {
+			logging.FromContext(ctx).Warn("Failed to invalidate user summary", "user_id", id.Hex(), "error", err)
+		}
+	}
+}
+
+// Run drops local entries as invalidations arrive from any instance, until
+// ctx is cancelled
+func (c *UserSummaryCache) Run(ctx context.Context) {
+	pubsub := c.redisClient.Subscribe(ctx, appredis.UserSummaryChannel)
+	defer pubsub.Close()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			id, err := primitive.ObjectIDFromHex(msg.Payload)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Ignoring malformed user summary invalidation", "payload", msg.Payload)
+				continue
+			}
+			c.forget(id)
+		}
+	}
+}
+
+// getRemote fills result from Redis and returns the IDs Redis didn't have.
+// Redis being unavailable only makes every ID a miss.
+func (c *UserSummaryCache) getRemote(ctx context.Context, ids []primitive.ObjectID, result map[primitive.ObjectID]models.UserSummary) []primitive.ObjectID {
+	if c.redisClient == nil {
+		return ids
+	}
+	// The keys live on different cluster slots, so they are read with a
+	// pipeline rather than MGET
+	pipe := c.redisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Get(ctx, appredis.UserSummaryKey(id.Hex()))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		logging.FromContext(ctx).Warn("Failed to read cached user summaries", "error", err)
+		return ids
+	}
+
+	var missing []primitive.ObjectID
+	for i, id := range ids {
+		var summary models.UserSummary
+		data, err := cmds[i].Bytes()
+		if err != nil || json.Unmarshal(data, &summary) != nil {
+			missing = append(missing, id)
+			continue
+		}
+		result[id] = summary
+		c.putLocal(summary)
+		userSummaryLookups.WithLabelValues("redis").Inc()
+	}
+	return missing
+}
+
+func (c *UserSummaryCache) putRemote(ctx context.Context, summaries []models.UserSummary) {
+	if c.redisClient == nil || len(summaries) == 0 {
+		return
+	}
+	pipe := c.redisClient.Pipeline()
+	for _, summary := range summaries {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			continue
+		}
+		pipe.Set(ctx, appredis.UserSummaryKey(summary.ID.Hex()), data, appredis.UserSummaryTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache user summaries", "error", err)
+	}
+}
+
+func (c *UserSummaryCache) getLocal(id primitive.ObjectID) (models.UserSummary, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[id]
+	if !ok {
+		return models.UserSummary{}, false
+	}
+	entry := elem.Value.(*userSummaryEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+		return models.UserSummary{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.summary, true
+}
+
+func (c *UserSummaryCache) putLocal(summary models.UserSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &userSummaryEntry{summary: summary, expiresAt: time.Now().Add(userSummaryLocalTTL)}
+	if elem, ok := c.entries[summary.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[summary.ID] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userSummaryEntry).summary.ID)
+	}
+}
+
+func (c *UserSummaryCache) forget(id primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+}
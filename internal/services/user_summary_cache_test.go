@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserSummaryCacheBatchesMisses(t *testing.T) {
+	users := &fakeUserStore{}
+	var ids []primitive.ObjectID
+	for i := 0; i < 20; i++ {
+		u := models.User{ID: primitive.NewObjectID(), Username: fmt.Sprintf("author%d", i)}
+		users.users = append(users.users, u)
+		// Authors repeat across a page, as they do in a real feed
+		ids = append(ids, u.ID, u.ID)
+	}
+	gone := primitive.NewObjectID()
+	ids = append(ids, gone)
+	cache := NewUserSummaryCache(users, unreachableRedis())
+	ctx := context.Background()
+
+	summaries, err := cache.Get(ctx, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.idLookups) != 1 || len(users.idLookups[0]) != 21 {
+		t.Fatalf("cold cache lookups = %v, want one query for 21 distinct ids", users.idLookups)
+	}
+	if len(summaries) != 21 || summaries[users.users[3].ID].Username != "author3" {
+		t.Fatalf("summaries = %v", summaries)
+	}
+	if summaries[gone].Username != models.DeletedUsername {
+		t.Errorf("missing user resolved to %q, want the deleted placeholder", summaries[gone].Username)
+	}
+
+	users.idLookups = nil
+	if _, err := cache.Get(ctx, ids[:40]); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.idLookups) != 0 {
+		t.Errorf("warm cache made %d queries, want none", len(users.idLookups))
+	}
+
+	cache.Invalidate(ctx, users.users[0].ID)
+	if _, err := cache.Get(ctx, ids[:40]); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.idLookups) != 1 || len(users.idLookups[0]) != 1 {
+		t.Errorf("lookups after invalidation = %v, want only the invalidated user", users.idLookups)
+	}
+}
+
+func TestUserSummaryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	users := &fakeUserStore{}
+	for i := 0; i < 3; i++ {
+		users.users = append(users.users, models.User{ID: primitive.NewObjectID(), Username: fmt.Sprintf("user%d", i)})
+	}
+	cache := NewUserSummaryCache(users, nil)
+	cache.size = 2
+	ctx := context.Background()
+	a, b, c := users.users[0].ID, users.users[1].ID, users.users[2].ID
+
+	for _, id := range []primitive.ObjectID{a, b, a, c} {
+		if _, err := cache.Get(ctx, []primitive.ObjectID{id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users.idLookups = nil
+	if _, err := cache.Get(ctx, []primitive.ObjectID{a, c}); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.idLookups) != 0 {
+		t.Errorf("recently used entries were evicted: lookups = %v", users.idLookups)
+	}
+	if _, err := cache.Get(ctx, []primitive.ObjectID{b}); err != nil {
+		t.Fatal(err)
+	}
+	if len(users.idLookups) != 1 {
+		t.Errorf("least recently used entry wasn't evicted")
+	}
+}
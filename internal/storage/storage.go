@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// PresignedUpload describes how a client uploads an object directly
+type PresignedUpload struct {
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Storage abstracts where uploaded media bytes live. The local disk
+// implementation is used today; an S3-compatible one can be dropped in later.
+type Storage interface {
+	// PresignUpload returns a time-limited upload target for key
+	PresignUpload(key, contentType string, expiresAt time.Time) (*PresignedUpload, error)
+	// Put stores the object under key
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL the object is served from
+	URL(key string) string
+	// PresignDownload returns a time-limited URL for downloading a private object
+	PresignDownload(key string, expiresAt time.Time) (string, error)
+}
+
+// UploadVerifier is implemented by storages that receive uploads through
+// this server rather than directly (e.g. local disk).
+type UploadVerifier interface {
+	VerifyUpload(key, expires, signature string) error
+}
+
+// DownloadServer is implemented by storages whose presigned downloads are
+// served through this server
+type DownloadServer interface {
+	VerifyDownload(key, expires, signature string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
@@ -0,0 +1,55 @@
+package websocket
+
+import "github.com/gorilla/websocket"
+
+// WebSocket subprotocols a client can ask for in Sec-WebSocket-Protocol.
+// Connections that ask for neither use JSON.
+const (
+	SubprotocolJSON    = "json"
+	SubprotocolMsgpack = "msgpack"
+)
+
+// Codec converts between the JSON frames the hub builds and the wire format
+// of one connection. It is chosen when the connection is upgraded, so clients
+// with different codecs can share a group.
+type Codec interface {
+	// Name labels metrics
+	Name() string
+	// Encode turns a JSON frame into wire data and its WebSocket message type
+	Encode(frame []byte) ([]byte, int, error)
+	// Decode turns wire data from the client into a JSON frame
+	Decode(data []byte) ([]byte, error)
+}
+
+// codecFor returns the codec of a negotiated subprotocol
+func codecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return SubprotocolJSON }
+
+func (jsonCodec) Encode(frame []byte) ([]byte, int, error) {
+	return frame, websocket.TextMessage, nil
+}
+
+func (jsonCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) Encode(frame []byte) ([]byte, int, error) {
+	data, err := jsonToMsgpack(frame)
+	return data, websocket.BinaryMessage, err
+}
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	return msgpackToJSON(data)
+}
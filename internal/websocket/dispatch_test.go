@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/websocket/events"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Every event a service publishes must reach a handler, and every handler
+// must accept the payload registered for its type
+func TestEveryRoutedEventHasAHandler(t *testing.T) {
+	h := &Hub{}
+	for _, eventType := range events.Routed() {
+		handler, ok := eventHandlers[eventType]
+		if !ok {
+			t.Errorf("no handler for %s", eventType)
+			continue
+		}
+		payload, err := events.Decode(models.WebSocketEvent{Type: eventType, Data: []byte("{}")})
+		if err != nil {
+			t.Errorf("%s: %v", eventType, err)
+			continue
+		}
+		if _, err := handler(h, payload); err != nil {
+			t.Errorf("%s: %v", eventType, err)
+		}
+	}
+}
+
+// Routed events arrive through Kafka at one instance only, so each must be
+// relayed to the others
+func TestEveryRoutedEventIsRelayed(t *testing.T) {
+	for _, eventType := range events.Routed() {
+		if !relayedEvents[eventType] {
+			t.Errorf("%s is not relayed to other instances", eventType)
+		}
+	}
+}
+
+func TestEveryHandlerIsRegistered(t *testing.T) {
+	routed := make(map[string]bool)
+	for _, eventType := range events.Routed() {
+		routed[eventType] = true
+	}
+	for eventType := range eventHandlers {
+		if !routed[eventType] {
+			t.Errorf("handler for %s, which isn't a registered routed event", eventType)
+		}
+	}
+}
+
+func TestRemovedMemberStopsReceivingGroupMessages(t *testing.T) {
+	h := &Hub{userClients: make(map[string]map[*Client]bool), groupClients: make(map[string]map[*Client]bool)}
+	groupID, stayingID, removedID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	connect := func(userID primitive.ObjectID) *Client {
+		c := &Client{
+			userID:    userID.Hex(),
+			send:      make(chan []byte, 8),
+			codec:     jsonCodec{},
+			listeners: map[string]bool{groupID.Hex(): true},
+			log:       slog.Default(),
+		}
+		h.addClient(c)
+		return c
+	}
+	staying, removed := connect(stayingID), connect(removedID)
+
+	event, err := events.NewGroupMemberRemoved(models.GroupMembershipEvent{GroupID: groupID, UserID: removedID, ActorID: stayingID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.dispatchEvent(event)
+	for name, c := range map[string]*Client{"staying": staying, "removed": removed} {
+		if frame := <-c.send; !strings.Contains(string(frame), models.EventGroupMemberRemoved) {
+			t.Errorf("%s member got %s, want the removal event", name, frame)
+		}
+	}
+
+	h.sendToClients(h.getClientsByGroup(groupID.Hex()), models.Message{ID: primitive.NewObjectID(), GroupID: groupID, SenderID: stayingID, Content: "after"})
+	if len(removed.send) != 0 {
+		t.Errorf("removed member still received %s", <-removed.send)
+	}
+	if len(staying.send) != 1 {
+		t.Errorf("remaining member got %d frames, want the message", len(staying.send))
+	}
+}
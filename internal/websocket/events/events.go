@@ -0,0 +1,149 @@
+// Package events is the registry of typed events sent to WebSocket clients.
+// Every event type has exactly one payload type; producers build events with
+// the constructors here and the hub decodes them with Decode, so the two
+// sides can't drift apart.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"messaging-app/internal/models"
+)
+
+// ErrUnknownType is returned for event types missing from the registry
+var ErrUnknownType = errors.New("unknown event type")
+
+type registration struct {
+	payload reflect.Type
+	direct  bool // written by the hub to one connection, never routed through Kafka
+}
+
+func register[T any](direct bool) registration {
+	return registration{payload: reflect.TypeOf((*T)(nil)).Elem(), direct: direct}
+}
+
+// registry maps each event type to its payload
+var registry = map[string]registration{
+	models.EventMessagesSeen:           register[models.MessagesSeenEvent](false),
+	models.EventGroupMemberAdded:       register[models.GroupMembershipEvent](false),
+	models.EventGroupMemberRemoved:     register[models.GroupMembershipEvent](false),
+	models.EventMessagePinned:          register[models.MessagePinEvent](false),
+	models.EventMessageUnpinned:        register[models.MessagePinEvent](false),
+	models.EventPreviewReady:           register[models.PreviewReadyEvent](false),
+	models.EventGroupUpdated:           register[models.GroupUpdatedEvent](false),
+	models.EventPollVoted:              register[models.PollEvent](false),
+	models.EventPollClosed:             register[models.PollEvent](false),
+	models.EventMessageDelivered:       register[models.MessageDeliveredEvent](false),
+	models.EventSystemAnnouncement:     register[models.Announcement](false),
+	models.EventConversationUnarchived: register[models.ConversationUnarchivedEvent](false),
+	models.EventPresenceSnapshot:       register[models.PresenceSnapshotEvent](true),
+	models.EventPresenceChanged:        register[models.PresenceChangedEvent](true),
+}
+
+// Routed lists the event types producers publish for the hub to route, sorted
+func Routed() []string {
+	var types []string
+	for eventType, reg := range registry {
+		if !reg.direct {
+			types = append(types, eventType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Registered reports whether eventType is in the registry
+func Registered(eventType string) bool {
+	_, ok := registry[eventType]
+	return ok
+}
+
+// New wraps payload in an event of the given type. The payload must be the
+// type registered for it.
+func New(eventType string, payload any) (models.WebSocketEvent, error) {
+	reg, ok := registry[eventType]
+	if !ok {
+		return models.WebSocketEvent{}, fmt.Errorf("%w: %s", ErrUnknownType, eventType)
+	}
+	if got := reflect.TypeOf(payload); got != reg.payload {
+		return models.WebSocketEvent{}, fmt.Errorf("%s event takes %s, not %v", eventType, reg.payload, got)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.WebSocketEvent{}, err
+	}
+	return models.WebSocketEvent{Type: eventType, Data: data}, nil
+}
+
+// Decode unmarshals an event's payload into a pointer to its registered type
+func Decode(ev models.WebSocketEvent) (any, error) {
+	reg, ok := registry[ev.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, ev.Type)
+	}
+	payload := reflect.New(reg.payload).Interface()
+	if err := json.Unmarshal(ev.Data, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func NewMessagesSeen(seen models.MessagesSeenEvent) (models.WebSocketEvent, error) {
+	return New(models.EventMessagesSeen, seen)
+}
+
+func NewGroupMemberAdded(change models.GroupMembershipEvent) (models.WebSocketEvent, error) {
+	return New(models.EventGroupMemberAdded, change)
+}
+
+func NewGroupMemberRemoved(change models.GroupMembershipEvent) (models.WebSocketEvent, error) {
+	return New(models.EventGroupMemberRemoved, change)
+}
+
+func NewMessagePinned(pin models.MessagePinEvent) (models.WebSocketEvent, error) {
+	return New(models.EventMessagePinned, pin)
+}
+
+func NewMessageUnpinned(pin models.MessagePinEvent) (models.WebSocketEvent, error) {
+	return New(models.EventMessageUnpinned, pin)
+}
+
+func NewPreviewReady(ready models.PreviewReadyEvent) (models.WebSocketEvent, error) {
+	return New(models.EventPreviewReady, ready)
+}
+
+func NewGroupUpdated(update models.GroupUpdatedEvent) (models.WebSocketEvent, error) {
+	return New(models.EventGroupUpdated, update)
+}
+
+func NewPollVoted(poll models.PollEvent) (models.WebSocketEvent, error) {
+	return New(models.EventPollVoted, poll)
+}
+
+func NewPollClosed(poll models.PollEvent) (models.WebSocketEvent, error) {
+	return New(models.EventPollClosed, poll)
+}
+
+func NewMessageDelivered(delivered models.MessageDeliveredEvent) (models.WebSocketEvent, error) {
+	return New(models.EventMessageDelivered, delivered)
+}
+
+func NewSystemAnnouncement(announcement models.Announcement) (models.WebSocketEvent, error) {
+	return New(models.EventSystemAnnouncement, announcement)
+}
+
+func NewConversationUnarchived(unarchived models.ConversationUnarchivedEvent) (models.WebSocketEvent, error) {
+	return New(models.EventConversationUnarchived, unarchived)
+}
+
+func NewPresenceSnapshot(snapshot models.PresenceSnapshotEvent) (models.WebSocketEvent, error) {
+	return New(models.EventPresenceSnapshot, snapshot)
+}
+
+func NewPresenceChanged(change models.PresenceChangedEvent) (models.WebSocketEvent, error) {
+	return New(models.EventPresenceChanged, change)
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"messaging-app/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewAndDecodeRoundTrip(t *testing.T) {
+	pin := models.MessagePinEvent{GroupID: primitive.NewObjectID(), MessageID: primitive.NewObjectID()}
+	ev, err := NewMessagePinned(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != models.EventMessagePinned {
+		t.Fatalf("type = %q, want %q", ev.Type, models.EventMessagePinned)
+	}
+
+	payload, err := Decode(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := payload.(*models.MessagePinEvent)
+	if !ok {
+		t.Fatalf("decoded %T, want *models.MessagePinEvent", payload)
+	}
+	if *decoded != pin {
+		t.Fatalf("decoded %+v, want %+v", *decoded, pin)
+	}
+}
+
+func TestNewRejectsMismatchedPayload(t *testing.T) {
+	if _, err := New(models.EventPollVoted, models.MessagePinEvent{}); err == nil {
+		t.Fatal("expected an error for a payload of the wrong type")
+	}
+	if _, err := New(models.EventPollVoted, &models.PollEvent{}); err == nil {
+		t.Fatal("expected an error for a pointer payload")
+	}
+}
+
+func TestUnknownTypes(t *testing.T) {
+	if _, err := New("PostCreated", struct{}{}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("New error = %v, want ErrUnknownType", err)
+	}
+	if _, err := Decode(models.WebSocketEvent{Type: "PostCreated", Data: []byte("{}")}); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("Decode error = %v, want ErrUnknownType", err)
+	}
+}
+
+func TestEveryRegisteredPayloadDecodes(t *testing.T) {
+	for eventType := range registry {
+		ev := models.WebSocketEvent{Type: eventType, Data: []byte("{}")}
+		if _, err := Decode(ev); err != nil {
+			t.Errorf("%s: %v", eventType, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReadFrameSkipsOversizedFrames(t *testing.T) {
+	frames := make(chan error, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(maxSkippedFrameSize)
+		c := &Client{conn: conn}
+		for i := 0; i < 3; i++ {
+			data, err := c.readFrame(16)
+			if err == nil && string(data) != "small" {
+				err = errors.New("got " + string(data))
+			}
+			frames <- err
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, frame := range []string{strings.Repeat("x", 4096), "small", "small"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := <-frames; !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("oversized frame: got %v, want errFrameTooLarge", err)
+	}
+	// The connection survives and later frames are read whole
+	for i := 0; i < 2; i++ {
+		if err := <-frames; err != nil {
+			t.Errorf("frame after the oversized one: %v", err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEnqueueAppliesSendPolicy(t *testing.T) {
+	t.Run("disconnect", func(t *testing.T) {
+		c := &Client{send: make(chan []byte, 1), policy: SendPolicyDisconnect}
+		dropped := testutil.ToFloat64(wsDroppedFrames.WithLabelValues(dropReasonSlowClient))
+		if got := c.enqueue([]byte("1")); got != enqueued {
+			t.Fatalf("first frame: got %v", got)
+		}
+		if got := c.enqueue([]byte("2")); got != enqueueOverflow {
+			t.Fatalf("full buffer: got %v, want overflow", got)
+		}
+		if got := testutil.ToFloat64(wsDroppedFrames.WithLabelValues(dropReasonSlowClient)) - dropped; got != 1 {
+			t.Errorf("counted %v slow client drops, want 1", got)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		c := &Client{send: make(chan []byte, 2), policy: SendPolicyDropOldest}
+		for _, frame := range []string{"1", "2", "3"} {
+			if got := c.enqueue([]byte(frame)); got != enqueued {
+				t.Fatalf("frame %s: got %v", frame, got)
+			}
+		}
+		if first := string(<-c.send); first != "2" {
+			t.Errorf("oldest queued frame is %q, want 2", first)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		c := &Client{send: make(chan []byte, 1), policy: SendPolicyDropOldest}
+		c.closeWith(nil)
+		c.closeWith(nil)
+		if got := c.enqueue([]byte("1")); got != enqueueClosed {
+			t.Fatalf("got %v, want closed", got)
+		}
+	})
+}
@@ -3,18 +3,28 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"messaging-app/internal/models"
 	"messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
+	"messaging-app/internal/websocket/events"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+	"messaging-app/pkg/middleware"
 	"messaging-app/pkg/utils"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
+	goredis "github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
@@ -25,7 +35,11 @@ var (
 	wsMessagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "websocket_messages_sent_total",
 		Help: "Total number of messages sent via WebSocket",
-	}, []string{"type"})
+	}, []string{"type", "codec"})
+	wsFramesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "websocket_frames_received_total",
+		Help: "Total number of frames received via WebSocket",
+	}, []string{"codec"})
 	pendingDirectMessages = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "pending_direct_messages_total",
 		Help: "Number of pending direct messages",
@@ -39,26 +53,105 @@ var (
 		Help:    "Time from message received to send",
 		Buckets: prometheus.DefBuckets,
 	})
+	wsConnectionsReaped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_connections_reaped_total",
+		Help: "Total number of idle WebSocket connections closed by the cleaner",
+	})
+	wsDroppedFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "websocket_dropped_frames_total",
+		Help: "Total number of frames not delivered to a client, by reason",
+	}, []string{"reason"})
+	wsUnknownEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_unknown_events_total",
+		Help: "Total number of consumed events with a type the hub can't route",
+	})
 )
 
-func init() {
-	prometheus.MustRegister(
-		wsConnections,
-		wsMessagesSent,
-		pendingDirectMessages,
-		pendingGroupMessages,
-		broadcastLatency,
-	)
+var metricsOnce sync.Once
+
+// registerMetrics registers the hub collectors once, however many hubs are created
+func registerMetrics() {
+	metricsOnce.Do(func() {
+		prometheus.MustRegister(
+			wsConnections,
+			wsMessagesSent,
+			wsFramesReceived,
+			pendingDirectMessages,
+			pendingGroupMessages,
+			broadcastLatency,
+			wsConnectionsReaped,
+			wsUnknownEvents,
+			wsDroppedFrames,
+		)
+	})
+}
+
+// MessageBroadcaster is the hub surface the Kafka consumer depends on
+type MessageBroadcaster interface {
+	BroadcastMessage(msg models.Message)
+	BroadcastEvent(ev models.WebSocketEvent)
+}
+
+var _ MessageBroadcaster = (*Hub)(nil)
+
+// MessageSender persists and publishes messages sent over a WebSocket;
+// implemented by services.MessageService
+type MessageSender interface {
+	SendMessage(ctx context.Context, senderID primitive.ObjectID, req models.MessageRequest) (*models.Message, error)
+}
+
+// PushNotifier alerts users with no connection anywhere about a message;
+// implemented by services.PushService
+type PushNotifier interface {
+	NotifyOffline(ctx context.Context, msg models.Message, userIDs []string)
 }
 
+// DeliveryRecorder records that recipients' connections received a message;
+// implemented by services.MessageService
+type DeliveryRecorder interface {
+	MarkDelivered(ctx context.Context, messageID primitive.ObjectID, recipientIDs []primitive.ObjectID)
+}
+
+// ProtocolVersion is the version of the client frame envelope. Frames
+// without a version are treated as version 1.
+const ProtocolVersion = 1
+
+// Frame is the envelope of client frames and of the server's replies to them
+type Frame struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	TempID  string          `json:"tempId,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// AckPayload confirms a client message was stored
+type AckPayload struct {
+	MessageID primitive.ObjectID `json:"message_id"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// Server reply frame types
+const (
+	FrameAck   = "ack"
+	FrameError = "error"
+)
+
 // Client represents a single websocket connection
 type Client struct {
 	userID    string
 	conn      *websocket.Conn
-	send      chan []byte
+	send      chan []byte // JSON frames; codec converts them for the wire
+	policy    SendPolicy  // what to do when send is full
+	codec     Codec
 	lastSeen  time.Time
-	mu        sync.RWMutex // protects lastSeen
+	closed    bool
+	closeOnce sync.Once
+	mu        sync.RWMutex // protects lastSeen and closed, and send against closing
 	listeners map[string]bool
+	requestID string        // of the upgrade request; messages sent on the connection carry it
+	log       *slog.Logger  // records the request ID and user
+	closeMsg  []byte        // close frame writePump sends once send is closed; empty by default
+	done      chan struct{} // closed when writePump has closed the connection
 }
 
 // Hub maintains the set of active clients and broadcasts messages to them.
@@ -67,51 +160,203 @@ type Hub struct {
 	groupClients map[string]map[*Client]bool
 
 	groupRepo    *repositories.GroupRepository
+	userRepo     *repositories.UserRepository
+	messages     MessageSender
+	push         PushNotifier     // optional
+	deliveries   DeliveryRecorder // optional
 	redisClient  *redis.ClusterClient
 	messageCache *MessageCache
+	instanceID   string // identifies this hub in the Redis presence sets and relays
+
+	opts ConnectionOptions
 
 	register     chan *Client
 	unregister   chan *Client
 	Broadcast    chan models.Message
+	Events       chan models.WebSocketEvent
 	typingEvents chan models.TypingEvent
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	closing   chan struct{} // closed by Shutdown; the hub stops registering clients
+	stopped   chan struct{} // closed once run has delivered what was queued and returned
+	closeOnce sync.Once
+
 	mu sync.RWMutex
 }
 
+// CloseReasonRestart is sent with the close frame when the server shuts down,
+// so clients know to reconnect after a pause rather than treat it as an error
+const CloseReasonRestart = "server restarting"
+
+// SendPolicy decides what happens to a client whose send buffer is full
+type SendPolicy string
+
+const (
+	// SendPolicyDisconnect closes the connection; the client reconnects and
+	// catches up from history
+	SendPolicyDisconnect SendPolicy = "disconnect"
+	// SendPolicyDropOldest discards the oldest queued frame to make room
+	SendPolicyDropOldest SendPolicy = "drop_oldest"
+)
+
+// Reasons frames are dropped, as recorded in websocket_dropped_frames_total
+const (
+	dropReasonClosed     = "client_closed"
+	dropReasonOldest     = "dropped_oldest"
+	dropReasonSlowClient = "slow_client_disconnected"
+)
+
+// ConnectionOptions configures the connections a hub serves
+type ConnectionOptions struct {
+	MaxMessageSize int64    // largest frame read from a client
+	AllowedOrigins []string // browser origins allowed to connect; "*" allows any
+	SendBufferSize int      // frames queued per connection
+	SendPolicy     SendPolicy
+}
+
+// DefaultConnectionOptions are used until SetConnectionOptions is called
+var DefaultConnectionOptions = ConnectionOptions{
+	MaxMessageSize: 16384,
+	AllowedOrigins: []string{"*"},
+	SendBufferSize: 256,
+	SendPolicy:     SendPolicyDisconnect,
+}
+
 // NewHub creates a new Hub and starts its goroutines
-func NewHub(redisClient *redis.ClusterClient, groupRepo *repositories.GroupRepository) *Hub {
+func NewHub(redisClient *redis.ClusterClient, groupRepo *repositories.GroupRepository, userRepo *repositories.UserRepository, messages MessageSender, push PushNotifier, deliveries DeliveryRecorder) *Hub {
+	registerMetrics()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	h := &Hub{
 		userClients:  make(map[string]map[*Client]bool),
 		groupClients: make(map[string]map[*Client]bool),
 		groupRepo:    groupRepo,
+		userRepo:     userRepo,
+		messages:     messages,
+		push:         push,
+		deliveries:   deliveries,
 		redisClient:  redisClient,
 		messageCache: NewMessageCache(redisClient),
+		instanceID:   primitive.NewObjectID().Hex(),
+		opts:         DefaultConnectionOptions,
 		register:     make(chan *Client),
 		unregister:   make(chan *Client),
 		Broadcast:    make(chan models.Message, 10000),
+		Events:       make(chan models.WebSocketEvent, 1000),
 		typingEvents: make(chan models.TypingEvent, 1000),
 		ctx:          ctx,
 		cancel:       cancel,
+		closing:      make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
 	go h.run()
 	go h.subscribeToRedis()
 	go h.cleanupStaleConnections()
+	go h.refreshPresence()
 	return h
 }
 
+// SetConnectionOptions configures the connections served from now on. Call
+// it before serving connections.
+func (h *Hub) SetConnectionOptions(opts ConnectionOptions) {
+	h.opts = opts
+}
+
+// BroadcastMessage queues a chat message for delivery to its recipients
+func (h *Hub) BroadcastMessage(msg models.Message) {
+	h.Broadcast <- msg
+}
+
+// relayedEvents are the event types every instance dispatches. Only one
+// instance consumes each event from Kafka, but the users it concerns may be
+// connected to any of them, and membership changes must reach the group
+// listeners of every instance.
+var relayedEvents = map[string]bool{
+	models.EventMessagesSeen:           true,
+	models.EventGroupMemberAdded:       true,
+	models.EventGroupMemberRemoved:     true,
+	models.EventMessageDelivered:       true,
+	models.EventMessagePinned:          true,
+	models.EventMessageUnpinned:        true,
+	models.EventGroupUpdated:           true,
+	models.EventPollVoted:              true,
+	models.EventPollClosed:             true,
+	models.EventPreviewReady:           true,
+	models.EventConversationUnarchived: true,
+	models.EventSystemAnnouncement:     true,
+}
+
+// BroadcastEvent queues a typed real-time event for delivery
+func (h *Hub) BroadcastEvent(ev models.WebSocketEvent) {
+	h.Events <- ev
+	if relayedEvents[ev.Type] {
+		h.relay(hubRelay{Event: &ev})
+	}
+}
+
+// hubRelay is the payload on redis.HubEventsChannel; exactly one of Typing
+// and Event is set. Origin lets an instance skip what it published itself,
+// since it already dispatched it locally.
+type hubRelay struct {
+	Origin string                 `json:"origin"`
+	Typing *models.TypingEvent    `json:"typing,omitempty"`
+	Event  *models.WebSocketEvent `json:"event,omitempty"`
+}
+
+// relay publishes an event that reached this instance to the others
+func (h *Hub) relay(r hubRelay) {
+	r.Origin = h.instanceID
+	data, err := json.Marshal(r)
+	if err != nil {
+		slog.Error("Error marshaling hub relay", "error", err)
+		return
+	}
+	if err := h.redisClient.Publish(h.ctx, redis.HubEventsChannel, data); err != nil {
+		slog.Error("Failed to relay event to other instances", "error", err)
+	}
+}
+
+// dispatchRelay delivers an event published by another instance to the
+// clients connected to this one
+func (h *Hub) dispatchRelay(payload []byte) {
+	var r hubRelay
+	if err := json.Unmarshal(payload, &r); err != nil {
+		slog.Error("Error unmarshaling hub relay", "error", err)
+		return
+	}
+	if r.Origin == h.instanceID {
+		return
+	}
+	switch {
+	case r.Typing != nil:
+		h.typingEvents <- *r.Typing
+	case r.Event != nil:
+		h.Events <- *r.Event
+	default:
+		slog.Warn("Empty hub relay", "origin", r.Origin)
+	}
+}
+
 func (h *Hub) run() {
+	defer close(h.stopped)
 	for {
 		select {
 		case <-h.ctx.Done():
 			return
 
+		case <-h.closing:
+			h.drainQueued()
+			return
+
 		case c := <-h.register:
 			h.addClient(c)
-			go h.sendCachedMessages(c)
+			go func() {
+				// The snapshot is always the first frame on a new connection
+				h.connectPresence(c)
+				h.sendCachedMessages(c)
+			}()
 
 		case c := <-h.unregister:
 			h.removeClient(c)
@@ -119,17 +364,86 @@ func (h *Hub) run() {
 		case msg := <-h.Broadcast:
 			start := time.Now()
 			if err := h.messageCache.Store(h.ctx, msg); err != nil {
-				log.Printf("Failed to cache message: %v", err)
+				slog.Warn("Failed to cache message", "message_id", msg.ID.Hex(), "error", err)
 			}
 			h.dispatchMessage(msg)
 			broadcastLatency.Observe(time.Since(start).Seconds())
 
+		case ev := <-h.Events:
+			h.dispatchEvent(ev)
+
 		case ev := <-h.typingEvents:
 			h.dispatchTypingEvent(ev)
 		}
 	}
 }
 
+// drainQueued delivers the messages and events already queued when the hub
+// shuts down. Clients still get them before their close frame, and messages
+// for anyone else land in the pending sets to be replayed on reconnect.
+func (h *Hub) drainQueued() {
+	for {
+		select {
+		case msg := <-h.Broadcast:
+			if err := h.messageCache.Store(h.ctx, msg); err != nil {
+				slog.Warn("Failed to cache message", "message_id", msg.ID.Hex(), "error", err)
+			}
+			h.dispatchMessage(msg)
+		case ev := <-h.Events:
+			h.dispatchEvent(ev)
+		default:
+			return
+		}
+	}
+}
+
+// Shutdown drains the hub before the process exits. It stops accepting
+// connections, delivers what is queued, sends every client a close frame
+// with CloseServiceRestart and waits until the connections are closed or ctx
+// is done, in which case the rest are dropped. Stop whatever feeds the hub,
+// like the Kafka consumer, before calling it.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.closeOnce.Do(func() { close(h.closing) })
+	defer h.cancel()
+
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	clients := h.getAllClients()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, CloseReasonRestart)
+	offline := make(map[string]bool)
+	for _, c := range clients {
+		removed, last := h.detachClient(c)
+		if !removed {
+			continue
+		}
+		if last {
+			offline[c.userID] = true
+		}
+		c.closeWith(closeMsg)
+	}
+	// The users are online on other instances, if anywhere, until they reconnect
+	for userID := range offline {
+		h.disconnectPresence(userID)
+	}
+
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			for _, c := range clients {
+				c.conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 func (h *Hub) addClient(c *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -146,17 +460,37 @@ func (h *Hub) addClient(c *Client) {
 	wsConnections.Inc()
 }
 
-func (h *Hub) removeClient(c *Client) {
+// removeClient unregisters the client and closes its connection, which also
+// ends its readPump. readPump, the cleaner and slow-client eviction can race
+// to remove the same client, so only the first call does anything; it
+// reports whether this call removed the client.
+func (h *Hub) removeClient(c *Client) bool {
+	removed, last := h.detachClient(c)
+	if !removed {
+		return false
+	}
+	if last {
+		go h.disconnectPresence(c.userID)
+	}
+	c.close()
+	return true
+}
+
+// detachClient takes the client out of the hub's maps without closing it. It
+// reports whether this call removed the client and whether it was the user's
+// last connection to this instance.
+func (h *Hub) detachClient(c *Client) (removed, last bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	// remove from user map
-	if conns, ok := h.userClients[c.userID]; ok {
-		if _, exists := conns[c]; exists {
-			delete(conns, c)
-			if len(conns) == 0 {
-				delete(h.userClients, c.userID)
-			}
-		}
+	conns, ok := h.userClients[c.userID]
+	if !ok || !conns[c] {
+		return false, false
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.userClients, c.userID)
+		last = true
 	}
 	// remove from group maps
 	for gid := range c.listeners {
@@ -170,13 +504,14 @@ func (h *Hub) removeClient(c *Client) {
 		}
 	}
 	wsConnections.Dec()
-	close(c.send)
+	return true, last
 }
 
 func (h *Hub) dispatchMessage(msg models.Message) {
 	// direct
 	if !msg.ReceiverID.IsZero() {
 		h.sendToClients(h.getClientsByUser(msg.ReceiverID.Hex()), msg)
+		h.queuePendingForUser(msg)
 		return
 	}
 	// group
@@ -189,35 +524,107 @@ func (h *Hub) dispatchMessage(msg models.Message) {
 func (h *Hub) sendToClients(clients []*Client, msg models.Message) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		slog.Error("Error marshaling message", "message_id", msg.ID.Hex(), "error", err)
 		return
 	}
+	var delivered []string
 	for _, c := range clients {
-		select {
-		case c.send <- data:
-			c.setLastSeen(time.Now())
-			wsMessagesSent.WithLabelValues(msg.ContentType).Inc()
-		default:
-			h.removeClient(c)
+		if !h.send(c, data, msg.ContentType) {
+			continue
+		}
+		if c.userID != msg.SenderID.Hex() && !slices.Contains(delivered, c.userID) {
+			delivered = append(delivered, c.userID)
+		}
+	}
+	if len(delivered) > 0 {
+		go h.recordDelivery(msg.ID, delivered)
+	}
+}
+
+// recordDelivery marks a message delivered to the given users
+func (h *Hub) recordDelivery(messageID primitive.ObjectID, userIDs []string) {
+	if h.deliveries == nil {
+		return
+	}
+	recipients := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, uid := range userIDs {
+		if id, err := primitive.ObjectIDFromHex(uid); err == nil {
+			recipients = append(recipients, id)
 		}
 	}
+	h.deliveries.MarkDelivered(h.ctx, messageID, recipients)
+}
+
+// queuePendingForUser keeps a direct message in the receiver's pending set
+// when they have no active connection on this instance, so it can be
+// replayed by sendCachedMessages on their next connect.
+func (h *Hub) queuePendingForUser(msg models.Message) {
+	uid := msg.ReceiverID.Hex()
+	h.mu.RLock()
+	_, online := h.userClients[uid]
+	h.mu.RUnlock()
+	if online {
+		return
+	}
+	if err := h.messageCache.AddPendingDirectMessage(h.ctx, uid, msg.ID.Hex()); err != nil {
+		slog.Error("Failed to queue pending message", "message_id", msg.ID.Hex(), "user_id", uid, "error", err)
+		return
+	}
+	pendingDirectMessages.Inc()
+	go h.notifyOffline(msg, []string{uid})
 }
 
 func (h *Hub) queuePendingForGroup(msg models.Message) {
 	members, err := h.getGroupMembers(msg.GroupID.Hex())
 	if err != nil {
-		log.Printf("Error getting group members: %v", err)
+		slog.Error("Error getting group members", "group_id", msg.GroupID.Hex(), "message_id", msg.ID.Hex(), "error", err)
 		return
 	}
+	var offline []string
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	for _, uid := range members {
+		if uid == msg.SenderID.Hex() {
+			continue
+		}
 		if _, online := h.userClients[uid]; !online {
 			if err := h.messageCache.AddPendingDirectMessage(h.ctx, uid, msg.ID.Hex()); err != nil {
-				log.Printf("Failed to queue pending for %s: %v", uid, err)
+				slog.Error("Failed to queue pending message", "message_id", msg.ID.Hex(), "user_id", uid, "error", err)
+				continue
 			}
+			pendingGroupMessages.Inc()
+			offline = append(offline, uid)
 		}
 	}
+	if len(offline) > 0 {
+		go h.notifyOffline(msg, offline)
+	}
+}
+
+// notifyOffline sends push notifications to the users among userIDs who
+// aren't connected to any instance; the others get the message from there
+func (h *Hub) notifyOffline(msg models.Message, userIDs []string) {
+	if h.push == nil {
+		return
+	}
+	online, err := redis.OnlineUsers(h.ctx, h.redisClient.GetClient(), userIDs)
+	if err != nil {
+		slog.Error("Failed to check presence before push", "message_id", msg.ID.Hex(), "error", err)
+		return
+	}
+	connected := make(map[string]bool, len(online))
+	for _, uid := range online {
+		connected[uid] = true
+	}
+	var offline []string
+	for _, uid := range userIDs {
+		if !connected[uid] {
+			offline = append(offline, uid)
+		}
+	}
+	if len(offline) > 0 {
+		h.push.NotifyOffline(h.ctx, msg, offline)
+	}
 }
 
 func (h *Hub) getClientsByUser(uid string) []*Client {
@@ -241,96 +648,230 @@ func (h *Hub) getClientsByGroup(gid string) []*Client {
 }
 
 // sendCachedMessages pushes any pending direct and group messages
-// to the newly registered client.
+// to the newly registered client. Both kinds are queued per user by
+// dispatchMessage, so draining the user's pending set is enough.
 func (h *Hub) sendCachedMessages(client *Client) {
-    ctx := h.ctx
-
-    directIDs, err := h.messageCache.GetPendingDirectMessages(ctx, client.userID)
-    if err != nil {
-        log.Printf("Error fetching direct messages: %v", err)
-    } else {
-        h.sendPendingMessages(client, directIDs, "direct")
-    }
-
-    for groupID := range client.listeners {
-        if groupID == client.userID {
-            continue
-        }
-        groupIDs, err := h.messageCache.GetPendingGroupMessages(ctx, groupID)
-        if err != nil {
-            log.Printf("Error fetching group messages: %v", err)
-            continue
-        }
-        h.sendPendingMessages(client, groupIDs, "group")
-    }
-}
-
-// sendPendingMessages delivers stored messages and cleans up the pending sets.
-func (h *Hub) sendPendingMessages(client *Client, msgIDs []string, msgType string) {
-    ctx := h.ctx
-
-    for _, id := range msgIDs {
-        msg, err := h.messageCache.Get(ctx, id)
-        if err != nil {
-            log.Printf("Error retrieving message %s: %v", id, err)
-            continue
-        }
-
-        // 2) basic delivery check
-        if msgType == "direct" {
-            if msg.ReceiverID.Hex() != client.userID {
-                continue
-            }
-        } else { 
-            if !client.listeners[msg.GroupID.Hex()] {
-                continue
-            }
-        }
-
-        // 3) marshal & send
-        data, err := json.Marshal(msg)
-        if err != nil {
-            log.Printf("Error marshaling message %s: %v", id, err)
-            continue
-        }
-
-        select {
-        case client.send <- data:
-            if msgType == "direct" {
-                if err := h.messageCache.RemovePendingDirectMessage(ctx, client.userID, id); err == nil {
-                    pendingDirectMessages.Dec()
-                }
-            } else {
-                if err := h.messageCache.RemovePendingGroupMessage(ctx, msg.GroupID.Hex(), id); err == nil {
-                    pendingGroupMessages.Dec()
-                }
-            }
-            wsMessagesSent.WithLabelValues(msg.ContentType).Inc()
-
-        default:
-            log.Printf("Client channel full, skipping cached message")
-        }
-    }
+	ids, err := h.messageCache.GetPendingDirectMessages(h.ctx, client.userID)
+	if err != nil {
+		client.log.Error("Error fetching pending messages", "error", err)
+		return
+	}
+	h.sendPendingMessages(client, ids)
+}
+
+// sendPendingMessages delivers stored messages and cleans up the pending set.
+func (h *Hub) sendPendingMessages(client *Client, msgIDs []string) {
+	ctx := h.ctx
+
+	for _, id := range msgIDs {
+		msg, err := h.messageCache.Get(ctx, id)
+		if err != nil {
+			client.log.Warn("Error retrieving pending message", "message_id", id, "error", err)
+			if errors.Is(err, goredis.Nil) {
+				// The cached copy expired; the entry can never be delivered.
+				h.removePending(client.userID, id, nil)
+			}
+			continue
+		}
+
+		// basic delivery check
+		h.mu.RLock()
+		listening := client.listeners[msg.GroupID.Hex()]
+		h.mu.RUnlock()
+		if msg.ReceiverID.Hex() != client.userID && !listening {
+			h.removePending(client.userID, id, nil)
+			continue
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			client.log.Error("Error marshaling pending message", "message_id", id, "error", err)
+			continue
+		}
+
+		// Left pending when the client can't take it; it is replayed next time
+		if !h.send(client, data, msg.ContentType) {
+			continue
+		}
+		h.removePending(client.userID, id, msg)
+		go h.recordDelivery(msg.ID, []string{client.userID})
+	}
+}
+
+// removePending drops msgID from the user's pending set and updates the
+// pending gauges when the message kind is known.
+func (h *Hub) removePending(userID, msgID string, msg *models.Message) {
+	if err := h.messageCache.RemovePendingDirectMessage(h.ctx, userID, msgID); err != nil {
+		slog.Error("Failed to remove pending message", "message_id", msgID, "user_id", userID, "error", err)
+		return
+	}
+	if msg == nil {
+		return
+	}
+	if msg.GroupID.IsZero() {
+		pendingDirectMessages.Dec()
+	} else {
+		pendingGroupMessages.Dec()
+	}
+}
+
+// eventHandler picks the clients an event goes to from its decoded payload
+type eventHandler func(h *Hub, payload any) ([]*Client, error)
+
+// routeEvent builds an eventHandler for events whose registered payload is T
+func routeEvent[T any](recipients func(h *Hub, payload T) []*Client) eventHandler {
+	return func(h *Hub, payload any) ([]*Client, error) {
+		typed, ok := payload.(*T)
+		if !ok {
+			return nil, fmt.Errorf("unexpected payload %T", payload)
+		}
+		return recipients(h, *typed), nil
+	}
+}
+
+func toGroup(h *Hub, groupID primitive.ObjectID) []*Client {
+	return h.getClientsByGroup(groupID.Hex())
+}
+
+// getAllClients lists every connection to this hub
+func (h *Hub) getAllClients() []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var list []*Client
+	for _, conns := range h.userClients {
+		for c := range conns {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// eventHandlers routes each typed event to the users it concerns
+var eventHandlers = map[string]eventHandler{
+	models.EventMessagesSeen: routeEvent(func(h *Hub, seen models.MessagesSeenEvent) []*Client {
+		var clients []*Client
+		for _, senderID := range seen.SenderIDs {
+			if senderID != seen.ReaderID {
+				clients = append(clients, h.getClientsByUser(senderID.Hex())...)
+			}
+		}
+		return clients
+	}),
+	models.EventGroupMemberAdded: routeEvent(func(h *Hub, change models.GroupMembershipEvent) []*Client {
+		h.updateMembership(change, true)
+		return toGroup(h, change.GroupID)
+	}),
+	models.EventGroupMemberRemoved: routeEvent(func(h *Hub, change models.GroupMembershipEvent) []*Client {
+		// The removed user's connections hear about it too, so they can drop the group
+		removed := h.updateMembership(change, false)
+		return append(toGroup(h, change.GroupID), removed...)
+	}),
+	models.EventMessagePinned: routeEvent(func(h *Hub, pin models.MessagePinEvent) []*Client {
+		return toGroup(h, pin.GroupID)
+	}),
+	models.EventMessageUnpinned: routeEvent(func(h *Hub, pin models.MessagePinEvent) []*Client {
+		return toGroup(h, pin.GroupID)
+	}),
+	models.EventPollVoted: routeEvent(func(h *Hub, poll models.PollEvent) []*Client {
+		return toGroup(h, poll.GroupID)
+	}),
+	models.EventPollClosed: routeEvent(func(h *Hub, poll models.PollEvent) []*Client {
+		return toGroup(h, poll.GroupID)
+	}),
+	models.EventGroupUpdated: routeEvent(func(h *Hub, update models.GroupUpdatedEvent) []*Client {
+		return toGroup(h, update.GroupID)
+	}),
+	models.EventMessageDelivered: routeEvent(func(h *Hub, delivered models.MessageDeliveredEvent) []*Client {
+		return h.getClientsByUser(delivered.SenderID.Hex())
+	}),
+	models.EventConversationUnarchived: routeEvent(func(h *Hub, unarchived models.ConversationUnarchivedEvent) []*Client {
+		return h.getClientsByUser(unarchived.UserID.Hex())
+	}),
+	models.EventSystemAnnouncement: routeEvent(func(h *Hub, _ models.Announcement) []*Client {
+		return h.getAllClients()
+	}),
+	models.EventPreviewReady: routeEvent(func(h *Hub, ready models.PreviewReadyEvent) []*Client {
+		if !ready.GroupID.IsZero() {
+			return toGroup(h, ready.GroupID)
+		}
+		return append(h.getClientsByUser(ready.SenderID.Hex()), h.getClientsByUser(ready.ReceiverID.Hex())...)
+	}),
+}
+
+// dispatchEvent routes typed events to the users they concern
+func (h *Hub) dispatchEvent(ev models.WebSocketEvent) {
+	handler, ok := eventHandlers[ev.Type]
+	if !ok {
+		wsUnknownEvents.Inc()
+		slog.Warn("Unknown event type", "type", ev.Type)
+		return
+	}
+	payload, err := events.Decode(ev)
+	if err != nil {
+		slog.Error("Error unmarshaling event", "type", ev.Type, "error", err)
+		return
+	}
+	clients, err := handler(h, payload)
+	if err != nil {
+		slog.Error("Error routing event", "type", ev.Type, "error", err)
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Error marshaling event", "type", ev.Type, "error", err)
+		return
+	}
+	h.sendRaw(clients, data, ev.Type)
+}
+
+// updateMembership subscribes or unsubscribes the user's open connections to
+// a group and returns those connections
+func (h *Hub) updateMembership(change models.GroupMembershipEvent, joined bool) []*Client {
+	gid := change.GroupID.Hex()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var clients []*Client
+	for c := range h.userClients[change.UserID.Hex()] {
+		clients = append(clients, c)
+		if joined {
+			c.listeners[gid] = true
+			if _, ok := h.groupClients[gid]; !ok {
+				h.groupClients[gid] = make(map[*Client]bool)
+			}
+			h.groupClients[gid][c] = true
+			continue
+		}
+		delete(c.listeners, gid)
+		if conns, ok := h.groupClients[gid]; ok {
+			delete(conns, c)
+			if len(conns) == 0 {
+				delete(h.groupClients, gid)
+			}
+		}
+	}
+	return clients
 }
 
+// sendRaw pushes an already encoded frame to the given clients
+func (h *Hub) sendRaw(clients []*Client, data []byte, label string) {
+	for _, c := range clients {
+		h.send(c, data, label)
+	}
+}
 
 func (h *Hub) dispatchTypingEvent(ev models.TypingEvent) {
 	clients := h.getClientsByGroup(ev.ConversationID)
 	data, err := json.Marshal(ev)
 	if err != nil {
-		log.Printf("Error marshaling typing event: %v", err)
+		slog.Error("Error marshaling typing event", "error", err)
 		return
 	}
 	for _, c := range clients {
 		if c.userID == ev.UserID {
 			continue
 		}
-		select {
-		case c.send <- data:
-			c.setLastSeen(time.Now())
-		default:
-			h.removeClient(c)
-		}
+		h.send(c, data, "typing")
 	}
 }
 
@@ -358,14 +899,16 @@ func (h *Hub) cleanupStaleConnections() {
 			}
 			h.mu.RUnlock()
 			for _, c := range stale {
-				h.removeClient(c)
+				if h.removeClient(c) {
+					wsConnectionsReaped.Inc()
+				}
 			}
 		}
 	}
 }
 
 func (h *Hub) subscribeToRedis() {
-	pubsub := h.redisClient.Subscribe(h.ctx, "messages")
+	pubsub := h.redisClient.Subscribe(h.ctx, "messages", redis.PresenceChannel, redis.HubEventsChannel)
 	defer pubsub.Close()
 	ch := pubsub.Channel()
 	for {
@@ -376,22 +919,209 @@ func (h *Hub) subscribeToRedis() {
 			if !ok {
 				return
 			}
-			var m models.Message
-			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
-				log.Printf("Error unmarshaling Redis message: %v", err)
-				continue
+			switch msg.Channel {
+			case redis.PresenceChannel:
+				h.dispatchPresence([]byte(msg.Payload))
+			case redis.HubEventsChannel:
+				h.dispatchRelay([]byte(msg.Payload))
+			default:
+				var m models.Message
+				if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+					slog.Error("Error unmarshaling Redis message", "channel", msg.Channel, "error", err)
+					continue
+				}
+				if m.ID.IsZero() {
+					slog.Warn("Ignoring non-message payload", "channel", msg.Channel)
+					continue
+				}
+				h.Broadcast <- m
 			}
-			h.Broadcast <- m
 		}
 	}
 }
 
 func (h *Hub) getGroupMembers(groupID string) ([]string, error) {
-	return h.redisClient.SMembers(context.Background(), "group:members:"+groupID).Result()
+	members, ok, err := redis.GetGroupMembers(h.ctx, h.redisClient.GetClient(), groupID)
+	if err == nil && ok {
+		return members, nil
+	}
+
+	gID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return nil, err
+	}
+	group, err := h.groupRepo.GetGroup(h.ctx, gID)
+	if err != nil {
+		return nil, err
+	}
+	if err := redis.CacheGroupMembers(h.ctx, h.redisClient.GetClient(), groupID, group.Members); err != nil {
+		slog.Warn("Failed to cache group members", "group_id", groupID, "error", err)
+	}
+
+	members = make([]string, len(group.Members))
+	for i, m := range group.Members {
+		members[i] = m.Hex()
+	}
+	return members, nil
 }
 
-// MessageCache handles storing and retrieving messages and pending queues
+// connectPresence marks the user online on this instance, tells their friends
+// if they just came online anywhere and sends the connection the friends that
+// are online
+func (h *Hub) connectPresence(c *Client) {
+	cameOnline, err := redis.MarkOnline(h.ctx, h.redisClient.GetClient(), c.userID, h.instanceID)
+	if err != nil {
+		c.log.Error("Failed to mark user online", "error", err)
+	} else if cameOnline {
+		h.publishPresence(c.userID, true)
+	}
+
+	friends, err := h.getFriends(c.userID)
+	if err != nil {
+		c.log.Error("Error loading friends", "error", err)
+		return
+	}
+	online, err := redis.OnlineUsers(h.ctx, h.redisClient.GetClient(), friends)
+	if err != nil {
+		c.log.Error("Error loading online friends", "error", err)
+		return
+	}
+	if online == nil {
+		online = []string{}
+	}
+
+	data, err := encodeEvent(models.EventPresenceSnapshot, models.PresenceSnapshotEvent{OnlineFriends: online})
+	if err != nil {
+		c.log.Error("Error marshaling event", "type", models.EventPresenceSnapshot, "error", err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.userClients[c.userID][c] {
+		h.send(c, data, models.EventPresenceSnapshot)
+	}
+}
+
+// disconnectPresence runs after the user's last connection to this instance
+// closed and tells their friends if they are now offline everywhere
+func (h *Hub) disconnectPresence(userID string) {
+	h.mu.RLock()
+	_, reconnected := h.userClients[userID]
+	h.mu.RUnlock()
+	if reconnected {
+		return
+	}
+
+	wentOffline, err := redis.MarkOffline(h.ctx, h.redisClient.GetClient(), userID, h.instanceID)
+	if err != nil {
+		slog.Error("Failed to mark user offline", "user_id", userID, "error", err)
+		return
+	}
+	if wentOffline {
+		h.publishPresence(userID, false)
+	}
+}
+
+// publishPresence fans a presence change out to every instance, including
+// this one, so each can notify the friends connected to it
+func (h *Hub) publishPresence(userID string, online bool) {
+	data, err := json.Marshal(models.PresenceChangedEvent{UserID: userID, Online: online, At: time.Now()})
+	if err != nil {
+		slog.Error("Error marshaling presence change", "user_id", userID, "error", err)
+		return
+	}
+	if err := h.redisClient.Publish(h.ctx, redis.PresenceChannel, data); err != nil {
+		slog.Error("Failed to publish presence", "user_id", userID, "error", err)
+	}
+}
+
+// dispatchPresence pushes a presence change to the user's friends connected
+// to this instance
+func (h *Hub) dispatchPresence(payload []byte) {
+	var change models.PresenceChangedEvent
+	if err := json.Unmarshal(payload, &change); err != nil {
+		slog.Error("Error unmarshaling presence change", "error", err)
+		return
+	}
+	friends, err := h.getFriends(change.UserID)
+	if err != nil {
+		slog.Error("Error loading friends", "user_id", change.UserID, "error", err)
+		return
+	}
+	data, err := encodeEvent(models.EventPresenceChanged, change)
+	if err != nil {
+		slog.Error("Error marshaling event", "type", models.EventPresenceChanged, "user_id", change.UserID, "error", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, friendID := range friends {
+		for c := range h.userClients[friendID] {
+			h.send(c, data, models.EventPresenceChanged)
+		}
+	}
+}
+
+// refreshPresence keeps the presence of connected users from expiring
+func (h *Hub) refreshPresence() {
+	ticker := time.NewTicker(redis.PresenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.mu.RLock()
+			userIDs := make([]string, 0, len(h.userClients))
+			for uid := range h.userClients {
+				userIDs = append(userIDs, uid)
+			}
+			h.mu.RUnlock()
+			if err := redis.RefreshPresence(h.ctx, h.redisClient.GetClient(), h.instanceID, userIDs); err != nil {
+				slog.Error("Failed to refresh presence", "users", len(userIDs), "error", err)
+			}
+		}
+	}
+}
+
+// getFriends returns the user's friend IDs, cached in Redis so presence
+// changes don't hit MongoDB
+func (h *Hub) getFriends(userID string) ([]string, error) {
+	friends, ok, err := redis.GetFriends(h.ctx, h.redisClient.GetClient(), userID)
+	if err == nil && ok {
+		return friends, nil
+	}
+
+	uID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := h.userRepo.GetFriendIDs(h.ctx, uID)
+	if err != nil {
+		return nil, err
+	}
+	if err := redis.CacheFriends(h.ctx, h.redisClient.GetClient(), userID, ids); err != nil {
+		slog.Warn("Failed to cache friends", "user_id", userID, "error", err)
+	}
+
+	friends = make([]string, len(ids))
+	for i, id := range ids {
+		friends[i] = id.Hex()
+	}
+	return friends, nil
+}
+
+// encodeEvent wraps data in the typed event envelope
+func encodeEvent(eventType string, data interface{}) ([]byte, error) {
+	ev, err := events.New(eventType, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ev)
+}
 
+// MessageCache handles storing and retrieving messages and pending queues
 
 type MessageCache struct {
 	redis *redis.ClusterClient
@@ -407,16 +1137,7 @@ func (mc *MessageCache) Store(ctx context.Context, msg models.Message) error {
 		return err
 	}
 	key := "msg:" + msg.ID.Hex()
-	if err := mc.redis.Set(ctx, key, data, 24*time.Hour); err != nil {
-		return err
-	}
-	if !msg.ReceiverID.IsZero() {
-		return mc.AddPendingDirectMessage(ctx, msg.ReceiverID.Hex(), msg.ID.Hex())
-	}
-	if !msg.GroupID.IsZero() {
-		return mc.AddPendingGroupMessage(ctx, msg.GroupID.Hex(), msg.ID.Hex())
-	}
-	return nil
+	return mc.redis.Set(ctx, key, data, 24*time.Hour)
 }
 
 func (mc *MessageCache) Get(ctx context.Context, msgID string) (*models.Message, error) {
@@ -459,26 +1180,34 @@ func (mc *MessageCache) RemovePendingGroupMessage(ctx context.Context, groupID,
 func ServeWs(c *gin.Context, hub *Hub) {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
-			// TODO: restrict allowed origins
-			return true
+			// Only browsers send an Origin; other clients authenticate by token alone
+			origin := r.Header.Get("Origin")
+			return origin == "" || middleware.OriginAllowed(hub.opts.AllowedOrigins, origin)
 		},
+		// In order of preference when a client offers both
+		Subprotocols: []string{SubprotocolMsgpack, SubprotocolJSON},
+	}
+	logger := logging.FromContext(c.Request.Context())
+	if hub.isClosing() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": CloseReasonRestart})
+		return
 	}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("Upgrade error: %v", err)
+		logger.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 
 	userID, err := utils.GetUserIDFromContext(c)
 	if err != nil || userID.IsZero() {
-		log.Printf("Unauthorized WS attempt")
+		logger.Warn("Unauthorized WebSocket attempt")
 		conn.Close()
 		return
 	}
 
 	groups, err := hub.groupRepo.GetUserGroups(c.Request.Context(), userID)
 	if err != nil {
-		log.Printf("Error fetching groups: %v", err)
+		logger.Error("Error fetching groups", "error", err)
 	}
 	listeners := make(map[string]bool)
 	for _, g := range groups {
@@ -488,26 +1217,68 @@ func ServeWs(c *gin.Context, hub *Hub) {
 	client := &Client{
 		userID:    userID.Hex(),
 		conn:      conn,
-		send:      make(chan []byte, 256),
+		send:      make(chan []byte, hub.opts.SendBufferSize),
+		policy:    hub.opts.SendPolicy,
+		codec:     codecFor(conn.Subprotocol()),
 		lastSeen:  time.Now(),
 		listeners: listeners,
+		requestID: logging.RequestID(c.Request.Context()),
+		log:       logger,
+		done:      make(chan struct{}),
+	}
+	select {
+	case hub.register <- client:
+	case <-hub.closing:
+		// Shutdown started during the upgrade
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, CloseReasonRestart))
+		conn.Close()
+		return
 	}
-	hub.register <- client
 	go client.writePump()
 	go client.readPump(hub)
 }
 
 // readPump pumps messages from the websocket connection to the Hub
+// maxSkippedFrameSize is the largest oversized frame readPump discards
+// instead of closing the connection
+const maxSkippedFrameSize = 1 << 20
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// readFrame reads the next frame. A frame over limit bytes is discarded as it
+// arrives, without being buffered, and reported as errFrameTooLarge.
+func (c *Client) readFrame(limit int64) ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, err
+		}
+		return nil, errFrameTooLarge
+	}
+	return data, nil
+}
+
 func (c *Client) readPump(h *Hub) {
-	const (
-		pongWait   = 60 * time.Second
-		maxMsgSize = 512
-	)
+	const pongWait = 60 * time.Second
 	defer func() {
-		h.unregister <- c
-		c.conn.Close()
+		select {
+		case h.unregister <- c:
+			c.conn.Close()
+		case <-h.stopped:
+			// Shutdown closes the connection after its close frame is written
+			h.removeClient(c)
+		}
 	}()
-	c.conn.SetReadLimit(maxMsgSize)
+	// Oversized frames up to this much are skipped with an error frame;
+	// beyond it the connection is closed
+	c.conn.SetReadLimit(max(h.opts.MaxMessageSize, maxSkippedFrameSize))
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -515,57 +1286,162 @@ func (c *Client) readPump(h *Hub) {
 		return nil
 	})
 	for {
-		_, msgBytes, err := c.conn.ReadMessage()
+		data, err := c.readFrame(h.opts.MaxMessageSize)
+		if errors.Is(err, errFrameTooLarge) {
+			c.log.Warn("Skipping oversized frame", "limit", h.opts.MaxMessageSize)
+			h.replyError(c, "", apperrors.Validation("frame too large").WithDetails(map[string]string{
+				"frame": fmt.Sprintf("must be at most %d bytes", h.opts.MaxMessageSize),
+			}))
+			continue
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WS read error: %v", err)
+				c.log.Warn("WebSocket read error", "error", err)
 			}
 			break
 		}
-		var env struct {
-			Type    string          `json:"type"`
-			Payload json.RawMessage `json:"payload"`
+		wsFramesReceived.WithLabelValues(c.codec.Name()).Inc()
+		msgBytes, err := c.codec.Decode(data)
+		if err != nil {
+			c.log.Warn("Invalid frame", "codec", c.codec.Name(), "error", err)
+			h.replyError(c, "", apperrors.Validation("invalid frame"))
+			continue
 		}
+		var env Frame
 		if err := json.Unmarshal(msgBytes, &env); err != nil {
-			log.Printf("Invalid message: %v", err)
+			c.log.Warn("Invalid frame envelope", "error", err)
+			h.replyError(c, "", apperrors.Validation("invalid frame"))
+			continue
+		}
+		if env.V != 0 && env.V != ProtocolVersion {
+			h.replyError(c, env.TempID, apperrors.Validation("unsupported protocol version"))
 			continue
 		}
 		switch env.Type {
 		case "typing":
 			var e models.TypingEvent
 			if err := json.Unmarshal(env.Payload, &e); err == nil && e.ConversationID != "" {
-				h.typingEvents <- models.TypingEvent{UserID: c.userID, ConversationID: e.ConversationID, IsTyping: e.IsTyping, Timestamp: time.Now().Unix()}
+				ev := models.TypingEvent{UserID: c.userID, ConversationID: e.ConversationID, IsTyping: e.IsTyping, Timestamp: time.Now().Unix()}
+				h.typingEvents <- ev
+				h.relay(hubRelay{Typing: &ev})
 			}
 		case "message":
-			var m models.Message
-			if err := json.Unmarshal(env.Payload, &m); err == nil && m.Content != "" && m.SenderID.Hex() == c.userID {
-				h.Broadcast <- m
-			}
+			h.handleClientMessage(c, env)
 		case "presence":
 			c.setLastSeen(time.Now())
 		default:
-			log.Printf("Unknown type: %s", env.Type)
+			c.log.Warn("Unknown frame type", "type", env.Type)
 		}
 	}
 }
 
+// handleClientMessage stores a message sent over the socket through the same
+// path as the REST API and acks it with the persisted ID. Delivery to the
+// recipients happens through Kafka like any other message.
+func (h *Hub) handleClientMessage(c *Client, env Frame) {
+	if env.TempID == "" {
+		h.replyError(c, "", apperrors.Validation("tempId is required"))
+		return
+	}
+
+	var req models.MessageRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		h.replyError(c, env.TempID, apperrors.Validation("invalid message payload"))
+		return
+	}
+
+	senderID, err := primitive.ObjectIDFromHex(c.userID)
+	if err != nil {
+		h.replyError(c, env.TempID, apperrors.Validation("invalid user ID"))
+		return
+	}
+	req.SenderID = c.userID
+
+	ctx, cancel := context.WithTimeout(h.ctx, 10*time.Second)
+	defer cancel()
+	ctx = logging.With(logging.WithRequestID(ctx, c.requestID), "user_id", c.userID)
+	msg, err := h.messages.SendMessage(ctx, senderID, req)
+	if err != nil {
+		h.replyError(c, env.TempID, err)
+		return
+	}
+
+	payload, err := json.Marshal(AckPayload{MessageID: msg.ID, CreatedAt: msg.CreatedAt})
+	if err != nil {
+		c.log.Error("Error marshaling ack", "message_id", msg.ID.Hex(), "error", err)
+		return
+	}
+	h.reply(c, Frame{V: ProtocolVersion, Type: FrameAck, TempID: env.TempID, Payload: payload})
+}
+
+// replyError tells the client a frame was rejected, using the API error envelope
+func (h *Hub) replyError(c *Client, tempID string, err error) {
+	payload, mErr := json.Marshal(apperrors.ToResponse(err))
+	if mErr != nil {
+		c.log.Error("Error marshaling error frame", "error", mErr)
+		return
+	}
+	h.reply(c, Frame{V: ProtocolVersion, Type: FrameError, TempID: tempID, Payload: payload})
+}
+
+// reply sends a frame to one connection. It holds the hub lock so the
+// connection can't be removed, and its send channel closed, mid-send.
+func (h *Hub) reply(c *Client, frame Frame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		c.log.Error("Error marshaling frame", "type", frame.Type, "error", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.userClients[c.userID][c] {
+		return
+	}
+	h.send(c, data, frame.Type)
+}
+
+// send queues data for c without blocking; every frame to a client goes
+// through it. A client whose buffer is full is handled by its send policy,
+// and one that has to go is removed in the background, since callers may
+// hold the hub lock. It reports whether the frame was queued.
+func (h *Hub) send(c *Client, data []byte, label string) bool {
+	switch c.enqueue(data) {
+	case enqueued:
+		c.setLastSeen(time.Now())
+		wsMessagesSent.WithLabelValues(label, c.codec.Name()).Inc()
+		return true
+	case enqueueOverflow:
+		c.log.Warn("Disconnecting slow client", "type", label)
+		go h.removeClient(c)
+	}
+	return false
+}
+
 // writePump pumps messages from the Hub to the websocket connection
 func (c *Client) writePump() {
 	const pingPeriod = (60 * time.Second * 9) / 10
 	ticker := time.NewTicker(pingPeriod)
-	defer func() { ticker.Stop(); c.conn.Close() }()
+	defer func() { ticker.Stop(); c.conn.Close(); close(c.done) }()
 	for {
 		select {
 		case msg, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.mu.RLock()
+				closeMsg := c.closeMsg
+				c.mu.RUnlock()
+				c.conn.WriteMessage(websocket.CloseMessage, closeMsg)
+				return
+			}
+			data, messageType, err := c.codec.Encode(msg)
+			if err != nil {
+				c.log.Error("Error encoding frame", "codec", c.codec.Name(), "error", err)
+				continue
+			}
+			if err := c.conn.WriteMessage(messageType, data); err != nil {
 				return
 			}
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil { return }
-			w.Write(msg)
-			if err := w.Close(); err != nil { return }
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -575,6 +1451,70 @@ func (c *Client) writePump() {
 	}
 }
 
+type enqueueResult int
+
+const (
+	enqueued        enqueueResult = iota
+	enqueueClosed                 // the client was already removed
+	enqueueOverflow               // the buffer is full and the policy is to disconnect
+)
+
+// enqueue queues a frame for writePump without blocking, applying the
+// client's send policy when the buffer is full
+func (c *Client) enqueue(data []byte) enqueueResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		wsDroppedFrames.WithLabelValues(dropReasonClosed).Inc()
+		return enqueueClosed
+	}
+	for {
+		select {
+		case c.send <- data:
+			return enqueued
+		default:
+		}
+		if c.policy != SendPolicyDropOldest {
+			wsDroppedFrames.WithLabelValues(dropReasonSlowClient).Inc()
+			return enqueueOverflow
+		}
+		// writePump may take the oldest first, which makes room just the same
+		select {
+		case <-c.send:
+			wsDroppedFrames.WithLabelValues(dropReasonOldest).Inc()
+		default:
+		}
+	}
+}
+
+// close stops writePump and closes the connection; removeClient calls it once
+func (c *Client) close() {
+	c.closeWith(nil)
+	c.conn.Close()
+}
+
+// closeWith stops writePump once it has written the frames already queued,
+// followed by a close frame carrying closeMsg. It is the only place the send
+// channel is closed, and only the first call does anything.
+func (c *Client) closeWith(closeMsg []byte) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.closeMsg = closeMsg
+		close(c.send)
+		c.mu.Unlock()
+	})
+}
+
+func (h *Hub) isClosing() bool {
+	select {
+	case <-h.closing:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Client) setLastSeen(t time.Time) {
 	c.mu.Lock()
 	c.lastSeen = t
@@ -0,0 +1,107 @@
+// Package apperrors defines typed application errors and how they map to
+// HTTP responses.
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error kinds. Match them with errors.Is.
+var (
+	ErrValidation    = errors.New("validation_error")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrNotFound      = errors.New("not_found")
+	ErrConflict      = errors.New("conflict")
+	ErrUnprocessable = errors.New("unprocessable_entity")
+	ErrInternal      = errors.New("internal_error")
+)
+
+// Error is an error of a given kind with a message safe to show to clients.
+// Error() returns only the message so existing callers comparing error
+// strings keep working.
+type Error struct {
+	Kind    error
+	Message string
+	Details map[string]string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// WithDetails returns a copy of e carrying per-field details
+func (e *Error) WithDetails(details map[string]string) *Error {
+	return &Error{Kind: e.Kind, Message: e.Message, Details: details}
+}
+
+func Validation(message string) *Error {
+	return &Error{Kind: ErrValidation, Message: message}
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Kind: ErrUnauthorized, Message: message}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: ErrForbidden, Message: message}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+func Conflict(message string) *Error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+// Unprocessable is for well-formed requests whose content is refused
+func Unprocessable(message string) *Error {
+	return &Error{Kind: ErrUnprocessable, Message: message}
+}
+
+// Response is the JSON envelope every migrated endpoint returns on error
+type Response struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Status returns the HTTP status for err; untyped errors are internal errors
+func Status(err error) int {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrUnprocessable):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ToResponse builds the envelope for err. Untyped errors don't leak their
+// message since it may contain internal details.
+func ToResponse(err error) Response {
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		return Response{Code: ErrInternal.Error(), Message: "internal server error"}
+	}
+	return Response{
+		Code:    appErr.Kind.Error(),
+		Message: appErr.Message,
+		Details: appErr.Details,
+	}
+}
@@ -0,0 +1,24 @@
+// Package clientinfo carries where a request came from through contexts, so
+// services can record it without taking HTTP types.
+package clientinfo
+
+import "context"
+
+// Info describes the client behind a request
+type Info struct {
+	IP        string
+	UserAgent string
+}
+
+type contextKey struct{}
+
+// With returns a context carrying info
+func With(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the client info carried by ctx, or the zero Info
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
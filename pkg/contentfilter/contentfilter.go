@@ -0,0 +1,179 @@
+// Package contentfilter checks text against a list of banned words and
+// patterns. Text is normalized before matching, so look-alike characters,
+// accents, invisible characters and common substitutions such as "3" for "e"
+// don't get around the list.
+package contentfilter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Verdict is the outcome of checking a text
+type Verdict string
+
+const (
+	Clean   Verdict = "clean"
+	Matched Verdict = "matched"
+)
+
+// What a deployment does with matching content: nothing, mark it for
+// moderators, or reject it
+const (
+	PolicyOff   = "off"
+	PolicyFlag  = "flag"
+	PolicyBlock = "block"
+)
+
+// patternPrefix marks list lines that are regular expressions rather than words
+const patternPrefix = "re:"
+
+// Filter checks text. The matches are the list entries the text matched,
+// never the text itself.
+type Filter interface {
+	Check(ctx context.Context, text string) (Verdict, []string)
+}
+
+// ListFilter matches the words, phrases and patterns of a list file, one per
+// line. Blank lines and lines starting with "#" are skipped; lines starting
+// with "re:" are regular expressions, matched against the normalized text:
+// lowercase letters and digits separated by single spaces. Words and phrases
+// match whole words only.
+type ListFilter struct {
+	path          string
+	substitutions map[rune]rune
+	list          atomic.Pointer[list]
+}
+
+type list struct {
+	terms    map[string]string // normalized term -> as listed
+	patterns []*regexp.Regexp
+}
+
+// NewListFilter loads the list at path. substitutions are "from=to" pairs of
+// single characters, undone before matching: "4=a" reads "h4te" as "hate".
+func NewListFilter(path string, substitutions []string) (*ListFilter, error) {
+	subs, err := ParseSubstitutions(substitutions)
+	if err != nil {
+		return nil, err
+	}
+	f := &ListFilter{path: path, substitutions: subs}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the list file again. On error the current list stays in use.
+func (f *ListFilter) Reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	l := &list{terms: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if source, ok := strings.CutPrefix(line, patternPrefix); ok {
+			pattern, err := regexp.Compile(source)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", f.path, n, err)
+			}
+			l.patterns = append(l.patterns, pattern)
+			continue
+		}
+		if term := strings.TrimSpace(f.normalize(line)); term != "" {
+			l.terms[term] = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	f.list.Store(l)
+	return nil
+}
+
+// Check reports which list entries text matches
+func (f *ListFilter) Check(ctx context.Context, text string) (Verdict, []string) {
+	l := f.list.Load()
+	normalized := strings.TrimSpace(f.normalize(text))
+
+	var matches []string
+	seen := make(map[string]bool)
+	match := func(entry string) {
+		if !seen[entry] {
+			seen[entry] = true
+			matches = append(matches, entry)
+		}
+	}
+	// Padding both sides makes terms match whole words only
+	padded := " " + normalized + " "
+	for term, entry := range l.terms {
+		if strings.Contains(padded, " "+term+" ") {
+			match(entry)
+		}
+	}
+	for _, pattern := range l.patterns {
+		if pattern.MatchString(normalized) {
+			match(patternPrefix + pattern.String())
+		}
+	}
+
+	if len(matches) == 0 {
+		return Clean, nil
+	}
+	return Matched, matches
+}
+
+// normalize folds text to lowercase letters and digits separated by single
+// spaces. Compatibility forms (fullwidth and styled letters, ligatures) become
+// plain letters, accents and invisible characters are dropped, and
+// substitutions are undone.
+func (f *ListFilter) normalize(text string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range norm.NFKD.String(text) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if to, ok := f.substitutions[r]; ok {
+			r = to
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// ParseSubstitutions parses "from=to" pairs of single characters
+func ParseSubstitutions(pairs []string) (map[rune]rune, error) {
+	subs := make(map[rune]rune, len(pairs))
+	for _, pair := range pairs {
+		from, to, ok := strings.Cut(pair, "=")
+		fromRunes, toRunes := []rune(from), []rune(to)
+		if !ok || len(fromRunes) != 1 || len(toRunes) != 1 {
+			return nil, fmt.Errorf("invalid substitution %q, want one character on each side of =", pair)
+		}
+		subs[unicode.ToLower(fromRunes[0])] = unicode.ToLower(toRunes[0])
+	}
+	return subs, nil
+}
@@ -0,0 +1,103 @@
+package contentfilter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var defaultSubstitutions = []string{"0=o", "1=i", "3=e", "4=a", "5=s", "7=t", "@=a", "$=s"}
+
+func newTestFilter(t *testing.T, list string, substitutions []string) (*ListFilter, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewListFilter(path, substitutions)
+	if err != nil {
+		t.Fatalf("NewListFilter: %v", err)
+	}
+	return f, path
+}
+
+func TestCheckSeesThroughEvasion(t *testing.T) {
+	f, _ := newTestFilter(t, "# banned\nbadword\nreally bad phrase\nre:spam+y\n", defaultSubstitutions)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"this is fine", nil},
+		{"BadWord!", []string{"badword"}},
+		{"b4dw0rd", []string{"badword"}},
+		{"b@dword", []string{"badword"}},
+		{"ｂａｄｗｏｒｄ", []string{"badword"}},             // fullwidth
+		{"𝐛𝐚𝐝𝐰𝐨𝐫𝐝", []string{"badword"}},             // mathematical bold
+		{"bádwórd", []string{"badword"}},             // accents
+		{"bad\u00adw\u200bord", []string{"badword"}}, // soft hyphen, zero-width space
+		{"badwords", nil},                            // whole words only
+		{"a Really.Bad  phrase", []string{"really bad phrase"}},
+		{"so spammmy", []string{"re:spam+y"}},
+	}
+	for _, tt := range tests {
+		verdict, matches := f.Check(context.Background(), tt.text)
+		if !slices.Equal(matches, tt.want) {
+			t.Errorf("Check(%q) matches = %q, want %q", tt.text, matches, tt.want)
+		}
+		want := Clean
+		if len(tt.want) > 0 {
+			want = Matched
+		}
+		if verdict != want {
+			t.Errorf("Check(%q) verdict = %s, want %s", tt.text, verdict, want)
+		}
+	}
+}
+
+func TestSubstitutionsAreConfigurable(t *testing.T) {
+	f, _ := newTestFilter(t, "badword\n", nil)
+	if verdict, _ := f.Check(context.Background(), "b4dword"); verdict != Clean {
+		t.Errorf("without substitutions b4dword = %s, want clean", verdict)
+	}
+
+	// Look-alike letters of other scripts can be mapped too
+	f, _ = newTestFilter(t, "badword\n", []string{"\u0430=a"}) // Cyrillic a
+	if verdict, _ := f.Check(context.Background(), "b\u0430dword"); verdict != Matched {
+		t.Errorf("with Cyrillic a mapped, b\u0430dword = %s, want matched", verdict)
+	}
+}
+
+func TestReloadKeepsListOnError(t *testing.T) {
+	f, path := newTestFilter(t, "badword\n", nil)
+
+	if err := os.WriteFile(path, []byte("otherword\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if verdict, _ := f.Check(context.Background(), "otherword"); verdict != Matched {
+		t.Errorf("otherword after reload = %s, want matched", verdict)
+	}
+
+	if err := os.WriteFile(path, []byte("re:(\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid pattern")
+	}
+	if verdict, _ := f.Check(context.Background(), "otherword"); verdict != Matched {
+		t.Errorf("otherword after failed reload = %s, want matched", verdict)
+	}
+}
+
+func TestParseSubstitutionsRejectsInvalidPairs(t *testing.T) {
+	for _, pair := range []string{"4", "4=", "=a", "44=a", "4=aa"} {
+		if _, err := ParseSubstitutions([]string{pair}); err == nil {
+			t.Errorf("ParseSubstitutions(%q) succeeded, want an error", pair)
+		}
+	}
+}
@@ -7,13 +7,23 @@ import (
 	"strings"
 	"time"
 
+	appredis "messaging-app/internal/redis"
+	"messaging-app/pkg/logging"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// TokenVersionSource holds the authoritative token version of each user,
+// read when Redis has no cached copy
+type TokenVersionSource interface {
+	GetTokenVersion(ctx context.Context, id primitive.ObjectID) (int64, error)
+}
+
 // AuthMiddleware creates a Gin middleware for JWT authentication with Redis blacklist check
-func AuthMiddleware(jwtSecret string, redisClient *redis.ClusterClient) gin.HandlerFunc {
+func AuthMiddleware(jwtSecret string, redisClient *redis.ClusterClient, versions TokenVersionSource) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -21,56 +31,108 @@ func AuthMiddleware(jwtSecret string, redisClient *redis.ClusterClient) gin.Hand
 			return
 		}
 
-		userID, err := ValidateToken(authHeader, jwtSecret, redisClient)
+		userID, role, err := validateToken(authHeader, jwtSecret, redisClient, versions)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
 
 		c.Set("userID", userID)
+		c.Set("role", role)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", userID))
 		c.Next()
 	}
 }
 
-func WSJwtAuthMiddleware(jwtSecret string,redisClient *redis.ClusterClient) gin.HandlerFunc {
-    return func(c *gin.Context) {
-        tokenString := c.Query("token")
-        if tokenString == "" {
-            c.AbortWithStatus(http.StatusUnauthorized)
-            return
-        }
-
-        userID, err := ValidateToken(tokenString, jwtSecret, redisClient)
-        if err != nil {
-            c.AbortWithStatus(http.StatusUnauthorized)
-            return
-        }
-        if err != nil {
-            c.AbortWithStatus(http.StatusUnauthorized)
-            return
-        }
-
-        // Store user ID in context
-        c.Set("userID", userID)
-        c.Next()
-    }
+// WSJwtAuthMiddleware authenticates WebSocket upgrades by a single-use
+// ?ticket= from POST /api/auth/ws-ticket. While allowTokens is set, access
+// tokens in the Authorization header or ?token= are accepted too.
+func WSJwtAuthMiddleware(jwtSecret string, redisClient *redis.ClusterClient, versions TokenVersionSource, allowTokens bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var userID, role string
+		var err error
+		if ticket := c.Query("ticket"); ticket != "" {
+			userID, err = redeemWSTicket(c.Request.Context(), ticket, redisClient)
+		} else if allowTokens {
+			tokenString := c.GetHeader("Authorization")
+			if tokenString == "" {
+				tokenString = c.Query("token")
+			}
+			userID, role, err = validateToken(tokenString, jwtSecret, redisClient, versions)
+		} else {
+			err = fmt.Errorf("ticket required")
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set("userID", userID)
+		c.Set("role", role)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", userID))
+		c.Next()
+	}
+}
+
+// redeemWSTicket returns the user a ticket was issued to. GETDEL makes
+// redeeming atomic, so a ticket opens at most one socket.
+func redeemWSTicket(ctx context.Context, ticket string, redisClient *redis.ClusterClient) (string, error) {
+	userID, err := redisClient.GetDel(ctx, appredis.WSTicketKey(ticket)).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("invalid or used ticket")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error checking ticket")
+	}
+
+	// Accounts deactivated since the ticket was issued can't connect with it
+	deactivated, err := redisClient.Exists(ctx, appredis.DeactivatedUserKey(userID)).Result()
+	if err != nil {
+		return "", fmt.Errorf("error checking ticket")
+	}
+	if deactivated > 0 {
+		return "", fmt.Errorf("account is deactivated")
+	}
+	return userID, nil
+}
+
+// RequireRole lets only users with one of the given roles through; use it
+// after AuthMiddleware
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if role == r {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+	}
 }
 
 // ValidateToken validates a JWT token and returns the user ID if valid
 // This can be used by both HTTP middleware and WebSocket handlers
-func ValidateToken(tokenString, jwtSecret string, redisClient *redis.ClusterClient) (string, error) {
+func ValidateToken(tokenString, jwtSecret string, redisClient *redis.ClusterClient, versions TokenVersionSource) (string, error) {
+	userID, _, err := validateToken(tokenString, jwtSecret, redisClient, versions)
+	return userID, err
+}
+
+// validateToken is ValidateToken that also returns the role claim, "user"
+// for tokens issued before roles were
+func validateToken(tokenString, jwtSecret string, redisClient *redis.ClusterClient, versions TokenVersionSource) (string, string, error) {
 	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 	if tokenString == "" {
-		return "", fmt.Errorf("bearer token required")
+		return "", "", fmt.Errorf("bearer token required")
 	}
 
 	// Check token blacklist
-	_, err := redisClient.Get(context.Background(), "blacklist:"+tokenString).Result()
+	_, err := redisClient.Get(context.Background(), appredis.BlacklistKey(tokenString)).Result()
 	if err == nil {
-		return "", fmt.Errorf("token revoked")
+		return "", "", fmt.Errorf("token revoked")
 	} else if err != redis.Nil {
 		// Only return error if it's not a "key not found" error
-		return "", fmt.Errorf("error checking token status")
+		return "", "", fmt.Errorf("error checking token status")
 	}
 
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -81,23 +143,81 @@ func ValidateToken(tokenString, jwtSecret string, redisClient *redis.ClusterClie
 	})
 
 	if err != nil {
-		return "", fmt.Errorf("invalid token")
+		return "", "", fmt.Errorf("invalid token")
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		if claims["type"] != "access" {
-			return "", fmt.Errorf("invalid token type")
+			return "", "", fmt.Errorf("invalid token type")
 		}
 
 		userID, ok := claims["id"].(string)
 		if !ok {
-			return "", fmt.Errorf("invalid token claims")
+			return "", "", fmt.Errorf("invalid token claims")
+		}
+
+		// Tokens of a family revoked by refresh-token reuse detection or logout
+		if family, _ := claims["fam"].(string); family != "" {
+			revoked, err := redisClient.Exists(context.Background(), appredis.RevokedFamilyKey(family)).Result()
+			if err != nil {
+				return "", "", fmt.Errorf("error checking token status")
+			}
+			if revoked > 0 {
+				return "", "", fmt.Errorf("token revoked")
+			}
+		}
+
+		// Sessions of deactivated accounts die immediately
+		deactivated, err := redisClient.Exists(context.Background(), appredis.DeactivatedUserKey(userID)).Result()
+		if err != nil {
+			return "", "", fmt.Errorf("error checking token status")
+		}
+		if deactivated > 0 {
+			return "", "", fmt.Errorf("account is deactivated")
+		}
+
+		// Tokens issued before a password change or "log out everywhere".
+		// Tokens without a version predate versions and count as 0.
+		version, _ := claims["ver"].(float64)
+		current, err := currentTokenVersion(context.Background(), userID, redisClient, versions)
+		if err != nil {
+			return "", "", fmt.Errorf("error checking token status")
+		}
+		if current != int64(version) {
+			return "", "", fmt.Errorf("token revoked")
+		}
+
+		role, _ := claims["role"].(string)
+		if role == "" {
+			role = "user"
 		}
+		return userID, role, nil
+	}
+
+	return "", "", fmt.Errorf("invalid token")
+}
 
-		return userID, nil
+// currentTokenVersion reads the user's token version from Redis, or from
+// versions when Redis lost it, so revocations survive a flush or restart
+func currentTokenVersion(ctx context.Context, userID string, redisClient *redis.ClusterClient, versions TokenVersionSource) (int64, error) {
+	current, err := redisClient.Get(ctx, appredis.TokenVersionKey(userID)).Int64()
+	if err != redis.Nil {
+		return current, err
 	}
 
-	return "", fmt.Errorf("invalid token")
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	current, err = versions.GetTokenVersion(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	// SETNX so a version bumped while this one was read isn't overwritten
+	if err := redisClient.SetNX(ctx, appredis.TokenVersionKey(userID), current, 0).Err(); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache token version", "user_id", userID, "error", err)
+	}
+	return current, nil
 }
 
 // BlacklistToken adds a token to the Redis blacklist
@@ -108,5 +228,5 @@ func BlacklistToken(tokenString string, expiration time.Duration, redisClient *r
 	}
 
 	ctx := context.Background()
-	return redisClient.Set(ctx, "blacklist:"+tokenString, "1", expiration).Err()
+	return redisClient.Set(ctx, appredis.BlacklistKey(tokenString), "1", expiration).Err()
 }
\ No newline at end of file
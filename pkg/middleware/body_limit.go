@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit caps request bodies at limit bytes. Requests that declare a
+// larger body are refused with 413 right away; bodies without a length fail
+// to read past the limit. Routes in exempt, given as their route patterns,
+// take uploads and enforce their own limits.
+func BodyLimit(limit int64, exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || skip[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"messaging-app/pkg/clientinfo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfo puts the client's IP and User-Agent on the request context
+func ClientInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info := clientinfo.Info{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+		c.Request = c.Request.WithContext(clientinfo.With(c.Request.Context(), info))
+		c.Next()
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler renders the last error a handler attached with ctx.Error as an
+// apperrors.Response, unless the handler already wrote a response
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		status := apperrors.Status(err)
+		if status >= 500 {
+			logging.FromContext(c.Request.Context()).Error("request failed",
+				"method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+		}
+		c.AbortWithStatusJSON(status, apperrors.ToResponse(err))
+	}
+}
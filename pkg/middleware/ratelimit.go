@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	rateLimitExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_exceeded_total",
+		Help: "Number of requests rejected by the rate limiter",
+	}, []string{"route"})
+	rateLimitMetricsOnce sync.Once
+
+	// rateLimitSeq keeps sliding window members unique within one nanosecond
+	rateLimitSeq uint64
+)
+
+// RateLimit allows Limit requests per Window for each key returned by KeyFunc
+type RateLimit struct {
+	Name    string
+	Limit   int
+	Window  time.Duration
+	KeyFunc func(c *gin.Context) string
+}
+
+// ByIP keys requests by client IP
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser keys requests by the authenticated user, falling back to the client
+// IP when the route isn't behind AuthMiddleware
+func ByUser(c *gin.Context) string {
+	if userID, ok := c.Get("userID"); ok {
+		return fmt.Sprintf("user:%v", userID)
+	}
+	return ByIP(c)
+}
+
+// ByEmail keys requests by the email address in their JSON body, so a limit
+// holds however many IPs the requests come from. The body is left for the
+// handler to read; requests without an address are keyed by IP.
+func ByEmail(c *gin.Context) string {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRateLimitBody))
+	if err != nil {
+		return ByIP(c)
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &req) != nil || strings.TrimSpace(req.Email) == "" {
+		return ByIP(c)
+	}
+	return "email:" + strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// maxRateLimitBody caps how much of a body ByEmail reads to find the address
+const maxRateLimitBody = 4096
+
+// RateLimitMiddleware enforces a sliding window limit stored in Redis. When
+// Redis is unavailable requests are let through rather than failing closed.
+func RateLimitMiddleware(redisClient redis.Cmdable, limit RateLimit) gin.HandlerFunc {
+	rateLimitMetricsOnce.Do(func() {
+		prometheus.MustRegister(rateLimitExceeded)
+	})
+
+	return func(c *gin.Context) {
+		key := "ratelimit:" + limit.Name + ":" + limit.KeyFunc(c)
+
+		allowed, retryAfter, err := allowRequest(c.Request.Context(), redisClient, key, limit.Limit, limit.Window)
+		if err != nil {
+			log.Printf("Rate limiter unavailable for %s: %v", key, err)
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			rateLimitExceeded.WithLabelValues(c.FullPath()).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// allowRequest records the request in the key's sliding window and reports
+// whether it fits, or how long until the oldest request leaves the window.
+func allowRequest(ctx context.Context, client redis.Cmdable, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(atomic.AddUint64(&rateLimitSeq, 1), 10)
+	windowStart := strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+
+	var count *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+windowStart)
+		count = pipe.ZCard(ctx, key)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: member})
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	if count.Val() < int64(limit) {
+		return true, 0, nil
+	}
+
+	// Rejected requests don't take up a slot in the window
+	client.ZRem(ctx, key, member)
+
+	oldest, err := client.ZRangeWithScores(ctx, key, 0, 0).Result()
+	if err != nil || len(oldest) == 0 {
+		return false, window, nil
+	}
+	retryAfter := time.Unix(0, int64(oldest[0].Score)).Add(window).Sub(now)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return false, retryAfter, nil
+}
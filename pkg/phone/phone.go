@@ -0,0 +1,68 @@
+// Package phone normalizes phone numbers to E.164 and hashes them for
+// contact discovery, so clients never have to upload raw numbers.
+package phone
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidNumber is returned for numbers that aren't in international format
+var ErrInvalidNumber = errors.New("phone number must be in international format, e.g. +14155550123")
+
+// E.164 allows up to 15 digits; anything under 7 is not a real subscriber number
+const (
+	minDigits = 7
+	maxDigits = 15
+)
+
+// Normalize converts an international number to E.164 ("+" and digits only).
+// Spaces, dashes, dots and parentheses are dropped and a leading "00" is read
+// as "+". Numbers without a country code are rejected, since the region they
+// belong to is unknown.
+func Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, "+"):
+		raw = raw[1:]
+	case strings.HasPrefix(raw, "00"):
+		raw = raw[2:]
+	default:
+		return "", ErrInvalidNumber
+	}
+
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidNumber
+		}
+	}
+
+	number := digits.String()
+	if len(number) < minDigits || len(number) > maxDigits || number[0] == '0' {
+		return "", ErrInvalidNumber
+	}
+	return "+" + number, nil
+}
+
+// Hash returns the hex SHA-256 of a normalized number, as clients send them
+// for discovery
+func Hash(e164 string) string {
+	sum := sha256.Sum256([]byte(e164))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsHash reports whether s looks like a hash returned by Hash
+func IsHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
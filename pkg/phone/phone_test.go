@@ -0,0 +1,55 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"+14155550123", "+14155550123"},
+		{" +1 (415) 555-0123 ", "+14155550123"},
+		{"0044 20.7946.0000", "+442079460000"},
+		{"+880 1711-000000", "+8801711000000"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw)
+		if err != nil {
+			t.Errorf("Normalize(%q) returned error: %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRejectsInvalidNumbers(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"4155550123",        // no country code
+		"+0155550123",       // country codes don't start with 0
+		"+1415555012a",      // letters
+		"+123",              // too short
+		"+1234567890123456", // more than 15 digits
+		"++14155550123",
+	} {
+		if got, err := Normalize(raw); err == nil {
+			t.Errorf("Normalize(%q) = %q, want an error", raw, got)
+		}
+	}
+}
+
+func TestHashMatchesAcrossFormats(t *testing.T) {
+	a, _ := Normalize("+1 415 555 0123")
+	b, _ := Normalize("001-415-555-0123")
+	if Hash(a) != Hash(b) {
+		t.Fatalf("hashes of the same number differ: %s, %s", Hash(a), Hash(b))
+	}
+	if !IsHash(Hash(a)) {
+		t.Fatalf("IsHash rejected %s", Hash(a))
+	}
+	if IsHash("not-a-hash") || IsHash(a) {
+		t.Fatal("IsHash accepted a non-hash")
+	}
+}
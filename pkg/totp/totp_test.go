@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// base32 of the RFC 6238 Appendix B SHA-1 seed "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	// Appendix B lists 8 digit codes; ours are their last 6
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAllowsOneStepOfDrift(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	for drift := -2; drift <= 2; drift++ {
+		at := now.Add(time.Duration(drift) * Period)
+		code, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		wantOK := drift >= -skew && drift <= skew
+		if ok != wantOK {
+			t.Errorf("drift %d: valid = %v, want %v", drift, ok, wantOK)
+		}
+		if ok && step != Step(at) {
+			t.Errorf("drift %d: step %d, want %d", drift, step, Step(at))
+		}
+	}
+}
+
+func TestValidateRejectsWrongLength(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"", code[:5], code + "0", "89005924"} {
+		if _, ok := Validate(rfcSecret, c, now); ok {
+			t.Errorf("Validate(%q) accepted a %d digit code", c, len(c))
+		}
+	}
+	if _, ok := Validate(rfcSecret, " "+code+"\n", now); !ok {
+		t.Error("surrounding whitespace should be ignored")
+	}
+}
+
+func TestCodeRejectsInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("Code accepted a secret that is not base32")
+	}
+	if _, ok := Validate("not base32!", "123456", time.Now()); ok {
+		t.Error("Validate accepted a code for a secret that is not base32")
+	}
+}
@@ -8,14 +8,17 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 // String helpers
@@ -57,6 +60,58 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// Text helpers
+
+// SanitizeText prepares user-written text for storage: it is normalized to
+// NFC so equal text compares equal, control characters other than newlines
+// and tabs are removed, as are invisible characters that only serve to
+// disguise text, and surrounding whitespace is trimmed. Zero-width joiners
+// and non-joiners, which emoji sequences and some scripts need, and the
+// direction marks of right-to-left text are kept.
+func SanitizeText(text string) string {
+	text = norm.NFC.String(text)
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\r':
+			return -1
+		case unicode.IsControl(r):
+			return -1
+		case r == '\u200B' || r == '\u2060' || r == '\uFEFF': // zero-width space, word joiner, BOM
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
+
+// Mention helpers
+
+// MaxMentions caps the usernames taken from one text
+const MaxMentions = 50
+
+// mentionPattern matches @username at the start of the text or after a
+// character that can't be part of a word, so e-mail addresses are skipped
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.-]+)`)
+
+// ExtractMentions returns the distinct usernames mentioned in text as
+// @username, lowercased, in order of first appearance
+func ExtractMentions(text string) []string {
+	var usernames []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(strings.TrimRight(m[1], ".-"))
+		if username == "" || ContainsString(usernames, username) {
+			continue
+		}
+		usernames = append(usernames, username)
+		if len(usernames) == MaxMentions {
+			break
+		}
+	}
+	return usernames
+}
+
 // UUID helpers
 func NewUUID() string {
 	return uuid.New().String()
@@ -180,15 +235,29 @@ func GetStatusCode(err error) int {
 	}
 
 	switch err.Error() {
-	case "not found", "user not found", "group not found":
+	case "not found", "user not found", "group not found", "invite not found", "join request not found",
+		"message not found", "message is not pinned":
 		return http.StatusNotFound
-	case "already exists", "user is already a group member", "user is already an admin":
+	case "already exists", "user is already a group member", "user is already an admin",
+		"last admin must transfer admin rights before leaving", "owner must transfer ownership before leaving",
+		"user is already a moderator", "cannot remove the group owner", "cannot demote the group owner",
+		"cannot remove the last admin", "join request already pending", "message is already pinned",
+		"pinned message limit reached":
 		return http.StatusConflict
 	case "unauthorized", "authentication required":
 		return http.StatusUnauthorized
-	case "forbidden", "only admins can add members", "only admins can add other admins":
+	case "invite is no longer valid":
+		return http.StatusGone
+	case "forbidden", "only admins can add members", "only admins can add other admins",
+		"only admins can remove members", "only admins can update group", "not a group member",
+		"only the owner can transfer ownership", "only the owner can demote admins",
+		"only the owner can delete the group", "only admins can manage moderators",
+		"only admins can manage invites", "only admins can review join requests", "only admins can pin messages":
 		return http.StatusForbidden
-	case "invalid input", "no valid fields to update":
+	case "invalid input", "no valid fields to update", "new owner must be a group member",
+		"user must be a member before becoming an admin", "user must be a member before becoming a moderator",
+		"user is not an admin", "user is not a moderator", "message does not belong to this group",
+		"invalid group settings":
 		return http.StatusBadRequest
 	default:
 		return http.StatusInternalServerError
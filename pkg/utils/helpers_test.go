@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"surrounding whitespace", "  hello \n", "hello"},
+		{"keeps newlines and tabs", "line 1\r\n\tline 2", "line 1\n\tline 2"},
+		{"control characters", "be\x00ll\x07 \x1b[31mred", "bell [31mred"},
+		{"zero-width characters", "\uFEFFpass\u200Bword\u2060", "password"},
+		{"composes accents", "cafe\u0301", "caf\u00e9"},
+		{"emoji sequences", "family \U0001F468\u200D\U0001F469\u200D\U0001F467 \U0001F44D\U0001F3FD", "family \U0001F468\u200D\U0001F469\u200D\U0001F467 \U0001F44D\U0001F3FD"},
+		{"right-to-left text", "\u200F\u05E9\u05DC\u05D5\u05DD 123 \u0645\u0631\u062D\u0628\u0627", "\u200F\u05E9\u05DC\u05D5\u05DD 123 \u0645\u0631\u062D\u0628\u0627"},
+		{"zero-width non-joiner", "\u0645\u06CC\u200C\u062E\u0648\u0627\u0647\u0645", "\u0645\u06CC\u200C\u062E\u0648\u0627\u0647\u0645"},
+		{"only invisible", "\u200B \u2060", ""},
+	}
+	for _, tt := range tests {
+		if got := SanitizeText(tt.text); got != tt.want {
+			t.Errorf("%s: SanitizeText(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"no mentions here", nil},
+		{"@alice", []string{"alice"}},
+		{"hey @Alice and @bob_2, see @alice again", []string{"alice", "bob_2"}},
+		{"(@carol) @dave. @erin-", []string{"carol", "dave", "erin"}},
+		{"mail bob@example.com or @@frank", nil},
+		{"@first.last: ping", []string{"first.last"}},
+		{"@", nil},
+	}
+	for _, tt := range tests {
+		if got := ExtractMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExtractMentions(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -1,23 +1,55 @@
 package integration
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
 	"messaging-app/config"
+	"messaging-app/internal/controllers"
 	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
 	"messaging-app/internal/repositories"
 	"messaging-app/internal/services"
+	"messaging-app/internal/storage"
+	"messaging-app/pkg/clientinfo"
+	"messaging-app/pkg/middleware"
+	"messaging-app/pkg/totp"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// capturingEmailQueue records queued emails instead of producing them to Kafka
+type capturingEmailQueue struct {
+	sent []models.EmailMessage
+}
+
+func (q *capturingEmailQueue) QueueEmail(ctx context.Context, message models.EmailMessage) error {
+	q.sent = append(q.sent, message)
+	return nil
+}
+
 type AuthIntegrationTestSuite struct {
 	suite.Suite
 	authService    *services.AuthService
+	emails         *capturingEmailQueue
+	auditLog       *services.AuditLog
+	stopAudit      context.CancelFunc
 	userRepo       *repositories.UserRepository
 	redisClient    *redis.ClusterClient
 	mongoClient    *mongo.Client
@@ -44,11 +76,18 @@ func (suite *AuthIntegrationTestSuite) SetupSuite() {
 	suite.userRepo = repositories.NewUserRepository(suite.mongoClient.Database(suite.testDBName))
 
 	// Create auth service
+	suite.emails = &capturingEmailQueue{}
+	suite.auditLog = services.NewAuditLog(repositories.NewAuditRepository(suite.mongoClient.Database(suite.testDBName)), suite.userRepo, suite.emails)
+	var auditCtx context.Context
+	auditCtx, suite.stopAudit = context.WithCancel(suite.ctx)
+	go suite.auditLog.Run(auditCtx)
 	suite.authService = services.NewAuthService(
 		suite.userRepo,
 		config.LoadConfig().JWTSecret,
 		suite.redisClient,
+		suite.emails,
 		config.LoadConfig(),
+		suite.auditLog,
 	)
 
 	// Create test user
@@ -59,6 +98,7 @@ func (suite *AuthIntegrationTestSuite) SetupSuite() {
 }
 
 func (suite *AuthIntegrationTestSuite) TearDownSuite() {
+	suite.stopAudit()
 	// Cleanup test database
 	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
 	suite.mongoClient.Disconnect(suite.ctx)
@@ -69,6 +109,7 @@ func (suite *AuthIntegrationTestSuite) BeforeTest(suiteName, testName string) {
 	// Clear data before each test
 	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
 	suite.redisClient.FlushDB(suite.ctx)
+	suite.emails.sent = nil
 }
 
 func TestAuthIntegrationTestSuite(t *testing.T) {
@@ -96,6 +137,38 @@ func (suite *AuthIntegrationTestSuite) TestRegisterAndLogin() {
 	suite.Error(err)
 }
 
+func (suite *AuthIntegrationTestSuite) TestRegisterIgnoresPrivilegedFields() {
+	gin.SetMode(gin.TestMode)
+	secret := config.LoadConfig().JWTSecret
+	router := gin.New()
+	router.POST("/register", controllers.NewAuthController(suite.authService).Register)
+	admin := router.Group("/admin", middleware.AuthMiddleware(secret, suite.redisClient, suite.userRepo), middleware.RequireRole(models.RoleAdmin))
+	admin.GET("/broadcast", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	body := `{"username":"sneaky","email":"sneaky@example.com","password":"password123",
+		"role":"admin","two_factor_enabled":true,"undo_send_seconds":30,
+		"phone_number":"+15550100","friends":["507f1f77bcf86cd799439011"]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+	suite.Require().Equal(http.StatusCreated, w.Code)
+	var registered models.AuthResponse
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &registered))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/broadcast", nil)
+	req.Header.Set("Authorization", "Bearer "+registered.AccessToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	stored, err := suite.userRepo.FindUserByID(suite.ctx, registered.User.ID)
+	suite.Require().NoError(err)
+	suite.Equal(models.RoleUser, stored.EffectiveRole())
+	suite.False(stored.TwoFactorEnabled)
+	suite.Zero(stored.UndoSendSeconds)
+	suite.Empty(stored.PhoneNumber)
+	suite.Empty(stored.Friends)
+}
+
 func (suite *AuthIntegrationTestSuite) TestTokenRefresh() {
 	// First register a user
 	authResponse, err := suite.authService.Register(suite.ctx, suite.testUser)
@@ -124,4 +197,579 @@ func (suite *AuthIntegrationTestSuite) TestLogout() {
 	// Verify token is blacklisted
 	_, err = suite.redisClient.Get(suite.ctx, "blacklist:"+authResponse.AccessToken).Result()
 	suite.NoError(err)
-}
\ No newline at end of file
+}
+
+func (suite *AuthIntegrationTestSuite) TestRefreshTokenReuseRevokesFamily() {
+	authResponse, err := suite.authService.Register(suite.ctx, suite.testUser)
+	suite.NoError(err)
+
+	// First exchange rotates the pair
+	rotated, err := suite.authService.RefreshToken(suite.ctx, authResponse.RefreshToken)
+	suite.NoError(err)
+	suite.NotEqual(authResponse.RefreshToken, rotated.RefreshToken)
+
+	// Replaying the old refresh token is detected as reuse
+	_, err = suite.authService.RefreshToken(suite.ctx, authResponse.RefreshToken)
+	suite.ErrorIs(err, services.ErrRefreshTokenReused)
+
+	// The whole family is gone: the rotated refresh and access tokens no longer work
+	_, err = suite.authService.RefreshToken(suite.ctx, rotated.RefreshToken)
+	suite.Error(err)
+
+	_, err = middleware.ValidateToken(rotated.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.Error(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestConcurrentRefreshTokenReplays() {
+	authResponse, err := suite.authService.Register(suite.ctx, suite.testUser)
+	suite.Require().NoError(err)
+
+	const replays = 10
+	results := make([]*models.AuthResponse, replays)
+	errs := make([]error, replays)
+	var wg sync.WaitGroup
+	for i := 0; i < replays; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = suite.authService.RefreshToken(suite.ctx, authResponse.RefreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	// Exactly one request exchanges the token; the rest count as reuse
+	var winner *models.AuthResponse
+	for i, err := range errs {
+		if err == nil {
+			suite.Require().Nil(winner, "the refresh token was exchanged twice")
+			winner = results[i]
+			continue
+		}
+		suite.ErrorIs(err, services.ErrRefreshTokenReused)
+	}
+	suite.Require().NotNil(winner)
+
+	// Reuse revoked the family, including the pair the winner got
+	_, err = suite.authService.RefreshToken(suite.ctx, winner.RefreshToken)
+	suite.Error(err)
+	_, err = middleware.ValidateToken(winner.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.Error(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestLogoutRevokesRefreshToken() {
+	authResponse, err := suite.authService.Register(suite.ctx, suite.testUser)
+	suite.NoError(err)
+
+	err = suite.authService.Logout(suite.ctx, authResponse.User.ID.Hex(), authResponse.AccessToken)
+	suite.NoError(err)
+
+	_, err = suite.authService.RefreshToken(suite.ctx, authResponse.RefreshToken)
+	suite.Error(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestDeactivateAndReactivate() {
+	password := "password123"
+	authResponse, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "deactivated_user",
+		Email:    "deactivated@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	userID := authResponse.User.ID.Hex()
+
+	suite.Require().NoError(suite.authService.DeactivateAccount(suite.ctx, userID, authResponse.AccessToken))
+
+	// Existing sessions and new logins are rejected
+	_, err = middleware.ValidateToken(authResponse.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.Error(err)
+	_, err = suite.authService.Login(suite.ctx, "deactivated@example.com", password)
+	suite.ErrorIs(err, services.ErrAccountDeactivated)
+
+	reactivated, err := suite.authService.ReactivateAccount(suite.ctx, "deactivated@example.com", password)
+	suite.Require().NoError(err)
+	_, err = middleware.ValidateToken(reactivated.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.NoError(err)
+
+	_, err = suite.authService.ReactivateAccount(suite.ctx, "deactivated@example.com", password)
+	suite.ErrorIs(err, services.ErrAccountNotDeactivated)
+}
+
+func (suite *AuthIntegrationTestSuite) TestExpiredDeactivationIsAnonymized() {
+	password := "password123"
+	authResponse, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "gone_user",
+		Email:    "gone@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+
+	// Backdate the deactivation past the grace period
+	longAgo := time.Now().Add(-config.LoadConfig().AccountReactivationGrace - time.Hour)
+	suite.Require().NoError(suite.userRepo.DeactivateUser(suite.ctx, authResponse.User.ID, longAgo))
+
+	_, err = suite.authService.ReactivateAccount(suite.ctx, "gone@example.com", password)
+	suite.ErrorIs(err, services.ErrReactivationExpired)
+
+	purged, err := suite.authService.PurgeDeactivatedAccounts(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), purged)
+
+	user, err := suite.userRepo.FindUserByID(suite.ctx, authResponse.User.ID)
+	suite.Require().NoError(err)
+	suite.Equal(models.DeletedUsername, user.Username)
+	suite.NotEqual("gone@example.com", user.Email)
+	suite.NotNil(user.AnonymizedAt)
+}
+
+func (suite *AuthIntegrationTestSuite) TestLoginCancelsScheduledDeletion() {
+	password := "password123"
+	authResponse, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "leaving_user",
+		Email:    "leaving@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	userID := authResponse.User.ID.Hex()
+
+	_, err = suite.authService.ScheduleDeletion(suite.ctx, userID, authResponse.AccessToken, "wrong-password")
+	suite.ErrorIs(err, services.ErrIncorrectPassword)
+
+	dueAt, err := suite.authService.ScheduleDeletion(suite.ctx, userID, authResponse.AccessToken, password)
+	suite.Require().NoError(err)
+	suite.True(dueAt.After(time.Now()))
+	_, err = middleware.ValidateToken(authResponse.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.Error(err)
+
+	loggedIn, err := suite.authService.Login(suite.ctx, "leaving@example.com", password)
+	suite.Require().NoError(err)
+	_, err = middleware.ValidateToken(loggedIn.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.NoError(err)
+
+	user, err := suite.userRepo.FindUserByID(suite.ctx, authResponse.User.ID)
+	suite.Require().NoError(err)
+	suite.True(user.IsActive())
+	suite.Nil(user.DeletionDueAt)
+}
+
+func (suite *AuthIntegrationTestSuite) TestDueDeletionAnonymizesAccount() {
+	db := suite.mongoClient.Database(suite.testDBName)
+	friendshipRepo := repositories.NewFriendshipRepository(db)
+	messageRepo := repositories.NewMessageRepository(db)
+	deletionService := services.NewAccountDeletionService(
+		suite.userRepo,
+		friendshipRepo,
+		repositories.NewFollowRepository(db),
+		messageRepo,
+		repositories.NewDeviceRepository(db),
+		repositories.NewAuditRepository(db),
+		suite.redisClient,
+		suite.emails,
+		config.LoadConfig(),
+	)
+
+	password := "password123"
+	leaving, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "deleted_soon",
+		Email:    "deleted-soon@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	friend, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "remaining_friend",
+		Email:    "remaining@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	leavingID, friendID := leaving.User.ID, friend.User.ID
+
+	request, err := friendshipRepo.CreateRequest(suite.ctx, leavingID, friendID)
+	suite.Require().NoError(err)
+	suite.Require().NoError(friendshipRepo.UpdateStatus(suite.ctx, request.ID, friendID, models.FriendshipStatusAccepted))
+	suite.Require().NoError(suite.userRepo.AddFriend(suite.ctx, leavingID, friendID))
+	message, err := messageRepo.CreateMessage(suite.ctx, &models.Message{
+		SenderID:    leavingID,
+		SenderName:  "deleted_soon",
+		ReceiverID:  friendID,
+		Content:     "goodbye",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.authService.ScheduleDeletion(suite.ctx, leavingID.Hex(), leaving.AccessToken, password)
+	suite.Require().NoError(err)
+
+	// Nothing is due until the grace period has passed
+	deleted, err := deletionService.DeleteDueAccounts(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Equal(0, deleted)
+
+	_, err = db.Collection("users").UpdateOne(suite.ctx,
+		bson.M{"_id": leavingID},
+		bson.M{"$set": bson.M{"deletion_due_at": time.Now().Add(-time.Minute)}},
+	)
+	suite.Require().NoError(err)
+	sentBefore := len(suite.emails.sent)
+
+	deleted, err = deletionService.DeleteDueAccounts(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Equal(1, deleted)
+
+	user, err := suite.userRepo.FindUserByID(suite.ctx, leavingID)
+	suite.Require().NoError(err)
+	suite.True(strings.HasPrefix(user.Username, "deleted_user_"))
+	suite.NotEqual("deleted-soon@example.com", user.Email)
+	suite.Empty(user.Password)
+	suite.NotNil(user.AnonymizedAt)
+	suite.Nil(user.DeletionDueAt)
+	suite.Empty(user.DeletionStage)
+
+	remaining, err := suite.userRepo.FindUserByID(suite.ctx, friendID)
+	suite.Require().NoError(err)
+	suite.NotContains(remaining.Friends, leavingID)
+	areFriends, err := friendshipRepo.AreFriends(suite.ctx, leavingID, friendID)
+	suite.Require().NoError(err)
+	suite.False(areFriends)
+
+	// The default policy keeps messages under the placeholder name
+	kept, err := messageRepo.GetMessageByID(suite.ctx, message.ID)
+	suite.Require().NoError(err)
+	suite.Equal("goodbye", kept.Content)
+	suite.Equal(models.DeletedUsername, kept.SenderName)
+
+	suite.Require().Len(suite.emails.sent, sentBefore+1)
+	suite.Equal("deleted-soon@example.com", suite.emails.sent[sentBefore].To)
+
+	// The deletion can no longer be cancelled
+	_, err = suite.authService.Login(suite.ctx, "deleted-soon@example.com", password)
+	suite.Error(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestEmailVerificationTokenIsSingleUse() {
+	authResponse, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "verify_user",
+		Email:    "verify@example.com",
+		Password: "password123",
+	})
+	suite.Require().NoError(err)
+	suite.False(authResponse.User.EmailVerified)
+
+	// Registration queues the email instead of sending it inline
+	suite.Require().Len(suite.emails.sent, 1)
+	suite.Equal("verify@example.com", suite.emails.sent[0].To)
+	_, token, found := strings.Cut(suite.emails.sent[0].Body, "?token=")
+	suite.Require().True(found)
+	token = strings.TrimSpace(token)
+
+	// Resending invalidates the first token
+	suite.Require().NoError(suite.authService.SendVerificationEmail(suite.ctx, authResponse.User.ID))
+	suite.ErrorIs(suite.authService.VerifyEmail(suite.ctx, token), services.ErrInvalidVerificationToken)
+
+	_, token, _ = strings.Cut(suite.emails.sent[1].Body, "?token=")
+	token = strings.TrimSpace(token)
+	suite.Require().NoError(suite.authService.VerifyEmail(suite.ctx, token))
+	suite.ErrorIs(suite.authService.VerifyEmail(suite.ctx, token), services.ErrInvalidVerificationToken)
+
+	user, err := suite.userRepo.FindUserByID(suite.ctx, authResponse.User.ID)
+	suite.Require().NoError(err)
+	suite.True(user.EmailVerified)
+	suite.ErrorIs(suite.authService.SendVerificationEmail(suite.ctx, user.ID), services.ErrEmailAlreadyVerified)
+}
+
+func (suite *AuthIntegrationTestSuite) TestTwoFactorLogin() {
+	password := "password123"
+	authResponse, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "totp_user",
+		Email:    "totp@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	userID := authResponse.User.ID
+
+	// Setup is gated on a verified email
+	_, err = suite.authService.SetupTwoFactor(suite.ctx, userID)
+	suite.ErrorIs(err, services.ErrEmailNotVerified)
+	_, err = suite.userRepo.UpdateUser(suite.ctx, userID, bson.M{"email_verified": true})
+	suite.Require().NoError(err)
+
+	setup, err := suite.authService.SetupTwoFactor(suite.ctx, userID)
+	suite.Require().NoError(err)
+	suite.Contains(setup.OTPAuthURL, "secret="+setup.Secret)
+
+	_, err = suite.authService.EnableTwoFactor(suite.ctx, userID, "000000")
+	suite.ErrorIs(err, services.ErrInvalidTwoFactorCode)
+	code, err := totp.Code(setup.Secret, time.Now())
+	suite.Require().NoError(err)
+	recoveryCodes, err := suite.authService.EnableTwoFactor(suite.ctx, userID, code)
+	suite.Require().NoError(err)
+	suite.Len(recoveryCodes, 10)
+
+	// Login now stops at a challenge
+	login, err := suite.authService.Login(suite.ctx, "totp@example.com", password)
+	suite.Require().NoError(err)
+	suite.True(login.TwoFactorRequired)
+	suite.Empty(login.AccessToken)
+
+	completed, err := suite.authService.CompleteTwoFactorLogin(suite.ctx, login.ChallengeToken, recoveryCodes[0])
+	suite.Require().NoError(err)
+	suite.NotEmpty(completed.AccessToken)
+
+	// Challenges and recovery codes are single use
+	_, err = suite.authService.CompleteTwoFactorLogin(suite.ctx, login.ChallengeToken, recoveryCodes[1])
+	suite.ErrorIs(err, services.ErrInvalidChallenge)
+	login, err = suite.authService.Login(suite.ctx, "totp@example.com", password)
+	suite.Require().NoError(err)
+	_, err = suite.authService.CompleteTwoFactorLogin(suite.ctx, login.ChallengeToken, recoveryCodes[0])
+	suite.ErrorIs(err, services.ErrInvalidTwoFactorCode)
+
+	next, err := totp.Code(setup.Secret, time.Now().Add(totp.Period))
+	suite.Require().NoError(err)
+	suite.Error(suite.authService.DisableTwoFactor(suite.ctx, userID, "wrongpassword", next))
+	suite.NoError(suite.authService.DisableTwoFactor(suite.ctx, userID, password, next))
+}
+
+func (suite *AuthIntegrationTestSuite) TestAvatarUploadReplacesPreviousFiles() {
+	// Avatars can't be set to an arbitrary URL at registration
+	suite.testUser.Avatar = "http://elsewhere.example/tracker.gif"
+	authResponse, err := suite.authService.Register(suite.ctx, suite.testUser)
+	suite.Require().NoError(err)
+	suite.Empty(authResponse.User.Avatar)
+
+	dir := suite.T().TempDir()
+	store, err := storage.NewLocalStorage(dir, "http://localhost:8080", "secret")
+	suite.Require().NoError(err)
+	avatarService := services.NewAvatarService(suite.userRepo, store, &config.Config{AvatarMaxSize: 1 << 20})
+
+	var img bytes.Buffer
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	src.SetNRGBA(150, 100, color.NRGBA{A: 0xff})
+	suite.Require().NoError(png.Encode(&img, src))
+
+	first, err := avatarService.UploadAvatar(suite.ctx, authResponse.User.ID, bytes.NewReader(img.Bytes()))
+	suite.Require().NoError(err)
+	suite.Len(first.AvatarSizes, 2)
+	suite.Equal(first.AvatarSizes["256"], first.Avatar)
+	firstFiles, _ := filepath.Glob(filepath.Join(dir, "avatar-*"))
+	suite.Len(firstFiles, 2)
+
+	second, err := avatarService.UploadAvatar(suite.ctx, authResponse.User.ID, bytes.NewReader(img.Bytes()))
+	suite.Require().NoError(err)
+	suite.NotEqual(first.Avatar, second.Avatar)
+	files, _ := filepath.Glob(filepath.Join(dir, "avatar-*"))
+	suite.Len(files, 2, "the previous avatar's files are removed")
+	for _, f := range firstFiles {
+		suite.NoFileExists(f)
+	}
+
+	user, err := suite.userRepo.FindUserByID(suite.ctx, authResponse.User.ID)
+	suite.Require().NoError(err)
+	suite.Equal(second.Avatar, user.Avatar)
+	suite.Equal(second.AvatarSizes, user.AvatarSizes)
+
+	// Markup with an image name is still markup
+	_, err = avatarService.UploadAvatar(suite.ctx, authResponse.User.ID, strings.NewReader("<svg onload=alert(1)>"))
+	suite.ErrorIs(err, services.ErrMediaTypeNotAllowed)
+
+	_, err = avatarService.UploadAvatar(suite.ctx, authResponse.User.ID, bytes.NewReader(make([]byte, 2<<20)))
+	suite.ErrorIs(err, services.ErrMediaTooLarge)
+}
+
+func (suite *AuthIntegrationTestSuite) TestStaleTokenVersionIsRejected() {
+	password := "password123"
+	registered, err := suite.authService.Register(suite.ctx, &models.User{
+		Username: "many_devices",
+		Email:    "devices@example.com",
+		Password: password,
+	})
+	suite.Require().NoError(err)
+	userID := registered.User.ID.Hex()
+	phone, err := suite.authService.Login(suite.ctx, "devices@example.com", password)
+	suite.Require().NoError(err)
+
+	secret := config.LoadConfig().JWTSecret
+	_, err = middleware.ValidateToken(phone.AccessToken, secret, suite.redisClient, suite.userRepo)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.authService.LogoutEverywhere(suite.ctx, userID))
+	for _, token := range []string{registered.AccessToken, phone.AccessToken} {
+		_, err = middleware.ValidateToken(token, secret, suite.redisClient, suite.userRepo)
+		suite.EqualError(err, "token revoked")
+	}
+	_, err = suite.authService.RefreshToken(suite.ctx, phone.RefreshToken)
+	suite.ErrorIs(err, services.ErrInvalidRefreshToken)
+
+	// A new login gets the new version, and changing the password bumps it again
+	loggedIn, err := suite.authService.Login(suite.ctx, "devices@example.com", password)
+	suite.Require().NoError(err)
+	_, err = middleware.ValidateToken(loggedIn.AccessToken, secret, suite.redisClient, suite.userRepo)
+	suite.Require().NoError(err)
+
+	db := suite.mongoClient.Database(suite.testDBName)
+	userService := services.NewUserService(suite.userRepo, repositories.NewFriendshipRepository(db), repositories.NewFollowRepository(db), suite.redisClient, nil)
+	_, err = userService.UpdateUser(suite.ctx, registered.User.ID, &models.UserUpdateRequest{CurrentPassword: password, NewPassword: "new-password456"})
+	suite.Require().NoError(err)
+	_, err = middleware.ValidateToken(loggedIn.AccessToken, secret, suite.redisClient, suite.userRepo)
+	suite.EqualError(err, "token revoked")
+
+	// Losing the cached version doesn't bring revoked tokens back
+	suite.redisClient.FlushDB(suite.ctx)
+	_, err = middleware.ValidateToken(loggedIn.AccessToken, secret, suite.redisClient, suite.userRepo)
+	suite.EqualError(err, "token revoked")
+	cached, err := suite.redisClient.Get(suite.ctx, appredis.TokenVersionKey(userID)).Int64()
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), cached)
+
+	// The version survives a Redis restart through the next login
+	suite.redisClient.FlushDB(suite.ctx)
+	loggedIn, err = suite.authService.Login(suite.ctx, "devices@example.com", "new-password456")
+	suite.Require().NoError(err)
+	_, err = middleware.ValidateToken(loggedIn.AccessToken, secret, suite.redisClient, suite.userRepo)
+	suite.NoError(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestActivityLogRecordsSecurityEvents() {
+	password := "password123"
+	registered, err := suite.authService.Register(suite.ctx, &models.User{Username: "audited", Email: "audited@example.com", Password: password})
+	suite.Require().NoError(err)
+	userID := registered.User.ID
+	laptop := clientinfo.With(suite.ctx, clientinfo.Info{IP: "198.51.100.1", UserAgent: "laptop"})
+	phone := clientinfo.With(suite.ctx, clientinfo.Info{IP: "203.0.113.7", UserAgent: "phone"})
+
+	_, err = suite.authService.Login(laptop, "audited@example.com", password)
+	suite.Require().NoError(err)
+	_, err = suite.authService.Login(laptop, "audited@example.com", "wrongpassword")
+	suite.Require().Error(err)
+	_, err = suite.authService.Login(laptop, "audited@example.com", password)
+	suite.Require().NoError(err)
+	suite.emails.sent = nil
+
+	// Only a login from a device not seen before is flagged and emailed about
+	_, err = suite.authService.Login(phone, "audited@example.com", password)
+	suite.Require().NoError(err)
+
+	var activity *models.AuditEventListResponse
+	suite.Eventually(func() bool {
+		activity, err = suite.auditLog.List(suite.ctx, userID, nil, 1, 20)
+		return err == nil && len(activity.Events) == 5
+	}, 5*time.Second, 50*time.Millisecond)
+	suite.Require().Len(activity.Events, 5)
+	types := map[string]int{}
+	for _, event := range activity.Events {
+		types[event.Type]++
+	}
+	suite.Equal(map[string]int{models.AuditLogin: 3, models.AuditLoginFailed: 1, models.AuditNewDevice: 1}, types)
+	suite.Require().Len(suite.emails.sent, 1)
+	suite.Contains(suite.emails.sent[0].Body, "203.0.113.7")
+
+	failed, err := suite.auditLog.List(suite.ctx, userID, []string{models.AuditLoginFailed}, 1, 20)
+	suite.Require().NoError(err)
+	suite.Require().Len(failed.Events, 1)
+	suite.Equal("198.51.100.1", failed.Events[0].IP)
+	suite.Equal("laptop", failed.Events[0].UserAgent)
+
+	page, err := suite.auditLog.List(suite.ctx, userID, nil, 1, 2)
+	suite.Require().NoError(err)
+	suite.Len(page.Events, 2)
+	suite.True(page.HasMore)
+
+	_, err = suite.auditLog.List(suite.ctx, userID, []string{"bogus"}, 1, 20)
+	suite.Error(err)
+}
+
+func (suite *AuthIntegrationTestSuite) TestWSTicketIsSingleUse() {
+	registered, err := suite.authService.Register(suite.ctx, &models.User{Username: "socket", Email: "socket@example.com", Password: "password123"})
+	suite.Require().NoError(err)
+
+	gin.SetMode(gin.TestMode)
+	connect := func(allowTokens bool, target string, header string) int {
+		router := gin.New()
+		router.GET("/ws", middleware.WSJwtAuthMiddleware(config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo, allowTokens), func(c *gin.Context) {
+			suite.Equal(registered.User.ID.Hex(), c.GetString("userID"))
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set("Authorization", "Bearer "+header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	ticket, err := suite.authService.IssueWSTicket(suite.ctx, registered.User.ID.Hex())
+	suite.Require().NoError(err)
+	suite.Equal(int64(30), ticket.ExpiresIn)
+
+	// The first socket redeems the ticket and a second one can't reuse it
+	suite.Equal(http.StatusOK, connect(false, "/ws?ticket="+ticket.Ticket, ""))
+	suite.Equal(http.StatusUnauthorized, connect(false, "/ws?ticket="+ticket.Ticket, ""))
+	suite.Equal(http.StatusUnauthorized, connect(true, "/ws?ticket="+ticket.Ticket, ""))
+
+	// Access tokens only work while the migration flag is on
+	suite.Equal(http.StatusOK, connect(true, "/ws", registered.AccessToken))
+	suite.Equal(http.StatusOK, connect(true, "/ws?token="+registered.AccessToken, ""))
+	suite.Equal(http.StatusUnauthorized, connect(false, "/ws", registered.AccessToken))
+	suite.Equal(http.StatusUnauthorized, connect(false, "/ws?token="+registered.AccessToken, ""))
+}
+
+func (suite *AuthIntegrationTestSuite) TestPasswordReset() {
+	registered, err := suite.authService.Register(suite.ctx, &models.User{Username: "forgetful", Email: "forgetful@example.com", Password: "password123"})
+	suite.Require().NoError(err)
+	suite.emails.sent = nil
+
+	// Known and unknown addresses get the same response, and only a known one gets an email
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/forgot-password", controllers.NewAuthController(suite.authService).ForgotPassword)
+	forgot := func(email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/forgot-password", strings.NewReader(`{"email":"`+email+`"}`)))
+		return w
+	}
+	unknown := forgot("nobody@example.com")
+	suite.Empty(suite.emails.sent)
+	known := forgot("forgetful@example.com")
+	suite.Equal(unknown.Code, known.Code)
+	suite.Equal(unknown.Body.String(), known.Body.String())
+	suite.Require().Len(suite.emails.sent, 1)
+	resetToken := func(i int) string {
+		_, token, found := strings.Cut(suite.emails.sent[i].Body, "?token=")
+		suite.Require().True(found)
+		token, _, _ = strings.Cut(token, "\n")
+		return token
+	}
+	first := resetToken(0)
+
+	// A new request replaces the earlier link
+	suite.Require().NoError(suite.authService.RequestPasswordReset(suite.ctx, "forgetful@example.com"))
+	suite.ErrorIs(suite.authService.ResetPassword(suite.ctx, first, "newpassword456"), services.ErrInvalidResetToken)
+	token := resetToken(1)
+
+	// A weak password is rejected without using up the token
+	suite.ErrorIs(suite.authService.ResetPassword(suite.ctx, token, "short1"), services.ErrWeakPassword)
+	suite.Require().NoError(suite.authService.ResetPassword(suite.ctx, token, "newpassword456"))
+	suite.ErrorIs(suite.authService.ResetPassword(suite.ctx, token, "otherpassword789"), services.ErrInvalidResetToken)
+
+	// The old sessions end and only the new password works
+	_, err = middleware.ValidateToken(registered.AccessToken, config.LoadConfig().JWTSecret, suite.redisClient, suite.userRepo)
+	suite.EqualError(err, "token revoked")
+	_, err = suite.authService.Login(suite.ctx, "forgetful@example.com", "password123")
+	suite.Error(err)
+	_, err = suite.authService.Login(suite.ctx, "forgetful@example.com", "newpassword456")
+	suite.NoError(err)
+	suite.Equal("Your password was reset", suite.emails.sent[len(suite.emails.sent)-1].Subject)
+
+	// Links stop working once their hour is up
+	suite.Require().NoError(suite.authService.RequestPasswordReset(suite.ctx, "forgetful@example.com"))
+	expiring := resetToken(len(suite.emails.sent) - 1)
+	hash, err := suite.redisClient.Get(suite.ctx, "password_reset:user:"+registered.User.ID.Hex()).Result()
+	suite.Require().NoError(err)
+	ttl, err := suite.redisClient.TTL(suite.ctx, "password_reset:"+hash).Result()
+	suite.Require().NoError(err)
+	suite.LessOrEqual(ttl, services.PasswordResetTTL)
+	suite.Require().NoError(suite.redisClient.PExpire(suite.ctx, "password_reset:"+hash, time.Millisecond).Err())
+	time.Sleep(10 * time.Millisecond)
+	suite.ErrorIs(suite.authService.ResetPassword(suite.ctx, expiring, "thirdpassword012"), services.ErrInvalidResetToken)
+}
@@ -0,0 +1,173 @@
+package integration
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/services"
+	"messaging-app/internal/storage"
+
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notifyingEmailQueue hands queued emails to the test, which runs concurrently
+// with the export goroutine
+type notifyingEmailQueue struct {
+	sent chan models.EmailMessage
+}
+
+func (q *notifyingEmailQueue) QueueEmail(ctx context.Context, message models.EmailMessage) error {
+	q.sent <- message
+	return nil
+}
+
+type ExportIntegrationTestSuite struct {
+	suite.Suite
+	exportService *services.ExportService
+	exportRepo    *repositories.ExportRepository
+	userRepo      *repositories.UserRepository
+	messageRepo   *repositories.MessageRepository
+	emails        *notifyingEmailQueue
+	mongoClient   *mongo.Client
+	testDBName    string
+	ctx           context.Context
+}
+
+func (suite *ExportIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.testDBName = "test_export_db"
+
+	opts := options.Client().ApplyURI(os.Getenv("MONGO_URI"))
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+}
+
+func (suite *ExportIntegrationTestSuite) TearDownSuite() {
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+}
+
+func (suite *ExportIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	db := suite.mongoClient.Database(suite.testDBName)
+	db.Drop(suite.ctx)
+	_, err := repositories.EnsureIndexes(suite.ctx, db, time.Hour, false)
+	suite.Require().NoError(err)
+
+	store, err := storage.NewLocalStorage(suite.T().TempDir(), "http://localhost:8080", "test-signing-key")
+	suite.Require().NoError(err)
+
+	suite.exportRepo = repositories.NewExportRepository(db)
+	suite.userRepo = repositories.NewUserRepository(db)
+	suite.messageRepo = repositories.NewMessageRepository(db)
+	suite.emails = &notifyingEmailQueue{sent: make(chan models.EmailMessage, 1)}
+	suite.exportService = services.NewExportService(
+		suite.exportRepo,
+		suite.userRepo,
+		suite.messageRepo,
+		repositories.NewFriendshipRepository(db),
+		repositories.NewGroupRepository(db),
+		store,
+		suite.emails,
+		&config.Config{ExportLinkTTL: time.Hour},
+	)
+}
+
+func TestExportIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(ExportIntegrationTestSuite))
+}
+
+func (suite *ExportIntegrationTestSuite) TestOnlyOneActiveExportPerUser() {
+	userID := primitive.NewObjectID()
+
+	job, err := suite.exportRepo.CreateJob(suite.ctx, userID)
+	suite.Require().NoError(err)
+	_, err = suite.exportRepo.CreateJob(suite.ctx, userID)
+	suite.ErrorIs(err, repositories.ErrExportInProgress)
+
+	// Finished jobs don't block the next export
+	suite.Require().NoError(suite.exportRepo.Fail(suite.ctx, job.ID, "test"))
+	_, err = suite.exportRepo.CreateJob(suite.ctx, userID)
+	suite.NoError(err)
+}
+
+func (suite *ExportIntegrationTestSuite) TestExportArchiveContainsConversations() {
+	me, err := suite.userRepo.CreateUser(suite.ctx, &models.User{Username: "exporter", Email: "exporter@example.com"})
+	suite.Require().NoError(err)
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+
+	for _, msg := range []models.Message{
+		{SenderID: me.ID, ReceiverID: alice, Content: "hi alice"},
+		{SenderID: alice, ReceiverID: me.ID, Content: "hi back"},
+		{SenderID: me.ID, GroupID: primitive.NewObjectID(), Content: "group hello"},
+		{SenderID: bob, ReceiverID: alice, Content: "not mine"},
+	} {
+		msg.ContentType = models.ContentTypeText
+		_, err := suite.messageRepo.CreateMessage(suite.ctx, &msg)
+		suite.Require().NoError(err)
+	}
+
+	job, err := suite.exportService.StartExport(suite.ctx, me.ID)
+	suite.Require().NoError(err)
+
+	select {
+	case email := <-suite.emails.sent:
+		suite.Equal(me.Email, email.To)
+	case <-time.After(10 * time.Second):
+		suite.FailNow("export did not finish")
+	}
+
+	job, err = suite.exportService.GetExport(suite.ctx, me.ID, job.ID)
+	suite.Require().NoError(err)
+	suite.Equal(models.ExportStatusCompleted, job.Status)
+	link, err := url.Parse(job.DownloadURL)
+	suite.Require().NoError(err)
+	key := path.Base(link.Path)
+
+	// Tampered links are refused
+	_, err = suite.exportService.OpenDownload(suite.ctx, key, link.Query().Get("expires"), "bogus")
+	suite.ErrorIs(err, services.ErrInvalidDownload)
+
+	archive, err := suite.exportService.OpenDownload(suite.ctx, key, link.Query().Get("expires"), link.Query().Get("signature"))
+	suite.Require().NoError(err)
+	data, err := io.ReadAll(archive)
+	archive.Close()
+	suite.Require().NoError(err)
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	suite.Require().NoError(err)
+	files := map[string]*zip.File{}
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+	suite.Contains(files, "profile.json")
+	suite.Contains(files, "friendships.json")
+	suite.Contains(files, "groups.json")
+	suite.Require().Contains(files, "messages.json")
+
+	f, err := files["messages.json"].Open()
+	suite.Require().NoError(err)
+	defer f.Close()
+	var messages []models.Message
+	suite.Require().NoError(json.NewDecoder(f).Decode(&messages))
+	suite.Len(messages, 3)
+	for _, msg := range messages {
+		suite.NotEqual("not mine", msg.Content)
+	}
+}
@@ -0,0 +1,632 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/phone"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FriendshipIntegrationTestSuite struct {
+	suite.Suite
+	friendshipRepo *repositories.FriendshipRepository
+	redisClient    *redis.ClusterClient
+	mongoClient    *mongo.Client
+	db             *mongo.Database
+	testDBName     string
+	ctx            context.Context
+}
+
+func (suite *FriendshipIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.testDBName = "test_friendship_db"
+
+	mongoURI := os.Getenv("MONGO_URI")
+	opts := options.Client().ApplyURI(mongoURI)
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+
+	suite.redisClient = redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{os.Getenv("REDIS_ADDR")},
+	})
+}
+
+func (suite *FriendshipIntegrationTestSuite) TearDownSuite() {
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+	suite.redisClient.Close()
+}
+
+func (suite *FriendshipIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	suite.db = suite.mongoClient.Database(suite.testDBName)
+	suite.db.Drop(suite.ctx)
+	_, err := repositories.EnsureIndexes(suite.ctx, suite.db, time.Hour, false)
+	suite.Require().NoError(err)
+}
+
+func TestFriendshipIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(FriendshipIntegrationTestSuite))
+}
+
+func (suite *FriendshipIntegrationTestSuite) ttlIndexes() []bson.M {
+	cursor, err := suite.db.Collection("friendships").Indexes().List(suite.ctx)
+	suite.Require().NoError(err)
+
+	var specs, ttl []bson.M
+	suite.Require().NoError(cursor.All(suite.ctx, &specs))
+	for _, spec := range specs {
+		if _, ok := spec["expireAfterSeconds"]; ok {
+			ttl = append(ttl, spec)
+		}
+	}
+	return ttl
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestLegacyTTLIndexReplacedOnStartup() {
+	// Simulate a deployment created with the old collection-wide TTL index
+	suite.Require().NoError(suite.db.Collection("friendships").Drop(suite.ctx))
+	_, err := suite.db.Collection("friendships").Indexes().CreateOne(suite.ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+	})
+	suite.Require().NoError(err)
+
+	_, err = repositories.EnsureIndexes(suite.ctx, suite.db, time.Hour, false)
+	suite.Require().NoError(err)
+
+	ttl := suite.ttlIndexes()
+	suite.Require().Len(ttl, 1)
+	suite.Equal(bson.M{"status": models.FriendshipStatusPending}, ttl[0]["partialFilterExpression"])
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestOldAcceptedFriendshipIsKept() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+
+	userA := primitive.NewObjectID()
+	userB := primitive.NewObjectID()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	_, err := suite.db.Collection("friendships").InsertOne(suite.ctx, models.Friendship{
+		RequesterID: userA,
+		ReceiverID:  userB,
+		Status:      models.FriendshipStatusAccepted,
+		CreatedAt:   old,
+		UpdatedAt:   old,
+	})
+	suite.Require().NoError(err)
+
+	// Only pending requests are covered by the TTL index
+	for _, spec := range suite.ttlIndexes() {
+		suite.Equal(bson.M{"status": models.FriendshipStatusPending}, spec["partialFilterExpression"])
+	}
+
+	areFriends, err := suite.friendshipRepo.AreFriends(suite.ctx, userA, userB)
+	suite.Require().NoError(err)
+	suite.True(areFriends)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestPendingRequestIsDirectional() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+
+	userA := primitive.NewObjectID()
+	userB := primitive.NewObjectID()
+	request, err := suite.friendshipRepo.CreateRequest(suite.ctx, userA, userB)
+	suite.Require().NoError(err)
+
+	pending, err := suite.friendshipRepo.GetPendingRequest(suite.ctx, userA, userB)
+	suite.Require().NoError(err)
+	suite.Equal(request.ID, pending.ID)
+
+	_, err = suite.friendshipRepo.GetPendingRequest(suite.ctx, userB, userA)
+	suite.ErrorIs(err, repositories.ErrFriendRequestNotFound)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestSuggestUsersRanksFriendsAndSkipsBlocked() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	me := create("Alice")
+	stranger := create("alfred")
+	friend := create("ALvin")
+	blocker := create("albert")
+	create("bob")
+
+	suite.Require().NoError(userRepo.AddFriend(suite.ctx, me, friend))
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, blocker, me))
+
+	suggestions, err := userService.SuggestUsers(suite.ctx, me, "@Al", 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(suggestions, 2)
+	suite.Equal(friend, suggestions[0].ID)
+	suite.Equal(stranger, suggestions[1].ID)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestAcceptOldestOfSeveralPendingRequests() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	friendshipService := services.NewFriendshipService(suite.friendshipRepo, userRepo, suite.redisClient)
+
+	newUser := func(name string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{
+			Username:  name,
+			Email:     name + "@example.com",
+			Password:  "hashed",
+			Friends:   []primitive.ObjectID{},
+			CreatedAt: time.Now(),
+		})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	receiver := newUser("receiver")
+
+	var requests []*models.Friendship
+	for _, name := range []string{"first", "second", "third"} {
+		request, err := suite.friendshipRepo.CreateRequest(suite.ctx, newUser(name), receiver)
+		suite.Require().NoError(err)
+		requests = append(requests, request)
+		time.Sleep(10 * time.Millisecond)
+	}
+	oldest := requests[0]
+
+	suite.Require().NoError(friendshipService.RespondToRequest(suite.ctx, oldest.ID, receiver, true))
+
+	accepted, err := suite.friendshipRepo.GetFriendshipByID(suite.ctx, oldest.ID)
+	suite.Require().NoError(err)
+	suite.Equal(models.FriendshipStatusAccepted, accepted.Status)
+
+	requester, err := userRepo.FindUserByID(suite.ctx, oldest.RequesterID)
+	suite.Require().NoError(err)
+	suite.Contains(requester.Friends, receiver)
+	receiverUser, err := userRepo.FindUserByID(suite.ctx, receiver)
+	suite.Require().NoError(err)
+	suite.Equal([]primitive.ObjectID{oldest.RequesterID}, receiverUser.Friends)
+
+	// Accepting again is a no-op, and the other requests are still pending
+	suite.NoError(friendshipService.RespondToRequest(suite.ctx, oldest.ID, receiver, true))
+	for _, request := range requests[1:] {
+		pending, err := suite.friendshipRepo.GetFriendshipByID(suite.ctx, request.ID)
+		suite.Require().NoError(err)
+		suite.Equal(models.FriendshipStatusPending, pending.Status)
+	}
+
+	// Only the receiver can respond, and a rejected request can't be accepted
+	err = friendshipService.RespondToRequest(suite.ctx, requests[1].ID, requests[2].RequesterID, true)
+	suite.ErrorIs(err, repositories.ErrFriendRequestNotFound)
+	suite.Require().NoError(friendshipService.RespondToRequest(suite.ctx, requests[1].ID, receiver, false))
+	err = friendshipService.RespondToRequest(suite.ctx, requests[1].ID, receiver, true)
+	suite.ErrorIs(err, repositories.ErrFriendRequestNotFound)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestUnfriendClearsFriendListsAndCache() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	friendshipService := services.NewFriendshipService(suite.friendshipRepo, userRepo, suite.redisClient)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	userA := create("unfriend_a")
+	userB := create("unfriend_b")
+
+	request, err := friendshipService.SendRequest(suite.ctx, userA, userB)
+	suite.Require().NoError(err)
+	suite.Require().NoError(friendshipService.RespondToRequest(suite.ctx, request.ID, userB, true))
+	suite.Require().NoError(appredis.CacheFriends(suite.ctx, suite.redisClient, userA.Hex(), []primitive.ObjectID{userB}))
+
+	suite.Require().NoError(friendshipService.Unfriend(suite.ctx, userA, userB))
+
+	for _, id := range []primitive.ObjectID{userA, userB} {
+		friends, err := userRepo.GetFriendIDs(suite.ctx, id)
+		suite.Require().NoError(err)
+		suite.Empty(friends)
+	}
+	_, cached, err := appredis.GetFriends(suite.ctx, suite.redisClient, userA.Hex())
+	suite.Require().NoError(err)
+	suite.False(cached)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestFriendSuggestionsRankByMutualFriends() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	friendshipService := services.NewFriendshipService(suite.friendshipRepo, userRepo, suite.redisClient)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	me := create("me")
+	alice := create("alice")
+	bob := create("bob")
+	carol := create("carol")
+	dave := create("dave")
+	pending := create("pending")
+	blocker := create("blocker")
+
+	for _, pair := range [][2]primitive.ObjectID{
+		{me, alice}, {me, bob},
+		{carol, alice}, {carol, bob},
+		{dave, alice},
+		{pending, alice}, {blocker, alice},
+	} {
+		suite.Require().NoError(userRepo.AddFriend(suite.ctx, pair[0], pair[1]))
+	}
+	_, err := suite.friendshipRepo.CreateRequest(suite.ctx, pending, me)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, blocker, me))
+
+	suggestions, err := friendshipService.GetFriendSuggestions(suite.ctx, me, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(suggestions, 2)
+	suite.Equal(carol, suggestions[0].ID)
+	suite.Equal(2, suggestions[0].MutualFriendCount)
+	suite.ElementsMatch([]string{"alice", "bob"}, suggestions[0].MutualFriends)
+	suite.Equal(dave, suggestions[1].ID)
+	suite.Equal(1, suggestions[1].MutualFriendCount)
+
+	// Sending a request drops the cached suggestions
+	_, err = friendshipService.SendRequest(suite.ctx, me, dave)
+	suite.Require().NoError(err)
+	suggestions, err = friendshipService.GetFriendSuggestions(suite.ctx, me, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(suggestions, 1)
+	suite.Equal(carol, suggestions[0].ID)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestProfileFieldsDependOnRelationship() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	owner := create("owner")
+	friend := create("friend")
+	stranger := create("stranger")
+	blocked := create("blocked")
+	common := create("common")
+
+	suite.Require().NoError(userRepo.AddFriend(suite.ctx, owner, friend))
+	suite.Require().NoError(userRepo.AddFriend(suite.ctx, owner, common))
+	suite.Require().NoError(userRepo.AddFriend(suite.ctx, stranger, common))
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, owner, blocked))
+
+	self, err := userService.GetProfile(suite.ctx, owner, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.ProfileRelationshipSelf, self.Relationship)
+	suite.Equal("owner@example.com", self.Email)
+	suite.ElementsMatch([]primitive.ObjectID{friend, common}, self.Friends)
+	suite.Nil(self.MutualFriendCount)
+
+	asFriend, err := userService.GetProfile(suite.ctx, friend, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.ProfileRelationshipFriend, asFriend.Relationship)
+	suite.Empty(asFriend.Email)
+	suite.Len(asFriend.Friends, 2)
+	suite.Equal(0, *asFriend.MutualFriendCount)
+
+	asStranger, err := userService.GetProfile(suite.ctx, stranger, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.ProfileRelationshipNone, asStranger.Relationship)
+	suite.Empty(asStranger.Email)
+	suite.Nil(asStranger.Friends)
+	suite.Equal(2, asStranger.FriendCount)
+	suite.Equal(1, *asStranger.MutualFriendCount)
+
+	// Blocks hide the profile in both directions
+	_, err = userService.GetProfile(suite.ctx, blocked, owner)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+	_, err = userService.GetProfile(suite.ctx, owner, blocked)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+
+	suite.Require().NoError(userRepo.DeactivateUser(suite.ctx, owner, time.Now()))
+	_, err = userService.GetProfile(suite.ctx, friend, owner)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestListUsersSearchModes() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	viewer := create("viewer")
+	create("Alice")
+	create("alistair")
+	blocker := create("alan")
+	gone := create("albert")
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, blocker, viewer))
+	suite.Require().NoError(userRepo.DeactivateUser(suite.ctx, gone, time.Now()))
+
+	usernames := func(res *models.UserListResponse) []string {
+		names := make([]string, len(res.Users))
+		for i, u := range res.Users {
+			names[i] = u.Username
+		}
+		return names
+	}
+
+	// A prefix is case-insensitive and anchored
+	res, err := userService.ListUsers(suite.ctx, viewer, 1, 10, "AL")
+	suite.Require().NoError(err)
+	suite.Equal(models.UserSearchPrefix, res.SearchMode)
+	suite.Equal([]string{"Alice", "alistair"}, usernames(res))
+	suite.Equal(int64(2), res.Total)
+
+	res, err = userService.ListUsers(suite.ctx, viewer, 1, 10, "ice")
+	suite.Require().NoError(err)
+	suite.Empty(res.Users)
+
+	res, err = userService.ListUsers(suite.ctx, viewer, 1, 10, "alistair alan")
+	suite.Require().NoError(err)
+	suite.Equal(models.UserSearchText, res.SearchMode)
+	suite.Equal([]string{"alistair"}, usernames(res))
+
+	res, err = userService.ListUsers(suite.ctx, viewer, 1, 10, "")
+	suite.Require().NoError(err)
+	suite.Equal(models.UserSearchAll, res.SearchMode)
+	suite.Equal([]string{"Alice", "alistair", "viewer"}, usernames(res))
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestPhoneNumberVisibility() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	owner := create("owner")
+	friend := create("friend")
+	stranger := create("stranger")
+	suite.Require().NoError(userRepo.AddFriend(suite.ctx, owner, friend))
+	number := "+14155550100"
+	_, err := userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{PhoneNumber: &number})
+	suite.Require().NoError(err)
+
+	// Who sees the number under each setting; empty is the default
+	cases := []struct {
+		visibility string
+		friend     bool
+		stranger   bool
+	}{
+		{"", false, false},
+		{models.FriendListOnlyMe, false, false},
+		{models.FriendListFriends, true, false},
+		{models.FriendListEveryone, true, true},
+	}
+	for _, tc := range cases {
+		if tc.visibility != "" {
+			visibility := tc.visibility
+			_, err := userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{PhoneVisibility: &visibility})
+			suite.Require().NoError(err)
+		}
+
+		for viewer, visible := range map[primitive.ObjectID]bool{owner: true, friend: tc.friend, stranger: tc.stranger} {
+			want := ""
+			if visible {
+				want = number
+			}
+			profile, err := userService.GetProfile(suite.ctx, viewer, owner)
+			suite.Require().NoError(err)
+			suite.Equal(want, profile.PhoneNumber, "profile, visibility %q", tc.visibility)
+
+			res, err := userService.ListUsers(suite.ctx, viewer, 1, 10, "owner")
+			suite.Require().NoError(err)
+			suite.Require().Len(res.Users, 1)
+			suite.Equal(want, res.Users[0].PhoneNumber, "listing, visibility %q", tc.visibility)
+		}
+	}
+
+	invalid := "contacts"
+	_, err = userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{PhoneVisibility: &invalid})
+	suite.Error(err)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestDiscoverByPhoneSkipsOptedOutUsers() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	withNumber := func(username, number string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		_, err = userService.UpdateUser(suite.ctx, user.ID, &models.UserUpdateRequest{PhoneNumber: &number})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	viewer := withNumber("viewer", "+14155550100")
+	withNumber("alice", "+1 (415) 555-0101")
+	hidden := withNumber("hidden", "+14155550102")
+	blocker := withNumber("blocker", "+14155550103")
+	no := false
+	_, err := userService.UpdateUser(suite.ctx, hidden, &models.UserUpdateRequest{DiscoverableByPhone: &no})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, blocker, viewer))
+
+	hashes := []string{
+		phone.Hash("+14155550100"),
+		strings.ToUpper(phone.Hash("+14155550101")),
+		phone.Hash("+14155550102"),
+		phone.Hash("+14155550103"),
+		phone.Hash("+14155550199"), // not a user
+	}
+	res, err := userService.DiscoverByPhone(suite.ctx, viewer, hashes)
+	suite.Require().NoError(err)
+	suite.Require().Len(res.Users, 1)
+	suite.Equal("alice", res.Users[0].Username)
+
+	_, err = userService.DiscoverByPhone(suite.ctx, viewer, []string{"+14155550101"})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+
+	// Removing the number takes the user out of discovery
+	empty := ""
+	alice, err := userRepo.FindUserByUserName(suite.ctx, "alice")
+	suite.Require().NoError(err)
+	_, err = userService.UpdateUser(suite.ctx, alice.ID, &models.UserUpdateRequest{PhoneNumber: &empty})
+	suite.Require().NoError(err)
+	res, err = userService.DiscoverByPhone(suite.ctx, viewer, hashes)
+	suite.Require().NoError(err)
+	suite.Empty(res.Users)
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestListFriendsRespectsVisibility() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, repositories.NewFollowRepository(suite.db), suite.redisClient, nil)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	owner := create("owner")
+	bob := create("bob")
+	betty := create("Betty")
+	carl := create("carl")
+	stranger := create("stranger")
+	for _, friend := range []primitive.ObjectID{bob, betty, carl} {
+		suite.Require().NoError(userRepo.AddFriend(suite.ctx, owner, friend))
+	}
+
+	usernames := func(res *models.UserListResponse) []string {
+		names := make([]string, len(res.Users))
+		for i, u := range res.Users {
+			names[i] = u.Username
+		}
+		return names
+	}
+
+	res, err := userService.ListFriends(suite.ctx, owner, owner, 1, 2, "")
+	suite.Require().NoError(err)
+	suite.Equal([]string{"Betty", "bob"}, usernames(res))
+	suite.Equal(int64(3), res.Total)
+
+	res, err = userService.ListFriends(suite.ctx, owner, owner, 1, 10, "B")
+	suite.Require().NoError(err)
+	suite.Equal([]string{"Betty", "bob"}, usernames(res))
+
+	// By default friends see the list and strangers don't
+	res, err = userService.ListFriends(suite.ctx, carl, owner, 1, 10, "")
+	suite.Require().NoError(err)
+	suite.Len(res.Users, 3)
+	_, err = userService.ListFriends(suite.ctx, stranger, owner, 1, 10, "")
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	everyone := models.FriendListEveryone
+	_, err = userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{FriendListVisibility: &everyone})
+	suite.Require().NoError(err)
+	_, err = userService.ListFriends(suite.ctx, stranger, owner, 1, 10, "")
+	suite.NoError(err)
+
+	onlyMe := models.FriendListOnlyMe
+	_, err = userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{FriendListVisibility: &onlyMe})
+	suite.Require().NoError(err)
+	_, err = userService.ListFriends(suite.ctx, carl, owner, 1, 10, "")
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	profile, err := userService.GetProfile(suite.ctx, carl, owner)
+	suite.Require().NoError(err)
+	suite.Empty(profile.Friends)
+
+	// Blocks hide the owner entirely
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, owner, stranger))
+	_, err = userService.ListFriends(suite.ctx, stranger, owner, 1, 10, "")
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+}
+
+func (suite *FriendshipIntegrationTestSuite) TestFollowPrivateAccount() {
+	suite.friendshipRepo = repositories.NewFriendshipRepository(suite.db)
+	userRepo := repositories.NewUserRepository(suite.db)
+	followRepo := repositories.NewFollowRepository(suite.db)
+	userService := services.NewUserService(userRepo, suite.friendshipRepo, followRepo, suite.redisClient, nil)
+	followService := services.NewFollowService(followRepo, userRepo, suite.friendshipRepo)
+
+	create := func(username string) primitive.ObjectID {
+		user, err := userRepo.CreateUser(suite.ctx, &models.User{Username: username, Email: username + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	owner := create("owner")
+	fan := create("fan")
+	stranger := create("stranger")
+
+	private := true
+	_, err := userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{IsPrivate: &private})
+	suite.Require().NoError(err)
+
+	res, err := followService.Follow(suite.ctx, fan, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.FollowStatusPending, res.Status)
+	_, err = followService.ListFollowers(suite.ctx, fan, owner, 1, 10, "")
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	requests, err := followService.ListFollowRequests(suite.ctx, owner, 1, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(requests.Users, 1)
+	suite.Equal("fan", requests.Users[0].Username)
+
+	suite.Require().NoError(followService.RespondToFollowRequest(suite.ctx, owner, fan, true))
+	followers, err := followService.ListFollowers(suite.ctx, fan, owner, 1, 10, "")
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), followers.Total)
+	_, err = followService.ListFollowing(suite.ctx, stranger, owner, 1, 10, "")
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	profile, err := userService.GetProfile(suite.ctx, fan, owner)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), profile.FollowerCount)
+	suite.Equal(models.FollowStatusAccepted, profile.FollowStatus)
+
+	// Going public accepts waiting requests
+	res, err = followService.Follow(suite.ctx, stranger, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.FollowStatusPending, res.Status)
+	public := false
+	_, err = userService.UpdateUser(suite.ctx, owner, &models.UserUpdateRequest{IsPrivate: &public})
+	suite.Require().NoError(err)
+	status, err := followRepo.GetStatus(suite.ctx, stranger, owner)
+	suite.Require().NoError(err)
+	suite.Equal(models.FollowStatusAccepted, status)
+
+	// Blocking ends the follow
+	suite.Require().NoError(suite.friendshipRepo.BlockUser(suite.ctx, owner, fan))
+	status, err = followRepo.GetStatus(suite.ctx, fan, owner)
+	suite.Require().NoError(err)
+	suite.Empty(status)
+	_, err = followService.Follow(suite.ctx, fan, owner)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+}
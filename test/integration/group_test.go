@@ -0,0 +1,1418 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"messaging-app/config"
+	"messaging-app/internal/controllers"
+	"messaging-app/internal/kafka"
+	"messaging-app/internal/linkpreview"
+	"messaging-app/internal/models"
+	appredis "messaging-app/internal/redis"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/services"
+	"messaging-app/pkg/apperrors"
+	"messaging-app/pkg/contentfilter"
+	"messaging-app/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// capturingPreviewQueue records queued link previews instead of producing them to Kafka
+type capturingPreviewQueue struct {
+	jobs []models.LinkPreviewJob
+}
+
+func (q *capturingPreviewQueue) QueueLinkPreview(ctx context.Context, job models.LinkPreviewJob) error {
+	q.jobs = append(q.jobs, job)
+	return nil
+}
+
+// switchablePublisher stands in for Kafka in the outbox and can be taken down
+type switchablePublisher struct {
+	down      bool
+	published [][]byte
+}
+
+func (p *switchablePublisher) Publish(ctx context.Context, key string, value []byte) error {
+	if p.down {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, value)
+	return nil
+}
+
+type GroupIntegrationTestSuite struct {
+	suite.Suite
+	groupService   *services.GroupService
+	messageService *services.MessageService
+	previewService *services.LinkPreviewService
+	previewRepo    *repositories.LinkPreviewRepository
+	previews       *capturingPreviewQueue
+	outboxRelay    *services.OutboxRelay
+	publisher      *switchablePublisher
+	messageRepo    *repositories.MessageRepository
+	groupRepo      *repositories.GroupRepository
+	pollRepo       *repositories.PollRepository
+	userRepo       *repositories.UserRepository
+	redisClient    *redis.ClusterClient
+	producer       *kafka.MessageProducer
+	mongoClient    *mongo.Client
+	testDBName   string
+	ctx          context.Context
+}
+
+func (suite *GroupIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.testDBName = "test_group_db"
+
+	mongoURI := os.Getenv("MONGO_URI")
+	opts := options.Client().ApplyURI(mongoURI)
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+
+	suite.redisClient = redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{os.Getenv("REDIS_ADDR")},
+	})
+
+	// The producer is async, so sends succeed without a reachable broker
+	suite.producer = kafka.NewMessageProducer([]string{os.Getenv("KAFKA_BROKERS")}, "test_group_messages")
+}
+
+func (suite *GroupIntegrationTestSuite) TearDownSuite() {
+	suite.producer.Close()
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+	suite.redisClient.Close()
+}
+
+func (suite *GroupIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	// Clear data before each test and recreate the indexes
+	db := suite.mongoClient.Database(suite.testDBName)
+	db.Drop(suite.ctx)
+	_, err := repositories.EnsureIndexes(suite.ctx, db, time.Hour, false)
+	suite.Require().NoError(err)
+	suite.redisClient.FlushDB(suite.ctx)
+	suite.userRepo = repositories.NewUserRepository(db)
+	suite.groupRepo = repositories.NewGroupRepository(db)
+	suite.messageRepo = repositories.NewMessageRepository(db)
+	suite.pollRepo = repositories.NewPollRepository(db)
+	messageRepo := suite.messageRepo
+	suite.groupService = services.NewGroupService(suite.groupRepo, suite.userRepo, messageRepo, suite.redisClient, suite.producer)
+
+	suite.previewRepo = repositories.NewLinkPreviewRepository(db)
+	suite.previewService = services.NewLinkPreviewService(suite.previewRepo, messageRepo, linkpreview.NewFetcher(time.Second), suite.producer, time.Hour)
+	suite.previews = &capturingPreviewQueue{}
+	suite.publisher = &switchablePublisher{}
+	suite.outboxRelay = services.NewOutboxRelay(repositories.NewOutboxRepository(db), suite.publisher, suite.redisClient)
+
+	mediaService := services.NewMediaService(repositories.NewMediaRepository(db), nil, &config.Config{})
+	suite.messageService = services.NewMessageService(
+		messageRepo,
+		suite.groupRepo,
+		repositories.NewFriendshipRepository(db),
+		suite.userRepo,
+		suite.producer,
+		suite.redisClient,
+		mediaService,
+		suite.previews,
+		suite.outboxRelay,
+		suite.pollRepo,
+		repositories.NewStarRepository(db),
+		repositories.NewArchiveRepository(db),
+	)
+}
+
+func TestGroupIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(GroupIntegrationTestSuite))
+}
+
+func (suite *GroupIntegrationTestSuite) createUsers(n int) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, n)
+	for i := 0; i < n; i++ {
+		user, err := suite.userRepo.CreateUser(suite.ctx, &models.User{
+			Username: fmt.Sprintf("group_user_%d", i),
+			Email:    fmt.Sprintf("group_user_%d@example.com", i),
+		})
+		suite.Require().NoError(err)
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func (suite *GroupIntegrationTestSuite) TestLastAdminCannotLeave() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "leavers", users[1:])
+	suite.Require().NoError(err)
+
+	err = suite.groupService.LeaveGroup(suite.ctx, group.ID, users[0])
+	suite.EqualError(err, "last admin must transfer admin rights before leaving")
+
+	// Regular members can leave freely
+	suite.NoError(suite.groupService.LeaveGroup(suite.ctx, group.ID, users[1]))
+	updated, err := suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.NotContains(updated.Members, users[1])
+}
+
+func (suite *GroupIntegrationTestSuite) TestOwnerMustTransferBeforeLeaving() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "succession", users[1:])
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.groupService.AddAdmin(suite.ctx, group.ID, users[0], users[1]))
+
+	suite.EqualError(suite.groupService.LeaveGroup(suite.ctx, group.ID, users[0]), "owner must transfer ownership before leaving")
+	suite.EqualError(suite.groupService.RemoveMember(suite.ctx, group.ID, users[1], users[0]), "cannot remove the group owner")
+
+	suite.Require().NoError(suite.groupService.TransferOwnership(suite.ctx, group.ID, users[0], users[2]))
+	suite.NoError(suite.groupService.LeaveGroup(suite.ctx, group.ID, users[0]))
+
+	updated, err := suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.Equal(users[2], updated.Owner())
+	suite.Equal(users[0], updated.CreatorID)
+	suite.Contains(updated.Admins, users[2])
+	suite.NotContains(updated.Members, users[0])
+}
+
+func (suite *GroupIntegrationTestSuite) TestOnlyOwnerCanDemoteAdminsAndDeleteGroup() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "hierarchy", users[1:])
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.groupService.AddAdmin(suite.ctx, group.ID, users[0], users[1]))
+	suite.Require().NoError(suite.groupService.AddAdmin(suite.ctx, group.ID, users[0], users[2]))
+
+	suite.EqualError(suite.groupService.RemoveAdmin(suite.ctx, group.ID, users[1], users[2]), "only the owner can demote admins")
+	suite.NoError(suite.groupService.RemoveAdmin(suite.ctx, group.ID, users[0], users[2]))
+
+	suite.EqualError(suite.groupService.DeleteGroup(suite.ctx, group.ID, users[1]), "only the owner can delete the group")
+	suite.NoError(suite.groupService.DeleteGroup(suite.ctx, group.ID, users[0]))
+	_, err = suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Error(err)
+}
+
+func (suite *GroupIntegrationTestSuite) TestModeratorCanDeleteOthersMessages() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "moderated", users[1:])
+	suite.Require().NoError(err)
+
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[2], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "spam",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.messageService.DeleteMessage(suite.ctx, msg.ID.Hex(), users[1])
+	suite.EqualError(err, "not authorized to delete this message")
+
+	suite.EqualError(suite.groupService.AddModerator(suite.ctx, group.ID, users[1], users[1]), "only admins can manage moderators")
+	suite.Require().NoError(suite.groupService.AddModerator(suite.ctx, group.ID, users[0], users[1]))
+
+	deleted, err := suite.messageService.DeleteMessage(suite.ctx, msg.ID.Hex(), users[1])
+	suite.Require().NoError(err)
+	suite.True(deleted.IsDeleted)
+	suite.Equal(users[2], deleted.SenderID)
+}
+
+func (suite *GroupIntegrationTestSuite) TestForbiddenDeleteReturnsErrorEnvelope() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "envelope", users[1:])
+	suite.Require().NoError(err)
+
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[2], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "mine",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler())
+	router.DELETE("/messages/:id", func(c *gin.Context) {
+		c.Set("userID", users[1].Hex())
+	}, controllers.NewMessageController(suite.messageService).DeleteMessage)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/messages/"+msg.ID.Hex(), nil))
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	var body apperrors.Response
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	suite.Equal("forbidden", body.Code)
+	suite.Equal("not authorized to delete this message", body.Message)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/messages/"+primitive.NewObjectID().Hex(), nil))
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestNonMemberCannotLeave() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "outsiders", users[1:2])
+	suite.Require().NoError(err)
+
+	err = suite.groupService.LeaveGroup(suite.ctx, group.ID, users[2])
+	suite.EqualError(err, "not a group member")
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupMembersPagination() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "paged", users[1:])
+	suite.Require().NoError(err)
+
+	members, _, total, err := suite.groupService.GetGroupMembers(suite.ctx, group.ID, users[0], 2, 2)
+	suite.Require().NoError(err)
+	suite.Equal(int64(3), total)
+	suite.Len(members, 1)
+
+	// Pages far past the end are empty rather than overflowing the offset
+	for _, page := range []int64{3, math.MaxInt64/2 + 1, math.MaxInt64} {
+		members, _, _, err = suite.groupService.GetGroupMembers(suite.ctx, group.ID, users[0], page, 2)
+		suite.Require().NoError(err)
+		suite.Empty(members, "page %d", page)
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) TestRemovedMemberCannotSendWithWarmCache() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "cached", users[1:])
+	suite.Require().NoError(err)
+
+	send := func(sender primitive.ObjectID) error {
+		_, err := suite.messageService.SendMessage(suite.ctx, sender, models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     "hello",
+			ContentType: models.ContentTypeText,
+		})
+		return err
+	}
+
+	// Reading the group warms the member cache
+	_, err = suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.Require().NoError(send(users[1]))
+
+	suite.Require().NoError(suite.groupService.RemoveMember(suite.ctx, group.ID, users[0], users[1]))
+	suite.EqualError(send(users[1]), "not a group member")
+
+	// Leaving invalidates the cache too
+	suite.Require().NoError(send(users[2]))
+	suite.Require().NoError(suite.groupService.LeaveGroup(suite.ctx, group.ID, users[2]))
+	suite.EqualError(send(users[2]), "not a group member")
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupMessageUnreadCounts() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "badges", users[1:])
+	suite.Require().NoError(err)
+
+	var sent []primitive.ObjectID
+	for i := 0; i < 2; i++ {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     "ping",
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		sent = append(sent, msg.ID)
+	}
+
+	// The first read rebuilds the hash from Mongo, later sends increment it
+	counts, err := suite.messageService.GetUnreadCountsByConversation(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{group.ID.Hex(): 2}, counts)
+
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[1], sent[:1]))
+	total, err := suite.messageService.GetUnreadCount(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), total)
+
+	// The sender never has unread messages of their own
+	total, err = suite.messageService.GetUnreadCount(suite.ctx, users[0])
+	suite.Require().NoError(err)
+	suite.Equal(int64(0), total)
+}
+
+func (suite *GroupIntegrationTestSuite) TestOutsidersCannotMarkMessagesSeen() {
+	users := suite.createUsers(4)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "private", users[1:2])
+	suite.Require().NoError(err)
+	inGroup, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "members only",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	direct, err := suite.messageRepo.CreateMessage(suite.ctx, &models.Message{
+		SenderID:    users[0],
+		ReceiverID:  users[2],
+		Content:     "just for you",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	ids := []primitive.ObjectID{inGroup.ID, direct.ID}
+
+	// users[3] is in neither conversation. Nothing matches, so no receipt is
+	// stored and no MessagesSeen event is produced.
+	outsider := users[3]
+	unseen, err := suite.messageRepo.GetUnseenMessages(suite.ctx, outsider, nil, ids)
+	suite.Require().NoError(err)
+	suite.Empty(unseen)
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, outsider, ids))
+	for _, id := range ids {
+		msg, err := suite.messageRepo.GetMessageByID(suite.ctx, id)
+		suite.Require().NoError(err)
+		suite.Empty(msg.SeenBy)
+	}
+	unread, err := suite.redisClient.HGetAll(suite.ctx, "unread:"+outsider.Hex()).Result()
+	suite.Require().NoError(err)
+	suite.Empty(unread)
+
+	// The participants still can
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[1], ids))
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[2], ids))
+	for _, id := range ids {
+		msg, err := suite.messageRepo.GetMessageByID(suite.ctx, id)
+		suite.Require().NoError(err)
+		suite.Len(msg.SeenBy, 1)
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupMentionCounts() {
+	users := suite.createUsers(4)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "mentions", users[1:3])
+	suite.Require().NoError(err)
+
+	send := func(content string) *models.Message {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		return msg
+	}
+
+	// group_user_3 isn't a member and the sender can't mention themselves
+	both := send("@group_user_1 @Group_User_2 @group_user_3 @group_user_0 standup?")
+	suite.ElementsMatch([]primitive.ObjectID{users[1], users[2]}, both.Mentions)
+
+	// The first read rebuilds the hash from Mongo, later sends increment it
+	counts, err := suite.messageService.GetUnreadCounts(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{group.ID.Hex(): 1}, counts.Mentions)
+
+	send("no mentions")
+	one := send("@group_user_1 and @group_user_1 again")
+	suite.Equal([]primitive.ObjectID{users[1]}, one.Mentions)
+
+	counts, err = suite.messageService.GetUnreadCounts(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{group.ID.Hex(): 3}, counts.Messages)
+	suite.Equal(map[string]int64{group.ID.Hex(): 2}, counts.Mentions)
+
+	conversations, err := suite.messageService.GetConversations(suite.ctx, users[2], 1, 20, false)
+	suite.Require().NoError(err)
+	suite.Require().Len(conversations.Conversations, 1)
+	suite.Equal(int64(3), conversations.Conversations[0].UnreadCount)
+	suite.Equal(int64(1), conversations.Conversations[0].MentionCount)
+
+	// Reading the mentions clears them
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[1], []primitive.ObjectID{both.ID}))
+	counts, err = suite.messageService.GetUnreadCounts(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{group.ID.Hex(): 1}, counts.Mentions)
+
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[1], []primitive.ObjectID{one.ID}))
+	counts, err = suite.messageService.GetUnreadCounts(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Empty(counts.Mentions)
+	suite.Equal(map[string]int64{group.ID.Hex(): 1}, counts.Messages)
+}
+
+func (suite *GroupIntegrationTestSuite) TestInviteJoinAndApproval() {
+	users := suite.createUsers(4)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "invited", nil)
+	suite.Require().NoError(err)
+
+	_, err = suite.groupService.CreateInvite(suite.ctx, group.ID, users[1], 0, 0)
+	suite.EqualError(err, "only admins can manage invites")
+
+	// A single-use invite joins directly while approval is off
+	invite, err := suite.groupService.CreateInvite(suite.ctx, group.ID, users[0], time.Hour, 1)
+	suite.Require().NoError(err)
+	joined, err := suite.groupService.JoinWithInvite(suite.ctx, invite.Token, users[1])
+	suite.Require().NoError(err)
+	suite.Equal("joined", joined.Status)
+	_, err = suite.groupService.JoinWithInvite(suite.ctx, invite.Token, users[2])
+	suite.EqualError(err, "invite is no longer valid")
+
+	// With approval on, joining files a request for admins to review
+	suite.Require().NoError(suite.groupService.UpdateGroup(suite.ctx, group.ID, users[0], map[string]interface{}{"require_approval": true}))
+	invite, err = suite.groupService.CreateInvite(suite.ctx, group.ID, users[0], 0, 0)
+	suite.Require().NoError(err)
+	pending, err := suite.groupService.JoinWithInvite(suite.ctx, invite.Token, users[2])
+	suite.Require().NoError(err)
+	suite.Equal(models.JoinRequestPending, pending.Status)
+	_, err = suite.groupService.JoinWithInvite(suite.ctx, invite.Token, users[2])
+	suite.EqualError(err, "join request already pending")
+	rejected, err := suite.groupService.JoinWithInvite(suite.ctx, invite.Token, users[3])
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.groupService.ResolveJoinRequest(suite.ctx, group.ID, users[0], *pending.RequestID, true))
+	suite.Require().NoError(suite.groupService.ResolveJoinRequest(suite.ctx, group.ID, users[0], *rejected.RequestID, false))
+	suite.EqualError(suite.groupService.ResolveJoinRequest(suite.ctx, group.ID, users[0], *pending.RequestID, true), "join request not found")
+
+	updated, err := suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.Contains(updated.Members, users[1])
+	suite.Contains(updated.Members, users[2])
+	suite.NotContains(updated.Members, users[3])
+
+	// Revoked invites stop working
+	suite.Require().NoError(suite.groupService.RevokeInvite(suite.ctx, group.ID, users[0], invite.ID))
+	_, err = suite.groupService.GetInvitePreview(suite.ctx, invite.Token)
+	suite.EqualError(err, "invite is no longer valid")
+}
+
+func (suite *GroupIntegrationTestSuite) TestForwardMessageToAnotherGroup() {
+	users := suite.createUsers(3)
+	source, err := suite.groupService.CreateGroup(suite.ctx, users[0], "source", users[1:2])
+	suite.Require().NoError(err)
+	destination, err := suite.groupService.CreateGroup(suite.ctx, users[0], "destination", users[2:])
+	suite.Require().NoError(err)
+
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+		GroupID:     source.ID.Hex(),
+		Content:     "worth sharing",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	forward := models.ForwardMessageRequest{GroupID: destination.ID.Hex()}
+	forwarded, err := suite.messageService.ForwardMessage(suite.ctx, users[0], msg.ID, forward)
+	suite.Require().NoError(err)
+	suite.Equal(destination.ID, forwarded.GroupID)
+	suite.Equal(users[0], forwarded.SenderID)
+	suite.Equal("worth sharing", forwarded.Content)
+	suite.Require().NotNil(forwarded.ForwardedFrom)
+	suite.Equal("group_user_1", forwarded.ForwardedFrom.SenderName)
+	suite.WithinDuration(msg.CreatedAt, forwarded.ForwardedFrom.SentAt, time.Millisecond)
+
+	// Only participants of the source may forward, and only into conversations they can post to
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[2], msg.ID, forward)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[1], msg.ID, forward)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	_, err = suite.messageService.DeleteMessage(suite.ctx, msg.ID.Hex(), users[1])
+	suite.Require().NoError(err)
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[0], msg.ID, forward)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+}
+
+func (suite *GroupIntegrationTestSuite) TestPinMessages() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "pins", users[1:])
+	suite.Require().NoError(err)
+	other, err := suite.groupService.CreateGroup(suite.ctx, users[0], "elsewhere", nil)
+	suite.Require().NoError(err)
+
+	send := func(groupID primitive.ObjectID, content string) *models.Message {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     groupID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		return msg
+	}
+	first := send(group.ID, "rules")
+	second := send(group.ID, "schedule")
+	foreign := send(other.ID, "not here")
+
+	_, err = suite.groupService.PinMessage(suite.ctx, group.ID, users[1], first.ID)
+	suite.EqualError(err, "only admins can pin messages")
+	_, err = suite.groupService.PinMessage(suite.ctx, group.ID, users[0], foreign.ID)
+	suite.EqualError(err, "message does not belong to this group")
+
+	_, err = suite.groupService.PinMessage(suite.ctx, group.ID, users[0], first.ID)
+	suite.Require().NoError(err)
+	_, err = suite.groupService.PinMessage(suite.ctx, group.ID, users[0], second.ID)
+	suite.Require().NoError(err)
+	_, err = suite.groupService.PinMessage(suite.ctx, group.ID, users[0], first.ID)
+	suite.EqualError(err, "message is already pinned")
+
+	pins, err := suite.groupService.ListPinnedMessages(suite.ctx, group.ID, users[1])
+	suite.Require().NoError(err)
+	suite.Require().Len(pins, 2)
+	suite.Equal(second.ID, pins[0].MessageID)
+	suite.Equal("schedule", pins[0].Message.Content)
+	suite.Equal(users[0], pins[1].PinnedBy)
+
+	// Deleting a pinned message unpins it
+	_, err = suite.messageService.DeleteMessage(suite.ctx, second.ID.Hex(), users[1])
+	suite.Require().NoError(err)
+	updated, err := suite.groupRepo.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(updated.PinnedMessages, 1)
+	suite.Equal(first.ID, updated.PinnedMessages[0].MessageID)
+
+	suite.NoError(suite.groupService.UnpinMessage(suite.ctx, group.ID, users[0], first.ID))
+	suite.EqualError(suite.groupService.UnpinMessage(suite.ctx, group.ID, users[0], first.ID), "message is not pinned")
+}
+
+func (suite *GroupIntegrationTestSuite) TestLinkPreviewIsAttachedToMessage() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "links", users[1:])
+	suite.Require().NoError(err)
+
+	send := func(content string) *models.Message {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		return msg
+	}
+
+	send("no links here")
+	msg := send("have a look at HTTPS://Example.com:443/article#intro.")
+	suite.Require().Len(suite.previews.jobs, 1)
+	job := suite.previews.jobs[0]
+	suite.Equal(msg.ID, job.MessageID)
+	suite.Equal("HTTPS://Example.com:443/article#intro", job.URL)
+
+	// A fresh stored preview is reused without fetching the page
+	suite.Require().NoError(suite.previewRepo.SavePreview(suite.ctx, &models.LinkPreview{
+		URL:       "https://example.com/article",
+		Title:     "An article",
+		FetchedAt: time.Now(),
+	}))
+	suite.Require().NoError(suite.previewService.GeneratePreview(suite.ctx, job))
+
+	stored, err := suite.messageService.GetAllMessages(suite.ctx, models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[0].Hex(), Page: 1, Limit: 10})
+	suite.Require().NoError(err)
+	var preview *models.LinkPreview
+	for _, m := range stored {
+		if m.ID == msg.ID {
+			preview = m.LinkPreview
+		}
+	}
+	suite.Require().NotNil(preview)
+	suite.Equal("An article", preview.Title)
+}
+
+func (suite *GroupIntegrationTestSuite) TestLinkPreviewRefusesInternalAddresses() {
+	users := suite.createUsers(1)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "internal", nil)
+	suite.Require().NoError(err)
+
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><meta property="og:title" content="secret"></head></html>`)
+	}))
+	defer server.Close()
+
+	link := server.URL + "/admin"
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     link,
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.previewService.GeneratePreview(suite.ctx, models.LinkPreviewJob{MessageID: msg.ID, URL: link}))
+
+	suite.Zero(hits)
+	_, err = suite.previewRepo.GetPreview(suite.ctx, link)
+	suite.ErrorIs(err, mongo.ErrNoDocuments)
+}
+
+func (suite *GroupIntegrationTestSuite) TestSearchGroupMessagesRequiresMembership() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "search", users[1:2])
+	suite.Require().NoError(err)
+
+	for _, content := range []string{"release on friday", "coffee?", "release notes are up"} {
+		_, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		// Keep created_at strictly increasing between messages
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	query := models.MessageSearchQuery{Query: "release", GroupID: group.ID.Hex(), Page: 1, Limit: 1}
+	response, err := suite.messageService.SearchMessages(suite.ctx, users[0], query)
+	suite.Require().NoError(err)
+	suite.True(response.HasMore)
+	suite.Require().Len(response.Results, 1)
+	suite.Equal("release notes are up", response.Results[0].Message.Content)
+	suite.Require().NotNil(response.Results[0].Before)
+	suite.Equal("coffee?", response.Results[0].Before.Content)
+	suite.Nil(response.Results[0].After)
+
+	_, err = suite.messageService.SearchMessages(suite.ctx, users[2], query)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+}
+
+func (suite *GroupIntegrationTestSuite) TestOutboxRelaysMessagesAfterBrokerOutage() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "outbox", users[1:])
+	suite.Require().NoError(err)
+
+	suite.publisher.down = true
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "sent during an outage",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err, "the message is stored even though Kafka is down")
+	suite.Empty(suite.publisher.published)
+
+	// Skip the delivery grace period
+	outbox := suite.mongoClient.Database(suite.testDBName).Collection("outbox")
+	_, err = outbox.UpdateMany(suite.ctx, bson.M{}, bson.M{"$set": bson.M{"next_attempt_at": time.Now().Add(-time.Second)}})
+	suite.Require().NoError(err)
+
+	suite.Error(suite.outboxRelay.RelayPending(suite.ctx))
+	var pending models.OutboxEvent
+	suite.Require().NoError(outbox.FindOne(suite.ctx, bson.M{}).Decode(&pending))
+	suite.Equal(1, pending.Attempts)
+	suite.Nil(pending.SentAt)
+	suite.True(pending.NextAttemptAt.After(time.Now()), "failed events back off")
+
+	_, err = outbox.UpdateMany(suite.ctx, bson.M{}, bson.M{"$set": bson.M{"next_attempt_at": time.Now().Add(-time.Second)}})
+	suite.Require().NoError(err)
+	suite.publisher.down = false
+	suite.Require().NoError(suite.outboxRelay.RelayPending(suite.ctx))
+
+	suite.Require().Len(suite.publisher.published, 1)
+	var relayed models.Message
+	suite.Require().NoError(json.Unmarshal(suite.publisher.published[0], &relayed))
+	suite.Equal(msg.ID, relayed.ID)
+	suite.Require().NoError(outbox.FindOne(suite.ctx, bson.M{}).Decode(&pending))
+	suite.NotNil(pending.SentAt)
+
+	// Nothing is published twice
+	suite.Require().NoError(suite.outboxRelay.RelayPending(suite.ctx))
+	suite.Len(suite.publisher.published, 1)
+}
+
+func (suite *GroupIntegrationTestSuite) TestUndoSendHoldsMessagesUntilDispatched() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "undo", users[1:])
+	suite.Require().NoError(err)
+	_, err = suite.userRepo.UpdateUser(suite.ctx, users[0], bson.M{"undo_send_seconds": 10})
+	suite.Require().NoError(err)
+
+	send := func(content string) *models.Message {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		suite.Equal(models.MessageStatusPendingDispatch, msg.Status)
+		return msg
+	}
+	cancelled := send("oops")
+	kept := send("hello")
+	suite.Empty(suite.publisher.published, "held-back messages are not delivered")
+
+	// Only the sender sees held-back messages
+	counts, err := suite.messageService.GetUnreadCountsByConversation(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Empty(counts)
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[1], kept.ID, models.ForwardMessageRequest{GroupID: group.ID.Hex()})
+	suite.True(errors.Is(err, apperrors.ErrNotFound))
+	reply := func() error {
+		_, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     "what did you say?",
+			ContentType: models.ContentTypeText,
+			ReplyTo:     kept.ID.Hex(),
+		})
+		return err
+	}
+	suite.True(errors.Is(reply(), apperrors.ErrNotFound), "a reply preview must not copy a held-back message")
+
+	// Deleting within the window withdraws the message entirely
+	tombstone, err := suite.messageService.DeleteMessage(suite.ctx, cancelled.ID.Hex(), users[0])
+	suite.Require().NoError(err)
+	suite.True(tombstone.IsDeleted)
+	_, err = suite.messageRepo.GetMessageByID(suite.ctx, cancelled.ID)
+	suite.True(errors.Is(err, mongo.ErrNoDocuments))
+
+	// Nothing is due until the window has passed
+	dispatched, err := suite.messageService.DispatchDueMessages(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Zero(dispatched)
+
+	messages := suite.mongoClient.Database(suite.testDBName).Collection("messages")
+	_, err = messages.UpdateMany(suite.ctx, bson.M{}, bson.M{"$set": bson.M{"dispatch_at": time.Now().Add(-time.Second)}})
+	suite.Require().NoError(err)
+	dispatched, err = suite.messageService.DispatchDueMessages(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Equal(1, dispatched)
+
+	suite.Require().Len(suite.publisher.published, 1)
+	var delivered models.Message
+	suite.Require().NoError(json.Unmarshal(suite.publisher.published[0], &delivered))
+	suite.Equal(kept.ID, delivered.ID)
+	suite.Empty(delivered.Status)
+
+	counts, err = suite.messageService.GetUnreadCountsByConversation(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{group.ID.Hex(): 1}, counts)
+
+	// Once delivered, deleting leaves a tombstone like any other message
+	_, err = suite.messageService.DeleteMessage(suite.ctx, kept.ID.Hex(), users[0])
+	suite.Require().NoError(err)
+	stored, err := suite.messageRepo.GetMessageByID(suite.ctx, kept.ID)
+	suite.Require().NoError(err)
+	suite.True(stored.IsDeleted)
+	suite.True(errors.Is(reply(), apperrors.ErrNotFound), "deleted messages can't be replied to")
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupSettingsPermissions() {
+	users := suite.createUsers(4)
+	owner, admin, member := users[0], users[1], users[2]
+	group, err := suite.groupService.CreateGroup(suite.ctx, owner, "settings", []primitive.ObjectID{admin, member})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.groupService.AddAdmin(suite.ctx, group.ID, owner, admin))
+
+	// Only admins change details and settings, and only to known values
+	err = suite.groupService.UpdateGroup(suite.ctx, group.ID, member, map[string]interface{}{"settings.send_messages": "admins"})
+	suite.EqualError(err, "only admins can update group")
+	err = suite.groupService.UpdateGroup(suite.ctx, group.ID, admin, map[string]interface{}{"settings.send_messages": "nobody"})
+	suite.EqualError(err, "invalid group settings")
+	suite.Require().NoError(suite.groupService.UpdateGroup(suite.ctx, group.ID, admin, map[string]interface{}{
+		"description": "team chat",
+		"avatar":      "http://localhost:8080/media/team.png",
+	}))
+	updated, err := suite.groupService.GetGroup(suite.ctx, group.ID)
+	suite.Require().NoError(err)
+	suite.Equal("team chat", updated.Description)
+	suite.Equal(models.GroupSettings{AddMembers: "admins", SendMessages: "all"}, updated.Settings.WithDefaults())
+
+	for _, tc := range []struct {
+		setting string
+		allowed map[primitive.ObjectID]bool
+	}{
+		{models.GroupPermissionAdmins, map[primitive.ObjectID]bool{owner: true, admin: true}},
+		{models.GroupPermissionAll, map[primitive.ObjectID]bool{owner: true, admin: true, member: true}},
+	} {
+		suite.Require().NoError(suite.groupService.UpdateGroup(suite.ctx, group.ID, owner, map[string]interface{}{
+			"settings.add_members":   tc.setting,
+			"settings.send_messages": tc.setting,
+		}))
+
+		for _, requester := range []primitive.ObjectID{owner, admin, member} {
+			newcomer, err := suite.userRepo.CreateUser(suite.ctx, &models.User{
+				Username: "newcomer_" + primitive.NewObjectID().Hex(),
+				Email:    primitive.NewObjectID().Hex() + "@example.com",
+			})
+			suite.Require().NoError(err)
+			err = suite.groupService.AddMember(suite.ctx, group.ID, requester, newcomer.ID)
+			if tc.allowed[requester] {
+				suite.NoError(err, "add_members=%s", tc.setting)
+			} else {
+				suite.EqualError(err, "only admins can add members", "add_members=%s", tc.setting)
+			}
+
+			_, err = suite.messageService.SendMessage(suite.ctx, requester, models.MessageRequest{
+				GroupID:     group.ID.Hex(),
+				Content:     "hello",
+				ContentType: models.ContentTypeText,
+			})
+			if tc.allowed[requester] {
+				suite.NoError(err, "send_messages=%s", tc.setting)
+			} else {
+				suite.True(errors.Is(err, apperrors.ErrForbidden), "send_messages=%s", tc.setting)
+			}
+		}
+
+		// Outsiders can do neither, whatever the settings
+		err = suite.groupService.AddMember(suite.ctx, group.ID, users[3], primitive.NewObjectID())
+		suite.EqualError(err, "only admins can add members")
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) TestMessagesCarrySenderName() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "named", users[1:])
+	suite.Require().NoError(err)
+	send := func() *models.Message {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     "hello",
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		return msg
+	}
+
+	// A cold cache falls back to the user record and warms the cache
+	suite.Equal("group_user_1", send().SenderName)
+	cached, err := suite.redisClient.Get(suite.ctx, appredis.UserNameKey(users[1].Hex())).Result()
+	suite.Require().NoError(err)
+	suite.Equal("group_user_1", cached)
+
+	// Renaming through the profile updates the cache for later messages
+	db := suite.mongoClient.Database(suite.testDBName)
+	userService := services.NewUserService(suite.userRepo, repositories.NewFriendshipRepository(db), repositories.NewFollowRepository(db), suite.redisClient, nil)
+	_, err = userService.UpdateUser(suite.ctx, users[1], &models.UserUpdateRequest{Username: "renamed"})
+	suite.Require().NoError(err)
+	suite.Equal("renamed", send().SenderName)
+}
+
+func (suite *GroupIntegrationTestSuite) TestPollVoting() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "polls", users[1:2])
+	suite.Require().NoError(err)
+	pollService := services.NewPollService(suite.pollRepo, suite.groupRepo, suite.messageRepo, suite.producer)
+
+	// Polls need the poll content type, a group and at least two options
+	_, err = suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		ContentType: models.ContentTypePoll,
+		Poll:        &models.PollRequest{Question: "Lunch?", Options: []string{"pizza"}},
+	})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		ContentType: models.ContentTypePoll,
+		Poll: &models.PollRequest{
+			Question:          "Lunch?",
+			Options:           []string{"pizza", "sushi", "salad"},
+			ResultsVisibility: models.PollResultsAfterVoting,
+		},
+	})
+	suite.Require().NoError(err)
+	suite.Equal("Lunch?", msg.Content)
+	suite.Require().False(msg.PollID.IsZero())
+	pollID := msg.PollID
+
+	// Results stay hidden until the viewer votes
+	results, err := pollService.GetResults(suite.ctx, users[1], pollID)
+	suite.Require().NoError(err)
+	suite.Nil(results.Counts)
+	suite.False(results.Voted)
+
+	_, err = pollService.Vote(suite.ctx, users[1], pollID, []int{0, 1})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+	_, err = pollService.Vote(suite.ctx, users[1], pollID, []int{3})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+	_, err = pollService.Vote(suite.ctx, users[2], pollID, []int{0})
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	_, err = pollService.Vote(suite.ctx, users[1], pollID, []int{0})
+	suite.Require().NoError(err)
+	results, err = pollService.Vote(suite.ctx, users[1], pollID, []int{1})
+	suite.Require().NoError(err)
+	suite.Equal([]int64{0, 1, 0}, results.Counts)
+	suite.Equal(int64(1), results.TotalVoters)
+	suite.Equal([]int{1}, results.MyOptions)
+
+	// Only the creator closes the poll, after which it takes no votes
+	_, err = pollService.ClosePoll(suite.ctx, users[1], pollID)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	results, err = pollService.ClosePoll(suite.ctx, users[0], pollID)
+	suite.Require().NoError(err)
+	suite.True(results.Closed)
+	suite.Equal([]int64{0, 1, 0}, results.Counts)
+	_, err = pollService.Vote(suite.ctx, users[0], pollID, []int{2})
+	suite.Equal(http.StatusConflict, apperrors.Status(err))
+	err = pollService.RetractVote(suite.ctx, users[1], pollID)
+	suite.Equal(http.StatusConflict, apperrors.Status(err))
+
+	// Polls can't be forwarded
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[0], msg.ID, models.ForwardMessageRequest{GroupID: group.ID.Hex()})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+}
+
+func (suite *GroupIntegrationTestSuite) TestIdempotentSendReplaysOriginal() {
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "retries", users[1:])
+	suite.Require().NoError(err)
+	req := models.MessageRequest{GroupID: group.ID.Hex(), Content: "once", ContentType: models.ContentTypeText}
+
+	first, replayed, err := suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-1", req)
+	suite.Require().NoError(err)
+	suite.False(replayed)
+	again, replayed, err := suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-1", req)
+	suite.Require().NoError(err)
+	suite.True(replayed)
+	suite.Equal(first.ID, again.ID)
+
+	// Keys are scoped per user
+	other, replayed, err := suite.messageService.SendMessageIdempotent(suite.ctx, users[1], "retry-1", req)
+	suite.Require().NoError(err)
+	suite.False(replayed)
+	suite.NotEqual(first.ID, other.ID)
+
+	count, err := suite.messageRepo.CountMessages(suite.ctx, models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[0].Hex()})
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), count)
+
+	// A failed request frees its key for the retry
+	_, _, err = suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-2", models.MessageRequest{GroupID: group.ID.Hex(), ContentType: models.ContentTypeText})
+	suite.Equal(http.StatusBadRequest, apperrors.Status(err))
+	_, replayed, err = suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-2", req)
+	suite.Require().NoError(err)
+	suite.False(replayed)
+
+	// A request still in progress makes a concurrent duplicate fail instead of writing
+	key := appredis.IdempotencyKey("messages", users[0].Hex(), "retry-3")
+	_, claimed, err := appredis.ClaimIdempotencyKey(suite.ctx, suite.redisClient, key)
+	suite.Require().NoError(err)
+	suite.Require().True(claimed)
+	_, _, err = suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-3", req)
+	suite.Equal(http.StatusConflict, apperrors.Status(err))
+
+	// Retrying a send that was deleted reports it gone, whether it was
+	// withdrawn in its undo window or deleted afterwards
+	deleted, _, err := suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-4", req)
+	suite.Require().NoError(err)
+	_, err = suite.messageService.DeleteMessage(suite.ctx, deleted.ID.Hex(), users[0])
+	suite.Require().NoError(err)
+	_, _, err = suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-4", req)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+
+	_, err = suite.userRepo.UpdateUser(suite.ctx, users[0], bson.M{"undo_send_seconds": 10})
+	suite.Require().NoError(err)
+	undone, _, err := suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-5", req)
+	suite.Require().NoError(err)
+	suite.Require().Equal(models.MessageStatusPendingDispatch, undone.Status)
+	_, err = suite.messageService.DeleteMessage(suite.ctx, undone.ID.Hex(), users[0])
+	suite.Require().NoError(err)
+	_, _, err = suite.messageService.SendMessageIdempotent(suite.ctx, users[0], "retry-5", req)
+	suite.Equal(http.StatusNotFound, apperrors.Status(err))
+}
+
+func (suite *GroupIntegrationTestSuite) TestReadingHistoryRequiresParticipation() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "members only", users[1:2])
+	suite.Require().NoError(err)
+	_, err = suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "members only",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	// A non-member can't page, count or search the group's history
+	outsider := models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[2].Hex(), Page: 1, Limit: 10}
+	_, err = suite.messageService.GetAllMessages(suite.ctx, outsider)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	_, err = suite.messageService.GetConversationMessageTotalCount(suite.ctx, outsider)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	_, err = suite.messageService.SearchMessages(suite.ctx, users[2], models.MessageSearchQuery{Query: "members", GroupID: group.ID.Hex(), Page: 1, Limit: 10})
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	member := models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[0].Hex(), Page: 1, Limit: 10}
+	messages, err := suite.messageService.GetAllMessages(suite.ctx, member)
+	suite.Require().NoError(err)
+	suite.Len(messages, 1)
+	total, err := suite.messageService.GetConversationMessageTotalCount(suite.ctx, member)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), total)
+
+	// A third party asking for a direct conversation only gets their own with that user
+	_, err = suite.messageRepo.CreateMessage(suite.ctx, &models.Message{SenderID: users[0], ReceiverID: users[1], Content: "just us", ContentType: models.ContentTypeText})
+	suite.Require().NoError(err)
+	thirdParty := models.MessageQuery{ReceiverID: users[1].Hex(), SenderID: users[2].Hex(), Page: 1, Limit: 10}
+	messages, err = suite.messageService.GetAllMessages(suite.ctx, thirdParty)
+	suite.Require().NoError(err)
+	suite.Empty(messages)
+	total, err = suite.messageService.GetConversationMessageTotalCount(suite.ctx, thirdParty)
+	suite.Require().NoError(err)
+	suite.Zero(total)
+
+	participant := models.MessageQuery{ReceiverID: users[0].Hex(), SenderID: users[1].Hex(), Page: 1, Limit: 10}
+	messages, err = suite.messageService.GetAllMessages(suite.ctx, participant)
+	suite.Require().NoError(err)
+	suite.Require().Len(messages, 1)
+	suite.Equal("just us", messages[0].Content)
+}
+
+func (suite *GroupIntegrationTestSuite) TestStarredMessages() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "stars", users[1:2])
+	suite.Require().NoError(err)
+	groupMsg, err := suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "remember this",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	direct, err := suite.messageRepo.CreateMessage(suite.ctx, &models.Message{SenderID: users[1], ReceiverID: users[0], Content: "and this", ContentType: models.ContentTypeText})
+	suite.Require().NoError(err)
+
+	// Only participants may star, and starring twice is harmless
+	err = suite.messageService.StarMessage(suite.ctx, users[2], groupMsg.ID)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+	suite.Require().NoError(suite.messageService.StarMessage(suite.ctx, users[0], groupMsg.ID))
+	suite.Require().NoError(suite.messageService.StarMessage(suite.ctx, users[0], groupMsg.ID))
+	suite.Require().NoError(suite.messageService.StarMessage(suite.ctx, users[0], direct.ID))
+
+	starred, err := suite.messageService.GetStarredMessages(suite.ctx, users[0], 1, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(starred.Messages, 2)
+	suite.False(starred.HasMore)
+	suite.Equal(direct.ID, starred.Messages[0].Message.ID)
+	suite.Equal("group_user_1", starred.Messages[0].Sender.Username)
+	suite.Equal(models.StarredConversation{ID: users[1], Name: "group_user_1"}, starred.Messages[0].Conversation)
+	suite.Equal(groupMsg.ID, starred.Messages[1].Message.ID)
+	suite.True(starred.Messages[1].Conversation.IsGroup)
+	suite.Equal("stars", starred.Messages[1].Conversation.Name)
+
+	page, err := suite.messageService.GetStarredMessages(suite.ctx, users[0], 1, 1)
+	suite.Require().NoError(err)
+	suite.Len(page.Messages, 1)
+	suite.True(page.HasMore)
+
+	// Stars are private to the user who set them
+	history := models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[0].Hex(), Page: 1, Limit: 10}
+	messages, err := suite.messageService.GetAllMessages(suite.ctx, history)
+	suite.Require().NoError(err)
+	suite.Require().Len(messages, 1)
+	suite.True(messages[0].Starred)
+	history.SenderID = users[1].Hex()
+	messages, err = suite.messageService.GetAllMessages(suite.ctx, history)
+	suite.Require().NoError(err)
+	suite.Require().Len(messages, 1)
+	suite.False(messages[0].Starred)
+
+	suite.Require().NoError(suite.messageService.UnstarMessage(suite.ctx, users[0], direct.ID))
+	_, err = suite.messageService.DeleteMessage(suite.ctx, groupMsg.ID.Hex(), users[1])
+	suite.Require().NoError(err)
+	starred, err = suite.messageService.GetStarredMessages(suite.ctx, users[0], 1, 10)
+	suite.Require().NoError(err)
+	suite.Empty(starred.Messages)
+	count, err := suite.mongoClient.Database(suite.testDBName).Collection("message_stars").CountDocuments(suite.ctx, bson.M{})
+	suite.Require().NoError(err)
+	suite.Zero(count)
+}
+
+func (suite *GroupIntegrationTestSuite) TestArchiveConversations() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "archived", users[1:2])
+	suite.Require().NoError(err)
+	send := func(from primitive.ObjectID, req models.MessageRequest) {
+		req.Content, req.ContentType = "hello", models.ContentTypeText
+		_, err := suite.messageService.SendMessage(suite.ctx, from, req)
+		suite.Require().NoError(err)
+	}
+	send(users[1], models.MessageRequest{GroupID: group.ID.Hex()})
+	send(users[1], models.MessageRequest{ReceiverID: users[0].Hex()})
+	listed := func(userID primitive.ObjectID, includeArchived bool) map[primitive.ObjectID]bool {
+		resp, err := suite.messageService.GetConversations(suite.ctx, userID, 1, 20, includeArchived)
+		suite.Require().NoError(err)
+		archived := make(map[primitive.ObjectID]bool)
+		for _, c := range resp.Conversations {
+			archived[c.ID] = c.Archived
+		}
+		return archived
+	}
+
+	// Only participants can archive a group
+	err = suite.messageService.ArchiveConversation(suite.ctx, users[2], group.ID)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+
+	suite.Require().NoError(suite.messageService.ArchiveConversation(suite.ctx, users[0], group.ID))
+	suite.Require().NoError(suite.messageService.ArchiveConversation(suite.ctx, users[0], users[1]))
+	suite.Empty(listed(users[0], false))
+	suite.Equal(map[primitive.ObjectID]bool{group.ID: true, users[1]: true}, listed(users[0], true))
+	// The other participant still sees both
+	suite.Equal(map[primitive.ObjectID]bool{group.ID: false, users[0]: false}, listed(users[1], false))
+
+	// A new message brings the conversation back; so does unarchiving
+	send(users[1], models.MessageRequest{GroupID: group.ID.Hex()})
+	suite.Equal(map[primitive.ObjectID]bool{group.ID: false}, listed(users[0], false))
+	suite.Require().NoError(suite.messageService.UnarchiveConversation(suite.ctx, users[0], users[1]))
+	suite.Equal(map[primitive.ObjectID]bool{group.ID: false, users[1]: false}, listed(users[0], false))
+
+	count, err := suite.mongoClient.Database(suite.testDBName).Collection("conversation_archives").CountDocuments(suite.ctx, bson.M{})
+	suite.Require().NoError(err)
+	suite.Zero(count)
+}
+
+func (suite *GroupIntegrationTestSuite) TestArchiveRacingNewMessages() {
+	users := suite.createUsers(2)
+	archives := repositories.NewArchiveRepository(suite.mongoClient.Database(suite.testDBName))
+
+	// However archiving and a new message interleave, a message sent after
+	// the archive is never hidden, and each archive is cleared only once
+	for i := 0; i < 20; i++ {
+		var wg sync.WaitGroup
+		var msg *models.Message
+		var sendErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			msg, sendErr = suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+				ReceiverID:  users[0].Hex(),
+				Content:     fmt.Sprintf("message %d", i),
+				ContentType: models.ContentTypeText,
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			suite.NoError(suite.messageService.ArchiveConversation(suite.ctx, users[0], users[1]))
+		}()
+		wg.Wait()
+		suite.Require().NoError(sendErr)
+		// As stored
+		sentAt := msg.CreatedAt.Truncate(time.Millisecond)
+
+		stored, err := archives.GetUserArchives(suite.ctx, users[0])
+		suite.Require().NoError(err)
+		resp, err := suite.messageService.GetConversations(suite.ctx, users[0], 1, 20, false)
+		suite.Require().NoError(err)
+		if len(stored) == 1 && !sentAt.After(stored[0].ArchivedAt) {
+			suite.Empty(resp.Conversations, "iteration %d", i)
+		} else {
+			suite.Len(resp.Conversations, 1, "iteration %d", i)
+		}
+
+		removed, err := archives.UnarchiveForMessage(suite.ctx, msg)
+		suite.Require().NoError(err)
+		if len(stored) == 1 && sentAt.After(stored[0].ArchivedAt) {
+			// The send path lost the race; the archive is cleared now
+			suite.Len(removed, 1)
+		} else {
+			suite.Empty(removed)
+		}
+		suite.Require().NoError(archives.Unarchive(suite.ctx, users[0], users[1]))
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) TestContentFilterFlagsMessages() {
+	path := filepath.Join(suite.T().TempDir(), "words.txt")
+	suite.Require().NoError(os.WriteFile(path, []byte("badword\n"), 0o600))
+	filter, err := contentfilter.NewListFilter(path, []string{"4=a"})
+	suite.Require().NoError(err)
+
+	users := suite.createUsers(2)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "filtered", users[1:])
+	suite.Require().NoError(err)
+	send := func(content string) (*models.Message, error) {
+		return suite.messageService.SendMessage(suite.ctx, users[1], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+	}
+
+	suite.messageService.SetContentFilter(filter, contentfilter.PolicyFlag)
+	flagged, err := send("such a b4dword")
+	suite.Require().NoError(err)
+	_, err = send("all good")
+	suite.Require().NoError(err)
+	queue, err := suite.messageService.GetFlaggedMessages(suite.ctx, 1, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(queue.Messages, 1)
+	suite.Equal(flagged.ID, queue.Messages[0].ID)
+
+	suite.messageService.SetContentFilter(filter, contentfilter.PolicyBlock)
+	_, err = send("another badword")
+	suite.Equal(http.StatusUnprocessableEntity, apperrors.Status(err))
+	total, err := suite.messageService.GetConversationMessageTotalCount(suite.ctx, models.MessageQuery{GroupID: group.ID.Hex(), SenderID: users[0].Hex()})
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), total)
+
+	// Forwarding is screened too, against the list and policy of the moment
+	forward := models.ForwardMessageRequest{GroupID: group.ID.Hex()}
+	_, err = suite.messageService.ForwardMessage(suite.ctx, users[1], flagged.ID, forward)
+	suite.Equal(http.StatusUnprocessableEntity, apperrors.Status(err))
+	suite.messageService.SetContentFilter(filter, contentfilter.PolicyOff)
+	unscreened, err := send("sent before the filter: badword")
+	suite.Require().NoError(err)
+	suite.False(unscreened.Flagged)
+	suite.messageService.SetContentFilter(filter, contentfilter.PolicyFlag)
+	forwarded, err := suite.messageService.ForwardMessage(suite.ctx, users[1], unscreened.ID, forward)
+	suite.Require().NoError(err)
+	suite.True(forwarded.Flagged)
+	suite.messageService.SetContentFilter(nil, contentfilter.PolicyOff)
+}
+
+func (suite *GroupIntegrationTestSuite) TestDeliveryReceipts() {
+	users := suite.createUsers(3)
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "receipts", users[1:])
+	suite.Require().NoError(err)
+	msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+		GroupID:     group.ID.Hex(),
+		Content:     "did you get this?",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+
+	// Delivery is recorded once per recipient, never for the sender
+	suite.messageService.MarkDelivered(suite.ctx, msg.ID, []primitive.ObjectID{users[0], users[1]})
+	suite.messageService.MarkDelivered(suite.ctx, msg.ID, []primitive.ObjectID{users[1]})
+	stored, err := suite.messageRepo.GetMessageByID(suite.ctx, msg.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(stored.DeliveredTo, 1)
+	suite.Equal(users[1], stored.DeliveredTo[0].UserID)
+
+	// Seeing a message implies it was delivered
+	suite.Require().NoError(suite.messageService.MarkMessagesAsSeen(suite.ctx, users[2], []primitive.ObjectID{msg.ID}))
+
+	res, err := suite.messageService.GetReceipts(suite.ctx, users[0], msg.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(res.Receipts, 2)
+	byUser := map[primitive.ObjectID]models.MessageReceipt{}
+	for _, r := range res.Receipts {
+		byUser[r.UserID] = r
+	}
+	suite.NotNil(byUser[users[1]].DeliveredAt)
+	suite.Nil(byUser[users[1]].SeenAt)
+	suite.Require().NotNil(byUser[users[2]].SeenAt)
+	suite.Equal(byUser[users[2]].SeenAt, byUser[users[2]].DeliveredAt)
+
+	_, err = suite.messageService.GetReceipts(suite.ctx, users[1], msg.ID)
+	suite.Equal(http.StatusForbidden, apperrors.Status(err))
+}
+
+func (suite *GroupIntegrationTestSuite) TestAnnouncements() {
+	users := suite.createUsers(3)
+	announcements := services.NewAnnouncementService(
+		repositories.NewAnnouncementRepository(suite.mongoClient.Database(suite.testDBName)), suite.producer)
+
+	now := time.Now()
+	_, err := announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Maintenance", Body: "Down for an hour", Level: "severe", EndsAt: now.Add(time.Hour),
+	})
+	suite.True(errors.Is(err, apperrors.ErrValidation))
+	_, err = announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Maintenance", Body: "Already over", EndsAt: now.Add(-time.Minute),
+	})
+	suite.True(errors.Is(err, apperrors.ErrValidation))
+	_, err = announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Maintenance", Body: " \u200B\u2060 ", EndsAt: now.Add(time.Hour),
+	})
+	suite.True(errors.Is(err, apperrors.ErrValidation))
+
+	path := filepath.Join(suite.T().TempDir(), "words.txt")
+	suite.Require().NoError(os.WriteFile(path, []byte("badword\n"), 0o600))
+	filter, err := contentfilter.NewListFilter(path, nil)
+	suite.Require().NoError(err)
+	announcements.SetContentFilter(filter, contentfilter.PolicyBlock)
+	_, err = announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Maintenance", Body: "badword", EndsAt: now.Add(time.Hour),
+	})
+	suite.Equal(http.StatusUnprocessableEntity, apperrors.Status(err))
+	announcements.SetContentFilter(nil, contentfilter.PolicyOff)
+
+	current, err := announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Maintenance", Body: "Down for an hour", Level: models.AnnouncementWarning, EndsAt: now.Add(time.Hour),
+	})
+	suite.Require().NoError(err)
+	later := now.Add(time.Hour)
+	_, err = announcements.Broadcast(suite.ctx, users[0], models.AnnouncementRequest{
+		Title: "Upgrade", Body: "Tomorrow", StartsAt: &later, EndsAt: later.Add(time.Hour),
+	})
+	suite.Require().NoError(err)
+
+	// Scheduled announcements stay hidden until they start
+	active, err := announcements.GetActive(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Require().Len(active, 1)
+	suite.Equal(current.ID, active[0].ID)
+	suite.Equal(models.AnnouncementWarning, active[0].Level)
+
+	// Dismissing is per user and can be repeated
+	suite.Require().NoError(announcements.Dismiss(suite.ctx, users[1], current.ID))
+	suite.Require().NoError(announcements.Dismiss(suite.ctx, users[1], current.ID))
+	active, err = announcements.GetActive(suite.ctx, users[1])
+	suite.Require().NoError(err)
+	suite.Empty(active)
+	active, err = announcements.GetActive(suite.ctx, users[2])
+	suite.Require().NoError(err)
+	suite.Len(active, 1)
+
+	err = announcements.Dismiss(suite.ctx, users[1], primitive.NewObjectID())
+	suite.True(errors.Is(err, apperrors.ErrNotFound))
+}
+
+func (suite *GroupIntegrationTestSuite) TestDeltaSync() {
+	users := suite.createUsers(3)
+	db := suite.mongoClient.Database(suite.testDBName)
+	sync := services.NewSyncService(suite.messageRepo, suite.groupRepo,
+		repositories.NewFriendshipRepository(db), suite.userRepo)
+
+	start := time.Now()
+	group, err := suite.groupService.CreateGroup(suite.ctx, users[0], "sync", users[1:])
+	suite.Require().NoError(err)
+	var sent []primitive.ObjectID
+	for _, content := range []string{"one", "two", "three"} {
+		msg, err := suite.messageService.SendMessage(suite.ctx, users[0], models.MessageRequest{
+			GroupID:     group.ID.Hex(),
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		})
+		suite.Require().NoError(err)
+		sent = append(sent, msg.ID)
+	}
+
+	resp, err := sync.Sync(suite.ctx, users[1], start)
+	suite.Require().NoError(err)
+	suite.False(resp.HasMore)
+	suite.Require().Len(resp.Groups, 1)
+	suite.Equal(group.ID, resp.Groups[0].ID)
+	suite.Require().Len(resp.Conversations, 1)
+	changes := resp.Conversations[0]
+	suite.Equal(group.ID, changes.ID)
+	suite.True(changes.IsGroup)
+	suite.False(changes.HasMore)
+	suite.Require().Len(changes.Messages, 3)
+	suite.Equal(sent, []primitive.ObjectID{changes.Messages[0].ID, changes.Messages[1].ID, changes.Messages[2].ID})
+
+	// Nothing changed since the token, then only the deleted message did
+	since, err := services.ParseSyncToken(resp.SyncToken)
+	suite.Require().NoError(err)
+	resp, err = sync.Sync(suite.ctx, users[1], since)
+	suite.Require().NoError(err)
+	suite.Empty(resp.Conversations)
+	suite.Empty(resp.Groups)
+
+	_, err = suite.messageService.DeleteMessage(suite.ctx, sent[1].Hex(), users[0])
+	suite.Require().NoError(err)
+	resp, err = sync.Sync(suite.ctx, users[1], since)
+	suite.Require().NoError(err)
+	suite.Require().Len(resp.Conversations, 1)
+	suite.Require().Len(resp.Conversations[0].Messages, 1)
+	suite.True(resp.Conversations[0].Messages[0].IsDeleted)
+
+	_, err = sync.Sync(suite.ctx, users[1], time.Now().Add(time.Hour))
+	suite.True(errors.Is(err, apperrors.ErrValidation))
+}
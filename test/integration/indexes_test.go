@@ -0,0 +1,93 @@
+package integration
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"messaging-app/internal/repositories"
+
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type IndexIntegrationTestSuite struct {
+	suite.Suite
+	mongoClient *mongo.Client
+	db          *mongo.Database
+	ctx         context.Context
+}
+
+func (suite *IndexIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+
+	mongoURI := os.Getenv("MONGO_URI")
+	opts := options.Client().ApplyURI(mongoURI)
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+	suite.db = suite.mongoClient.Database("test_index_db")
+}
+
+func (suite *IndexIntegrationTestSuite) TearDownSuite() {
+	suite.db.Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+}
+
+func (suite *IndexIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	suite.db.Drop(suite.ctx)
+}
+
+func TestIndexIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(IndexIntegrationTestSuite))
+}
+
+func (suite *IndexIntegrationTestSuite) TestSecondStartupIsNoOp() {
+	first, err := repositories.EnsureIndexes(suite.ctx, suite.db, time.Hour, false)
+	suite.Require().NoError(err)
+	suite.Contains(first.Created, "messages.content_text")
+	suite.Contains(first.Created, "friendships.created_at_pending_ttl")
+
+	second, err := repositories.EnsureIndexes(suite.ctx, suite.db, time.Hour, false)
+	suite.Require().NoError(err)
+	suite.Empty(second.Created)
+	suite.Empty(second.Rebuilt)
+	suite.Empty(second.Conflicts)
+}
+
+func (suite *IndexIntegrationTestSuite) TestChangedIndexIsKeptUnlessMigrating() {
+	_, err := repositories.EnsureIndexes(suite.ctx, suite.db, time.Hour, false)
+	suite.Require().NoError(err)
+
+	// A new preview TTL changes the spec of an existing index
+	report, err := repositories.EnsureIndexes(suite.ctx, suite.db, 2*time.Hour, false)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"link_previews.fetched_at_1"}, report.Conflicts)
+	suite.Equal(int64(time.Hour.Seconds()), suite.previewTTL())
+
+	report, err = repositories.EnsureIndexes(suite.ctx, suite.db, 2*time.Hour, true)
+	suite.Require().NoError(err)
+	suite.Equal([]string{"link_previews.fetched_at_1"}, report.Rebuilt)
+	suite.Empty(report.Conflicts)
+	suite.Equal(int64((2 * time.Hour).Seconds()), suite.previewTTL())
+}
+
+func (suite *IndexIntegrationTestSuite) previewTTL() int64 {
+	cursor, err := suite.db.Collection("link_previews").Indexes().List(suite.ctx)
+	suite.Require().NoError(err)
+	var specs []struct {
+		Name               string `bson:"name"`
+		ExpireAfterSeconds int64  `bson:"expireAfterSeconds"`
+	}
+	suite.Require().NoError(cursor.All(suite.ctx, &specs))
+	for _, spec := range specs {
+		if spec.Name == "fetched_at_1" {
+			return spec.ExpireAfterSeconds
+		}
+	}
+	suite.FailNow("fetched_at_1 index missing")
+	return 0
+}
@@ -0,0 +1,210 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"messaging-app/internal/kafka"
+	"messaging-app/internal/models"
+	"messaging-app/pkg/logging"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingBroadcaster stands in for the hub and records delivered messages
+type recordingBroadcaster struct {
+	messages chan models.Message
+}
+
+func (b *recordingBroadcaster) BroadcastMessage(msg models.Message) {
+	b.messages <- msg
+}
+
+func (b *recordingBroadcaster) BroadcastEvent(ev models.WebSocketEvent) {}
+
+type KafkaIntegrationTestSuite struct {
+	suite.Suite
+	brokers []string
+	topic   string
+	ctx     context.Context
+}
+
+func (suite *KafkaIntegrationTestSuite) SetupTest() {
+	suite.ctx = context.Background()
+	suite.brokers = []string{os.Getenv("KAFKA_BROKERS")}
+	// A fresh topic per test keeps earlier runs from leaking into the assertions
+	suite.topic = fmt.Sprintf("test_consumer_%d", time.Now().UnixNano())
+}
+
+func TestKafkaIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(KafkaIntegrationTestSuite))
+}
+
+func (suite *KafkaIntegrationTestSuite) TestMalformedMessageIsDeadLettered() {
+	writer := &kafkago.Writer{
+		Addr:                   kafkago.TCP(suite.brokers...),
+		Topic:                  suite.topic,
+		AllowAutoTopicCreation: true,
+	}
+	defer writer.Close()
+
+	valid := models.Message{
+		ID:       primitive.NewObjectID(),
+		SenderID: primitive.NewObjectID(),
+		Content:  "after the garbage",
+	}
+	payload, err := json.Marshal(valid)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(writer.WriteMessages(suite.ctx,
+		kafkago.Message{Key: []byte("garbage"), Value: []byte{0xde, 0xad, 0xbe, 0xef}},
+		kafkago.Message{Key: []byte("valid"), Value: payload},
+	))
+
+	hub := &recordingBroadcaster{messages: make(chan models.Message, 1)}
+	consumer := kafka.NewMessageConsumer(suite.brokers, suite.topic, suite.topic+"-group", hub)
+	consumerCtx, stop := context.WithCancel(suite.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeMessages(consumerCtx)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	// The valid message behind the garbage is still delivered
+	select {
+	case msg := <-hub.messages:
+		suite.Equal(valid.ID, msg.ID)
+	case <-time.After(30 * time.Second):
+		suite.FailNow("valid message was not delivered")
+	}
+
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers: suite.brokers,
+		Topic:   kafka.DeadLetterTopic(suite.topic),
+	})
+	defer reader.Close()
+
+	readCtx, cancel := context.WithTimeout(suite.ctx, 30*time.Second)
+	defer cancel()
+	dead, err := reader.ReadMessage(readCtx)
+	suite.Require().NoError(err)
+	suite.Equal([]byte{0xde, 0xad, 0xbe, 0xef}, dead.Value)
+
+	headers := make(map[string]string)
+	for _, h := range dead.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	suite.Equal(suite.topic, headers["dlq-original-topic"])
+	suite.Contains(headers["dlq-error"], "malformed message")
+}
+
+// createTopic creates a topic with several partitions, which auto-creation
+// wouldn't give us
+func (suite *KafkaIntegrationTestSuite) createTopic(partitions int) {
+	conn, err := kafkago.Dial("tcp", suite.brokers[0])
+	suite.Require().NoError(err)
+	defer conn.Close()
+	controller, err := conn.Controller()
+	suite.Require().NoError(err)
+	controllerConn, err := kafkago.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	suite.Require().NoError(err)
+	defer controllerConn.Close()
+	suite.Require().NoError(controllerConn.CreateTopics(kafkago.TopicConfig{
+		Topic:             suite.topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: 1,
+	}))
+}
+
+func (suite *KafkaIntegrationTestSuite) TestConversationIsDeliveredInOrder() {
+	const count = 1000
+	suite.createTopic(8)
+
+	// Both directions of a direct chat share a partition
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	producer := kafka.NewMessageProducer(suite.brokers, suite.topic)
+	for i := 1; i <= count; i++ {
+		msg := models.Message{ID: primitive.NewObjectID(), SenderID: alice, ReceiverID: bob, Seq: int64(i)}
+		if i%2 == 0 {
+			msg.SenderID, msg.ReceiverID = bob, alice
+		}
+		suite.Require().NoError(producer.ProduceMessage(suite.ctx, msg.ConversationKey(), msg))
+	}
+	suite.Require().NoError(producer.Close())
+
+	hub := &recordingBroadcaster{messages: make(chan models.Message, count)}
+	consumer := kafka.NewMessageConsumer(suite.brokers, suite.topic, suite.topic+"-group", hub)
+	consumerCtx, stop := context.WithCancel(suite.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeMessages(consumerCtx)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	for want := int64(1); want <= count; want++ {
+		select {
+		case msg := <-hub.messages:
+			suite.Require().Equal(want, msg.Seq)
+		case <-time.After(30 * time.Second):
+			suite.FailNow("messages stopped arriving", "last sequence seen: %d", want-1)
+		}
+	}
+}
+
+// requestIDSender records the request ID each email was handled under
+type requestIDSender struct {
+	requestIDs chan string
+}
+
+func (s *requestIDSender) Send(ctx context.Context, message models.EmailMessage) error {
+	s.requestIDs <- logging.RequestID(ctx)
+	return nil
+}
+
+func (suite *KafkaIntegrationTestSuite) TestRequestIDReachesConsumer() {
+	suite.createTopic(1)
+
+	producer := kafka.NewSyncProducer(suite.brokers, suite.topic)
+	ctx := logging.WithRequestID(suite.ctx, "req-from-api")
+	suite.Require().NoError(producer.QueueEmail(ctx, models.EmailMessage{To: "someone@example.com", Subject: "hi"}))
+	suite.Require().NoError(producer.Close())
+
+	sender := &requestIDSender{requestIDs: make(chan string, 1)}
+	consumer := kafka.NewEmailConsumer(suite.brokers, suite.topic, suite.topic+"-group", sender)
+	consumerCtx, stop := context.WithCancel(suite.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.ConsumeMessages(consumerCtx)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	select {
+	case id := <-sender.requestIDs:
+		suite.Equal("req-from-api", id)
+	case <-time.After(30 * time.Second):
+		suite.FailNow("email was not consumed")
+	}
+}
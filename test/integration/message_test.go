@@ -0,0 +1,184 @@
+package integration
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MessageIntegrationTestSuite struct {
+	suite.Suite
+	messageRepo *repositories.MessageRepository
+	mongoClient *mongo.Client
+	testDBName  string
+	ctx         context.Context
+}
+
+func (suite *MessageIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.testDBName = "test_message_db"
+
+	mongoURI := os.Getenv("MONGO_URI")
+	opts := options.Client().ApplyURI(mongoURI)
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+}
+
+func (suite *MessageIntegrationTestSuite) TearDownSuite() {
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+}
+
+func (suite *MessageIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	db := suite.mongoClient.Database(suite.testDBName)
+	db.Drop(suite.ctx)
+	_, err := repositories.EnsureIndexes(suite.ctx, db, time.Hour, false)
+	suite.Require().NoError(err)
+	suite.messageRepo = repositories.NewMessageRepository(db)
+}
+
+func TestMessageIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(MessageIntegrationTestSuite))
+}
+
+func (suite *MessageIntegrationTestSuite) send(msg models.Message) *models.Message {
+	msg.ContentType = models.ContentTypeText
+	created, err := suite.messageRepo.CreateMessage(suite.ctx, &msg)
+	suite.Require().NoError(err)
+	// Keep created_at strictly increasing between messages
+	time.Sleep(5 * time.Millisecond)
+	return created
+}
+
+func (suite *MessageIntegrationTestSuite) TestConversationsSortedByLastActivity() {
+	me := primitive.NewObjectID()
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+	groupID := primitive.NewObjectID()
+	otherGroup := primitive.NewObjectID()
+
+	suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "hi"})
+	suite.send(models.Message{SenderID: me, ReceiverID: bob, Content: "hey bob"})
+	suite.send(models.Message{SenderID: alice, GroupID: groupID, Content: "group hello"})
+	suite.send(models.Message{SenderID: bob, GroupID: otherGroup, Content: "not my group"})
+	last := suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "still there?"})
+
+	conversations, total, err := suite.messageRepo.GetConversations(suite.ctx, me, []primitive.ObjectID{groupID}, nil, 1, 10)
+	suite.Require().NoError(err)
+	suite.Equal(int64(3), total)
+	suite.Require().Len(conversations, 3)
+
+	suite.Equal(alice, conversations[0].ID)
+	suite.False(conversations[0].IsGroup)
+	suite.Equal(last.ID, conversations[0].LastMessage.ID)
+	suite.Equal(int64(2), conversations[0].UnreadCount)
+
+	suite.Equal(groupID, conversations[1].ID)
+	suite.True(conversations[1].IsGroup)
+	suite.Equal(int64(1), conversations[1].UnreadCount)
+
+	// Messages the user sent never count as unread
+	suite.Equal(bob, conversations[2].ID)
+	suite.Equal(int64(0), conversations[2].UnreadCount)
+}
+
+func (suite *MessageIntegrationTestSuite) TestSeenMessagesLeaveUnreadCount() {
+	me := primitive.NewObjectID()
+	alice := primitive.NewObjectID()
+
+	first := suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "one"})
+	suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "two"})
+	suite.Require().NoError(suite.messageRepo.MarkMessagesAsSeen(suite.ctx, me, nil, []primitive.ObjectID{first.ID}, time.Now()))
+
+	conversations, _, err := suite.messageRepo.GetConversations(suite.ctx, me, nil, nil, 1, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(conversations, 1)
+	suite.Equal(int64(1), conversations[0].UnreadCount)
+}
+
+func (suite *MessageIntegrationTestSuite) TestDeletedMessageStaysInPageAsTombstone() {
+	alice := primitive.NewObjectID()
+	groupID := primitive.NewObjectID()
+
+	var sent []*models.Message
+	for _, content := range []string{"one", "two", "three", "four", "five"} {
+		sent = append(sent, suite.send(models.Message{SenderID: alice, GroupID: groupID, Content: content}))
+	}
+	middle := sent[2]
+	_, err := suite.messageRepo.DeleteMessage(suite.ctx, middle.ID, alice, nil)
+	suite.Require().NoError(err)
+
+	page, err := suite.messageRepo.GetMessages(suite.ctx, models.MessageQuery{GroupID: groupID.Hex(), Page: 1, Limit: 10})
+	suite.Require().NoError(err)
+	suite.Require().Len(page, 5)
+
+	// Newest first, so the third message is still in the middle
+	tombstone := page[2]
+	suite.Equal(middle.ID, tombstone.ID)
+	suite.True(tombstone.IsDeleted)
+	suite.NotNil(tombstone.DeletedAt)
+	suite.Empty(tombstone.Content)
+	suite.Empty(tombstone.MediaURLs)
+	suite.Equal(models.ContentTypeDeleted, tombstone.ContentType)
+	suite.Equal("four", page[1].Content)
+	suite.Equal("two", page[3].Content)
+
+	// The stored document no longer has the content outside original_content
+	var stored bson.M
+	suite.Require().NoError(suite.mongoClient.Database(suite.testDBName).Collection("messages").FindOne(suite.ctx, bson.M{"_id": middle.ID}).Decode(&stored))
+	suite.Equal("", stored["content"])
+	suite.Equal("three", stored["original_content"])
+}
+
+func (suite *MessageIntegrationTestSuite) TestSearchStaysInConversation() {
+	me := primitive.NewObjectID()
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+
+	suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "lunch tomorrow?"})
+	older := suite.send(models.Message{SenderID: me, ReceiverID: alice, Content: "the Lunch place"})
+	suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "sounds good"})
+	suite.send(models.Message{SenderID: bob, ReceiverID: me, Content: "lunch with bob"})
+	deleted := suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "secret lunch"})
+	newest := suite.send(models.Message{SenderID: alice, ReceiverID: me, Content: "lunch at noon"})
+	_, err := suite.messageRepo.DeleteMessage(suite.ctx, deleted.ID, alice, nil)
+	suite.Require().NoError(err)
+
+	matches, err := suite.messageRepo.SearchMessages(suite.ctx, me, primitive.NilObjectID, alice, "lunch", 0, 2)
+	suite.Require().NoError(err)
+	suite.Require().Len(matches, 2)
+	suite.Equal(newest.ID, matches[0].ID)
+	suite.Equal(older.ID, matches[1].ID)
+
+	matches, err = suite.messageRepo.SearchMessages(suite.ctx, me, primitive.NilObjectID, alice, "lunch", 2, 2)
+	suite.Require().NoError(err)
+	suite.Require().Len(matches, 1)
+	suite.Equal("lunch tomorrow?", matches[0].Content)
+
+	// Neighbours come from the same conversation; deleted ones as tombstones
+	before, after, err := suite.messageRepo.GetAdjacentMessages(suite.ctx, me, *newest)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(before)
+	suite.Equal(deleted.ID, before.ID)
+	suite.Empty(before.Content)
+	suite.Nil(after)
+
+	before, after, err = suite.messageRepo.GetAdjacentMessages(suite.ctx, me, *older)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(before)
+	suite.Require().NotNil(after)
+	suite.Equal("lunch tomorrow?", before.Content)
+	suite.Equal("sounds good", after.Content)
+}
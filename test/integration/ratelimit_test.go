@@ -0,0 +1,97 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"messaging-app/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitIntegrationTestSuite struct {
+	suite.Suite
+	redisClient *redis.ClusterClient
+	router      *gin.Engine
+	ctx         context.Context
+}
+
+func (suite *RateLimitIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.redisClient = redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{os.Getenv("REDIS_ADDR")},
+	})
+
+	gin.SetMode(gin.TestMode)
+	suite.router = gin.New()
+	suite.router.POST("/login", middleware.RateLimitMiddleware(suite.redisClient, middleware.RateLimit{
+		Name:    "test_auth",
+		Limit:   5,
+		Window:  time.Minute,
+		KeyFunc: middleware.ByIP,
+	}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	suite.router.POST("/messages", func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User"))
+		c.Next()
+	}, middleware.RateLimitMiddleware(suite.redisClient, middleware.RateLimit{
+		Name:    "test_messages",
+		Limit:   2,
+		Window:  time.Minute,
+		KeyFunc: middleware.ByUser,
+	}), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+}
+
+func (suite *RateLimitIntegrationTestSuite) TearDownSuite() {
+	suite.redisClient.Close()
+}
+
+func (suite *RateLimitIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	suite.redisClient.FlushDB(suite.ctx)
+}
+
+func TestRateLimitIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(RateLimitIntegrationTestSuite))
+}
+
+func (suite *RateLimitIntegrationTestSuite) do(path, remoteAddr, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *RateLimitIntegrationTestSuite) TestLoginLimitedPerIP() {
+	for i := 0; i < 5; i++ {
+		suite.Equal(http.StatusOK, suite.do("/login", "10.0.0.1:1234", "").Code)
+	}
+
+	w := suite.do("/login", "10.0.0.1:1234", "")
+	suite.Equal(http.StatusTooManyRequests, w.Code)
+	suite.NotEmpty(w.Header().Get("Retry-After"))
+
+	// Other clients keep their own window
+	suite.Equal(http.StatusOK, suite.do("/login", "10.0.0.2:1234", "").Code)
+}
+
+func (suite *RateLimitIntegrationTestSuite) TestMessagesLimitedPerUser() {
+	suite.Equal(http.StatusCreated, suite.do("/messages", "10.0.0.1:1234", "alice").Code)
+	suite.Equal(http.StatusCreated, suite.do("/messages", "10.0.0.2:1234", "alice").Code)
+	suite.Equal(http.StatusTooManyRequests, suite.do("/messages", "10.0.0.3:1234", "alice").Code)
+
+	suite.Equal(http.StatusCreated, suite.do("/messages", "10.0.0.1:1234", "bob").Code)
+}
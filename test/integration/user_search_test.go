@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const benchmarkUserCount = 100000
+
+// BenchmarkListUsers compares the unanchored regex the user directory used to
+// run with the indexed prefix and text searches, on a seeded collection
+func BenchmarkListUsers(b *testing.B) {
+	if testing.Short() {
+		b.Skip("Skipping integration benchmarks")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("bench_user_search_db")
+	db.Drop(ctx)
+	defer db.Drop(ctx)
+	if _, err := repositories.EnsureIndexes(ctx, db, time.Hour, false); err != nil {
+		b.Fatal(err)
+	}
+
+	docs := make([]interface{}, 0, 1000)
+	for i := 0; i < benchmarkUserCount; i++ {
+		username := fmt.Sprintf("user%06d", i)
+		docs = append(docs, models.User{
+			ID:            primitive.NewObjectID(),
+			Username:      username,
+			UsernameLower: username,
+			Email:         username + "@example.com",
+			CreatedAt:     time.Now(),
+		})
+		if len(docs) == cap(docs) {
+			if _, err := db.Collection("users").InsertMany(ctx, docs); err != nil {
+				b.Fatal(err)
+			}
+			docs = docs[:0]
+		}
+	}
+
+	userRepo := repositories.NewUserRepository(db)
+	userService := services.NewUserService(userRepo, repositories.NewFriendshipRepository(db), repositories.NewFollowRepository(db), nil, nil)
+	viewer := primitive.NewObjectID()
+
+	b.Run("regex", func(b *testing.B) {
+		filter := bson.M{
+			"deactivated_at": bson.M{"$exists": false},
+			"$or": []bson.M{
+				{"username": bson.M{"$regex": "user0424", "$options": "i"}},
+				{"email": bson.M{"$regex": "user0424", "$options": "i"}},
+			},
+		}
+		opts := options.Find().SetLimit(20).SetSort(bson.D{{Key: "username", Value: 1}})
+		for i := 0; i < b.N; i++ {
+			if _, err := userRepo.CountUsers(ctx, filter); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := userRepo.FindUsers(ctx, filter, opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prefix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := userService.ListUsers(ctx, viewer, 1, 20, "user0424"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("text", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := userService.ListUsers(ctx, viewer, 1, 20, "user042424 user042425"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,683 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"messaging-app/internal/models"
+	"messaging-app/internal/push"
+	appredis "messaging-app/internal/redis"
+	"messaging-app/internal/repositories"
+	"messaging-app/internal/services"
+	"messaging-app/internal/websocket"
+	"messaging-app/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// persistingSender stands in for the message service: it stores the message
+// and hands it to the hub the way the Kafka consumer would
+type persistingSender struct {
+	messageRepo *repositories.MessageRepository
+	hub         websocket.MessageBroadcaster
+}
+
+func (s *persistingSender) SendMessage(ctx context.Context, senderID primitive.ObjectID, req models.MessageRequest) (*models.Message, error) {
+	receiverID, err := primitive.ObjectIDFromHex(req.ReceiverID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid receiver ID")
+	}
+	msg, err := s.messageRepo.CreateMessage(ctx, &models.Message{
+		SenderID:    senderID,
+		ReceiverID:  receiverID,
+		Content:     req.Content,
+		ContentType: req.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.hub.BroadcastMessage(*msg)
+	return msg, nil
+}
+
+// recordingPushNotifier records which users the hub wanted to notify
+type recordingPushNotifier struct {
+	mu       sync.Mutex
+	notified map[primitive.ObjectID][]string
+}
+
+func (n *recordingPushNotifier) NotifyOffline(ctx context.Context, msg models.Message, userIDs []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notified[msg.ID] = append(n.notified[msg.ID], userIDs...)
+}
+
+func (n *recordingPushNotifier) usersNotified(msgID primitive.ObjectID) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.notified[msgID]
+}
+
+// capturingPushQueue records push jobs instead of producing them to Kafka
+type capturingPushQueue struct {
+	jobs []models.PushJob
+}
+
+func (q *capturingPushQueue) QueuePush(ctx context.Context, job models.PushJob) error {
+	q.jobs = append(q.jobs, job)
+	return nil
+}
+
+// fakePushSender fails for the tokens in invalid and records the rest
+type fakePushSender struct {
+	invalid map[string]bool
+	sent    []models.PushNotification
+}
+
+func (s *fakePushSender) Send(ctx context.Context, n models.PushNotification) error {
+	if s.invalid[n.Token] {
+		return push.ErrInvalidToken
+	}
+	s.sent = append(s.sent, n)
+	return nil
+}
+
+type WebSocketIntegrationTestSuite struct {
+	suite.Suite
+	hub         *websocket.Hub
+	push        *recordingPushNotifier
+	groupRepo   *repositories.GroupRepository
+	messageRepo *repositories.MessageRepository
+	userRepo    *repositories.UserRepository
+	redisClient *appredis.ClusterClient
+	mongoClient *mongo.Client
+	server      *httptest.Server
+	testDBName  string
+	ctx         context.Context
+}
+
+func (suite *WebSocketIntegrationTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	suite.testDBName = "test_websocket_db"
+
+	// Initialize Redis
+	suite.redisClient = &appredis.ClusterClient{ClusterClient: redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{os.Getenv("REDIS_ADDR")},
+	})}
+
+	// Initialize MongoDB
+	mongoURI := os.Getenv("MONGO_URI")
+	opts := options.Client().ApplyURI(mongoURI)
+	suite.mongoClient, _ = mongo.Connect(suite.ctx, opts)
+
+	db := suite.mongoClient.Database(suite.testDBName)
+	suite.groupRepo = repositories.NewGroupRepository(db)
+	suite.messageRepo = repositories.NewMessageRepository(db)
+	suite.userRepo = repositories.NewUserRepository(db)
+	sender := &persistingSender{messageRepo: suite.messageRepo}
+	suite.push = &recordingPushNotifier{notified: map[primitive.ObjectID][]string{}}
+	suite.hub = websocket.NewHub(suite.redisClient, suite.groupRepo, suite.userRepo, sender, suite.push, nil)
+	sender.hub = suite.hub
+
+	// The auth middleware is replaced by a query param so tests can pick the user
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("userID", c.Query("user"))
+		websocket.ServeWs(c, suite.hub)
+	})
+	suite.server = httptest.NewServer(router)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TearDownSuite() {
+	suite.server.Close()
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.mongoClient.Disconnect(suite.ctx)
+	suite.redisClient.Close()
+}
+
+func (suite *WebSocketIntegrationTestSuite) BeforeTest(suiteName, testName string) {
+	suite.mongoClient.Database(suite.testDBName).Drop(suite.ctx)
+	suite.redisClient.FlushDB(suite.ctx)
+}
+
+func TestWebSocketIntegrationTestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests")
+	}
+	suite.Run(t, new(WebSocketIntegrationTestSuite))
+}
+
+// connect opens a socket for the user and consumes the presence snapshot
+// that starts every connection
+func (suite *WebSocketIntegrationTestSuite) connect(userID primitive.ObjectID) *gorillaws.Conn {
+	conn, _ := suite.connectWithSnapshot(userID)
+	return conn
+}
+
+func (suite *WebSocketIntegrationTestSuite) connectWithSnapshot(userID primitive.ObjectID) (*gorillaws.Conn, models.PresenceSnapshotEvent) {
+	return suite.connectTo(suite.server, userID)
+}
+
+// connectTo is connectWithSnapshot against another hub's server
+func (suite *WebSocketIntegrationTestSuite) connectTo(server *httptest.Server, userID primitive.ObjectID) (*gorillaws.Conn, models.PresenceSnapshotEvent) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user=" + userID.Hex()
+	conn, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+	suite.Require().NoError(err)
+
+	var snapshot models.PresenceSnapshotEvent
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(conn, models.EventPresenceSnapshot), &snapshot))
+	return conn, snapshot
+}
+
+func (suite *WebSocketIntegrationTestSuite) readEvent(conn *gorillaws.Conn, eventType string) json.RawMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev models.WebSocketEvent
+	suite.Require().NoError(conn.ReadJSON(&ev))
+	suite.Require().Equal(eventType, ev.Type)
+	return ev.Data
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestOfflineDirectMessageDeliveredOnConnect() {
+	senderID := primitive.NewObjectID()
+	receiverID := primitive.NewObjectID()
+	msg := models.Message{
+		ID:          primitive.NewObjectID(),
+		SenderID:    senderID,
+		ReceiverID:  receiverID,
+		Content:     "are you there?",
+		ContentType: models.ContentTypeText,
+		CreatedAt:   time.Now(),
+	}
+
+	// Receiver is offline, so the message must be parked in the pending set
+	suite.hub.Broadcast <- msg
+	pendingKey := "pending:direct:" + receiverID.Hex()
+	suite.Eventually(func() bool {
+		ok, _ := suite.redisClient.SIsMember(suite.ctx, pendingKey, msg.ID.Hex()).Result()
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+
+	conn := suite.connect(receiverID)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	suite.Require().NoError(err)
+
+	var received models.Message
+	suite.Require().NoError(json.Unmarshal(data, &received))
+	suite.Equal(msg.ID, received.ID)
+	suite.Equal(msg.Content, received.Content)
+
+	suite.Eventually(func() bool {
+		n, _ := suite.redisClient.SCard(suite.ctx, pendingKey).Result()
+		return n == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestAdditionalHubSharesMetricsAndDelivers() {
+	// A second hub must not re-register the Prometheus collectors
+	var hub websocket.MessageBroadcaster
+	suite.NotPanics(func() {
+		hub = websocket.NewHub(suite.redisClient, suite.groupRepo, suite.userRepo, nil, nil, nil)
+	})
+
+	receiverID := primitive.NewObjectID()
+	msg := models.Message{
+		ID:          primitive.NewObjectID(),
+		SenderID:    primitive.NewObjectID(),
+		ReceiverID:  receiverID,
+		Content:     "via the broadcaster interface",
+		ContentType: models.ContentTypeText,
+		CreatedAt:   time.Now(),
+	}
+	hub.BroadcastMessage(msg)
+
+	pendingKey := "pending:direct:" + receiverID.Hex()
+	suite.Eventually(func() bool {
+		ok, _ := suite.redisClient.SIsMember(suite.ctx, pendingKey, msg.ID.Hex()).Result()
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func (suite *WebSocketIntegrationTestSuite) readFrame(conn *gorillaws.Conn) websocket.Frame {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame websocket.Frame
+	suite.Require().NoError(conn.ReadJSON(&frame))
+	return frame
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestClientMessageIsStoredAckedAndDelivered() {
+	senderID := primitive.NewObjectID()
+	receiverID := primitive.NewObjectID()
+
+	receiver := suite.connect(receiverID)
+	defer receiver.Close()
+	sender := suite.connect(senderID)
+	defer sender.Close()
+	// Give the hub a moment to register both connections
+	time.Sleep(100 * time.Millisecond)
+
+	payload, err := json.Marshal(models.MessageRequest{
+		ReceiverID:  receiverID.Hex(),
+		Content:     "over the socket",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(sender.WriteJSON(websocket.Frame{V: 1, Type: "message", TempID: "tmp-1", Payload: payload}))
+
+	ack := suite.readFrame(sender)
+	suite.Equal(websocket.FrameAck, ack.Type)
+	suite.Equal("tmp-1", ack.TempID)
+	var ackPayload websocket.AckPayload
+	suite.Require().NoError(json.Unmarshal(ack.Payload, &ackPayload))
+
+	stored, err := suite.messageRepo.GetMessageByID(suite.ctx, ackPayload.MessageID)
+	suite.Require().NoError(err)
+	suite.Equal(senderID, stored.SenderID)
+	suite.Equal("over the socket", stored.Content)
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var delivered models.Message
+	suite.Require().NoError(receiver.ReadJSON(&delivered))
+	suite.Equal(ackPayload.MessageID, delivered.ID)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestUnknownProtocolVersionIsRejected() {
+	conn := suite.connect(primitive.NewObjectID())
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	suite.Require().NoError(conn.WriteJSON(websocket.Frame{V: 99, Type: "message", TempID: "tmp-2"}))
+
+	frame := suite.readFrame(conn)
+	suite.Equal(websocket.FrameError, frame.Type)
+	suite.Equal("tmp-2", frame.TempID)
+	var body apperrors.Response
+	suite.Require().NoError(json.Unmarshal(frame.Payload, &body))
+	suite.Equal("unsupported protocol version", body.Message)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestMsgpackClientReceivesBinaryFrames() {
+	senderID := primitive.NewObjectID()
+	receiverID := primitive.NewObjectID()
+
+	url := "ws" + strings.TrimPrefix(suite.server.URL, "http") + "/ws?user=" + receiverID.Hex()
+	dialer := gorillaws.Dialer{Subprotocols: []string{websocket.SubprotocolMsgpack}}
+	receiver, _, err := dialer.Dial(url, nil)
+	suite.Require().NoError(err)
+	defer receiver.Close()
+	suite.Equal(websocket.SubprotocolMsgpack, receiver.Subprotocol())
+
+	// Even the presence snapshot is binary
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, _, err := receiver.ReadMessage()
+	suite.Require().NoError(err)
+	suite.Equal(gorillaws.BinaryMessage, messageType)
+
+	// A JSON client in the same conversation is unaffected
+	sender := suite.connect(senderID)
+	defer sender.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	payload, err := json.Marshal(models.MessageRequest{
+		ReceiverID:  receiverID.Hex(),
+		Content:     "over msgpack",
+		ContentType: models.ContentTypeText,
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(sender.WriteJSON(websocket.Frame{V: 1, Type: "message", TempID: "tmp-3", Payload: payload}))
+	ack := suite.readFrame(sender)
+	suite.Equal(websocket.FrameAck, ack.Type)
+
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, data, err := receiver.ReadMessage()
+	suite.Require().NoError(err)
+	suite.Equal(gorillaws.BinaryMessage, messageType)
+	suite.Equal(byte(0x80), data[0]&0xf0, "expected a msgpack map")
+	suite.True(bytes.Contains(data, []byte("over msgpack")))
+
+	// Frames that aren't MessagePack are answered with an error
+	suite.Require().NoError(receiver.WriteMessage(gorillaws.BinaryMessage, []byte(`{"v":1}`)))
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err = receiver.ReadMessage()
+	suite.Require().NoError(err)
+	suite.True(bytes.Contains(data, []byte("invalid frame")))
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestPresenceIsSharedWithFriendsOnly() {
+	create := func(name string) primitive.ObjectID {
+		user, err := suite.userRepo.CreateUser(suite.ctx, &models.User{Username: name, Email: name + "@example.com"})
+		suite.Require().NoError(err)
+		return user.ID
+	}
+	alice := create("alice")
+	bob := create("bob")
+	stranger := create("stranger")
+	suite.Require().NoError(suite.userRepo.AddFriend(suite.ctx, alice, bob))
+
+	bobConn, snapshot := suite.connectWithSnapshot(bob)
+	defer bobConn.Close()
+	suite.Empty(snapshot.OnlineFriends)
+	strangerConn := suite.connect(stranger)
+	defer strangerConn.Close()
+
+	aliceConn, snapshot := suite.connectWithSnapshot(alice)
+	suite.Equal([]string{bob.Hex()}, snapshot.OnlineFriends)
+
+	var change models.PresenceChangedEvent
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(bobConn, models.EventPresenceChanged), &change))
+	suite.Equal(alice.Hex(), change.UserID)
+	suite.True(change.Online)
+
+	aliceConn.Close()
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(bobConn, models.EventPresenceChanged), &change))
+	suite.Equal(alice.Hex(), change.UserID)
+	suite.False(change.Online)
+
+	// Presence isn't broadcast to users who aren't friends
+	strangerConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, _, err := strangerConn.ReadMessage()
+	suite.Error(err)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestOfflineRecipientsArePushed() {
+	online := primitive.NewObjectID()
+	conn := suite.connect(online)
+	defer conn.Close()
+
+	send := func(receiverID primitive.ObjectID) models.Message {
+		msg := models.Message{
+			ID:          primitive.NewObjectID(),
+			SenderID:    primitive.NewObjectID(),
+			ReceiverID:  receiverID,
+			Content:     "ping",
+			ContentType: models.ContentTypeText,
+			CreatedAt:   time.Now(),
+		}
+		suite.hub.BroadcastMessage(msg)
+		return msg
+	}
+
+	offline := primitive.NewObjectID()
+	toOffline := send(offline)
+	suite.Eventually(func() bool {
+		return len(suite.push.usersNotified(toOffline.ID)) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	suite.Equal([]string{offline.Hex()}, suite.push.usersNotified(toOffline.ID))
+
+	// A connected recipient gets the message over the socket instead
+	toOnline := send(online)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	suite.Require().NoError(err)
+	suite.Never(func() bool {
+		return len(suite.push.usersNotified(toOnline.ID)) > 0
+	}, 300*time.Millisecond, 50*time.Millisecond)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestPushPrunesInvalidTokens() {
+	deviceRepo := repositories.NewDeviceRepository(suite.mongoClient.Database(suite.testDBName))
+	queue := &capturingPushQueue{}
+	sender := &fakePushSender{invalid: map[string]bool{"stale-token": true}}
+	pushService := services.NewPushService(deviceRepo, queue, sender, suite.redisClient.ClusterClient, nil)
+
+	userID := primitive.NewObjectID()
+	for _, req := range []models.DeviceRequest{
+		{Platform: models.PlatformAndroid, Token: "fresh-token"},
+		{Platform: models.PlatformIOS, Token: "stale-token"},
+	} {
+		_, err := pushService.RegisterDevice(suite.ctx, userID, req)
+		suite.Require().NoError(err)
+	}
+
+	// Rapid messages in one conversation share a collapse key
+	senderID, groupID := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, content := range []string{"first", "second"} {
+		pushService.NotifyOffline(suite.ctx, models.Message{
+			ID:          primitive.NewObjectID(),
+			SenderID:    senderID,
+			SenderName:  "alice",
+			GroupID:     groupID,
+			GroupName:   "team",
+			Content:     content,
+			ContentType: models.ContentTypeText,
+		}, []string{userID.Hex()})
+	}
+	suite.Require().Len(queue.jobs, 2)
+	suite.Equal(groupID.Hex(), queue.jobs[0].CollapseKey)
+	suite.Equal(queue.jobs[0].CollapseKey, queue.jobs[1].CollapseKey)
+	suite.Equal("team", queue.jobs[1].Title)
+	suite.Equal("alice: second", queue.jobs[1].Body)
+
+	suite.Require().NoError(pushService.DeliverPush(suite.ctx, queue.jobs[1]))
+	suite.Require().Len(sender.sent, 1)
+	suite.Equal("fresh-token", sender.sent[0].Token)
+
+	// A job handled again, as after a consumer restart, doesn't push twice
+	suite.Require().NoError(pushService.DeliverPush(suite.ctx, queue.jobs[1]))
+	suite.Len(sender.sent, 1)
+
+	devices, err := deviceRepo.GetUserDevices(suite.ctx, userID)
+	suite.Require().NoError(err)
+	suite.Require().Len(devices, 1)
+	suite.Equal("fresh-token", devices[0].Token)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestRealtimeEventsReachOtherInstances() {
+	// A second instance sharing the same Redis, as behind a load balancer
+	other := websocket.NewHub(suite.redisClient, suite.groupRepo, suite.userRepo, nil, nil, nil)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("userID", c.Query("user"))
+		websocket.ServeWs(c, other)
+	})
+	otherServer := httptest.NewServer(router)
+	defer otherServer.Close()
+
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+	group, err := suite.groupRepo.CreateGroup(suite.ctx, &models.Group{
+		Name:    "two instances",
+		Admins:  []primitive.ObjectID{alice},
+		Members: []primitive.ObjectID{alice, bob},
+	})
+	suite.Require().NoError(err)
+
+	aliceConn := suite.connect(alice)
+	defer aliceConn.Close()
+	bobConn, _ := suite.connectTo(otherServer, bob)
+	defer bobConn.Close()
+
+	payload, err := json.Marshal(models.TypingEvent{ConversationID: group.ID.Hex(), IsTyping: true})
+	suite.Require().NoError(err)
+	suite.Require().NoError(aliceConn.WriteJSON(websocket.Frame{V: websocket.ProtocolVersion, Type: "typing", Payload: payload}))
+
+	bobConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var typing models.TypingEvent
+	suite.Require().NoError(bobConn.ReadJSON(&typing))
+	suite.Equal(alice.Hex(), typing.UserID)
+	suite.Equal(group.ID.Hex(), typing.ConversationID)
+	suite.True(typing.IsTyping)
+
+	// A receipt consumed by the first instance reaches the sender on the
+	// second, and the first doesn't deliver its own relay a second time
+	msgID := primitive.NewObjectID()
+	data, err := json.Marshal(models.MessagesSeenEvent{
+		ConversationID: bob.Hex(),
+		ReaderID:       alice,
+		MessageIDs:     []primitive.ObjectID{msgID},
+		SenderIDs:      []primitive.ObjectID{bob},
+	})
+	suite.Require().NoError(err)
+	bobLocal := suite.connect(bob)
+	defer bobLocal.Close()
+	suite.hub.BroadcastEvent(models.WebSocketEvent{Type: models.EventMessagesSeen, Data: data})
+
+	var seen models.MessagesSeenEvent
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(bobConn, models.EventMessagesSeen), &seen))
+	suite.Equal([]primitive.ObjectID{msgID}, seen.MessageIDs)
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(bobLocal, models.EventMessagesSeen), &seen))
+
+	// So does a delivery receipt for bob's message
+	delivered, err := json.Marshal(models.MessageDeliveredEvent{MessageID: msgID, SenderID: bob, RecipientIDs: []primitive.ObjectID{alice}})
+	suite.Require().NoError(err)
+	suite.hub.BroadcastEvent(models.WebSocketEvent{Type: models.EventMessageDelivered, Data: delivered})
+	var receipt models.MessageDeliveredEvent
+	suite.Require().NoError(json.Unmarshal(suite.readEvent(bobConn, models.EventMessageDelivered), &receipt))
+	suite.Equal(msgID, receipt.MessageID)
+	suite.readEvent(bobLocal, models.EventMessageDelivered)
+
+	// Removing bob takes effect on every instance, not only the one that
+	// consumed the event: the second stops sending him group messages
+	removal, err := json.Marshal(models.GroupMembershipEvent{GroupID: group.ID, UserID: bob, ActorID: alice})
+	suite.Require().NoError(err)
+	suite.hub.BroadcastEvent(models.WebSocketEvent{Type: models.EventGroupMemberRemoved, Data: removal})
+	suite.readEvent(bobConn, models.EventGroupMemberRemoved)
+	suite.readEvent(bobLocal, models.EventGroupMemberRemoved)
+	other.BroadcastMessage(models.Message{
+		ID:          primitive.NewObjectID(),
+		SenderID:    alice,
+		GroupID:     group.ID,
+		Content:     "bob is gone",
+		ContentType: models.ContentTypeText,
+	})
+
+	for _, conn := range []*gorillaws.Conn{bobLocal, bobConn} {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, _, err = conn.ReadMessage()
+		suite.Error(err)
+	}
+}
+
+// gaugeValue reads a gauge from the default Prometheus registry
+func gaugeValue(name string) float64 {
+	families, _ := prometheus.DefaultGatherer.Gather()
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) > 0 {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestConcurrentDisconnectsUnderLoad() {
+	const clients = 1000
+	baseline := gaugeValue("websocket_connections_total")
+	url := "ws" + strings.TrimPrefix(suite.server.URL, "http") + "/ws?user="
+
+	users := make([]primitive.ObjectID, clients)
+	for i := range users {
+		users[i] = primitive.NewObjectID()
+	}
+
+	// Read receipts keep arriving for the users while their connections come
+	// and go, so sends race with removal
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			data, _ := json.Marshal(models.MessagesSeenEvent{
+				ReaderID:  primitive.NewObjectID(),
+				SenderIDs: []primitive.ObjectID{users[i%clients]},
+			})
+			suite.hub.BroadcastEvent(models.WebSocketEvent{Type: models.EventMessagesSeen, Data: data})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var dialErrors int
+	for _, userID := range users {
+		wg.Add(1)
+		go func(userID primitive.ObjectID) {
+			defer wg.Done()
+			conn, _, err := gorillaws.DefaultDialer.Dial(url+userID.Hex(), nil)
+			if err != nil {
+				mu.Lock()
+				dialErrors++
+				mu.Unlock()
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			conn.ReadMessage()
+			conn.Close()
+		}(userID)
+	}
+	wg.Wait()
+	close(done)
+
+	suite.Zero(dialErrors)
+	suite.Eventually(func() bool {
+		return gaugeValue("websocket_connections_total") == baseline
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestShutdownClosesClientsWithRestartCode() {
+	hub := websocket.NewHub(suite.redisClient, suite.groupRepo, suite.userRepo, nil, nil, nil)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("userID", c.Query("user"))
+		websocket.ServeWs(c, hub)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	online := primitive.NewObjectID()
+	offline := primitive.NewObjectID()
+	conn, _ := suite.connectTo(server, online)
+	defer conn.Close()
+
+	// Queued before shutdown, so delivered or parked rather than lost
+	toOnline := models.Message{ID: primitive.NewObjectID(), SenderID: offline, ReceiverID: online, Content: "before the deploy", ContentType: models.ContentTypeText, CreatedAt: time.Now()}
+	toOffline := models.Message{ID: primitive.NewObjectID(), SenderID: online, ReceiverID: offline, Content: "see you later", ContentType: models.ContentTypeText, CreatedAt: time.Now()}
+	hub.BroadcastMessage(toOnline)
+	hub.BroadcastMessage(toOffline)
+
+	ctx, cancel := context.WithTimeout(suite.ctx, 5*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- hub.Shutdown(ctx) }()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var received models.Message
+	suite.Require().NoError(conn.ReadJSON(&received))
+	suite.Equal(toOnline.ID, received.ID)
+
+	_, _, err := conn.ReadMessage()
+	var closeErr *gorillaws.CloseError
+	suite.Require().ErrorAs(err, &closeErr)
+	suite.Equal(gorillaws.CloseServiceRestart, closeErr.Code)
+	suite.Equal(websocket.CloseReasonRestart, closeErr.Text)
+	suite.NoError(<-shutdownErr)
+
+	ok, err := suite.redisClient.SIsMember(suite.ctx, "pending:direct:"+offline.Hex(), toOffline.ID.Hex()).Result()
+	suite.Require().NoError(err)
+	suite.True(ok)
+
+	// New connections are turned away
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user=" + online.Hex()
+	_, resp, err := gorillaws.DefaultDialer.Dial(url, nil)
+	suite.Require().Error(err)
+	suite.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+}